@@ -6,92 +6,272 @@ import (
 	"simple-godis/config"
 	dbInterface "simple-godis/interface/database"
 	"simple-godis/lib/logger"
-	"simple-godis/lib/utils"
+	"simple-godis/lib/sync/atomic"
+	"simple-godis/lib/sync/wait"
 	"simple-godis/resp/client"
 	"simple-godis/resp/parser"
 	"simple-godis/resp/reply"
 	"strconv"
+	"sync"
+	syncAtomic "sync/atomic"
+	"time"
 )
 
 const aofBufferSize = 1 << 8
 
-type CmdLine [][]byte
+// 落盘后调用fsync的策略
+const (
+	FsyncAlways   = "always"   // 每次写入后都fsync 写入持久化之后才回复客户端
+	FsyncEverySec = "everysec" // 后台每秒fsync一次
+	FsyncNo       = "no"       // 不主动fsync 由操作系统决定何时刷盘
+)
+
+type CmdLine = [][]byte
 
+// payload 一次落盘的内容 包含一条或多条指令 多条指令会被一次性写入文件
 type payload struct {
-	cmdLine CmdLine
-	dbIndex int
+	cmdLines []CmdLine
+	dbIndex  int
+	wait     *wait.Wait // always策略下AddAof等待写入持久化完成
 }
 
 // AofHandler 落盘处理器
 // 构造方法
 // 将用户指令包装成payload放到缓冲区aofChan中去，再将aofChan中的数据落到硬盘中
 // 加载 将磁盘中的aof指令加载出来
+// 重写 将数据库的当前状态重新生成为最少的指令 替换原来的aof文件
 type AofHandler struct {
 	database      dbInterface.Database // 持有数据库
-	aofChan       chan *payload        // 数据缓冲区 缓存的是指令的集合
+	tmpDBMaker    func() dbInterface.DBEngine
+	aofChan       chan *payload // 数据缓冲区 缓存的是指令的集合
 	aofFile       *os.File
 	aofFilename   string
 	currenDbIndex int // 该文件对应哪个分数据库
+
+	pausingAof    sync.RWMutex   // 写入文件时持有读锁 开始和结束重写时持有写锁
+	rewriting     atomic.Boolean // 是否正在重写
+	rewriteBuffer []*payload     // 重写期间到达的指令 重写结束时追加到新文件末尾
+	aofSize       int64          // 当前aof文件的大小
+	baseSize      int64          // 上一次重写后(或启动时)aof文件的大小 用于自动重写
+
+	fsyncPolicy   string        // fsync策略
+	lastFsyncTime int64         // 上一次fsync的时间 unix毫秒
+	pendingBytes  int64         // 已经写入文件但还没有fsync的字节数
+	aofFinished   chan struct{} // 落盘协程退出时关闭
+	closeOnce     sync.Once
+	stopFsync     chan struct{} // 通知everysec的fsync协程退出
+	closing       sync.RWMutex  // AddAof发送时持有读锁 Close关闭aofChan时持有写锁
+	closed        bool          // aofChan已经关闭 之后的指令不再写入
 }
 
-// NewAofHandler AofHandler的构造方法
-func NewAofHandler(database dbInterface.Database) (*AofHandler, error) {
+// NewAofHandler AofHandler的构造方法 tmpDBMaker用于在重写时创建不落盘的临时数据库
+func NewAofHandler(database dbInterface.Database, tmpDBMaker func() dbInterface.DBEngine) (*AofHandler, error) {
 	handler := &AofHandler{}
 	handler.aofFilename = config.Properties.AppendFilename
 	handler.database = database
+	handler.tmpDBMaker = tmpDBMaker
+	handler.fsyncPolicy = parseFsyncPolicy(config.Properties.AppendFsync)
 	// LoadAof程序启动时将磁盘中的aof文件加载出来
-	handler.loadAof()
+	handler.loadAof(handler.database, -1)
 	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
 		return nil, err
 	}
 	handler.aofFile = aofFile
+	fileInfo, err := aofFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	handler.aofSize = fileInfo.Size()
+	handler.baseSize = fileInfo.Size()
+	handler.lastFsyncTime = time.Now().UnixMilli()
 	// 创建缓冲区
 	handler.aofChan = make(chan *payload, aofBufferSize)
+	handler.aofFinished = make(chan struct{})
+	handler.stopFsync = make(chan struct{})
+	// 文件末尾可能停留在任意一个分数据库 第一次写入时总是先写入select
+	handler.currenDbIndex = -1
 	// 新建协程用于接收
 	go func() {
 		handler.handleAof()
 	}()
+	if handler.fsyncPolicy == FsyncEverySec {
+		handler.fsyncEverySecond()
+	}
 	return handler, nil
 }
 
-// AddAof 将一条指令语句追加到缓冲区aofChan中
-func (handler *AofHandler) AddAof(dbIndex int, cmd CmdLine) {
-	if config.Properties.AppendOnly && handler.aofChan != nil {
-		handler.aofChan <- &payload{
-			cmdLine: cmd,
-			dbIndex: dbIndex,
+// parseFsyncPolicy 解析fsync策略 不合法的配置使用everysec
+func parseFsyncPolicy(policy string) string {
+	switch policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return policy
+	case "":
+		return FsyncEverySec
+	}
+	logger.Warn("unknown appendfsync policy " + policy + ", use " + FsyncEverySec)
+	return FsyncEverySec
+}
+
+// AddAof 将一条或多条指令语句追加到缓冲区aofChan中 同一次追加的多条指令会连续地写入文件
+// always策略下阻塞到指令写入文件并fsync之后才返回
+func (handler *AofHandler) AddAof(dbIndex int, cmds ...CmdLine) {
+	if config.Properties.AppendOnly && handler.aofChan != nil && len(cmds) > 0 {
+		p := &payload{
+			cmdLines: cmds,
+			dbIndex:  dbIndex,
+		}
+		if handler.fsyncPolicy == FsyncAlways {
+			p.wait = &wait.Wait{}
+			p.wait.Add(1)
+		}
+		handler.closing.RLock()
+		if handler.closed { // 关闭之后仍在执行的指令不再落盘
+			handler.closing.RUnlock()
+			return
+		}
+		handler.aofChan <- p
+		handler.closing.RUnlock()
+		if p.wait != nil {
+			p.wait.Wait()
 		}
 	}
 }
 
 // HandleAof 将缓冲区aofChan中的内容源源不断地往外取，并保存到磁盘中
 func (handler *AofHandler) handleAof() {
-	handler.currenDbIndex = 0
 	for payload := range handler.aofChan {
-		// 需要切换分数据库
-		if payload.dbIndex != handler.currenDbIndex {
-			handler.currenDbIndex = payload.dbIndex
-			// 转成字节数组写入文件中
-			data := reply.MakeMultiBulkReply(utils.ToCmdLine("select", strconv.Itoa(payload.dbIndex))).ToBytes()
-			_, err := handler.aofFile.Write(data)
-			if err != nil {
-				logger.Error(err)
-				continue
+		handler.writePayload(payload)
+		if handler.fsyncPolicy == FsyncAlways {
+			handler.Fsync()
+		}
+		if payload.wait != nil {
+			payload.wait.Done()
+		}
+		handler.autoRewrite()
+	}
+	close(handler.aofFinished)
+}
+
+// Fsync 将已经写入aof文件的内容刷到磁盘
+func (handler *AofHandler) Fsync() {
+	handler.pausingAof.RLock()
+	defer handler.pausingAof.RUnlock()
+	pending := syncAtomic.LoadInt64(&handler.pendingBytes)
+	if err := handler.aofFile.Sync(); err != nil {
+		logger.Error("fsync aof file failed: ", err)
+		return
+	}
+	syncAtomic.AddInt64(&handler.pendingBytes, -pending)
+	syncAtomic.StoreInt64(&handler.lastFsyncTime, time.Now().UnixMilli())
+}
+
+// fsyncEverySecond 启动后台协程每秒fsync一次
+func (handler *AofHandler) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if syncAtomic.LoadInt64(&handler.pendingBytes) > 0 {
+					handler.Fsync()
+				}
+			case <-handler.stopFsync:
+				return
 			}
 		}
-		// 如果不需要切换数据库 或者已经切换好数据库 直接将指令的字节数组写入文件
-		data := reply.MakeMultiBulkReply(payload.cmdLine).ToBytes()
-		_, err := handler.aofFile.Write(data)
+	}()
+}
+
+// Close 不再接收新的指令 等待缓冲区中的指令全部写入文件 fsync之后关闭文件
+// 持有closing的写锁关闭aofChan 正在发送的AddAof完成后才会关闭 之后的AddAof不会再向已关闭的aofChan发送
+func (handler *AofHandler) Close() {
+	handler.closeOnce.Do(func() {
+		close(handler.stopFsync)
+		handler.closing.Lock()
+		handler.closed = true
+		close(handler.aofChan)
+		handler.closing.Unlock()
+		<-handler.aofFinished
+		handler.Fsync()
+		handler.pausingAof.Lock()
+		defer handler.pausingAof.Unlock()
+		if err := handler.aofFile.Close(); err != nil {
+			logger.Error("close aof file failed: ", err)
+		}
+	})
+}
+
+// FsyncPolicy 返回fsync策略
+func (handler *AofHandler) FsyncPolicy() string {
+	return handler.fsyncPolicy
+}
+
+// LastFsyncTime 返回上一次fsync的时间
+func (handler *AofHandler) LastFsyncTime() time.Time {
+	return time.UnixMilli(syncAtomic.LoadInt64(&handler.lastFsyncTime))
+}
+
+// PendingBytes 返回已经写入文件但还没有fsync的字节数
+func (handler *AofHandler) PendingBytes() int64 {
+	return syncAtomic.LoadInt64(&handler.pendingBytes)
+}
+
+// PendingCommands 返回缓冲区中还没有写入文件的落盘请求数量
+func (handler *AofHandler) PendingCommands() int {
+	return len(handler.aofChan)
+}
+
+// AofSize 返回当前aof文件的大小
+func (handler *AofHandler) AofSize() int64 {
+	return syncAtomic.LoadInt64(&handler.aofSize)
+}
+
+// BaseSize 返回上一次重写后aof文件的大小
+func (handler *AofHandler) BaseSize() int64 {
+	return syncAtomic.LoadInt64(&handler.baseSize)
+}
+
+// writePayload 将一次落盘的内容写入文件 正在重写时同时放入重写缓冲区
+func (handler *AofHandler) writePayload(payload *payload) {
+	handler.pausingAof.RLock()
+	defer handler.pausingAof.RUnlock()
+	if handler.rewriting.Get() {
+		handler.rewriteBuffer = append(handler.rewriteBuffer, payload)
+	}
+	// 需要切换分数据库
+	if payload.dbIndex != handler.currenDbIndex {
+		// 转成字节数组写入文件中
+		n, err := handler.aofFile.Write(makeSelectCmd(payload.dbIndex))
+		handler.addWritten(n)
 		if err != nil {
 			logger.Error(err)
-			continue
+			return
 		}
+		handler.currenDbIndex = payload.dbIndex
+	}
+	// 如果不需要切换数据库 或者已经切换好数据库 直接将指令的字节数组写入文件
+	var data []byte
+	for _, cmdLine := range payload.cmdLines {
+		data = append(data, reply.MakeMultiBulkReply(cmdLine).ToBytes()...)
 	}
+	n, err := handler.aofFile.Write(data)
+	handler.addWritten(n)
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// addWritten 记录写入文件的字节数
+func (handler *AofHandler) addWritten(n int) {
+	syncAtomic.AddInt64(&handler.aofSize, int64(n))
+	syncAtomic.AddInt64(&handler.pendingBytes, int64(n))
 }
 
 // loadAof 在服务启动时将磁盘中的resp格式的指令当作用户发来的指令恢复
-func (handler *AofHandler) loadAof() {
+// maxBytes限制最多读取的字节数 小于0时读取整个文件
+func (handler *AofHandler) loadAof(database dbInterface.Database, maxBytes int64) {
 	file, err := os.Open(handler.aofFilename)
 	if err != nil {
 		logger.Error(err)
@@ -103,9 +283,15 @@ func (handler *AofHandler) loadAof() {
 			logger.Error("Close AofFile Failed", err)
 		}
 	}()
+	var reader io.Reader = file
+	if maxBytes >= 0 {
+		reader = io.LimitReader(file, maxBytes)
+	}
 	// 使用解析器解析Aof文件的历史指令 并将解析结果吐到ch管道里 再遍历管道还原指令
-	ch := parser.ParseStream(file)
+	ch := parser.ParseStream(reader)
+	// aof文件中的指令由服务器自己写入 作为内部连接不做权限检查
 	dummyClient := &client.Client{}
+	dummyClient.SetInternal(true)
 	for payload := range ch {
 		if payload.Err != nil {
 			if payload.Err == io.EOF {
@@ -122,10 +308,16 @@ func (handler *AofHandler) loadAof() {
 		if !ok {
 			continue
 		}
-		// 将取到的指令送到数据库执行
-		executeReply := handler.database.Exec(dummyClient, res.Msg)
+		// 将取到的指令送到数据库执行 事务块同样以MULTI...EXEC的形式重放
+		executeReply := database.Exec(dummyClient, res.Msg)
 		if reply.IsErrorReply(executeReply) {
 			logger.Error(executeReply)
 		}
 	}
+	// 文件以没有EXEC的事务结尾 说明写入事务时发生了宕机 丢弃这个不完整的事务
+	if dummyClient.InMultiState() {
+		logger.Warn("aof file ends with an incomplete transaction, discarded " +
+			strconv.Itoa(len(dummyClient.GetQueuedCmdLine())) + " commands")
+		dummyClient.SetMultiState(false)
+	}
 }
@@ -0,0 +1,104 @@
+package aof
+
+import (
+	List "simple-godis/datastructure/list"
+	HashSet "simple-godis/datastructure/set"
+	"simple-godis/datastructure/smap"
+	SortedSet "simple-godis/datastructure/sortedset"
+	dbInterface "simple-godis/interface/database"
+	"strconv"
+	"time"
+)
+
+/*
+将内存中的数据实体转换为能重建该实体的指令 用于AOF重写
+*/
+
+// EntityToCmd 将一个key对应的数据实体转换为一条指令 不支持的类型返回nil
+func EntityToCmd(key string, entity *dbInterface.DataEntity) CmdLine {
+	if entity == nil {
+		return nil
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		return stringToCmd(key, val)
+	case List.List:
+		return listToCmd(key, val)
+	case *HashSet.Set:
+		return setToCmd(key, val)
+	case smap.Map:
+		return hashToCmd(key, val)
+	case *SortedSet.SortedSet:
+		return sortedSetToCmd(key, val)
+	}
+	return nil
+}
+
+// stringToCmd set key value
+func stringToCmd(key string, bytes []byte) CmdLine {
+	return CmdLine{[]byte("set"), []byte(key), bytes}
+}
+
+// listToCmd rpush key value [value ...]
+func listToCmd(key string, list List.List) CmdLine {
+	cmdLine := make(CmdLine, 2, 2+list.Len())
+	cmdLine[0] = []byte("rpush")
+	cmdLine[1] = []byte(key)
+	list.ForEach(func(i int, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		cmdLine = append(cmdLine, bytes)
+		return true
+	})
+	return cmdLine
+}
+
+// setToCmd sadd key member [member ...]
+func setToCmd(key string, set *HashSet.Set) CmdLine {
+	cmdLine := make(CmdLine, 2, 2+set.Len())
+	cmdLine[0] = []byte("sadd")
+	cmdLine[1] = []byte(key)
+	set.ForEach(func(member string) bool {
+		cmdLine = append(cmdLine, []byte(member))
+		return true
+	})
+	return cmdLine
+}
+
+// hashToCmd hmset key field value [field value ...]
+func hashToCmd(key string, hash smap.Map) CmdLine {
+	cmdLine := make(CmdLine, 2, 2+hash.Len()*2)
+	cmdLine[0] = []byte("hmset")
+	cmdLine[1] = []byte(key)
+	hash.ForEach(func(field string, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		cmdLine = append(cmdLine, []byte(field), bytes)
+		return true
+	})
+	return cmdLine
+}
+
+// sortedSetToCmd zadd key score member [score member ...]
+func sortedSetToCmd(key string, sortedSet *SortedSet.SortedSet) CmdLine {
+	size := sortedSet.Len()
+	cmdLine := make(CmdLine, 2, 2+size*2)
+	cmdLine[0] = []byte("zadd")
+	cmdLine[1] = []byte(key)
+	if size == 0 {
+		return cmdLine
+	}
+	sortedSet.ForEach(0, size, false, func(element *SortedSet.Element) bool {
+		score := strconv.FormatFloat(element.Score, 'f', -1, 64)
+		cmdLine = append(cmdLine, []byte(score), []byte(element.Member))
+		return true
+	})
+	return cmdLine
+}
+
+// makeExpireCmd pexpireat key timestamp 使用绝对时间保证重放时已经过期的key不会复活
+func makeExpireCmd(key string, expireTime time.Time) CmdLine {
+	return CmdLine{
+		[]byte("pexpireat"),
+		[]byte(key),
+		[]byte(strconv.FormatInt(expireTime.UnixMilli(), 10)),
+	}
+}
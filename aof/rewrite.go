@@ -0,0 +1,233 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"simple-godis/config"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/lib/logger"
+	"simple-godis/lib/utils"
+	"simple-godis/resp/reply"
+	"strconv"
+	syncAtomic "sync/atomic"
+	"time"
+)
+
+/*
+AOF重写
+1. 开始重写 暂停落盘 记录当前aof文件的大小 之后到达的指令同时写入重写缓冲区
+2. 执行重写 将aof文件中记录大小之前的部分加载到临时数据库中 再将临时数据库中的数据导出为最少的指令写入临时文件
+3. 结束重写 暂停落盘 将重写缓冲区中的指令追加到临时文件 用临时文件原子地替换aof文件
+*/
+
+// ErrRewriteInProgress 已经有一个重写正在进行
+var ErrRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// rewriteCtx 一次重写的上下文
+type rewriteCtx struct {
+	tmpFile  *os.File // 重写生成的临时文件
+	fileSize int64    // 开始重写时aof文件的大小
+}
+
+// IsRewriting 是否正在重写
+func (handler *AofHandler) IsRewriting() bool {
+	return handler.rewriting.Get()
+}
+
+// Rewrite 同步地执行一次重写
+func (handler *AofHandler) Rewrite() error {
+	ctx, err := handler.startRewrite()
+	if err != nil {
+		return err
+	}
+	return handler.doRewrite(ctx)
+}
+
+// BackgroundRewrite 在后台执行重写 开始重写失败时返回错误
+func (handler *AofHandler) BackgroundRewrite() error {
+	ctx, err := handler.startRewrite()
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := handler.doRewrite(ctx); err != nil {
+			logger.Error("rewrite aof failed: ", err)
+		}
+	}()
+	return nil
+}
+
+// needRewrite 判断是否需要自动重写 aof文件超过最小大小并且相对上次重写后的增长比例超过配置值
+func (handler *AofHandler) needRewrite() bool {
+	percentage := config.Properties.AutoAofRewritePercentage
+	if percentage <= 0 || handler.rewriting.Get() {
+		return false
+	}
+	aofSize := handler.AofSize()
+	if aofSize < int64(config.Properties.AutoAofRewriteMinSize) {
+		return false
+	}
+	baseSize := handler.BaseSize()
+	base := baseSize
+	if base <= 0 {
+		base = 1
+	}
+	growth := (aofSize - baseSize) * 100 / base
+	return growth >= int64(percentage)
+}
+
+// autoRewrite 达到自动重写的条件时在后台开始重写 只在落盘协程中调用
+func (handler *AofHandler) autoRewrite() {
+	if !handler.needRewrite() {
+		return
+	}
+	logger.Info("aof file size " + strconv.FormatInt(handler.AofSize(), 10) + " reaches rewrite threshold")
+	if err := handler.BackgroundRewrite(); err != nil && err != ErrRewriteInProgress {
+		logger.Error("auto rewrite aof failed: ", err)
+	}
+}
+
+// startRewrite 开始重写 暂停落盘 记录aof文件当前的大小并创建临时文件
+func (handler *AofHandler) startRewrite() (*rewriteCtx, error) {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	if handler.rewriting.Get() {
+		return nil, ErrRewriteInProgress
+	}
+	fileInfo, err := handler.aofFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	dir, name := filepath.Split(handler.aofFilename)
+	tmpFile, err := os.CreateTemp(dir, name+".*.rewrite")
+	if err != nil {
+		return nil, err
+	}
+	handler.rewriting.Set(true)
+	handler.rewriteBuffer = nil
+	return &rewriteCtx{
+		tmpFile:  tmpFile,
+		fileSize: fileInfo.Size(),
+	}, nil
+}
+
+// doRewrite 将临时数据库中的数据写入临时文件 完成后结束重写 出错时放弃本次重写
+func (handler *AofHandler) doRewrite(ctx *rewriteCtx) error {
+	start := time.Now()
+	lastDbIndex, err := handler.dumpSnapshot(ctx)
+	if err == nil {
+		err = handler.finishRewrite(ctx, lastDbIndex)
+	}
+	if err != nil {
+		handler.cancelRewrite(ctx)
+		return err
+	}
+	logger.Info("aof rewrite finished in " + time.Since(start).String())
+	return nil
+}
+
+// dumpSnapshot 将aof文件开始重写之前的部分加载到临时数据库 再将临时数据库导出到临时文件
+// 返回临时文件中最后选择的分数据库 没有写入任何数据时返回-1
+func (handler *AofHandler) dumpSnapshot(ctx *rewriteCtx) (int, error) {
+	tmpDB := handler.tmpDBMaker()
+	defer tmpDB.Close()
+	handler.loadAof(tmpDB, ctx.fileSize)
+
+	writer := bufio.NewWriter(ctx.tmpFile)
+	lastDbIndex := -1
+	var err error
+	for i := 0; i < config.Properties.Databases; i++ {
+		dbIndex := i
+		tmpDB.ForEach(dbIndex, func(key string, entity *dbInterface.DataEntity, expiration *time.Time) bool {
+			cmdLine := EntityToCmd(key, entity)
+			if len(cmdLine) < 3 { // 不支持的类型或者空的集合
+				return true
+			}
+			if dbIndex != lastDbIndex {
+				lastDbIndex = dbIndex
+				if _, err = writer.Write(makeSelectCmd(dbIndex)); err != nil {
+					return false
+				}
+			}
+			if _, err = writer.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+				return false
+			}
+			if expiration != nil {
+				_, err = writer.Write(reply.MakeMultiBulkReply(makeExpireCmd(key, *expiration)).ToBytes())
+			}
+			return err == nil
+		})
+		if err != nil {
+			return lastDbIndex, err
+		}
+	}
+	return lastDbIndex, writer.Flush()
+}
+
+// finishRewrite 暂停落盘 将重写期间到达的指令追加到临时文件 再用临时文件替换aof文件
+func (handler *AofHandler) finishRewrite(ctx *rewriteCtx, lastDbIndex int) error {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+
+	writer := bufio.NewWriter(ctx.tmpFile)
+	for _, payload := range handler.rewriteBuffer {
+		if payload.dbIndex != lastDbIndex {
+			lastDbIndex = payload.dbIndex
+			if _, err := writer.Write(makeSelectCmd(lastDbIndex)); err != nil {
+				return err
+			}
+		}
+		for _, cmdLine := range payload.cmdLines {
+			if _, err := writer.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := ctx.tmpFile.Sync(); err != nil {
+		return err
+	}
+	// 先打开临时文件再重命名 重命名之后文件描述符仍然指向同一个文件
+	aofFile, err := os.OpenFile(ctx.tmpFile.Name(), os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	// rename是原子操作 替换过程中宕机也只会留下旧文件或者新文件
+	if err := os.Rename(ctx.tmpFile.Name(), handler.aofFilename); err != nil {
+		_ = aofFile.Close()
+		return err
+	}
+	_ = ctx.tmpFile.Close()
+	_ = handler.aofFile.Close()
+	handler.aofFile = aofFile
+	handler.currenDbIndex = lastDbIndex
+	if fileInfo, err := aofFile.Stat(); err == nil {
+		syncAtomic.StoreInt64(&handler.aofSize, fileInfo.Size())
+	}
+	syncAtomic.StoreInt64(&handler.baseSize, handler.AofSize())
+	// 临时文件已经fsync过了
+	syncAtomic.StoreInt64(&handler.pendingBytes, 0)
+	syncAtomic.StoreInt64(&handler.lastFsyncTime, time.Now().UnixMilli())
+	handler.rewriting.Set(false)
+	handler.rewriteBuffer = nil
+	return nil
+}
+
+// cancelRewrite 放弃本次重写 删除临时文件
+func (handler *AofHandler) cancelRewrite(ctx *rewriteCtx) {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	handler.rewriting.Set(false)
+	handler.rewriteBuffer = nil
+	_ = ctx.tmpFile.Close()
+	_ = os.Remove(ctx.tmpFile.Name())
+}
+
+// makeSelectCmd 生成切换分数据库的指令
+func makeSelectCmd(dbIndex int) []byte {
+	return reply.MakeMultiBulkReply(utils.ToCmdLine("select", strconv.Itoa(dbIndex))).ToBytes()
+}
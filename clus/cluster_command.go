@@ -0,0 +1,373 @@
+package clus
+
+import (
+	"errors"
+	"net"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/hashslot"
+	"simple-godis/lib/utils"
+	"simple-godis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+CLUSTER指令 查询集群的槽位信息和节点状态以及迁移槽
+*/
+
+// execCluster CLUSTER subcommand [args ...]
+func execCluster(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 2 {
+		return reply.MakeArgNumErrReply("cluster")
+	}
+	subCmd := strings.ToLower(string(cmdArgs[1]))
+	switch subCmd {
+	case "keyslot":
+		if len(cmdArgs) != 3 {
+			return reply.MakeArgNumErrReply("cluster|keyslot")
+		}
+		return reply.MakeIntReply(int64(hashslot.KeySlot(string(cmdArgs[2]))))
+	case "slots":
+		if len(cmdArgs) != 2 {
+			return reply.MakeArgNumErrReply("cluster|slots")
+		}
+		return clusterSlots(cluster)
+	case "nodes":
+		if len(cmdArgs) != 2 {
+			return reply.MakeArgNumErrReply("cluster|nodes")
+		}
+		return reply.MakeBulkReply([]byte(cluster.clusterNodes()))
+	case "info":
+		if len(cmdArgs) != 2 {
+			return reply.MakeArgNumErrReply("cluster|info")
+		}
+		return reply.MakeBulkReply([]byte(cluster.clusterInfo()))
+	case "replicate":
+		if len(cmdArgs) != 3 {
+			return reply.MakeArgNumErrReply("cluster|replicate")
+		}
+		if err := cluster.replicate(string(cmdArgs[2])); err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeOkReply()
+	case "failover":
+		if len(cmdArgs) > 3 {
+			return reply.MakeArgNumErrReply("cluster|failover")
+		}
+		option := ""
+		if len(cmdArgs) == 3 {
+			option = strings.ToLower(string(cmdArgs[2]))
+		}
+		if err := cluster.manualFailover(option); err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeOkReply()
+	case "countkeysinslot":
+		if len(cmdArgs) != 3 {
+			return reply.MakeArgNumErrReply("cluster|countkeysinslot")
+		}
+		slot, err := parseSlot(cmdArgs[2])
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeIntReply(int64(cluster.countKeysInSlot(conn.GetDBIndex(), slot)))
+	case "getkeysinslot":
+		if len(cmdArgs) != 4 {
+			return reply.MakeArgNumErrReply("cluster|getkeysinslot")
+		}
+		slot, err := parseSlot(cmdArgs[2])
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		count, err := strconv.Atoi(string(cmdArgs[3]))
+		if err != nil || count < 0 {
+			return reply.MakeErrReply("ERR Invalid number of keys")
+		}
+		keys := cluster.keysInSlot(conn.GetDBIndex(), slot, count)
+		if len(keys) == 0 {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		return reply.MakeMultiBulkReply(utils.ToCmdLine(keys...))
+	case "setslot":
+		return clusterSetSlot(cluster, cmdArgs[2:])
+	case "reshard":
+		if len(cmdArgs) != 5 {
+			return reply.MakeArgNumErrReply("cluster|reshard")
+		}
+		start, err := parseSlot(cmdArgs[2])
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		end, err := parseSlot(cmdArgs[3])
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		if start > end {
+			return reply.MakeErrReply("ERR Invalid slot range")
+		}
+		moved, err := cluster.reshard(start, end, string(cmdArgs[4]))
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeIntReply(int64(moved))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLUSTER HELP.")
+}
+
+// replicate CLUSTER REPLICATE node 成为node的从节点 node可以是地址或者节点ID 自己不能负责任何槽
+func (cluster *ClusterDatabase) replicate(name string) error {
+	master, ok := cluster.lookupNode(name)
+	if !ok {
+		return errors.New("ERR Unknown node " + name)
+	}
+	if master == cluster.self {
+		return errors.New("ERR Can't replicate myself")
+	}
+	if cluster.getMaster(master) != "" {
+		return errors.New("ERR I can only replicate a master, not a replica.")
+	}
+	if len(cluster.slots.slotsOf(cluster.self)) > 0 {
+		return errors.New("ERR To set a master the node must be empty and without assigned slots.")
+	}
+	cluster.becomeReplica(master)
+	return nil
+}
+
+// execReplicaOf 集群模式下主从关系由集群维护 不能直接使用REPLICAOF
+func execReplicaOf(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	return reply.MakeErrReply("ERR REPLICAOF not allowed in cluster mode. Use CLUSTER REPLICATE instead.")
+}
+
+// parseSlot 解析槽的编号
+func parseSlot(arg []byte) (int, error) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= hashslot.SlotCount {
+		return 0, errors.New("ERR Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+// clusterSetSlot CLUSTER SETSLOT slot IMPORTING node | MIGRATING node | NODE node | STABLE
+func clusterSetSlot(cluster *ClusterDatabase, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster|setslot")
+	}
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	action := strings.ToLower(string(args[1]))
+	node := ""
+	if action == "stable" {
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("cluster|setslot")
+		}
+	} else {
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|setslot")
+		}
+		node = string(args[2])
+	}
+	if err := cluster.setSlots(action, node, []int{slot}); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// setSlots 修改一批槽的状态 action为importing migrating node stable
+func (cluster *ClusterDatabase) setSlots(action string, node string, slots []int) error {
+	for _, slot := range slots {
+		owner := cluster.slots.getNode(slot)
+		switch action {
+		case "importing":
+			if owner == cluster.self {
+				return errors.New("ERR I'm already the owner of hash slot " + strconv.Itoa(slot))
+			}
+		case "migrating":
+			if owner != cluster.self {
+				return errors.New("ERR I'm not the owner of hash slot " + strconv.Itoa(slot))
+			}
+		case "node", "stable":
+		default:
+			return errors.New("ERR Invalid CLUSTER SETSLOT action or number of arguments")
+		}
+	}
+	if node != "" {
+		cluster.addNode(node)
+	}
+	for _, slot := range slots {
+		switch action {
+		case "importing":
+			cluster.slots.setImporting(slot, node)
+		case "migrating":
+			cluster.slots.setMigrating(slot, node)
+		case "node":
+			cluster.slots.setNode(slot, node)
+		case "stable":
+			cluster.slots.setStable(slot)
+		}
+	}
+	if action == "node" {
+		// 接管槽的节点使用新的纪元 其他节点通过心跳得知后以它为准
+		if node == cluster.self {
+			cluster.slots.bumpEpoch(cluster.self)
+		}
+		cluster.saveConfig()
+	}
+	return nil
+}
+
+// clusterSlots 返回每段连续的槽以及负责它们的节点 [[start, end, [host, port]], ...]
+func clusterSlots(cluster *ClusterDatabase) resp.Reply {
+	ranges := cluster.slots.ranges()
+	result := make([]resp.Reply, 0, len(ranges))
+	for _, r := range ranges {
+		host, port := splitNodeAddr(r.node)
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(int64(r.start)),
+			reply.MakeIntReply(int64(r.end)),
+			reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte(host)),
+				reply.MakeIntReply(int64(port)),
+			}),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// splitNodeAddr 将节点地址拆分为host和port
+func splitNodeAddr(addr string) (string, int) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// countKeysInSlot 统计本地分数据库中属于该槽的key的数量
+func (cluster *ClusterDatabase) countKeysInSlot(dbIndex int, slot int) int {
+	count := 0
+	cluster.db.ForEach(dbIndex, func(key string, entity *dbInterface.DataEntity, expiration *time.Time) bool {
+		if hashslot.KeySlot(key) == slot {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// clusterNodes 每个节点一行 id addr@port flags master ping-sent pong-recv config-epoch link-state slot ...
+func (cluster *ClusterDatabase) clusterNodes() string {
+	migrating, importing := cluster.slots.migrations()
+	var sb strings.Builder
+	for _, addr := range cluster.getNodes() {
+		_, port := splitNodeAddr(addr)
+		flags := "master"
+		master := "-"
+		linkState := "connected"
+		var pingSent, pongRecv int64
+		cluster.mutex.RLock()
+		if node, ok := cluster.nodes[addr]; ok {
+			if node.master != "" {
+				flags = "slave"
+				master = nodeId(node.master)
+			}
+			if addr == cluster.self {
+				flags = "myself," + flags
+			} else {
+				switch node.state {
+				case nodePFail:
+					flags += ",fail?"
+				case nodeFail:
+					flags += ",fail"
+				}
+				if node.state != nodeOk {
+					linkState = "disconnected"
+				}
+				if !node.pingSent.IsZero() {
+					pingSent = node.pingSent.UnixMilli()
+				}
+				pongRecv = node.pongReceived.UnixMilli()
+			}
+		}
+		cluster.mutex.RUnlock()
+		sb.WriteString(nodeId(addr) + " " + addr + "@" + strconv.Itoa(port) + " " + flags + " " + master + " " +
+			strconv.FormatInt(pingSent, 10) + " " + strconv.FormatInt(pongRecv, 10) + " " +
+			strconv.FormatInt(cluster.slots.getEpoch(addr), 10) + " " + linkState)
+		for _, part := range strings.Split(formatSlots(cluster.slots.slotsOf(addr)), ",") {
+			if part != "-" {
+				sb.WriteString(" " + part)
+			}
+		}
+		// 自己正在迁移的槽 [slot->-target] [slot-<-source]
+		if addr == cluster.self {
+			for _, slot := range sortedSlots(migrating) {
+				sb.WriteString(" [" + strconv.Itoa(slot) + "->-" + nodeId(migrating[slot]) + "]")
+			}
+			for _, slot := range sortedSlots(importing) {
+				sb.WriteString(" [" + strconv.Itoa(slot) + "-<-" + nodeId(importing[slot]) + "]")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// sortedSlots 返回按编号排序的槽
+func sortedSlots(slots map[int]string) []int {
+	result := make([]int, 0, len(slots))
+	for slot := range slots {
+		result = append(result, slot)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// clusterInfo 集群的整体状态 每行name:value
+// 有槽没有被分配或者负责某个槽的节点已经下线时集群状态为fail
+func (cluster *ClusterDatabase) clusterInfo() string {
+	assigned, pfail, fail := 0, 0, 0
+	for _, r := range cluster.slots.ranges() {
+		count := r.end - r.start + 1
+		assigned += count
+		if r.node == cluster.self {
+			continue
+		}
+		switch cluster.getNodeState(r.node) {
+		case nodePFail:
+			pfail += count
+		case nodeFail:
+			fail += count
+		}
+	}
+	state := "ok"
+	if assigned < hashslot.SlotCount || fail > 0 {
+		state = "fail"
+	}
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"cluster_state", state},
+		{"cluster_slots_assigned", strconv.Itoa(assigned)},
+		{"cluster_slots_ok", strconv.Itoa(assigned - pfail - fail)},
+		{"cluster_slots_pfail", strconv.Itoa(pfail)},
+		{"cluster_slots_fail", strconv.Itoa(fail)},
+		{"cluster_known_nodes", strconv.Itoa(len(cluster.getNodes()))},
+		{"cluster_size", strconv.Itoa(len(cluster.slots.nodeSet()))},
+		{"cluster_current_epoch", strconv.FormatInt(cluster.slots.getCurrentEpoch(), 10)},
+		{"cluster_my_epoch", strconv.FormatInt(cluster.slots.getEpoch(cluster.self), 10)},
+		{"cluster_stats_messages_sent", strconv.FormatInt(atomic.LoadInt64(&cluster.messagesSent), 10)},
+		{"cluster_stats_messages_received", strconv.FormatInt(atomic.LoadInt64(&cluster.messagesRecv), 10)},
+	}
+	var sb strings.Builder
+	for _, field := range fields {
+		sb.WriteString(field.name + ":" + field.value + reply.CRLF)
+	}
+	return sb.String()
+}
@@ -4,54 +4,129 @@ import (
 	"context"
 	"fmt"
 	pool "github.com/jolestar/go-commons-pool/v2"
+	"net"
 	"runtime/debug"
 	"simple-godis/clus/factory"
 	"simple-godis/config"
 	"simple-godis/database"
 	dbInterface "simple-godis/interface/database"
 	"simple-godis/interface/resp"
-	"simple-godis/lib/consistenthashing"
 	"simple-godis/lib/logger"
 	"simple-godis/resp/reply"
 	"strings"
+	"sync"
 )
 
 // ClusterDatabase 集群模式数据库 数据有三种执行模式 单节点返回、转发、群发
 type ClusterDatabase struct {
-	self           string   // 记录自己的节点
-	nodes          []string // 记录集群中所有的节点
-	peerPicker     *consistenthashing.NodeMap
+	self           string                      // 记录自己的节点
+	nodes          map[string]*clusterNode     // 记录集群中所有的节点以及它们的状态
+	slots          *slotTable                  // 每个槽由哪个节点负责
 	peerConnection map[string]*pool.ObjectPool // 每个节点需要一个连接池
-	db             dbInterface.Database
+	mutex          sync.RWMutex                // 保护nodes和peerConnection
+	migrateLock    sync.RWMutex                // 执行正在迁出的槽中的指令时加读锁 搬运key时加写锁
+	db             dbInterface.ClusterEngine   // 本机的数据库
+	failover       *failoverState              // 故障转移的选举和投票状态
+	stopGossip     chan struct{}               // 关闭时停止集群总线
+	messagesSent   int64                       // 发送的心跳数量
+	messagesRecv   int64                       // 收到的心跳数量
+	router         map[string]CmdFunc          // 指令名称对应的路由方法
 }
 
-// MakeClusterDatabase 新建了集群之间的连接和连接池的连接，新建了一致性哈希集合和所有节点的列表
+// MakeClusterDatabase 新建了集群之间的连接和连接池的连接，新建了槽位表和所有节点的列表
 func MakeClusterDatabase() *ClusterDatabase {
+	// 集群节点之间的连接不做权限检查 必须使用专门的密码认证
+	if config.Properties.ClusterPassword == "" {
+		logger.Fatal("cluster mode requires cluster-password")
+	}
 	cluster := &ClusterDatabase{
 		self:           config.Properties.Self,             // 配置文件中本机的地址
 		db:             database.MakeStandaloneDatabases(), // 本机的数据库
-		peerPicker:     consistenthashing.NewNodeMap(nil),  // 存储集群各个节点信息的map
 		peerConnection: make(map[string]*pool.ObjectPool),  // 各个节点之间的连接池
+		nodes:          make(map[string]*clusterNode),
+		failover:       makeFailoverState(),
+		stopGossip:     make(chan struct{}),
+		router:         makeRouter(),
 	}
 	nodes := make([]string, 0, len(config.Properties.Peers)+1) // 新建nodes列表，存储所有的节点
 	// 遍历所有配置的其他节点，将其他节点和自己都加入到nodes中
 	for _, peer := range config.Properties.Peers {
 		nodes = append(nodes, peer)
 	}
-	nodes = append(nodes, cluster.self)
-	cluster.peerPicker.AddNode(nodes...)
-	ctx := context.Background()
-	// 初始化该节点与其他各个节点之间的连接池
-	for _, peer := range config.Properties.Peers {
-		cluster.peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &factory.ConnectionFactory{
-			Peer: peer,
-		})
+	for _, node := range nodes {
+		cluster.addNode(node)
 	}
-	cluster.nodes = nodes
+	cluster.addNode(cluster.self)
+	// 从节点不负责槽 第一次启动时不参与平分
+	master := configuredMaster()
+	if master == "" {
+		nodes = append(nodes, cluster.self)
+	} else {
+		cluster.addNode(master)
+		cluster.setMaster(cluster.self, master)
+	}
+	cluster.slots = cluster.initSlotTable(nodes)
+	// 槽位表中可能有配置之外的节点 例如后来加入集群并迁入了槽的节点
+	for _, node := range cluster.slots.nodeSet() {
+		cluster.addNode(node)
+	}
+	cluster.startGossip()
 	return cluster
 }
 
-var router = makeRouter()
+// initSlotTable 优先使用集群配置文件中的槽位表 其次向已经在运行的节点获取 都没有时按配置平分所有槽
+func (cluster *ClusterDatabase) initSlotTable(nodes []string) *slotTable {
+	table, err := loadSlotTable(clusterConfigFile())
+	if err != nil {
+		panic("load cluster config file failed: " + err.Error())
+	}
+	if table != nil {
+		return table
+	}
+	table = cluster.joinCluster()
+	if table == nil {
+		table = makeSlotTable(nodes)
+	}
+	if err := table.save(clusterConfigFile()); err != nil {
+		logger.Error("save cluster config file failed: ", err)
+	}
+	return table
+}
+
+// configuredMaster 配置文件中replicaof指定的主节点地址 没有配置时为空
+func configuredMaster() string {
+	fields := strings.Fields(config.Properties.ReplicaOf)
+	if len(fields) != 2 {
+		return ""
+	}
+	return net.JoinHostPort(fields[0], fields[1])
+}
+
+// clusterConfigFile 集群配置文件名 默认为nodes.conf
+func clusterConfigFile() string {
+	if config.Properties.ClusterConfigFile == "" {
+		return "nodes.conf"
+	}
+	return config.Properties.ClusterConfigFile
+}
+
+// getPool 返回与节点之间的连接池 第一次使用时创建
+func (cluster *ClusterDatabase) getPool(peer string) *pool.ObjectPool {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	p, ok := cluster.peerConnection[peer]
+	if !ok {
+		poolConfig := pool.NewDefaultPoolConfig()
+		// 借出和归还时检查连接 节点宕机后不会一直复用已经失效的连接
+		poolConfig.TestOnBorrow = true
+		poolConfig.TestOnReturn = true
+		p = pool.NewObjectPool(context.Background(), &factory.ConnectionFactory{
+			Peer: peer,
+		}, poolConfig)
+		cluster.peerConnection[peer] = p
+	}
+	return p
+}
 
 func (cluster *ClusterDatabase) Exec(client resp.Connection, cmdLine dbInterface.CmdLine) (result resp.Reply) {
 	defer func() {
@@ -61,16 +136,25 @@ func (cluster *ClusterDatabase) Exec(client resp.Connection, cmdLine dbInterface
 		}
 	}()
 	cmdName := strings.ToLower(string(cmdLine[0]))
-	cmdFunc, ok := router[cmdName]
+	cmdFunc, ok := cluster.router[cmdName]
 	if !ok {
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "', or not supported in cluster mode")
 	}
+	if errReply := database.CheckPermission(client, cmdLine); errReply != nil {
+		return errReply
+	}
+	cluster.waitPaused(cmdName)
 	result = cmdFunc(cluster, client, cmdLine)
+	// ASKING只对紧接着的一条指令有效 节点之间的心跳PING可能插在ASKING和指令之间 不清除标记
+	if cmdName != "asking" && cmdName != "ping" {
+		client.SetAsking(false)
+	}
 	return
 }
 
 // Close 关闭单节点的数据库
 func (cluster *ClusterDatabase) Close() {
+	close(cluster.stopGossip)
 	cluster.db.Close()
 }
 
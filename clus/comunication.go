@@ -13,11 +13,7 @@ import (
 
 // getPeerConnection 从连接池中拿到对应peer节点的连接 peer:兄弟节点的地址
 func (cluster *ClusterDatabase) getPeerConnection(peer string) (*client.ClusterClient, error) {
-	pool, ok := cluster.peerConnection[peer]
-	if !ok {
-		return nil, errors.New("connection not found when borrowing")
-	}
-	object, err := pool.BorrowObject(context.Background())
+	object, err := cluster.getPool(peer).BorrowObject(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -30,11 +26,7 @@ func (cluster *ClusterDatabase) getPeerConnection(peer string) (*client.ClusterC
 
 // returnPeerConnection 使用完连接通信完成后归还连接
 func (cluster *ClusterDatabase) returnPeerConnection(peer string, peerClient *client.ClusterClient) error {
-	pool, ok := cluster.peerConnection[peer]
-	if !ok {
-		return errors.New("connection not found when returning")
-	}
-	return pool.ReturnObject(context.Background(), peerClient)
+	return cluster.getPool(peer).ReturnObject(context.Background(), peerClient)
 }
 
 // relay 将指令转发到集群的另一个节点 转发规则由哈希计算
@@ -43,6 +35,10 @@ func (cluster *ClusterDatabase) relay(peer string, conn resp.Connection, args []
 	if peer == cluster.self {
 		return cluster.db.Exec(conn, args)
 	}
+	// 已经下线的节点不再转发 避免每条指令都等到超时
+	if cluster.isFailed(peer) {
+		return reply.MakeErrReply("CLUSTERDOWN The node " + peer + " is down")
+	}
 	peerClient, err := cluster.getPeerConnection(peer)
 	if err != nil {
 		return reply.MakeErrReply(err.Error())
@@ -61,7 +57,7 @@ func (cluster *ClusterDatabase) relay(peer string, conn resp.Connection, args []
 // broadcast 向集群内的所有节点广播转发一条指令
 func (cluster *ClusterDatabase) broadcast(conn resp.Connection, args [][]byte) map[string]resp.Reply {
 	results := make(map[string]resp.Reply)
-	for _, node := range cluster.nodes {
+	for _, node := range cluster.getNodes() {
 		// 遍历每个节点执行转发
 		result := cluster.relay(node, conn, args)
 		results[node] = result
@@ -32,8 +32,10 @@ func (c *ConnectionFactory) DestroyObject(ctx context.Context, object *pool.Pool
 	return nil
 }
 
+// ValidateObject 请求超时或者重连失败的连接不再使用 由连接池销毁
 func (c *ConnectionFactory) ValidateObject(ctx context.Context, object *pool.PooledObject) bool {
-	return true
+	clusterClient, ok := object.Object.(*client.ClusterClient)
+	return ok && !clusterClient.IsBroken()
 }
 
 func (c *ConnectionFactory) ActivateObject(ctx context.Context, object *pool.PooledObject) error {
@@ -0,0 +1,335 @@
+package clus
+
+import (
+	"errors"
+	"math/rand"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/logger"
+	"simple-godis/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+故障转移
+1. 从节点发现自己的主节点被标记为下线后 等待一段随机时间 复制进度越落后等待越久
+2. 从节点使用新的纪元向所有负责槽的主节点请求投票 每个主节点在一个纪元内只投一票
+3. 获得多数主节点的投票后 从节点以新的纪元认领原主节点的所有槽并提升为主节点 然后立即向所有节点发送心跳
+4. 其他节点通过心跳得知槽的新主人 原主节点恢复后发现自己的槽被接管 转为新主节点的从节点
+手动故障转移CLUSTER FAILOVER在从节点上执行 主节点暂停客户端的指令 从节点追上主节点的复制偏移量后发起选举
+FORCE不等待主节点 TAKEOVER不经过选举直接接管
+*/
+
+const (
+	relayAuthRequest  = "_auth_request" // 从节点请求投票 _auth_request sender epoch master manual
+	relayManualStart  = "_mfstart"      // 手动故障转移时从节点通知主节点暂停客户端的指令 回复主节点的复制偏移量
+	manualFailoverTTL = 5 * time.Second // 手动故障转移的超时时间 主节点最多暂停这么久
+)
+
+// failoverState 故障转移相关的状态
+type failoverState struct {
+	mutex sync.Mutex
+
+	// 从节点一侧
+	electionTime time.Time // 开始选举的时间 零值表示还没有安排选举
+	electing     bool      // 是否正在进行选举
+
+	// 主节点一侧
+	lastVoteEpoch int64                // 最后一次投票的纪元
+	votedFor      map[string]time.Time // 为每个下线的主节点的从节点投票的时间
+	pauseUntil    time.Time            // 手动故障转移期间暂停客户端指令的截止时间
+	resume        chan struct{}        // 暂停结束时关闭
+}
+
+// makeFailoverState failoverState的构造方法
+func makeFailoverState() *failoverState {
+	return &failoverState{
+		votedFor: make(map[string]time.Time),
+	}
+}
+
+// failoverCron 从节点的主节点下线后安排选举 由集群总线每秒调用一次
+func (cluster *ClusterDatabase) failoverCron() {
+	state := cluster.failover
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	master := cluster.getMaster(cluster.self)
+	if master == "" || !cluster.isFailed(master) {
+		state.electionTime = time.Time{}
+		return
+	}
+	if state.electing {
+		return
+	}
+	now := time.Now()
+	if state.electionTime.IsZero() {
+		// 等待下线的消息传播到所有节点 复制偏移量越大的从节点越先发起选举
+		rank := cluster.replicaRank(master, cluster.db.ReplicationOffset())
+		delay := 500*time.Millisecond + time.Duration(rand.Intn(500))*time.Millisecond + time.Duration(rank)*time.Second
+		state.electionTime = now.Add(delay)
+		logger.Info("master " + master + " failed, start election in " + delay.String())
+		return
+	}
+	if now.Before(state.electionTime) {
+		return
+	}
+	state.electing = true
+	go func() {
+		err := cluster.runElection(master, false)
+		state.mutex.Lock()
+		defer state.mutex.Unlock()
+		state.electing = false
+		if err != nil {
+			logger.Warn(err.Error())
+			// 选举失败后等待一段时间再重试 避免多个从节点不断地互相竞争
+			state.electionTime = time.Now().Add(2 * nodeTimeout())
+		}
+	}()
+}
+
+// runElection 使用新的纪元请求所有负责槽的主节点投票 获得多数票后接管master的槽
+func (cluster *ClusterDatabase) runElection(master string, manual bool) error {
+	epoch := cluster.slots.nextEpoch()
+	masters := cluster.slots.nodeSet()
+	needed := len(masters)/2 + 1
+	manualFlag := "0"
+	if manual {
+		manualFlag = "1"
+	}
+	request := [][]byte{
+		[]byte(relayAuthRequest),
+		[]byte(cluster.self),
+		[]byte(strconv.FormatInt(epoch, 10)),
+		[]byte(master),
+		[]byte(manualFlag),
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	votes := 0
+	for _, node := range masters {
+		if cluster.isFailed(node) {
+			continue
+		}
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			result := cluster.sendToPeer(node, request)
+			if intReply, ok := result.(*reply.IntReply); ok && intReply.Code == 1 {
+				mutex.Lock()
+				votes++
+				mutex.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+	if votes < needed {
+		return errors.New("failover election for epoch " + strconv.FormatInt(epoch, 10) + " lost, got " +
+			strconv.Itoa(votes) + " of " + strconv.Itoa(needed) + " votes")
+	}
+	logger.Info("failover election for epoch " + strconv.FormatInt(epoch, 10) + " won with " +
+		strconv.Itoa(votes) + " votes")
+	return cluster.takeover(master, epoch)
+}
+
+// takeover 以epoch认领master的所有槽并提升为主节点 然后立即通知所有节点
+func (cluster *ClusterDatabase) takeover(master string, epoch int64) error {
+	slots := cluster.slots.slotsOf(master)
+	if err := cluster.db.ReplicaOf(""); err != nil {
+		return err
+	}
+	cluster.setMaster(cluster.self, "")
+	cluster.slots.claim(cluster.self, epoch, slots)
+	cluster.saveConfig()
+	logger.Info("took over " + strconv.Itoa(len(slots)) + " slots of " + master +
+		", config epoch " + strconv.FormatInt(epoch, 10))
+	for _, node := range cluster.getNodes() {
+		if node != cluster.self {
+			cluster.pingNode(node)
+		}
+	}
+	return nil
+}
+
+// followNewMaster sender在心跳中认领了新的槽之后调用
+// 自己原来负责的槽全部被接管 或者自己的主节点已经没有槽时 转为复制sender
+func (cluster *ClusterDatabase) followNewMaster(sender string, hadSlots bool) {
+	if len(cluster.slots.slotsOf(sender)) == 0 {
+		return
+	}
+	master := cluster.getMaster(cluster.self)
+	lostAll := hadSlots && len(cluster.slots.slotsOf(cluster.self)) == 0
+	masterLostAll := master != "" && master != sender && len(cluster.slots.slotsOf(master)) == 0
+	if !lostAll && !masterLostAll {
+		return
+	}
+	logger.Info("slots taken over by " + sender + ", start replicating it")
+	cluster.becomeReplica(sender)
+}
+
+// becomeReplica 转为复制master 结束手动故障转移的暂停
+func (cluster *ClusterDatabase) becomeReplica(master string) {
+	cluster.setMaster(cluster.self, master)
+	if err := cluster.db.ReplicaOf(master); err != nil {
+		logger.Error("replicate " + master + " failed: " + err.Error())
+	}
+	cluster.resumeClients()
+}
+
+// onAuthRequest 处理从节点的投票请求 _auth_request sender epoch master manual 同意时回复1
+func onAuthRequest(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) != 5 {
+		return reply.MakeArgNumErrReply(relayAuthRequest)
+	}
+	sender := string(cmdArgs[1])
+	epoch, err := strconv.ParseInt(string(cmdArgs[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid epoch")
+	}
+	master := string(cmdArgs[3])
+	manual := string(cmdArgs[4]) == "1"
+	if !cluster.vote(sender, epoch, master, manual) {
+		return reply.MakeIntReply(0)
+	}
+	logger.Info("voted for " + sender + " to replace " + master + " in epoch " + strconv.FormatInt(epoch, 10))
+	return reply.MakeIntReply(1)
+}
+
+// vote 判断是否同意sender接管master 只有负责槽的主节点可以投票 每个纪元只投一票
+// 除手动故障转移外master必须已经下线 同一个主节点的从节点在2倍超时时间内只能得到一次投票
+func (cluster *ClusterDatabase) vote(sender string, epoch int64, master string, manual bool) bool {
+	state := cluster.failover
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	cluster.slots.observeEpoch(epoch)
+	if len(cluster.slots.slotsOf(cluster.self)) == 0 || len(cluster.slots.slotsOf(master)) == 0 {
+		return false
+	}
+	if epoch < cluster.slots.getCurrentEpoch() || epoch <= state.lastVoteEpoch {
+		return false
+	}
+	if !manual && !cluster.isFailed(master) {
+		return false
+	}
+	if votedAt, ok := state.votedFor[master]; ok && time.Since(votedAt) < 2*nodeTimeout() {
+		return false
+	}
+	state.lastVoteEpoch = epoch
+	state.votedFor[master] = time.Now()
+	cluster.addNode(sender)
+	return true
+}
+
+// manualFailover CLUSTER FAILOVER [FORCE|TAKEOVER] 在从节点上执行
+func (cluster *ClusterDatabase) manualFailover(option string) error {
+	master := cluster.getMaster(cluster.self)
+	if master == "" {
+		return errors.New("ERR You should send CLUSTER FAILOVER to a replica")
+	}
+	state := cluster.failover
+	state.mutex.Lock()
+	if state.electing {
+		state.mutex.Unlock()
+		return errors.New("ERR Failover already in progress")
+	}
+	state.electing = true
+	state.mutex.Unlock()
+	defer func() {
+		state.mutex.Lock()
+		state.electing = false
+		state.mutex.Unlock()
+	}()
+
+	switch option {
+	case "takeover":
+		// 不经过选举 直接使用新的纪元接管
+		return cluster.takeover(master, cluster.slots.nextEpoch())
+	case "force":
+		return cluster.runElection(master, true)
+	case "":
+	default:
+		return errors.New("ERR syntax error")
+	}
+	if cluster.isFailed(master) {
+		return errors.New("ERR Master is down or failed, please use CLUSTER FAILOVER FORCE")
+	}
+	// 让主节点暂停客户端的指令 等待自己的复制进度追上主节点
+	result := cluster.sendToPeer(master, [][]byte{[]byte(relayManualStart), []byte(cluster.self)})
+	intReply, ok := result.(*reply.IntReply)
+	if !ok {
+		return errors.New("ERR Manual failover failed: " + strings.TrimSpace(string(result.ToClient())))
+	}
+	deadline := time.Now().Add(manualFailoverTTL)
+	for cluster.db.ReplicationOffset() < intReply.Code {
+		if time.Now().After(deadline) {
+			return errors.New("ERR Manual failover timed out waiting for replication offset")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cluster.runElection(master, true)
+}
+
+// onManualStart 主节点收到手动故障转移的请求 暂停客户端的指令并回复自己的复制偏移量
+func onManualStart(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) != 2 {
+		return reply.MakeArgNumErrReply(relayManualStart)
+	}
+	if len(cluster.slots.slotsOf(cluster.self)) == 0 {
+		return reply.MakeErrReply("ERR I'm not a master serving slots")
+	}
+	cluster.pauseClients(manualFailoverTTL)
+	logger.Info("manual failover requested by " + string(cmdArgs[1]) + ", clients paused")
+	return reply.MakeIntReply(cluster.db.ReplicationOffset())
+}
+
+// pauseClients 暂停客户端的指令 超时或者转为从节点后恢复
+func (cluster *ClusterDatabase) pauseClients(timeout time.Duration) {
+	state := cluster.failover
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.resume == nil {
+		state.resume = make(chan struct{})
+	}
+	state.pauseUntil = time.Now().Add(timeout)
+}
+
+// resumeClients 结束客户端指令的暂停
+func (cluster *ClusterDatabase) resumeClients() {
+	state := cluster.failover
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.resume != nil {
+		close(state.resume)
+		state.resume = nil
+	}
+	state.pauseUntil = time.Time{}
+}
+
+// 手动故障转移期间仍然可以执行的指令 节点之间的内部指令以'_'开头 也不会暂停
+var unpausedCommands = map[string]bool{
+	"ping":     true,
+	"cluster":  true,
+	"replconf": true,
+	"psync":    true,
+}
+
+// waitPaused 手动故障转移期间客户端的指令等待暂停结束
+func (cluster *ClusterDatabase) waitPaused(cmdName string) {
+	if unpausedCommands[cmdName] || strings.HasPrefix(cmdName, "_") {
+		return
+	}
+	state := cluster.failover
+	state.mutex.Lock()
+	resume, remaining := state.resume, time.Until(state.pauseUntil)
+	state.mutex.Unlock()
+	if resume == nil || remaining <= 0 {
+		return
+	}
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case <-resume:
+	case <-timer.C:
+	}
+}
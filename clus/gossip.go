@@ -0,0 +1,323 @@
+package clus
+
+import (
+	"errors"
+	"simple-godis/config"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/hashslot"
+	"simple-godis/lib/logger"
+	"simple-godis/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+集群总线
+每个节点每秒向其他所有节点发送一次心跳 _gossip sender currentEpoch configEpoch master offset slots [node state ...]
+对方处理后用同样格式的消息作为回复 双方借此交换纪元 负责的槽以及对其他节点健康状态的看法
+心跳中出现未知的节点时自动加入 发现负责某个槽的节点纪元更大时更新槽位表
+自己负责的槽或者自己的主节点负责的槽全部被其他节点接管时 转为复制接管槽的节点
+*/
+
+const (
+	relayGossip    = "_gossip" // 节点之间的心跳
+	relayFail      = "_fail"   // 通知所有节点某个节点已经下线 _fail node
+	gossipInterval = time.Second
+	gossipHeader   = 6 // 心跳消息中节点列表之前的字段数量
+)
+
+// nodeTimeout 节点超过该时间没有回复心跳时标记为疑似下线
+func nodeTimeout() time.Duration {
+	timeout := config.Properties.ClusterNodeTimeout
+	if timeout <= 0 {
+		timeout = 15000
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+// startGossip 启动集群总线 定期发送心跳并检查节点的健康状态
+func (cluster *ClusterDatabase) startGossip() {
+	go func() {
+		ticker := time.NewTicker(gossipInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, node := range cluster.getNodes() {
+					if node != cluster.self {
+						cluster.pingNode(node)
+					}
+				}
+				cluster.checkFailures()
+				cluster.failoverCron()
+			case <-cluster.stopGossip:
+				return
+			}
+		}
+	}()
+}
+
+// pingNode 在后台向节点发送一次心跳 上一次心跳还没有结束时跳过
+func (cluster *ClusterDatabase) pingNode(addr string) {
+	cluster.mutex.Lock()
+	node, ok := cluster.nodes[addr]
+	if !ok || node.pinging {
+		cluster.mutex.Unlock()
+		return
+	}
+	node.pinging = true
+	if node.pingSent.IsZero() {
+		node.pingSent = time.Now()
+	}
+	cluster.mutex.Unlock()
+
+	go func() {
+		atomic.AddInt64(&cluster.messagesSent, 1)
+		args := append([][]byte{[]byte(relayGossip)}, cluster.makeGossip()...)
+		result := cluster.sendToPeer(addr, args)
+		multiBulk, ok := result.(*reply.MultiBulkReply)
+		if ok {
+			atomic.AddInt64(&cluster.messagesRecv, 1)
+			if err := cluster.processGossip(multiBulk.Msg); err != nil {
+				logger.Warn("invalid gossip from " + addr + ": " + err.Error())
+				ok = false
+			}
+		}
+		cluster.mutex.Lock()
+		defer cluster.mutex.Unlock()
+		node.pinging = false
+		if !ok {
+			return
+		}
+		node.pingSent = time.Time{}
+		node.pongReceived = time.Now()
+		if node.state != nodeOk {
+			logger.Info("cluster node " + addr + " is reachable again")
+			node.state = nodeOk
+			node.failReports = make(map[string]time.Time)
+		}
+	}()
+}
+
+// makeGossip 生成自己的心跳消息 sender currentEpoch configEpoch master offset slots [node state ...] 主节点的master为"-"
+func (cluster *ClusterDatabase) makeGossip() [][]byte {
+	master := cluster.getMaster(cluster.self)
+	if master == "" {
+		master = "-"
+	}
+	msg := [][]byte{
+		[]byte(cluster.self),
+		[]byte(strconv.FormatInt(cluster.slots.getCurrentEpoch(), 10)),
+		[]byte(strconv.FormatInt(cluster.slots.getEpoch(cluster.self), 10)),
+		[]byte(master),
+		[]byte(strconv.FormatInt(cluster.db.ReplicationOffset(), 10)),
+		[]byte(formatSlots(cluster.slots.slotsOf(cluster.self))),
+	}
+	cluster.mutex.RLock()
+	defer cluster.mutex.RUnlock()
+	for addr, node := range cluster.nodes {
+		if addr == cluster.self {
+			continue
+		}
+		msg = append(msg, []byte(addr), []byte(node.state.String()))
+	}
+	return msg
+}
+
+// processGossip 处理其他节点的心跳消息或者心跳的回复
+func (cluster *ClusterDatabase) processGossip(msg [][]byte) error {
+	if len(msg) < gossipHeader || (len(msg)-gossipHeader)%2 != 0 {
+		return errors.New("wrong number of fields")
+	}
+	sender := string(msg[0])
+	currentEpoch, err1 := strconv.ParseInt(string(msg[1]), 10, 64)
+	configEpoch, err2 := strconv.ParseInt(string(msg[2]), 10, 64)
+	master := string(msg[3])
+	offset, err3 := strconv.ParseInt(string(msg[4]), 10, 64)
+	slots, err4 := parseSlots(string(msg[5]))
+	if sender == "" || sender == cluster.self || err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return errors.New("malformed header")
+	}
+	if master == "-" {
+		master = ""
+	}
+	if cluster.addNode(sender) {
+		logger.Info("cluster node " + sender + " discovered")
+	}
+	cluster.mutex.Lock()
+	cluster.nodes[sender].master = master
+	cluster.nodes[sender].replOffset = offset
+	cluster.mutex.Unlock()
+
+	hadSlots := len(cluster.slots.slotsOf(cluster.self)) > 0
+	changed := cluster.slots.observeEpoch(currentEpoch)
+	if cluster.slots.claim(sender, configEpoch, slots) {
+		changed = true
+		logger.Info("slots of " + sender + " updated, config epoch " + strconv.FormatInt(configEpoch, 10))
+		cluster.followNewMaster(sender, hadSlots)
+	}
+	if changed {
+		cluster.saveConfig()
+	}
+	// 只有负责槽的主节点的报告参与下线的判断
+	senderIsMaster := len(slots) > 0
+	for i := gossipHeader; i < len(msg); i += 2 {
+		addr := string(msg[i])
+		if addr == cluster.self {
+			continue
+		}
+		if cluster.addNode(addr) {
+			logger.Info("cluster node " + addr + " discovered via " + sender)
+		}
+		state := parseNodeState(string(msg[i+1]))
+		cluster.mutex.Lock()
+		node := cluster.nodes[addr]
+		if state == nodeOk {
+			delete(node.failReports, sender)
+		} else if senderIsMaster {
+			node.failReports[sender] = time.Now()
+		}
+		cluster.mutex.Unlock()
+	}
+	return nil
+}
+
+// checkFailures 将超时没有回复心跳的节点标记为疑似下线
+// 自己和其他主节点的报告达到多数时标记为下线并通知所有节点
+func (cluster *ClusterDatabase) checkFailures() {
+	timeout := nodeTimeout()
+	masters := make(map[string]bool)
+	for _, node := range cluster.slots.nodeSet() {
+		masters[node] = true
+	}
+	quorum := len(masters)/2 + 1
+	now := time.Now()
+	var failed []string
+	cluster.mutex.Lock()
+	for addr, node := range cluster.nodes {
+		if addr == cluster.self || node.state == nodeFail {
+			continue
+		}
+		if node.state == nodeOk && now.Sub(node.pongReceived) > timeout {
+			node.state = nodePFail
+			logger.Warn("cluster node " + addr + " is possibly failing")
+		}
+		if node.state != nodePFail {
+			continue
+		}
+		votes := 0
+		if masters[cluster.self] {
+			votes++
+		}
+		for reporter, reportTime := range node.failReports {
+			if now.Sub(reportTime) > 2*timeout {
+				delete(node.failReports, reporter)
+			} else if masters[reporter] {
+				votes++
+			}
+		}
+		if votes >= quorum {
+			node.state = nodeFail
+			failed = append(failed, addr)
+		}
+	}
+	cluster.mutex.Unlock()
+
+	for _, addr := range failed {
+		logger.Warn("cluster node " + addr + " is marked as failed")
+		for _, node := range cluster.getNodes() {
+			if node == cluster.self || node == addr {
+				continue
+			}
+			peer := node
+			go cluster.sendToPeer(peer, [][]byte{[]byte(relayFail), []byte(addr)})
+		}
+	}
+}
+
+// onGossip 处理其他节点的心跳 回复自己的心跳消息
+func onGossip(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	atomic.AddInt64(&cluster.messagesRecv, 1)
+	if err := cluster.processGossip(cmdArgs[1:]); err != nil {
+		return reply.MakeErrReply("ERR invalid gossip message: " + err.Error())
+	}
+	atomic.AddInt64(&cluster.messagesSent, 1)
+	return reply.MakeMultiBulkReply(cluster.makeGossip())
+}
+
+// onFail 处理其他节点的下线通知 _fail node
+func onFail(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) != 2 {
+		return reply.MakeArgNumErrReply(relayFail)
+	}
+	addr := string(cmdArgs[1])
+	if addr == cluster.self {
+		return reply.MakeOkReply()
+	}
+	cluster.mutex.Lock()
+	node, ok := cluster.nodes[addr]
+	if ok && node.state != nodeFail {
+		node.state = nodeFail
+		logger.Warn("cluster node " + addr + " is marked as failed by quorum")
+	}
+	cluster.mutex.Unlock()
+	return reply.MakeOkReply()
+}
+
+// saveConfig 保存集群配置文件
+func (cluster *ClusterDatabase) saveConfig() {
+	if err := cluster.slots.save(clusterConfigFile()); err != nil {
+		logger.Error("save cluster config file failed: ", err)
+	}
+}
+
+// formatSlots 将槽的列表格式化为"start-end,slot,..." 没有槽时为"-"
+func formatSlots(slots []int) string {
+	if len(slots) == 0 {
+		return "-"
+	}
+	var parts []string
+	for i := 0; i < len(slots); {
+		j := i
+		for j+1 < len(slots) && slots[j+1] == slots[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(slots[i]))
+		} else {
+			parts = append(parts, strconv.Itoa(slots[i])+"-"+strconv.Itoa(slots[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseSlots 解析formatSlots生成的槽的列表
+func parseSlots(s string) ([]int, error) {
+	if s == "-" {
+		return nil, nil
+	}
+	var slots []int
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		if start < 0 || end >= hashslot.SlotCount || start > end {
+			return nil, errors.New("invalid slot range " + part)
+		}
+		for slot := start; slot <= end; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
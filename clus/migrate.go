@@ -0,0 +1,371 @@
+package clus
+
+import (
+	"errors"
+	"simple-godis/config"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/hashslot"
+	"simple-godis/lib/logger"
+	"simple-godis/lib/utils"
+	"simple-godis/resp/client"
+	"simple-godis/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+槽的在线迁移
+1. 目标节点将槽标记为importing 源节点将槽标记为migrating
+2. 源节点逐个将槽中的key序列化后发送给目标节点 目标节点加载成功后源节点删除该key
+3. 依次通知目标节点 源节点和其他所有节点 槽已经属于目标节点
+迁移期间源节点上不存在的key回复ASK 客户端需要先发送ASKING再到目标节点上执行
+转发指令的节点会自动跟随MOVED和ASK 直接连接的客户端才会看到这两种错误
+*/
+
+const (
+	relayRestore  = "_restore"  // 迁移槽时源节点发送给目标节点的内部指令 加载一个序列化的key
+	relayJoin     = "_join"     // 节点第一次启动时向已有节点获取槽位表 同时让对方记录自己
+	relaySetSlots = "_setslots" // 迁移槽时一次修改一批槽的状态 _setslots action node slot [slot ...]
+	maxRedirects  = 2           // 转发指令时最多跟随MOVED和ASK的次数
+)
+
+// makeMovedReply 槽已经属于其他节点
+func makeMovedReply(slot int, node string) resp.Reply {
+	return reply.MakeErrReply("MOVED " + strconv.Itoa(slot) + " " + node)
+}
+
+// makeAskReply 槽正在迁移 key需要到目标节点上访问
+func makeAskReply(slot int, node string) resp.Reply {
+	return reply.MakeErrReply("ASK " + strconv.Itoa(slot) + " " + node)
+}
+
+// parseRedirect 解析MOVED和ASK错误 返回错误类型和目标节点
+func parseRedirect(result resp.Reply) (string, string, bool) {
+	errReply, ok := result.(reply.ErrorReply)
+	if !ok {
+		return "", "", false
+	}
+	fields := strings.Fields(errReply.Error())
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", "", false
+	}
+	return fields[0], fields[2], true
+}
+
+// isOkReply 判断节点的回复是否是OK 本地执行时为OkReply
+func isOkReply(result resp.Reply) bool {
+	if _, ok := result.(*reply.OkReply); ok {
+		return true
+	}
+	status, ok := result.(*reply.StatusReply)
+	return ok && status.Status == "OK"
+}
+
+// execOnSlot 执行只涉及一个槽的指令 key为指令的第一个key
+// 槽属于自己时在本地执行 正在迁出并且key已经不在本地时回复ASK
+// 槽正在迁入并且客户端发送过ASKING时在本地执行 其余情况转发给负责该槽的节点
+func (cluster *ClusterDatabase) execOnSlot(conn resp.Connection, slot int, key string, cmdArgs [][]byte) resp.Reply {
+	owner, migratingTo, importingFrom := cluster.slots.getState(slot)
+	if owner == cluster.self {
+		if migratingTo == "" {
+			return cluster.db.Exec(conn, cmdArgs)
+		}
+		// 持有读锁期间key不会被搬走 判断存在和执行指令之间不会发生迁移
+		cluster.migrateLock.RLock()
+		defer cluster.migrateLock.RUnlock()
+		if cluster.keyExists(conn, key) {
+			return cluster.db.Exec(conn, cmdArgs)
+		}
+		return makeAskReply(slot, migratingTo)
+	}
+	if importingFrom != "" && conn.IsAsking() {
+		return cluster.db.Exec(conn, cmdArgs)
+	}
+	// 其他节点转发来的指令说明对方的槽位表已经过期 不再继续转发
+	if conn.IsPeer() {
+		return makeMovedReply(slot, owner)
+	}
+	return cluster.relayToSlot(owner, slot, conn, cmdArgs)
+}
+
+// keyExists 判断key是否在本地的分数据库中
+func (cluster *ClusterDatabase) keyExists(conn resp.Connection, key string) bool {
+	result := cluster.db.Exec(conn, utils.ToCmdLine("exists", key))
+	intReply, ok := result.(*reply.IntReply)
+	return ok && intReply.Code > 0
+}
+
+// relayToSlot 将指令转发给负责该槽的节点 对方回复MOVED或ASK时转发到新的节点
+func (cluster *ClusterDatabase) relayToSlot(peer string, slot int, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	result := cluster.relay(peer, conn, cmdArgs)
+	for i := 0; i < maxRedirects; i++ {
+		kind, node, ok := parseRedirect(result)
+		if !ok {
+			return result
+		}
+		if kind == "MOVED" {
+			// 本地的槽位表已经过期 以负责该槽的节点的回复为准
+			cluster.slots.setNode(slot, node)
+			cluster.addNode(node)
+			result = cluster.relay(node, conn, cmdArgs)
+		} else {
+			result = cluster.relayAsking(node, conn, cmdArgs)
+		}
+	}
+	return result
+}
+
+// relayAsking 先发送ASKING再转发指令 目标节点是自己时说明自己正在迁入该槽 直接在本地执行
+func (cluster *ClusterDatabase) relayAsking(peer string, conn resp.Connection, args [][]byte) resp.Reply {
+	if peer == cluster.self {
+		return cluster.db.Exec(conn, args)
+	}
+	peerClient, err := cluster.getPeerConnection(peer)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	defer func() {
+		if err := cluster.returnPeerConnection(peer, peerClient); err != nil {
+			logger.Error("return peer client failed")
+		}
+	}()
+	peerClient.Send(utils.ToCmdLine("select", strconv.Itoa(conn.GetDBIndex())))
+	peerClient.Send(utils.ToCmdLine("asking"))
+	return peerClient.Send(args)
+}
+
+// execAsking ASKING 允许下一条指令访问正在迁入的槽
+func execAsking(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) != 1 {
+		return reply.MakeArgNumErrReply("asking")
+	}
+	conn.SetAsking(true)
+	return reply.MakeOkReply()
+}
+
+// onRestore 处理源节点迁移过来的key _restore payload
+func onRestore(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) != 2 {
+		return reply.MakeArgNumErrReply(relayRestore)
+	}
+	if err := cluster.db.RestoreKeys(cmdArgs[1]); err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// onJoin 处理新节点的加入 _join node 记录新节点并返回槽位表 每段槽为连续的三个元素start end node
+func onJoin(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) != 2 {
+		return reply.MakeArgNumErrReply(relayJoin)
+	}
+	cluster.addNode(string(cmdArgs[1]))
+	var lines [][]byte
+	for _, r := range cluster.slots.ranges() {
+		lines = append(lines, []byte(strconv.Itoa(r.start)), []byte(strconv.Itoa(r.end)), []byte(r.node))
+	}
+	return reply.MakeMultiBulkReply(lines)
+}
+
+// joinCluster 向配置中的节点获取槽位表 所有节点都无法连接时返回nil
+func (cluster *ClusterDatabase) joinCluster() *slotTable {
+	for _, peer := range config.Properties.Peers {
+		peerClient, err := client.MakeClusterClient(peer)
+		if err != nil {
+			continue
+		}
+		peerClient.Start()
+		result := peerClient.Send(utils.ToCmdLine(relayJoin, cluster.self))
+		peerClient.Close()
+		table, err := parseJoinReply(result)
+		if err != nil {
+			logger.Warn("join cluster via " + peer + " failed: " + err.Error())
+			continue
+		}
+		logger.Info("slot table fetched from " + peer)
+		return table
+	}
+	return nil
+}
+
+// parseJoinReply 解析_join的回复
+func parseJoinReply(result resp.Reply) (*slotTable, error) {
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return nil, errors.New(errReply.Error())
+	}
+	multiBulk, ok := result.(*reply.MultiBulkReply)
+	if !ok || len(multiBulk.Msg)%3 != 0 {
+		return nil, errors.New("unexpected reply")
+	}
+	var ranges []*slotRange
+	for i := 0; i < len(multiBulk.Msg); i += 3 {
+		r, err := parseSlotRange(string(multiBulk.Msg[i]), string(multiBulk.Msg[i+1]), string(multiBulk.Msg[i+2]))
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	table := makeEmptySlotTable()
+	table.setRanges(ranges)
+	return table, nil
+}
+
+// keysInSlot 返回本地分数据库中属于该槽的key 最多count个 count小于0时不限制
+func (cluster *ClusterDatabase) keysInSlot(dbIndex int, slot int, count int) []string {
+	var keys []string
+	cluster.db.ForEach(dbIndex, func(key string, entity *dbInterface.DataEntity, expiration *time.Time) bool {
+		if hashslot.KeySlot(key) == slot {
+			keys = append(keys, key)
+		}
+		return count < 0 || len(keys) < count
+	})
+	return keys
+}
+
+// onSetSlots 处理_setslots action node slot [slot ...]
+func onSetSlots(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 4 {
+		return reply.MakeArgNumErrReply(relaySetSlots)
+	}
+	slots := make([]int, 0, len(cmdArgs)-3)
+	for _, arg := range cmdArgs[3:] {
+		slot, err := parseSlot(arg)
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		slots = append(slots, slot)
+	}
+	if err := cluster.setSlots(strings.ToLower(string(cmdArgs[1])), string(cmdArgs[2]), slots); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// makeSetSlotsCmd 生成_setslots指令
+func makeSetSlotsCmd(action string, node string, slots []int) [][]byte {
+	args := make([][]byte, 0, len(slots)+3)
+	args = append(args, []byte(relaySetSlots), []byte(action), []byte(node))
+	for _, slot := range slots {
+		args = append(args, []byte(strconv.Itoa(slot)))
+	}
+	return args
+}
+
+// sendToPeer 向其他节点发送一条指令
+func (cluster *ClusterDatabase) sendToPeer(peer string, args [][]byte) resp.Reply {
+	peerClient, err := cluster.getPeerConnection(peer)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	defer func() {
+		if err := cluster.returnPeerConnection(peer, peerClient); err != nil {
+			logger.Error("return peer client failed")
+		}
+	}()
+	return peerClient.Send(args)
+}
+
+// reshard 将[start, end]中属于自己的槽迁移到target 返回迁移的key的数量
+// 先把所有槽标记为迁移中 之后新的key只会写到目标节点 再一次遍历找出这些槽中已有的key逐个搬运 最后通知所有节点
+// 中途失败时槽保持迁移中的状态 重新执行即可继续
+func (cluster *ClusterDatabase) reshard(start int, end int, target string) (int, error) {
+	if target == cluster.self {
+		return 0, errors.New("ERR can't migrate slots to myself")
+	}
+	var slots []int
+	for slot := start; slot <= end; slot++ {
+		if cluster.slots.getNode(slot) == cluster.self {
+			slots = append(slots, slot)
+		}
+	}
+	if len(slots) == 0 {
+		return 0, nil
+	}
+	result := cluster.sendToPeer(target, makeSetSlotsCmd("importing", cluster.self, slots))
+	if !isOkReply(result) {
+		return 0, errors.New("ERR set slots importing on " + target + " failed: " + string(result.ToClient()))
+	}
+	if err := cluster.setSlots("migrating", target, slots); err != nil {
+		return 0, err
+	}
+	moved, err := cluster.moveKeys(slots, target)
+	if err != nil {
+		return moved, err
+	}
+	if err := cluster.finishMigration(slots, target); err != nil {
+		return moved, err
+	}
+	logger.Info("slots " + strconv.Itoa(start) + "-" + strconv.Itoa(end) + " migrated to " + target +
+		", " + strconv.Itoa(moved) + " keys moved")
+	return moved, nil
+}
+
+// moveKeys 将slots中的所有key搬运到target
+func (cluster *ClusterDatabase) moveKeys(slots []int, target string) (int, error) {
+	slotSet := make(map[int]struct{}, len(slots))
+	for _, slot := range slots {
+		slotSet[slot] = struct{}{}
+	}
+	peerClient, err := cluster.getPeerConnection(target)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := cluster.returnPeerConnection(target, peerClient); err != nil {
+			logger.Error("return peer client failed")
+		}
+	}()
+	send := func(payload []byte) error {
+		result := peerClient.Send([][]byte{[]byte(relayRestore), payload})
+		if !isOkReply(result) {
+			return errors.New("ERR restore key on " + target + " failed: " + string(result.ToClient()))
+		}
+		return nil
+	}
+	moved := 0
+	for dbIndex := 0; dbIndex < config.Properties.Databases; dbIndex++ {
+		var keys []string
+		cluster.db.ForEach(dbIndex, func(key string, entity *dbInterface.DataEntity, expiration *time.Time) bool {
+			if _, ok := slotSet[hashslot.KeySlot(key)]; ok {
+				keys = append(keys, key)
+			}
+			return true
+		})
+		for _, key := range keys {
+			cluster.migrateLock.Lock()
+			ok, err := cluster.db.DumpKey(dbIndex, key, send)
+			cluster.migrateLock.Unlock()
+			if err != nil {
+				return moved, err
+			}
+			if ok {
+				moved++
+			}
+		}
+	}
+	return moved, nil
+}
+
+// finishMigration 通知所有节点槽已经属于target 先让目标节点接管 再修改自己的槽位表 最后通知其他节点
+func (cluster *ClusterDatabase) finishMigration(slots []int, target string) error {
+	setNode := makeSetSlotsCmd("node", target, slots)
+	result := cluster.sendToPeer(target, setNode)
+	if !isOkReply(result) {
+		return errors.New("ERR set slots node on " + target + " failed: " + string(result.ToClient()))
+	}
+	if err := cluster.setSlots("node", target, slots); err != nil {
+		return err
+	}
+	for _, node := range cluster.getNodes() {
+		if node == cluster.self || node == target {
+			continue
+		}
+		// 通知失败的节点转发这些槽的指令时会收到MOVED并更新槽位表
+		if result := cluster.sendToPeer(node, setNode); !isOkReply(result) {
+			logger.Warn("notify " + node + " of new slot owner failed")
+		}
+	}
+	return nil
+}
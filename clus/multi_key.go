@@ -0,0 +1,167 @@
+package clus
+
+import (
+	"simple-godis/interface/resp"
+	"simple-godis/lib/hashslot"
+	"simple-godis/resp/reply"
+	"sync"
+)
+
+/*
+多key指令
+按照负责槽的节点将key分组 每个节点执行一条只包含自己的key的子指令 各个节点并发执行
+MGET按照key原来的顺序合并结果 DEL EXISTS UNLINK TOUCH将各个节点的计数相加
+MSET涉及多个节点时使用try-commit-cancel保证原子性
+只有一个key时与单key指令相同 多个key中有key所在的槽正在迁移时回复TRYAGAIN
+*/
+
+// pickNodes 返回每个key所在的节点
+func (cluster *ClusterDatabase) pickNodes(conn resp.Connection, keys [][]byte) ([]string, resp.Reply) {
+	nodes := make([]string, len(keys))
+	for i, key := range keys {
+		slot := hashslot.KeySlot(string(key))
+		owner, migratingTo, importingFrom := cluster.slots.getState(slot)
+		if owner == "" {
+			return nil, reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+		}
+		if migratingTo != "" || importingFrom != "" {
+			return nil, reply.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+		// 其他节点转发来的子指令只能在本地执行
+		if conn.IsPeer() && owner != cluster.self {
+			return nil, makeMovedReply(slot, owner)
+		}
+		nodes[i] = owner
+	}
+	return nodes, nil
+}
+
+// groupByNode 将key按照节点分组 返回每个节点上的key在原指令中的下标
+func groupByNode(nodes []string) map[string][]int {
+	groups := make(map[string][]int)
+	for i, node := range nodes {
+		groups[node] = append(groups[node], i)
+	}
+	return groups
+}
+
+// fanOut 并发地在每个节点上执行对应的子指令
+func (cluster *ClusterDatabase) fanOut(conn resp.Connection, cmdLines map[string]CmdLine) map[string]resp.Reply {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]resp.Reply, len(cmdLines))
+	for node, cmdLine := range cmdLines {
+		wg.Add(1)
+		go func(node string, cmdLine CmdLine) {
+			defer wg.Done()
+			result := cluster.relay(node, conn, cmdLine)
+			mutex.Lock()
+			results[node] = result
+			mutex.Unlock()
+		}(node, cmdLine)
+	}
+	wg.Wait()
+	return results
+}
+
+// makeSubCmd 使用下标为indexes的参数生成子指令 每个下标对应从参数开始的step个参数
+func makeSubCmd(cmdArgs [][]byte, indexes []int, step int) CmdLine {
+	cmdLine := make(CmdLine, 0, 1+len(indexes)*step)
+	cmdLine = append(cmdLine, cmdArgs[0])
+	for _, i := range indexes {
+		cmdLine = append(cmdLine, cmdArgs[1+i*step:1+i*step+step]...)
+	}
+	return cmdLine
+}
+
+// clusterMGet MGET key [key ...]
+func clusterMGet(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 2 {
+		return reply.MakeArgNumErrReply("mget")
+	}
+	if len(cmdArgs) == 2 {
+		return defaultClusterRouter(cluster, conn, cmdArgs)
+	}
+	nodes, errReply := cluster.pickNodes(conn, cmdArgs[1:])
+	if errReply != nil {
+		return errReply
+	}
+	groups := groupByNode(nodes)
+	cmdLines := make(map[string]CmdLine, len(groups))
+	for node, indexes := range groups {
+		cmdLines[node] = makeSubCmd(cmdArgs, indexes, 1)
+	}
+	results := cluster.fanOut(conn, cmdLines)
+	values := make([][]byte, len(nodes))
+	for node, result := range results {
+		if reply.IsErrorReply(result) {
+			return result
+		}
+		multiBulk, ok := result.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Msg) != len(groups[node]) {
+			return reply.MakeErrReply("ERR unexpected reply from " + node)
+		}
+		for j, i := range groups[node] {
+			values[i] = multiBulk.Msg[j]
+		}
+	}
+	return reply.MakeMultiBulkReply(values)
+}
+
+// clusterCountKeys DEL EXISTS UNLINK TOUCH key [key ...] 返回各个节点的计数之和
+func clusterCountKeys(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 2 {
+		return reply.MakeArgNumErrReply(string(cmdArgs[0]))
+	}
+	if len(cmdArgs) == 2 {
+		return defaultClusterRouter(cluster, conn, cmdArgs)
+	}
+	nodes, errReply := cluster.pickNodes(conn, cmdArgs[1:])
+	if errReply != nil {
+		return errReply
+	}
+	groups := groupByNode(nodes)
+	cmdLines := make(map[string]CmdLine, len(groups))
+	for node, indexes := range groups {
+		cmdLines[node] = makeSubCmd(cmdArgs, indexes, 1)
+	}
+	var count int64
+	for node, result := range cluster.fanOut(conn, cmdLines) {
+		if reply.IsErrorReply(result) {
+			return result
+		}
+		intReply, ok := result.(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("ERR unexpected reply from " + node)
+		}
+		count += intReply.Code
+	}
+	return reply.MakeIntReply(count)
+}
+
+// clusterMSet MSET key value [key value ...] key分布在多个节点上时原子地写入
+func clusterMSet(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 3 || len(cmdArgs)%2 != 1 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	if len(cmdArgs) == 3 {
+		return defaultClusterRouter(cluster, conn, cmdArgs)
+	}
+	keys := make([][]byte, 0, len(cmdArgs)/2)
+	for i := 1; i < len(cmdArgs); i += 2 {
+		keys = append(keys, cmdArgs[i])
+	}
+	nodes, errReply := cluster.pickNodes(conn, keys)
+	if errReply != nil {
+		return errReply
+	}
+	groups := groupByNode(nodes)
+	if len(groups) == 1 {
+		return cluster.relay(nodes[0], conn, cmdArgs)
+	}
+	cmdLines := make(map[string]CmdLine, len(groups))
+	for node, indexes := range groups {
+		cmdLines[node] = makeSubCmd(cmdArgs, indexes, 2)
+	}
+	return cluster.execAtomic(conn, cmdLines)
+}
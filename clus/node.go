@@ -0,0 +1,160 @@
+package clus
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+/*
+集群中每个节点的状态 由集群总线的心跳维护
+节点超过cluster-node-timeout没有回复心跳时标记为疑似下线(PFAIL)
+超过半数负责槽的主节点都认为它疑似下线时标记为下线(FAIL) 并通知所有节点
+负责槽的节点是主节点 从节点不负责槽 复制某个主节点的数据 主节点下线后由从节点接管它的槽
+*/
+
+// nodeState 节点的健康状态
+type nodeState int
+
+const (
+	nodeOk    nodeState = iota // 正常
+	nodePFail                  // 自己认为节点疑似下线
+	nodeFail                   // 多数主节点认为节点下线
+)
+
+// String 心跳消息和CLUSTER NODES中使用的状态名
+func (state nodeState) String() string {
+	switch state {
+	case nodePFail:
+		return "pfail"
+	case nodeFail:
+		return "fail"
+	}
+	return "ok"
+}
+
+// parseNodeState 解析心跳消息中的状态名
+func parseNodeState(s string) nodeState {
+	switch s {
+	case "pfail":
+		return nodePFail
+	case "fail":
+		return nodeFail
+	}
+	return nodeOk
+}
+
+// clusterNode 一个节点的状态 由ClusterDatabase.mutex保护
+type clusterNode struct {
+	addr         string
+	master       string // 从节点复制的主节点 主节点为空
+	replOffset   int64  // 心跳中携带的复制偏移量
+	state        nodeState
+	pingSent     time.Time            // 还没有收到回复的心跳的发送时间 收到回复后清零
+	pongReceived time.Time            // 最后一次收到心跳回复的时间
+	failReports  map[string]time.Time // 其他主节点报告该节点疑似下线的时间
+	pinging      bool                 // 是否有正在进行的心跳 避免对宕机的节点堆积请求
+}
+
+// makeClusterNode clusterNode的构造方法 刚加入的节点视为刚刚回复过心跳
+func makeClusterNode(addr string) *clusterNode {
+	return &clusterNode{
+		addr:         addr,
+		pongReceived: time.Now(),
+		failReports:  make(map[string]time.Time),
+	}
+}
+
+// nodeId 节点的ID 由地址计算 同一个地址在所有节点上的ID相同
+func nodeId(addr string) string {
+	sum := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(sum[:])
+}
+
+// getNodes 返回集群中所有节点 按地址排序
+func (cluster *ClusterDatabase) getNodes() []string {
+	cluster.mutex.RLock()
+	defer cluster.mutex.RUnlock()
+	nodes := make([]string, 0, len(cluster.nodes))
+	for addr := range cluster.nodes {
+		nodes = append(nodes, addr)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// addNode 记录一个新的节点 已经存在时忽略 返回是否是新节点
+func (cluster *ClusterDatabase) addNode(addr string) bool {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	if _, ok := cluster.nodes[addr]; ok {
+		return false
+	}
+	cluster.nodes[addr] = makeClusterNode(addr)
+	return true
+}
+
+// getNodeState 返回节点的健康状态 未知的节点视为正常
+func (cluster *ClusterDatabase) getNodeState(addr string) nodeState {
+	cluster.mutex.RLock()
+	defer cluster.mutex.RUnlock()
+	node, ok := cluster.nodes[addr]
+	if !ok {
+		return nodeOk
+	}
+	return node.state
+}
+
+// isFailed 节点是否已经被多数主节点认为下线
+func (cluster *ClusterDatabase) isFailed(addr string) bool {
+	return cluster.getNodeState(addr) == nodeFail
+}
+
+// getMaster 返回节点复制的主节点 主节点返回空
+func (cluster *ClusterDatabase) getMaster(addr string) string {
+	cluster.mutex.RLock()
+	defer cluster.mutex.RUnlock()
+	node, ok := cluster.nodes[addr]
+	if !ok {
+		return ""
+	}
+	return node.master
+}
+
+// setMaster 记录节点复制的主节点
+func (cluster *ClusterDatabase) setMaster(addr string, master string) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	if node, ok := cluster.nodes[addr]; ok {
+		node.master = master
+	}
+}
+
+// replicaRank 自己在主节点的所有从节点中的排名 复制偏移量比自己大的从节点的数量
+func (cluster *ClusterDatabase) replicaRank(master string, offset int64) int {
+	cluster.mutex.RLock()
+	defer cluster.mutex.RUnlock()
+	rank := 0
+	for addr, node := range cluster.nodes {
+		if addr != cluster.self && node.master == master && node.state == nodeOk && node.replOffset > offset {
+			rank++
+		}
+	}
+	return rank
+}
+
+// lookupNode 根据地址或者节点ID找到节点的地址
+func (cluster *ClusterDatabase) lookupNode(name string) (string, bool) {
+	cluster.mutex.RLock()
+	defer cluster.mutex.RUnlock()
+	if _, ok := cluster.nodes[name]; ok {
+		return name, true
+	}
+	for addr := range cluster.nodes {
+		if nodeId(addr) == name {
+			return addr, true
+		}
+	}
+	return "", false
+}
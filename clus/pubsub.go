@@ -0,0 +1,52 @@
+package clus
+
+import (
+	"simple-godis/interface/resp"
+	"simple-godis/lib/logger"
+	"simple-godis/resp/reply"
+)
+
+// 节点之间转发PUBLISH时使用的内部指令 收到该指令的节点只在本地发布 不再继续广播
+const relayPublish = "_publish"
+
+// clusterPublish 向集群中所有节点广播PUBLISH 任何节点上的订阅者都能收到消息
+// 返回回复了的节点上收到消息的订阅者数量之和 无法连接的节点只记录日志 不影响其他节点
+func clusterPublish(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) != 3 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	// 先在本地发布 本地出错时直接返回 不再广播
+	rep := cluster.db.Exec(conn, cmdArgs)
+	if reply.IsErrorReply(rep) {
+		return rep
+	}
+	var received int64 = 0
+	if intReply, ok := rep.(*reply.IntReply); ok {
+		received += intReply.Code
+	}
+	args := make([][]byte, len(cmdArgs))
+	copy(args, cmdArgs)
+	args[0] = []byte(relayPublish)
+	for _, node := range cluster.getNodes() {
+		if node == cluster.self {
+			continue
+		}
+		rep = cluster.relay(node, conn, args)
+		if errReply, ok := rep.(reply.ErrorReply); ok {
+			logger.Warn("publish to " + node + " failed: " + errReply.Error())
+			continue
+		}
+		if intReply, ok := rep.(*reply.IntReply); ok {
+			received += intReply.Code
+		}
+	}
+	return reply.MakeIntReply(received)
+}
+
+// onRelayPublish 处理其他节点转发来的PUBLISH 只在本地发布
+func onRelayPublish(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	args := make([][]byte, len(cmdArgs))
+	copy(args, cmdArgs)
+	args[0] = []byte("publish")
+	return cluster.db.Exec(conn, args)
+}
@@ -1,6 +1,12 @@
 package clus
 
-import "simple-godis/interface/resp"
+import (
+	"simple-godis/database"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/hashslot"
+	"simple-godis/resp/reply"
+	"strings"
+)
 
 type CmdLine = [][]byte
 type CmdFunc func(cluster *ClusterDatabase, c resp.Connection, cmdAndArgs [][]byte) resp.Reply
@@ -9,38 +15,94 @@ func makeRouter() map[string]CmdFunc {
 	routerMap := make(map[string]CmdFunc)
 	routerMap["ping"] = LocalRouter
 	routerMap["select"] = LocalRouter
+	routerMap["bgrewriteaof"] = LocalRouter
+	routerMap["save"] = LocalRouter
+	routerMap["bgsave"] = LocalRouter
+	routerMap["lastsave"] = LocalRouter
+	routerMap["info"] = LocalRouter
+	routerMap["acl"] = LocalRouter
+	routerMap["cluster"] = execCluster
+	routerMap["asking"] = execAsking
+	routerMap[relayRestore] = onRestore
+	routerMap[relayJoin] = onJoin
+	routerMap[relaySetSlots] = onSetSlots
+	routerMap[relayGossip] = onGossip
+	routerMap[relayFail] = onFail
+	routerMap[relayAuthRequest] = onAuthRequest
+	routerMap[relayManualStart] = onManualStart
+	routerMap[relayPrepare] = onPrepare
+	routerMap[relayCommit] = onCommit
+	routerMap[relayRollback] = onRollback
 
-	routerMap["del"] = ClusterDel
+	routerMap["replicaof"] = execReplicaOf
+	routerMap["slaveof"] = execReplicaOf
+	routerMap["role"] = LocalRouter
+	routerMap["psync"] = LocalRouter
+	routerMap["replconf"] = LocalRouter
+
+	// 需要在多个节点上执行的指令
+	routerMap["del"] = clusterCountKeys
+	routerMap["unlink"] = clusterCountKeys
+	routerMap["exists"] = clusterCountKeys
+	routerMap["touch"] = clusterCountKeys
+	routerMap["mget"] = clusterMGet
+	routerMap["mset"] = clusterMSet
 	routerMap["flush"] = ClusterFlushDB
 
-	routerMap["rename"] = clusterRename
-	routerMap["renamenx"] = clusterRename
-
-	routerMap["exists"] = defaultClusterRouter
-	routerMap["type"] = defaultClusterRouter
-
-	routerMap["set"] = defaultClusterRouter
-	routerMap["setnx"] = defaultClusterRouter
-	routerMap["get"] = defaultClusterRouter
-	routerMap["getset"] = defaultClusterRouter
-
-	routerMap["sAdd"] = defaultClusterRouter
-	routerMap["sIsMember"] = defaultClusterRouter
-	routerMap["sRem"] = defaultClusterRouter
-	routerMap["sMembers"] = defaultClusterRouter
-	routerMap["sCard"] = defaultClusterRouter
-	routerMap["sInter"] = defaultClusterRouter
-	routerMap["sUnion"] = defaultClusterRouter
-	routerMap["sDiff"] = defaultClusterRouter
-	routerMap["sPop"] = defaultClusterRouter
+	routerMap["publish"] = clusterPublish
+	routerMap[relayPublish] = onRelayPublish
+	routerMap["subscribe"] = LocalRouter
+	routerMap["unsubscribe"] = LocalRouter
+	routerMap["psubscribe"] = LocalRouter
+	routerMap["punsubscribe"] = LocalRouter
+	routerMap["pubsub"] = LocalRouter
+
+	// 事务中的key可能分布在不同节点上 集群模式下不支持事务
+	routerMap["multi"] = txNotSupported
+	routerMap["exec"] = txNotSupported
+	routerMap["discard"] = txNotSupported
+	routerMap["watch"] = txNotSupported
+	routerMap["unwatch"] = txNotSupported
+
+	// 其余注册的指令根据指令的元数据生成路由 不涉及key的指令在本地执行 涉及key的指令转发到key所在的节点
+	for name, cmd := range database.CommandTable {
+		if _, ok := routerMap[name]; ok || cmd.HasFlag(database.FlagNoCluster) {
+			continue
+		}
+		if cmd.HasKeys() {
+			routerMap[name] = clusterKeysRouter
+		} else {
+			routerMap[name] = LocalRouter
+		}
+	}
 	return routerMap
 }
 
 // defaultClusterRouter 集群间转发的默认方法
 func defaultClusterRouter(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
 	key := string(cmdArgs[1])
-	peer := cluster.peerPicker.PickNode(key) // PickNode是要找到对应的peer地址
-	return cluster.relay(peer, conn, cmdArgs)
+	return cluster.execOnSlot(conn, hashslot.KeySlot(key), key, cmdArgs) // 根据key所在的槽找到对应的节点
+}
+
+// clusterKeysRouter 涉及key的指令 所有的key必须在同一个槽中
+func clusterKeysRouter(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	keys, ok := database.GetCommandKeys(cmdArgs)
+	if !ok || len(keys) == 0 {
+		// 参数数量错误 由本地数据库回复错误
+		return cluster.db.Exec(conn, cmdArgs)
+	}
+	slot := hashslot.KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if hashslot.KeySlot(key) != slot {
+			return reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+	return cluster.execOnSlot(conn, slot, keys[0], cmdArgs)
+}
+
+// txNotSupported 集群模式下拒绝事务指令
+func txNotSupported(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	return reply.MakeErrReply("ERR " + strings.ToUpper(string(cmdArgs[0])) + " is not supported in cluster mode")
 }
 
 // LocalRouter 将指令转发到本地
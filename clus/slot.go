@@ -0,0 +1,342 @@
+package clus
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"simple-godis/lib/hashslot"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+槽位表 记录每个槽由哪个节点负责
+第一次启动时所有节点按地址排序后平分16384个槽 每个节点负责一段连续的槽 各个节点根据相同的配置计算出相同的槽位表
+迁移槽之后槽位表会发生变化 每次变化都会保存到集群配置文件中 重启后以配置文件为准
+每个节点认领槽时带有自己的配置纪元 节点之间通过心跳交换各自负责的槽 同一个槽以纪元更大的节点为准
+*/
+
+// slotRange 一段连续的槽 [start, end]
+type slotRange struct {
+	start int
+	end   int
+	node  string
+}
+
+// slotTable 槽到节点的映射 以及正在迁移的槽
+type slotTable struct {
+	mutex     sync.RWMutex
+	slots     [hashslot.SlotCount]string
+	migrating map[int]string // 正在迁出的槽 -> 目标节点
+	importing map[int]string // 正在迁入的槽 -> 源节点
+
+	epochs       map[string]int64 // 每个节点的配置纪元 节点接管新的槽时增大
+	currentEpoch int64            // 集群中见过的最大纪元
+}
+
+// makeEmptySlotTable 新建一个没有分配任何槽的槽位表
+func makeEmptySlotTable() *slotTable {
+	return &slotTable{
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+		epochs:    make(map[string]int64),
+	}
+}
+
+// makeSlotTable 将所有槽平均分配给nodes
+func makeSlotTable(nodes []string) *slotTable {
+	sorted := make([]string, len(nodes))
+	copy(sorted, nodes)
+	sort.Strings(sorted)
+	table := makeEmptySlotTable()
+	for i, node := range sorted {
+		start := i * hashslot.SlotCount / len(sorted)
+		end := (i + 1) * hashslot.SlotCount / len(sorted)
+		for slot := start; slot < end; slot++ {
+			table.slots[slot] = node
+		}
+	}
+	return table
+}
+
+// getNode 返回负责该槽的节点
+func (table *slotTable) getNode(slot int) string {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	return table.slots[slot]
+}
+
+// pickNode 返回负责该key的节点
+func (table *slotTable) pickNode(key string) string {
+	return table.getNode(hashslot.KeySlot(key))
+}
+
+// getState 返回负责该槽的节点 以及迁出的目标节点和迁入的源节点 没有迁移时为空
+func (table *slotTable) getState(slot int) (owner string, migratingTo string, importingFrom string) {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	return table.slots[slot], table.migrating[slot], table.importing[slot]
+}
+
+// setNode 将槽分配给node 同时结束该槽的迁移状态
+func (table *slotTable) setNode(slot int, node string) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.slots[slot] = node
+	delete(table.migrating, slot)
+	delete(table.importing, slot)
+}
+
+// setMigrating 标记槽正在迁出到target
+func (table *slotTable) setMigrating(slot int, target string) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.migrating[slot] = target
+}
+
+// setImporting 标记槽正在从source迁入
+func (table *slotTable) setImporting(slot int, source string) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.importing[slot] = source
+}
+
+// setStable 清除槽的迁移状态
+func (table *slotTable) setStable(slot int) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	delete(table.migrating, slot)
+	delete(table.importing, slot)
+}
+
+// nodeSet 返回槽位表中出现的所有节点
+func (table *slotTable) nodeSet() []string {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	set := make(map[string]struct{})
+	for _, node := range table.slots {
+		if node != "" {
+			set[node] = struct{}{}
+		}
+	}
+	nodes := make([]string, 0, len(set))
+	for node := range set {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// ranges 将槽位表合并成连续的区间 按槽的顺序返回
+func (table *slotTable) ranges() []*slotRange {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	var result []*slotRange
+	for slot, node := range table.slots {
+		if node == "" {
+			continue
+		}
+		last := len(result) - 1
+		if last >= 0 && result[last].node == node && result[last].end == slot-1 {
+			result[last].end = slot
+			continue
+		}
+		result = append(result, &slotRange{start: slot, end: slot, node: node})
+	}
+	return result
+}
+
+// setRanges 用ranges替换整个槽位表
+func (table *slotTable) setRanges(ranges []*slotRange) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.slots = [hashslot.SlotCount]string{}
+	for _, r := range ranges {
+		for slot := r.start; slot <= r.end; slot++ {
+			table.slots[slot] = r.node
+		}
+	}
+}
+
+// getEpoch 返回节点的配置纪元
+func (table *slotTable) getEpoch(node string) int64 {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	return table.epochs[node]
+}
+
+// getCurrentEpoch 返回集群中见过的最大纪元
+func (table *slotTable) getCurrentEpoch() int64 {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	return table.currentEpoch
+}
+
+// observeEpoch 收到其他节点的纪元 比自己见过的大时更新 返回是否发生了变化
+func (table *slotTable) observeEpoch(epoch int64) bool {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	if epoch <= table.currentEpoch {
+		return false
+	}
+	table.currentEpoch = epoch
+	return true
+}
+
+// bumpEpoch 为节点分配一个新的配置纪元 节点接管槽之后调用 使其他节点以它的认领为准
+func (table *slotTable) bumpEpoch(node string) int64 {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.currentEpoch++
+	table.epochs[node] = table.currentEpoch
+	return table.currentEpoch
+}
+
+// nextEpoch 为故障转移的选举生成一个新的纪元
+func (table *slotTable) nextEpoch() int64 {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	table.currentEpoch++
+	return table.currentEpoch
+}
+
+// claim 节点以epoch认领slots 槽没有负责的节点或者原来的节点纪元更小时交给node 返回槽位表是否发生了变化
+func (table *slotTable) claim(node string, epoch int64, slots []int) bool {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	changed := false
+	if epoch > table.epochs[node] {
+		table.epochs[node] = epoch
+		changed = true
+	}
+	if epoch > table.currentEpoch {
+		table.currentEpoch = epoch
+	}
+	for _, slot := range slots {
+		owner := table.slots[slot]
+		if owner == node || (owner != "" && table.epochs[owner] >= epoch) {
+			continue
+		}
+		table.slots[slot] = node
+		delete(table.migrating, slot)
+		delete(table.importing, slot)
+		changed = true
+	}
+	return changed
+}
+
+// slotsOf 返回节点负责的所有槽
+func (table *slotTable) slotsOf(node string) []int {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	var slots []int
+	for slot, n := range table.slots {
+		if n == node {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// migrations 返回正在迁出和迁入的槽 返回的是副本
+func (table *slotTable) migrations() (map[int]string, map[int]string) {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	migrating := make(map[int]string, len(table.migrating))
+	for slot, node := range table.migrating {
+		migrating[slot] = node
+	}
+	importing := make(map[int]string, len(table.importing))
+	for slot, node := range table.importing {
+		importing[slot] = node
+	}
+	return migrating, importing
+}
+
+// parseSlotRange 解析"start end node"
+func parseSlotRange(start string, end string, node string) (*slotRange, error) {
+	s, err1 := strconv.Atoi(start)
+	e, err2 := strconv.Atoi(end)
+	if err1 != nil || err2 != nil || s < 0 || e >= hashslot.SlotCount || s > e || node == "" {
+		return nil, errors.New("invalid slot range: " + start + " " + end + " " + node)
+	}
+	return &slotRange{start: s, end: e, node: node}, nil
+}
+
+// save 将槽位表写入集群配置文件 每行一段连续的槽"start end node"
+// 之后是每个节点的配置纪元"epoch node n"和"currentEpoch n" 先写临时文件再重命名
+func (table *slotTable) save(filename string) error {
+	var sb strings.Builder
+	for _, r := range table.ranges() {
+		sb.WriteString(strconv.Itoa(r.start) + " " + strconv.Itoa(r.end) + " " + r.node + "\n")
+	}
+	table.mutex.RLock()
+	nodes := make([]string, 0, len(table.epochs))
+	for node := range table.epochs {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		sb.WriteString("epoch " + node + " " + strconv.FormatInt(table.epochs[node], 10) + "\n")
+	}
+	sb.WriteString("currentEpoch " + strconv.FormatInt(table.currentEpoch, 10) + "\n")
+	table.mutex.RUnlock()
+	tmpName := filename + ".tmp"
+	if err := os.WriteFile(tmpName, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
+
+// loadSlotTable 从集群配置文件中读取槽位表 文件不存在时返回nil
+func loadSlotTable(filename string) (*slotTable, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	table := makeEmptySlotTable()
+	var ranges []*slotRange
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		invalid := errors.New("invalid line in cluster config file: " + scanner.Text())
+		switch {
+		case fields[0] == "currentEpoch" && len(fields) == 2:
+			epoch, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, invalid
+			}
+			table.currentEpoch = epoch
+		case fields[0] == "epoch" && len(fields) == 3:
+			epoch, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, invalid
+			}
+			table.epochs[fields[1]] = epoch
+		case len(fields) == 3:
+			r, err := parseSlotRange(fields[0], fields[1], fields[2])
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r)
+		default:
+			return nil, invalid
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	table.setRanges(ranges)
+	return table, nil
+}
@@ -0,0 +1,142 @@
+package clus
+
+import (
+	"simple-godis/interface/resp"
+	"simple-godis/lib/logger"
+	"simple-godis/resp/reply"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/*
+跨节点的原子指令 协调者为收到指令的节点
+1. 按节点地址的顺序依次向每个节点发送_prepare 节点锁住子指令涉及的key并记录回滚日志
+2. 全部预备成功后依次发送_commit 节点执行子指令并释放锁
+3. 任意一步失败时向已经预备的节点发送_rollback 已经提交的节点根据回滚日志恢复数据
+所有协调者都按照相同的顺序预备节点 节点内部按照相同的顺序加锁 事务之间不会互相等待形成死锁
+*/
+
+const (
+	relayPrepare  = "_prepare"  // _prepare txId dbIndex cmd [args ...]
+	relayCommit   = "_commit"   // _commit txId
+	relayRollback = "_rollback" // _rollback txId
+)
+
+// txSeq 事务编号 与节点地址和启动时间一起组成全局唯一的事务ID
+var (
+	txSeq       uint64
+	txStartTime = strconv.FormatInt(time.Now().UnixNano(), 36)
+)
+
+// makeTxId 生成事务ID
+func (cluster *ClusterDatabase) makeTxId() string {
+	return cluster.self + "-" + txStartTime + "-" + strconv.FormatUint(atomic.AddUint64(&txSeq, 1), 10)
+}
+
+// execAtomic 在多个节点上原子地执行各自的子指令 全部成功时返回第一个节点的回复
+func (cluster *ClusterDatabase) execAtomic(conn resp.Connection, cmdLines map[string]CmdLine) resp.Reply {
+	txId := cluster.makeTxId()
+	nodes := make([]string, 0, len(cmdLines))
+	for node := range cmdLines {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	dbIndex := []byte(strconv.Itoa(conn.GetDBIndex()))
+
+	prepared := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		args := append([][]byte{[]byte(relayPrepare), []byte(txId), dbIndex}, cmdLines[node]...)
+		result := cluster.sendTxCmd(node, conn, args)
+		if !isOkReply(result) {
+			cluster.rollbackTx(conn, txId, prepared)
+			return makeTxErrReply("prepare", node, result)
+		}
+		prepared = append(prepared, node)
+	}
+	var first resp.Reply
+	for _, node := range nodes {
+		result := cluster.sendTxCmd(node, conn, [][]byte{[]byte(relayCommit), []byte(txId)})
+		if reply.IsErrorReply(result) {
+			cluster.rollbackTx(conn, txId, nodes)
+			return makeTxErrReply("commit", node, result)
+		}
+		if first == nil {
+			first = result
+		}
+	}
+	return first
+}
+
+// rollbackTx 通知节点回滚事务 回滚失败的节点在超时后自动回滚没有提交的事务
+func (cluster *ClusterDatabase) rollbackTx(conn resp.Connection, txId string, nodes []string) {
+	for _, node := range nodes {
+		result := cluster.sendTxCmd(node, conn, [][]byte{[]byte(relayRollback), []byte(txId)})
+		if !isOkReply(result) {
+			logger.Warn("rollback transaction " + txId + " on " + node + " failed")
+		}
+	}
+}
+
+// makeTxErrReply 事务失败时的回复
+func makeTxErrReply(phase string, node string, result resp.Reply) resp.Reply {
+	msg := "unexpected reply"
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		msg = errReply.Error()
+	}
+	return reply.MakeErrReply("ERR " + phase + " on " + node + " failed: " + msg)
+}
+
+// sendTxCmd 向节点发送事务指令 节点是自己时直接执行
+func (cluster *ClusterDatabase) sendTxCmd(node string, conn resp.Connection, args [][]byte) resp.Reply {
+	if node != cluster.self {
+		return cluster.sendToPeer(node, args)
+	}
+	switch string(args[0]) {
+	case relayPrepare:
+		return onPrepare(cluster, conn, args)
+	case relayCommit:
+		return onCommit(cluster, conn, args)
+	default:
+		return onRollback(cluster, conn, args)
+	}
+}
+
+// onPrepare _prepare txId dbIndex cmd [args ...]
+func onPrepare(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 4 {
+		return reply.MakeArgNumErrReply(relayPrepare)
+	}
+	dbIndex, err := strconv.Atoi(string(cmdArgs[2]))
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid DB index")
+	}
+	if err := cluster.db.PrepareTx(string(cmdArgs[1]), dbIndex, cmdArgs[3:]); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// onCommit _commit txId 回复子指令的执行结果
+func onCommit(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) != 2 {
+		return reply.MakeArgNumErrReply(relayCommit)
+	}
+	result, err := cluster.db.CommitTx(string(cmdArgs[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return result
+}
+
+// onRollback _rollback txId
+func onRollback(cluster *ClusterDatabase, conn resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) != 2 {
+		return reply.MakeArgNumErrReply(relayRollback)
+	}
+	if err := cluster.db.RollbackTx(string(cmdArgs[1])); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeOkReply()
+}
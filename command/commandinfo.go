@@ -4,13 +4,16 @@ import (
 	"simple-godis/database"
 	"simple-godis/interface/resp"
 	"simple-godis/resp/reply"
+	"sort"
+	"strings"
 )
 
 func init() {
-	database.RegisterCommand("commands", executeCommands, 1)
+	database.RegisterCommand("commands", executeCommands, database.NoPrepare, 1, 0)
+	database.RegisterCommand("command", executeCommand, database.NoPrepare, -1, 0)
 }
 
-// executeDel 执行删除keys方法
+// executeCommands 返回所有指令的名称
 func executeCommands(db *database.DB, args [][]byte) resp.Reply {
 	return getAllGodisCommandReply()
 }
@@ -22,3 +25,56 @@ func getAllGodisCommandReply() resp.Reply {
 	}
 	return reply.MakeMultiRawReply(replies)
 }
+
+// executeCommand COMMAND [COUNT | INFO command-name [command-name ...]] 没有子命令时返回所有指令的信息
+func executeCommand(db *database.DB, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		names := make([]string, 0, len(database.CommandTable))
+		for name := range database.CommandTable {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		replies := make([]resp.Reply, 0, len(names))
+		for _, name := range names {
+			replies = append(replies, makeCommandInfoReply(name))
+		}
+		return reply.MakeMultiRawReply(replies)
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "count":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("command|count")
+		}
+		return reply.MakeIntReply(int64(len(database.CommandTable)))
+	case "info":
+		replies := make([]resp.Reply, 0, len(args)-1)
+		for _, name := range args[1:] {
+			replies = append(replies, makeCommandInfoReply(strings.ToLower(string(name))))
+		}
+		return reply.MakeMultiRawReply(replies)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try COMMAND HELP.")
+}
+
+// makeCommandInfoReply 指令的信息 name arity flags firstKey lastKey step 指令不存在时为nil
+func makeCommandInfoReply(name string) resp.Reply {
+	cmd, ok := database.CommandTable[name]
+	if !ok {
+		return reply.MakeNullBulkReply()
+	}
+	flagNames := cmd.FlagNames()
+	flags := make([]resp.Reply, 0, len(flagNames))
+	for _, flag := range flagNames {
+		flags = append(flags, reply.MakeStatusReply(flag))
+	}
+	firstKey, lastKey, step := cmd.KeySpec()
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(cmd.Name())),
+		reply.MakeIntReply(int64(cmd.Arity())),
+		reply.MakeMultiRawReply(flags),
+		reply.MakeIntReply(int64(firstKey)),
+		reply.MakeIntReply(int64(lastKey)),
+		reply.MakeIntReply(int64(step)),
+	})
+}
@@ -1,14 +1,19 @@
 package command
 
 import (
+	"math"
 	"simple-godis/database"
 	List "simple-godis/datastructure/list"
 	"simple-godis/datastructure/set"
 	"simple-godis/datastructure/smap"
+	"simple-godis/datastructure/sortedset"
+	dbInterface "simple-godis/interface/database"
 	"simple-godis/interface/resp"
 	"simple-godis/lib/utils"
 	"simple-godis/lib/wildcard"
 	"simple-godis/resp/reply"
+	"strconv"
+	"time"
 )
 
 /*
@@ -16,13 +21,22 @@ import (
 */
 
 func init() {
-	database.RegisterCommand("del", executeDel, -2)
-	database.RegisterCommand("exists", executeExists, -2)
-	database.RegisterCommand("flush", executeFlush, -1)
-	database.RegisterCommand("type", executeType, 2)
-	database.RegisterCommand("rename", executeRename, 3)
-	database.RegisterCommand("renameNx", executeRenameNx, 3)
-	database.RegisterCommand("keys", executeKeys, 2)
+	database.RegisterCommand("del", executeDel, database.WriteAllKeys, -2, database.FlagWrite).AttachKeys(1, -1, 1)
+	database.RegisterCommand("exists", executeExists, database.ReadAllKeys, -2, database.FlagReadOnly).AttachKeys(1, -1, 1)
+	database.RegisterCommand("unlink", executeDel, database.WriteAllKeys, -2, database.FlagWrite).AttachKeys(1, -1, 1)
+	database.RegisterCommand("touch", executeExists, database.ReadAllKeys, -2, database.FlagReadOnly).AttachKeys(1, -1, 1)
+	database.RegisterCommand("flush", executeFlush, database.NoPrepare, -1, database.FlagWrite|database.FlagAdmin)
+	database.RegisterCommand("type", executeType, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("rename", executeRename, prepareRename, 3, database.FlagWrite).AttachKeys(1, 2, 1)
+	database.RegisterCommand("renameNx", executeRenameNx, prepareRename, 3, database.FlagWrite).AttachKeys(1, 2, 1)
+	database.RegisterCommand("keys", executeKeys, database.NoPrepare, 2, database.FlagReadOnly)
+	database.RegisterCommand("expire", executeExpire, database.WriteFirstKey, 3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("pexpire", executePExpire, database.WriteFirstKey, 3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("expireAt", executeExpireAt, database.WriteFirstKey, 3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("pexpireAt", executePExpireAt, database.WriteFirstKey, 3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ttl", executeTTL, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("pttl", executePTTL, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("persist", executePersist, database.WriteFirstKey, 2, database.FlagWrite).AttachKeys(1, 1, 1)
 }
 
 // executeDel 执行删除keys方法
@@ -66,20 +80,26 @@ func executeType(db *database.DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeStatusReply("None")
 	}
-	// TODO:实现其他数据类型
 	switch entity.Data.(type) {
 	case []byte:
 		return reply.MakeStatusReply("string")
 	case List.List:
 		return reply.MakeStatusReply("list")
-	case set.Set:
+	case *set.Set:
 		return reply.MakeStatusReply("set")
 	case smap.Map:
 		return reply.MakeStatusReply("hash")
+	case *sortedset.SortedSet:
+		return reply.MakeStatusReply("zset")
 	}
 	return reply.MakeUnknownErrReply()
 }
 
+// prepareRename rename会同时写入源key和目标key
+func prepareRename(args [][]byte) ([]string, []string) {
+	return []string{string(args[0]), string(args[1])}, nil
+}
+
 // executeRename 键的重命名 rename key1 key2 执行会覆盖key2
 func executeRename(db *database.DB, args [][]byte) resp.Reply {
 	srcKey := string(args[0])
@@ -88,8 +108,14 @@ func executeRename(db *database.DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeErrReply(srcKey + "not exists")
 	}
+	expireTime, hasTTL := db.GetExpiration(srcKey)
 	db.PutEntity(destKey, entity)
 	db.RemoveEntity(srcKey)
+	// 过期时间随key一起转移
+	db.Persist(destKey)
+	if hasTTL {
+		db.Expire(destKey, expireTime)
+	}
 	db.AddAof(utils.ToCmdLine2("rename", args...))
 	return reply.MakeOkReply()
 }
@@ -106,8 +132,13 @@ func executeRenameNx(db *database.DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeErrReply(srcKey + "not exists")
 	}
+	expireTime, hasTTL := db.GetExpiration(srcKey)
 	db.PutEntity(destKey, entity)
 	db.RemoveEntity(srcKey)
+	db.Persist(destKey)
+	if hasTTL {
+		db.Expire(destKey, expireTime)
+	}
 	db.AddAof(utils.ToCmdLine2("renameNx", args...))
 	return reply.MakeIntReply(1)
 }
@@ -116,7 +147,7 @@ func executeRenameNx(db *database.DB, args [][]byte) resp.Reply {
 func executeKeys(db *database.DB, args [][]byte) resp.Reply {
 	pattern := wildcard.CompilePattern(string(args[0]))
 	result := make([][]byte, 0)
-	db.Data.ForEach(func(key string, val interface{}) bool {
+	db.ForEach(func(key string, entity *dbInterface.DataEntity, expiration *time.Time) bool {
 		if pattern.IsMatch(key) {
 			result = append(result, []byte(key))
 		}
@@ -124,3 +155,108 @@ func executeKeys(db *database.DB, args [][]byte) resp.Reply {
 	})
 	return reply.MakeMultiBulkReply(result)
 }
+
+// expireAfter 为key设置过期时间点 如果过期时间已经过去则直接删除key
+// 落盘时统一转换为pexpireat指令
+func expireAfter(db *database.DB, key string, expireTime time.Time) resp.Reply {
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if !expireTime.After(time.Now()) {
+		db.RemoveEntity(key)
+		db.AddAof(utils.ToCmdLine("del", key))
+		return reply.MakeIntReply(1)
+	}
+	db.Expire(key, expireTime)
+	db.AddAof(database.MakeExpireCmd(key, expireTime))
+	return reply.MakeIntReply(1)
+}
+
+// parseExpireArg 解析过期时间参数 绝对值超过limit时换算成纳秒或者毫秒会溢出 回复invalid expire time
+func parseExpireArg(arg []byte, limit int64, cmdName string) (int64, resp.Reply) {
+	value, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if value > limit || value < -limit {
+		return 0, reply.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	return value, nil
+}
+
+// executeExpire 以秒为单位设置key的存活时间 expire key seconds
+func executeExpire(db *database.DB, args [][]byte) resp.Reply {
+	ttl, errReply := parseExpireArg(args[1], math.MaxInt64/int64(time.Second), "expire")
+	if errReply != nil {
+		return errReply
+	}
+	return expireAfter(db, string(args[0]), time.Now().Add(time.Duration(ttl)*time.Second))
+}
+
+// executePExpire 以毫秒为单位设置key的存活时间 pexpire key milliseconds
+func executePExpire(db *database.DB, args [][]byte) resp.Reply {
+	ttl, errReply := parseExpireArg(args[1], math.MaxInt64/int64(time.Millisecond), "pexpire")
+	if errReply != nil {
+		return errReply
+	}
+	return expireAfter(db, string(args[0]), time.Now().Add(time.Duration(ttl)*time.Millisecond))
+}
+
+// executeExpireAt 以秒级时间戳设置key的过期时间点 expireat key timestamp
+func executeExpireAt(db *database.DB, args [][]byte) resp.Reply {
+	// 落盘时转换为毫秒级时间戳
+	timestamp, errReply := parseExpireArg(args[1], math.MaxInt64/1000, "expireat")
+	if errReply != nil {
+		return errReply
+	}
+	return expireAfter(db, string(args[0]), time.Unix(timestamp, 0))
+}
+
+// executePExpireAt 以毫秒级时间戳设置key的过期时间点 pexpireat key milliseconds-timestamp
+func executePExpireAt(db *database.DB, args [][]byte) resp.Reply {
+	timestamp, errReply := parseExpireArg(args[1], math.MaxInt64, "pexpireat")
+	if errReply != nil {
+		return errReply
+	}
+	return expireAfter(db, string(args[0]), time.UnixMilli(timestamp))
+}
+
+// remainingTTL 返回key剩余的存活时间 key不存在返回-2 没有设置过期时间返回-1
+func remainingTTL(db *database.DB, key string, unit time.Duration) resp.Reply {
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, ok := db.GetExpiration(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	// 向上取整 与redis保持一致
+	remaining := time.Until(expireTime)
+	return reply.MakeIntReply(int64((remaining + unit - 1) / unit))
+}
+
+// executeTTL 以秒为单位返回key剩余的存活时间
+func executeTTL(db *database.DB, args [][]byte) resp.Reply {
+	return remainingTTL(db, string(args[0]), time.Second)
+}
+
+// executePTTL 以毫秒为单位返回key剩余的存活时间
+func executePTTL(db *database.DB, args [][]byte) resp.Reply {
+	return remainingTTL(db, string(args[0]), time.Millisecond)
+}
+
+// executePersist 移除key的过期时间 使其永久有效
+func executePersist(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	result := db.Persist(key)
+	if result > 0 {
+		db.AddAof(utils.ToCmdLine("persist", key))
+	}
+	return reply.MakeIntReply(int64(result))
+}
@@ -0,0 +1,40 @@
+package command
+
+import (
+	"simple-godis/database"
+	"simple-godis/resp/reply"
+	"testing"
+)
+
+func TestExpire(t *testing.T) {
+	db := database.MakeDB()
+	notInteger := reply.MakeErrReply("ERR value is not an integer or out of range")
+	runTestCases(t, db, []cmdTestCase{
+		{executeSet, []string{"k", "v"}, reply.MakeOkReply()},
+		{executeTTL, []string{"k"}, reply.MakeIntReply(-1)},
+		{executeExpire, []string{"k", "100"}, reply.MakeIntReply(1)},
+		{executeTTL, []string{"k"}, reply.MakeIntReply(100)},
+		{executePersist, []string{"k"}, reply.MakeIntReply(1)},
+		{executePersist, []string{"k"}, reply.MakeIntReply(0)},
+		{executeTTL, []string{"k"}, reply.MakeIntReply(-1)},
+		{executeTTL, []string{"missing"}, reply.MakeIntReply(-2)},
+		{executeExpire, []string{"missing", "100"}, reply.MakeIntReply(0)},
+		// 换算成纳秒之后溢出的存活时间
+		{executeExpire, []string{"k", "9223372036"}, reply.MakeIntReply(1)},
+		{executeExpire, []string{"k", "9223372037"}, reply.MakeErrReply("ERR invalid expire time in 'expire' command")},
+		{executeExpire, []string{"k", "-9223372037"}, reply.MakeErrReply("ERR invalid expire time in 'expire' command")},
+		{executeExpire, []string{"k", "9223372036854775807"}, reply.MakeErrReply("ERR invalid expire time in 'expire' command")},
+		{executePExpire, []string{"k", "9223372036854"}, reply.MakeIntReply(1)},
+		{executePExpire, []string{"k", "9223372036855"}, reply.MakeErrReply("ERR invalid expire time in 'pexpire' command")},
+		{executeExpireAt, []string{"k", "9223372036854776"}, reply.MakeErrReply("ERR invalid expire time in 'expireat' command")},
+		{executeExpire, []string{"k", "abc"}, notInteger},
+		{executeExpire, []string{"k", "9223372036854775808"}, notInteger},
+		{executeExists, []string{"k"}, reply.MakeIntReply(1)},
+		// 过期时间已经过去时直接删除key
+		{executeExpire, []string{"k", "-1"}, reply.MakeIntReply(1)},
+		{executeExists, []string{"k"}, reply.MakeIntReply(0)},
+		{executeSet, []string{"k", "v"}, reply.MakeOkReply()},
+		{executePExpireAt, []string{"k", "1"}, reply.MakeIntReply(1)},
+		{executeTTL, []string{"k"}, reply.MakeIntReply(-2)},
+	})
+}
@@ -9,17 +9,17 @@ import (
 )
 
 func init() {
-	database.RegisterCommand("LPush", executeLPush, -3)
-	database.RegisterCommand("LPushX", executeLPushX, -3)
-	database.RegisterCommand("RPush", executeRPush, -3)
-	database.RegisterCommand("RPushX", executeRPushX, -3)
-	database.RegisterCommand("LPop", executeLPop, 2)
-	database.RegisterCommand("RPop", executeRPop, 2)
-	database.RegisterCommand("LIndex", executeLIndex, 3)
-	database.RegisterCommand("LSet", executeLSet, 4)
-	database.RegisterCommand("LRange", executeLRange, 4)
-	database.RegisterCommand("LRem", executeLRem, 3)
-	database.RegisterCommand("LLen", executeLLen, 2)
+	database.RegisterCommand("LPush", executeLPush, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("LPushX", executeLPushX, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("RPush", executeRPush, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("RPushX", executeRPushX, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("LPop", executeLPop, database.WriteFirstKey, 2, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("RPop", executeRPop, database.WriteFirstKey, 2, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("LIndex", executeLIndex, database.ReadFirstKey, 3, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("LSet", executeLSet, database.WriteFirstKey, 4, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("LRange", executeLRange, database.ReadFirstKey, 4, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("LRem", executeLRem, database.WriteFirstKey, 3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("LLen", executeLLen, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
 }
 
 // executeLIndex 查找下标为index的元素
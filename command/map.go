@@ -8,19 +8,19 @@ import (
 )
 
 func init() {
-	database.RegisterCommand("HSet", executeHSet, 4)
-	database.RegisterCommand("HSetNx", executeHSetNx, 4)
-	database.RegisterCommand("HGet", executeHGet, 3)
-	database.RegisterCommand("HDel", executeHDel, -3)
-	database.RegisterCommand("HExists", executeHExists, 3)
-	database.RegisterCommand("HMSet", executeHMSet, -3)
-	database.RegisterCommand("HMGet", executeHMGet, -3)
-	database.RegisterCommand("HMDel", executeHMDel, -3)
-	database.RegisterCommand("HKeys", executeHKeys, 2)
-	database.RegisterCommand("HValues", executeHValues, 2)
-	database.RegisterCommand("HGetAll", executeHGetAll, 2)
-	database.RegisterCommand("HLen", executeHLen, 2)
-	database.RegisterCommand("HStrlen", executeHStrlen, 3)
+	database.RegisterCommand("HSet", executeHSet, database.WriteFirstKey, 4, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HSetNx", executeHSetNx, database.WriteFirstKey, 4, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HGet", executeHGet, database.ReadFirstKey, 3, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HDel", executeHDel, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HExists", executeHExists, database.ReadFirstKey, 3, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HMSet", executeHMSet, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HMGet", executeHMGet, database.ReadFirstKey, -3, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HMDel", executeHMDel, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HKeys", executeHKeys, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HValues", executeHValues, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HGetAll", executeHGetAll, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HLen", executeHLen, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("HStrlen", executeHStrlen, database.ReadFirstKey, 3, database.FlagReadOnly).AttachKeys(1, 1, 1)
 }
 
 // executeHSet 将以key1为键的实体中添加映射(field,val)
@@ -304,18 +304,18 @@ func executeHGetAll(db *database.DB, args [][]byte) resp.Reply {
 		return errorReply
 	}
 	if iMap == nil {
-		return reply.MakeEmptyMultiBulkReply()
+		return reply.MakeMapReply(nil, nil)
 	}
 
 	mapSize := iMap.Len()
-	result := make([][]byte, mapSize*2)
-	cnt := 0
+	keys := make([]resp.Reply, 0, mapSize)
+	values := make([]resp.Reply, 0, mapSize)
 	iMap.ForEach(func(key string, val interface{}) bool {
-		result[cnt] = []byte(key)
-		cnt++
-		result[cnt], _ = val.([]byte)
-		cnt++
+		value, _ := val.([]byte)
+		keys = append(keys, reply.MakeBulkReply([]byte(key)))
+		values = append(values, reply.MakeBulkReply(value))
 		return true
 	})
-	return reply.MakeMultiBulkReply(result)
+	// RESP3中回复map RESP2中回复键值交替的数组
+	return reply.MakeMapReply(keys, values)
 }
@@ -13,5 +13,5 @@ func Ping(db *database.DB, args [][]byte) resp.Reply {
 
 // init 初始化时执行
 func init() {
-	database.RegisterCommand("ping", Ping, -1)
+	database.RegisterCommand("ping", Ping, database.NoPrepare, -1, 0)
 }
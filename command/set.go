@@ -10,15 +10,15 @@ import (
 )
 
 func init() {
-	database.RegisterCommand("sAdd", executeSAdd, -3)
-	database.RegisterCommand("sIsMember", executeSIsMember, 3)
-	database.RegisterCommand("sRem", executeSRemove, -3)
-	database.RegisterCommand("sMembers", executeSMembers, 2)
-	database.RegisterCommand("sCard", executeSCard, 2)
-	database.RegisterCommand("sInter", executeSIntersection, -2)
-	database.RegisterCommand("sUnion", executeUnion, -2)
-	database.RegisterCommand("sDiff", executeDiff, -2)
-	database.RegisterCommand("sPop", executeSPop, -2)
+	database.RegisterCommand("sAdd", executeSAdd, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("sIsMember", executeSIsMember, database.ReadFirstKey, 3, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("sRem", executeSRemove, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("sMembers", executeSMembers, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("sCard", executeSCard, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("sInter", executeSIntersection, database.ReadAllKeys, -2, database.FlagReadOnly).AttachKeys(1, -1, 1)
+	database.RegisterCommand("sUnion", executeUnion, database.ReadAllKeys, -2, database.FlagReadOnly).AttachKeys(1, -1, 1)
+	database.RegisterCommand("sDiff", executeDiff, database.ReadAllKeys, -2, database.FlagReadOnly).AttachKeys(1, -1, 1)
+	database.RegisterCommand("sPop", executeSPop, database.WriteFirstKey, -2, database.FlagWrite).AttachKeys(1, 1, 1)
 }
 
 // executeGet 执行获取一个键对应的value
@@ -102,7 +102,7 @@ func executeSMembers(db *database.DB, args [][]byte) resp.Reply {
 		i++
 		return true
 	})
-	return reply.MakeMultiBulkReply(members)
+	return reply.MakeSetReply(reply.BulkReplies(members))
 }
 
 // executeSCard 返回集合中的元素个数
@@ -130,7 +130,7 @@ func executeSIntersection(db *database.DB, args [][]byte) resp.Reply {
 			return errorReply
 		}
 		if set.Len() == 0 {
-			return reply.MakeEmptyMultiBulkReply()
+			return reply.MakeSetReply(nil)
 		}
 		sets = append(sets, set)
 	}
@@ -215,5 +215,5 @@ func set2reply(set *HashSet.Set) resp.Reply {
 		i++
 		return true
 	})
-	return reply.MakeMultiBulkReply(arr)
+	return reply.MakeSetReply(reply.BulkReplies(arr))
 }
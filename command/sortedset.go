@@ -0,0 +1,795 @@
+package command
+
+import (
+	"math"
+	"simple-godis/database"
+	HashSet "simple-godis/datastructure/set"
+	SortedSet "simple-godis/datastructure/sortedset"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/utils"
+	"simple-godis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	database.RegisterCommand("ZAdd", executeZAdd, database.WriteFirstKey, -4, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZRem", executeZRem, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZScore", executeZScore, database.ReadFirstKey, 3, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZMScore", executeZMScore, database.ReadFirstKey, -3, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZIncrBy", executeZIncrBy, database.WriteFirstKey, 4, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZCard", executeZCard, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZCount", executeZCount, database.ReadFirstKey, 4, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZRank", executeZRank, database.ReadFirstKey, 3, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZRevRank", executeZRevRank, database.ReadFirstKey, 3, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZRange", executeZRange, database.ReadFirstKey, -4, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZRangeByScore", executeZRangeByScore, database.ReadFirstKey, -4, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZRemRangeByRank", executeZRemRangeByRank, database.WriteFirstKey, 4, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZRemRangeByScore", executeZRemRangeByScore, database.WriteFirstKey, 4, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZPopMin", executeZPopMin, database.WriteFirstKey, -2, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZPopMax", executeZPopMax, database.WriteFirstKey, -2, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZUnionStore", executeZUnionStore, prepareZStore, -4, database.FlagWrite|database.FlagMovableKeys).AttachKeys(1, 1, 1)
+	database.RegisterCommand("ZInterStore", executeZInterStore, prepareZStore, -4, database.FlagWrite|database.FlagMovableKeys).AttachKeys(1, 1, 1)
+}
+
+// formatScore 将分数转换为字符串 无穷大与redis保持一致输出为inf和-inf
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	} else if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// parseScore 解析分数 不允许NaN
+func parseScore(arg []byte) (float64, bool) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// elements2reply 将元素列表转成二维字节数组的reply withScores为true时成员和分数交替返回
+func elements2reply(elements []*SortedSet.Element, withScores bool) resp.Reply {
+	size := len(elements)
+	if withScores {
+		size *= 2
+	}
+	result := make([][]byte, 0, size)
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, []byte(formatScore(element.Score)))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// normalizeRankRange 将可能为负数的排名区间[start, stop]转换为[start, stop)的形式
+// 区间为空时返回false
+func normalizeRankRange(start int64, stop int64, size int64) (int64, int64, bool) {
+	if start < -size {
+		start = 0
+	} else if start < 0 {
+		start = start + size
+	} else if start >= size {
+		return 0, 0, false
+	}
+	if stop < -size {
+		return 0, 0, false
+	} else if stop < 0 {
+		stop = stop + size + 1
+	} else if stop < size {
+		stop = stop + 1
+	} else {
+		stop = size
+	}
+	if stop <= start {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+// executeZAdd 向有序集合中添加成员或更新成员的分数
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func executeZAdd(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+	// 解析可选参数 遇到第一个不是选项的参数时停止
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "NX" {
+			nx = true
+		} else if option == "XX" {
+			xx = true
+		} else if option == "GT" {
+			gt = true
+		} else if option == "LT" {
+			lt = true
+		} else if option == "CH" {
+			ch = true
+		} else if option == "INCR" {
+			incr = true
+		} else {
+			break
+		}
+	}
+	if nx && xx {
+		return reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if incr && len(pairs) != 2 {
+		return reply.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+	elements := make([]*SortedSet.Element, len(pairs)/2)
+	for j := range elements {
+		score, ok := parseScore(pairs[2*j])
+		if !ok {
+			return reply.MakeErrReply("ERR value is not a valid float")
+		}
+		elements[j] = &SortedSet.Element{
+			Member: string(pairs[2*j+1]),
+			Score:  score,
+		}
+	}
+
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	added, changed := 0, 0
+	applied := make([]string, 0, len(pairs))
+	var incrResult *float64
+	for _, element := range elements {
+		var oldScore float64
+		exists := false
+		if sortedSet != nil {
+			var oldElement *SortedSet.Element
+			oldElement, exists = sortedSet.Get(element.Member)
+			if exists {
+				oldScore = oldElement.Score
+			}
+		}
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		score := element.Score
+		if incr && exists {
+			score += oldScore
+		}
+		if math.IsNaN(score) {
+			return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+		}
+		if exists && ((gt && score <= oldScore) || (lt && score >= oldScore)) {
+			continue
+		}
+		// 只有真正要写入时才初始化有序集合 避免XX时创建出空的key
+		if sortedSet == nil {
+			sortedSet, _, errorReply = db.GetOrInitSortedSet(key)
+			if errorReply != nil {
+				return errorReply
+			}
+		}
+		if !exists {
+			added++
+		} else if score != oldScore {
+			changed++
+		}
+		sortedSet.Add(element.Member, score)
+		applied = append(applied, formatScore(score), element.Member)
+		finalScore := score
+		incrResult = &finalScore
+	}
+	// 落盘时记录写入后的最终分数 保证重放结果确定
+	if len(applied) > 0 {
+		db.AddAof(utils.ToCmdLine(append([]string{"ZAdd", key}, applied...)...))
+	}
+	if incr {
+		if incrResult == nil {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply([]byte(formatScore(*incrResult)))
+	}
+	if ch {
+		return reply.MakeIntReply(int64(added + changed))
+	}
+	return reply.MakeIntReply(int64(added))
+}
+
+// executeZRem 删除有序集合中的一个或多个成员
+func executeZRem(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	var deleted int64 = 0
+	for _, member := range args[1:] {
+		if sortedSet.Remove(string(member)) {
+			deleted++
+		}
+	}
+	if sortedSet.Len() == 0 {
+		db.RemoveEntity(key)
+	}
+	if deleted > 0 {
+		db.AddAof(utils.ToCmdLine3("ZRem", args...))
+	}
+	return reply.MakeIntReply(deleted)
+}
+
+// executeZScore 返回有序集合中成员的分数
+func executeZScore(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	if sortedSet == nil {
+		return reply.MakeNullBulkReply()
+	}
+	element, exists := sortedSet.Get(member)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeDoubleReply(element.Score)
+}
+
+// executeZMScore 返回有序集合中多个成员的分数 不存在的成员返回nil
+func executeZMScore(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	members := args[1:]
+	result := make([][]byte, len(members))
+	if sortedSet == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+	for i, member := range members {
+		element, exists := sortedSet.Get(string(member))
+		if exists {
+			result[i] = []byte(formatScore(element.Score))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// executeZIncrBy 将有序集合中成员的分数增加increment 成员不存在时视为分数为0
+func executeZIncrBy(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	increment, ok := parseScore(args[1])
+	if !ok {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+	member := string(args[2])
+	sortedSet, _, errorReply := db.GetOrInitSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	score := increment
+	element, exists := sortedSet.Get(member)
+	if exists {
+		score += element.Score
+	}
+	if math.IsNaN(score) {
+		return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+	}
+	sortedSet.Add(member, score)
+	db.AddAof(utils.ToCmdLine3("ZIncrBy", args...))
+	return reply.MakeDoubleReply(score)
+}
+
+// executeZCard 返回有序集合中成员的数量
+func executeZCard(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.Len())
+}
+
+// executeZCount 返回分数在[min, max]范围内的成员数量
+func executeZCount(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.RangeCount(min, max))
+}
+
+// zRank 返回成员的排名 desc为true时按分数从大到小排名
+func zRank(db *database.DB, args [][]byte, desc bool) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	if sortedSet == nil {
+		return reply.MakeNullBulkReply()
+	}
+	rank := sortedSet.GetRank(member, desc)
+	if rank < 0 {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeIntReply(rank)
+}
+
+// executeZRank 返回成员按分数从小到大的排名
+func executeZRank(db *database.DB, args [][]byte) resp.Reply {
+	return zRank(db, args, false)
+}
+
+// executeZRevRank 返回成员按分数从大到小的排名
+func executeZRevRank(db *database.DB, args [][]byte) resp.Reply {
+	return zRank(db, args, true)
+}
+
+// zRangeOptions zrange指令解析后的可选参数
+type zRangeOptions struct {
+	byScore    bool
+	byLex      bool
+	rev        bool
+	withScores bool
+	hasLimit   bool
+	offset     int64
+	count      int64
+}
+
+// parseZRangeOptions 解析zrange系列指令的可选参数 [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func parseZRangeOptions(args [][]byte) (*zRangeOptions, resp.Reply) {
+	options := &zRangeOptions{
+		count: -1,
+	}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "BYSCORE":
+			options.byScore = true
+		case "BYLEX":
+			options.byLex = true
+		case "REV":
+			options.rev = true
+		case "WITHSCORES":
+			options.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			offset, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			count, err := strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			options.hasLimit = true
+			options.offset = offset
+			options.count = count
+			i += 2
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	if options.byScore && options.byLex {
+		return nil, reply.MakeSyntaxErrReply()
+	}
+	return options, nil
+}
+
+// executeZRange 按排名 分数或字典序返回有序集合中的成员
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func executeZRange(db *database.DB, args [][]byte) resp.Reply {
+	options, errReply := parseZRangeOptions(args[3:])
+	if errReply != nil {
+		return errReply
+	}
+	if options.hasLimit && !options.byScore && !options.byLex {
+		return reply.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if options.withScores && options.byLex {
+		return reply.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	key := string(args[0])
+	if options.byScore || options.byLex {
+		// REV时参数的顺序是max min
+		minArg, maxArg := args[1], args[2]
+		if options.rev {
+			minArg, maxArg = args[2], args[1]
+		}
+		var min, max SortedSet.Border
+		var err error
+		if options.byScore {
+			min, err = SortedSet.ParseScoreBorder(string(minArg))
+			if err == nil {
+				max, err = SortedSet.ParseScoreBorder(string(maxArg))
+			}
+		} else {
+			min, err = SortedSet.ParseLexBorder(string(minArg))
+			if err == nil {
+				max, err = SortedSet.ParseLexBorder(string(maxArg))
+			}
+		}
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return zRangeByBorder(db, key, min, max, options)
+	}
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	if sortedSet == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	start, stop, ok := normalizeRankRange(start, stop, sortedSet.Len())
+	if !ok {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return elements2reply(sortedSet.Range(start, stop, options.rev), options.withScores)
+}
+
+// zRangeByBorder 返回在边界范围内的成员
+func zRangeByBorder(db *database.DB, key string, min SortedSet.Border, max SortedSet.Border, options *zRangeOptions) resp.Reply {
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	if sortedSet == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	elements := sortedSet.RangeByBorder(min, max, options.offset, options.count, options.rev)
+	return elements2reply(elements, options.withScores)
+}
+
+// executeZRangeByScore 返回分数在[min, max]范围内的成员
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func executeZRangeByScore(db *database.DB, args [][]byte) resp.Reply {
+	options, errReply := parseZRangeOptions(args[3:])
+	if errReply != nil {
+		return errReply
+	}
+	if options.byScore || options.byLex || options.rev {
+		return reply.MakeSyntaxErrReply()
+	}
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return zRangeByBorder(db, string(args[0]), min, max, options)
+}
+
+// executeZRemRangeByRank 删除排名在[start, stop]范围内的成员
+func executeZRemRangeByRank(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	start, stop, ok := normalizeRankRange(start, stop, sortedSet.Len())
+	if !ok {
+		return reply.MakeIntReply(0)
+	}
+	removed := sortedSet.RemoveByRank(start, stop)
+	if sortedSet.Len() == 0 {
+		db.RemoveEntity(key)
+	}
+	if removed > 0 {
+		db.AddAof(utils.ToCmdLine3("ZRemRangeByRank", args...))
+	}
+	return reply.MakeIntReply(removed)
+}
+
+// executeZRemRangeByScore 删除分数在[min, max]范围内的成员
+func executeZRemRangeByScore(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	removed := sortedSet.RemoveRange(min, max)
+	if sortedSet.Len() == 0 {
+		db.RemoveEntity(key)
+	}
+	if removed > 0 {
+		db.AddAof(utils.ToCmdLine3("ZRemRangeByScore", args...))
+	}
+	return reply.MakeIntReply(removed)
+}
+
+// zPop 弹出分数最小或最大的count个成员 ZPOPMIN/ZPOPMAX key [count]
+func zPop(db *database.DB, args [][]byte, max bool) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+	count := 1
+	if len(args) == 2 {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = int(count64)
+	}
+	sortedSet, errorReply := db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return errorReply
+	}
+	if sortedSet == nil || count == 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	var removed []*SortedSet.Element
+	if max {
+		removed = sortedSet.PopMax(count)
+	} else {
+		removed = sortedSet.PopMin(count)
+	}
+	if sortedSet.Len() == 0 {
+		db.RemoveEntity(key)
+	}
+	// 弹出的结果依赖当前数据 落盘时转换成删除具体成员的指令
+	if len(removed) > 0 {
+		aofLine := make([]string, 0, len(removed)+2)
+		aofLine = append(aofLine, "ZRem", key)
+		for _, element := range removed {
+			aofLine = append(aofLine, element.Member)
+		}
+		db.AddAof(utils.ToCmdLine(aofLine...))
+	}
+	return elements2reply(removed, true)
+}
+
+// executeZPopMin 弹出分数最小的count个成员
+func executeZPopMin(db *database.DB, args [][]byte) resp.Reply {
+	return zPop(db, args, false)
+}
+
+// executeZPopMax 弹出分数最大的count个成员
+func executeZPopMax(db *database.DB, args [][]byte) resp.Reply {
+	return zPop(db, args, true)
+}
+
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+// aggregate 按聚合方式合并两个分数
+func aggregate(policy int, a float64, b float64) float64 {
+	switch policy {
+	case aggregateMin:
+		return math.Min(a, b)
+	case aggregateMax:
+		return math.Max(a, b)
+	}
+	result := a + b
+	// inf + -inf 的结果为NaN 与redis保持一致视为0
+	if math.IsNaN(result) {
+		return 0
+	}
+	return result
+}
+
+// getAsScoreMap 以key为键获取有序集合或集合 并转换为成员到分数的映射 集合中成员的分数视为1
+func getAsScoreMap(db *database.DB, key string) (map[string]float64, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return map[string]float64{}, nil
+	}
+	switch data := entity.Data.(type) {
+	case *SortedSet.SortedSet:
+		result := make(map[string]float64, data.Len())
+		if data.Len() > 0 {
+			data.ForEach(0, data.Len(), false, func(element *SortedSet.Element) bool {
+				result[element.Member] = element.Score
+				return true
+			})
+		}
+		return result, nil
+	case *HashSet.Set:
+		result := make(map[string]float64, data.Len())
+		data.ForEach(func(member string) bool {
+			result[member] = 1
+			return true
+		})
+		return result, nil
+	}
+	return nil, reply.MakeWrongTypeReply()
+}
+
+// zStore 计算多个有序集合的并集或交集并保存到destination
+// ZUNIONSTORE/ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func zStore(db *database.DB, args [][]byte, cmdName string, union bool) resp.Reply {
+	dest := string(args[0])
+	numKeys, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return reply.MakeErrReply("ERR at least 1 input key is needed for '" + strings.ToLower(cmdName) + "' command")
+	}
+	if int64(len(args)-2) < numKeys {
+		return reply.MakeSyntaxErrReply()
+	}
+	keys := args[2 : 2+numKeys]
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	policy := aggregateSum
+	options := args[2+numKeys:]
+	for i := 0; i < len(options); i++ {
+		switch strings.ToUpper(string(options[i])) {
+		case "WEIGHTS":
+			if int64(len(options)-i-1) < numKeys {
+				return reply.MakeSyntaxErrReply()
+			}
+			for j := int64(0); j < numKeys; j++ {
+				weight, ok := parseScore(options[i+1+int(j)])
+				if !ok {
+					return reply.MakeErrReply("ERR weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += int(numKeys)
+		case "AGGREGATE":
+			if i+1 >= len(options) {
+				return reply.MakeSyntaxErrReply()
+			}
+			switch strings.ToUpper(string(options[i+1])) {
+			case "SUM":
+				policy = aggregateSum
+			case "MIN":
+				policy = aggregateMin
+			case "MAX":
+				policy = aggregateMax
+			default:
+				return reply.MakeSyntaxErrReply()
+			}
+			i++
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	var result map[string]float64
+	for i, key := range keys {
+		scores, errorReply := getAsScoreMap(db, string(key))
+		if errorReply != nil {
+			return errorReply
+		}
+		weighted := make(map[string]float64, len(scores))
+		for member, score := range scores {
+			weighted[member] = score * weights[i]
+			if math.IsNaN(weighted[member]) {
+				weighted[member] = 0
+			}
+		}
+		if i == 0 {
+			result = weighted
+			continue
+		}
+		if union {
+			for member, score := range weighted {
+				if old, ok := result[member]; ok {
+					result[member] = aggregate(policy, old, score)
+				} else {
+					result[member] = score
+				}
+			}
+		} else {
+			for member, old := range result {
+				score, ok := weighted[member]
+				if !ok {
+					delete(result, member)
+					continue
+				}
+				result[member] = aggregate(policy, old, score)
+			}
+		}
+	}
+
+	// 结果会覆盖destination原有的值
+	db.RemoveEntity(dest)
+	if len(result) > 0 {
+		sortedSet, _, errorReply := db.GetOrInitSortedSet(dest)
+		if errorReply != nil {
+			return errorReply
+		}
+		for member, score := range result {
+			sortedSet.Add(member, score)
+		}
+	}
+	db.AddAof(utils.ToCmdLine3(cmdName, args...))
+	return reply.MakeIntReply(int64(len(result)))
+}
+
+// prepareZStore 写入destination 读取numkeys个源key
+func prepareZStore(args [][]byte) ([]string, []string) {
+	dest := string(args[0])
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+		return []string{dest}, nil
+	}
+	keys := make([]string, numKeys)
+	for i := 0; i < numKeys; i++ {
+		keys[i] = string(args[i+2])
+	}
+	return []string{dest}, keys
+}
+
+// executeZUnionStore 计算多个有序集合的并集并保存到destination
+func executeZUnionStore(db *database.DB, args [][]byte) resp.Reply {
+	return zStore(db, args, "ZUnionStore", true)
+}
+
+// executeZInterStore 计算多个有序集合的交集并保存到destination
+func executeZInterStore(db *database.DB, args [][]byte) resp.Reply {
+	return zStore(db, args, "ZInterStore", false)
+}
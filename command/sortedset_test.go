@@ -0,0 +1,130 @@
+package command
+
+import (
+	"simple-godis/database"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/utils"
+	"simple-godis/resp/reply"
+	"testing"
+)
+
+// cmdTestCase 在同一个数据库中依次执行的指令和期望的回复
+type cmdTestCase struct {
+	executor database.ExecuteCommand
+	args     []string
+	want     resp.Reply
+}
+
+func runTestCases(t *testing.T, db *database.DB, tests []cmdTestCase) {
+	t.Helper()
+	for i, tt := range tests {
+		got := tt.executor(db, utils.ToCmdLine(tt.args...))
+		if string(got.ToBytes()) != string(tt.want.ToBytes()) {
+			t.Errorf("case %d %v: expected %q, got %q", i, tt.args, tt.want.ToBytes(), got.ToBytes())
+		}
+	}
+}
+
+// withScores 成员和分数交替的ZRANGE WITHSCORES回复
+func withScores(memberScores ...string) resp.Reply {
+	return reply.MakeMultiBulkReply(utils.ToCmdLine(memberScores...))
+}
+
+func TestZAdd(t *testing.T) {
+	db := database.MakeDB()
+	syntaxErr := reply.MakeSyntaxErrReply()
+	runTestCases(t, db, []cmdTestCase{
+		{executeZAdd, []string{"z", "1", "a", "2", "b"}, reply.MakeIntReply(2)},
+		{executeZAdd, []string{"z", "NX", "5", "a", "3", "c"}, reply.MakeIntReply(1)},
+		{executeZAdd, []string{"z", "XX", "5", "a", "4", "d"}, reply.MakeIntReply(0)},
+		{executeZAdd, []string{"z", "XX", "CH", "6", "a", "4", "d"}, reply.MakeIntReply(1)},
+		{executeZAdd, []string{"z", "GT", "1", "a"}, reply.MakeIntReply(0)},
+		{executeZAdd, []string{"z", "GT", "CH", "7", "a"}, reply.MakeIntReply(1)},
+		{executeZAdd, []string{"z", "LT", "CH", "8", "a", "1", "b"}, reply.MakeIntReply(1)},
+		{executeZAdd, []string{"z", "CH", "1", "b"}, reply.MakeIntReply(0)},
+		{executeZRange, []string{"z", "0", "-1", "WITHSCORES"}, withScores("b", "1", "c", "3", "a", "7")},
+		// GT和LT只限制更新已有的成员 新成员总是会被添加
+		{executeZAdd, []string{"z", "GT", "5", "new"}, reply.MakeIntReply(1)},
+		{executeZAdd, []string{"z", "CH", "1", "new", "9", "e"}, reply.MakeIntReply(2)},
+		{executeZAdd, []string{"z", "INCR", "2", "b"}, reply.MakeBulkReply([]byte("3"))},
+		{executeZAdd, []string{"z", "INCR", "1.5", "f"}, reply.MakeBulkReply([]byte("1.5"))},
+		{executeZAdd, []string{"z", "INCR", "NX", "1", "b"}, reply.MakeNullBulkReply()},
+		{executeZAdd, []string{"z", "INCR", "XX", "1", "x"}, reply.MakeNullBulkReply()},
+		{executeZAdd, []string{"z", "INCR", "GT", "-1", "b"}, reply.MakeNullBulkReply()},
+		{executeZAdd, []string{"z", "INCR", "LT", "-1", "b"}, reply.MakeBulkReply([]byte("2"))},
+		{executeZAdd, []string{"z", "INCR", "+inf", "e"}, reply.MakeBulkReply([]byte("inf"))},
+		{executeZAdd, []string{"z", "INCR", "-inf", "e"}, reply.MakeErrReply("ERR resulting score is not a number (NaN)")},
+		{executeZRange, []string{"z", "0", "-1", "WITHSCORES"},
+			withScores("new", "1", "f", "1.5", "b", "2", "c", "3", "a", "7", "e", "inf")},
+		{executeZAdd, []string{"z", "NX", "XX", "1", "a"}, reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")},
+		{executeZAdd, []string{"z", "GT", "LT", "1", "a"}, reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")},
+		{executeZAdd, []string{"z", "NX", "GT", "1", "a"}, reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")},
+		{executeZAdd, []string{"z", "INCR", "1", "a", "2", "b"}, reply.MakeErrReply("ERR INCR option supports a single increment-element pair")},
+		{executeZAdd, []string{"z", "1"}, syntaxErr},
+		{executeZAdd, []string{"z", "1", "a", "2"}, syntaxErr},
+		{executeZAdd, []string{"z", "abc", "a"}, reply.MakeErrReply("ERR value is not a valid float")},
+		{executeZAdd, []string{"z", "nan", "a"}, reply.MakeErrReply("ERR value is not a valid float")},
+		{executeZCard, []string{"z"}, reply.MakeIntReply(6)},
+		// XX不会创建空的有序集合
+		{executeZAdd, []string{"missing", "XX", "1", "a"}, reply.MakeIntReply(0)},
+		{executeZAdd, []string{"missing", "XX", "INCR", "1", "a"}, reply.MakeNullBulkReply()},
+		{executeLPush, []string{"list", "a"}, reply.MakeIntReply(1)},
+		{executeZAdd, []string{"list", "1", "a"}, reply.MakeWrongTypeReply()},
+	})
+	if _, exists := db.GetEntity("missing"); exists {
+		t.Fatal("ZADD XX created an empty key")
+	}
+}
+
+func TestZStore(t *testing.T) {
+	db := database.MakeDB()
+	runTestCases(t, db, []cmdTestCase{
+		{executeZAdd, []string{"z1", "1", "a", "2", "b", "3", "c"}, reply.MakeIntReply(3)},
+		{executeZAdd, []string{"z2", "10", "b", "20", "c", "30", "d"}, reply.MakeIntReply(3)},
+		{executeSAdd, []string{"s", "b", "d"}, reply.MakeIntReply(2)},
+
+		{executeZUnionStore, []string{"out", "2", "z1", "z2"}, reply.MakeIntReply(4)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("a", "1", "b", "12", "c", "23", "d", "30")},
+		{executeZUnionStore, []string{"out", "2", "z1", "z2", "WEIGHTS", "2", "0.5"}, reply.MakeIntReply(4)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("a", "2", "b", "9", "d", "15", "c", "16")},
+		{executeZUnionStore, []string{"out", "2", "z1", "z2", "AGGREGATE", "MIN"}, reply.MakeIntReply(4)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("a", "1", "b", "2", "c", "3", "d", "30")},
+		{executeZUnionStore, []string{"out", "2", "z1", "z2", "weights", "1", "-1", "aggregate", "max"}, reply.MakeIntReply(4)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("d", "-30", "a", "1", "b", "2", "c", "3")},
+		// 集合中成员的分数视为1
+		{executeZUnionStore, []string{"out", "2", "z1", "s"}, reply.MakeIntReply(4)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("a", "1", "d", "1", "b", "3", "c", "3")},
+
+		{executeZInterStore, []string{"out", "2", "z1", "z2"}, reply.MakeIntReply(2)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("b", "12", "c", "23")},
+		{executeZInterStore, []string{"out", "2", "z1", "z2", "WEIGHTS", "2", "1", "AGGREGATE", "MAX"}, reply.MakeIntReply(2)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("b", "10", "c", "20")},
+		{executeZInterStore, []string{"out", "2", "z1", "z2", "AGGREGATE", "MIN", "WEIGHTS", "3", "0.1"}, reply.MakeIntReply(2)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("b", "1", "c", "2")},
+		{executeZInterStore, []string{"out", "3", "z1", "z2", "s"}, reply.MakeIntReply(1)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("b", "13")},
+		// 结果为空时删除destination
+		{executeZInterStore, []string{"out", "2", "z1", "missing"}, reply.MakeIntReply(0)},
+		{executeZCard, []string{"out"}, reply.MakeIntReply(0)},
+		// destination也可以是源key
+		{executeZUnionStore, []string{"z1", "2", "z1", "z2"}, reply.MakeIntReply(4)},
+		{executeZRange, []string{"z1", "0", "-1", "WITHSCORES"}, withScores("a", "1", "b", "12", "c", "23", "d", "30")},
+
+		// inf与-inf相加的结果视为0
+		{executeZAdd, []string{"pinf", "+inf", "a"}, reply.MakeIntReply(1)},
+		{executeZAdd, []string{"ninf", "-inf", "a"}, reply.MakeIntReply(1)},
+		{executeZUnionStore, []string{"out", "2", "pinf", "ninf"}, reply.MakeIntReply(1)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("a", "0")},
+		{executeZUnionStore, []string{"out", "1", "pinf", "WEIGHTS", "0"}, reply.MakeIntReply(1)},
+		{executeZRange, []string{"out", "0", "-1", "WITHSCORES"}, withScores("a", "0")},
+
+		{executeZUnionStore, []string{"out", "0", "z1"}, reply.MakeErrReply("ERR at least 1 input key is needed for 'zunionstore' command")},
+		{executeZInterStore, []string{"out", "3", "z1", "z2"}, reply.MakeSyntaxErrReply()},
+		{executeZUnionStore, []string{"out", "2", "z1", "z2", "WEIGHTS", "1"}, reply.MakeSyntaxErrReply()},
+		{executeZUnionStore, []string{"out", "2", "z1", "z2", "WEIGHTS", "1", "x"}, reply.MakeErrReply("ERR weight value is not a float")},
+		{executeZUnionStore, []string{"out", "2", "z1", "z2", "AGGREGATE", "AVG"}, reply.MakeSyntaxErrReply()},
+		{executeZUnionStore, []string{"out", "2", "z1", "z2", "AGGREGATE"}, reply.MakeSyntaxErrReply()},
+		{executeLPush, []string{"list", "a"}, reply.MakeIntReply(1)},
+		{executeZInterStore, []string{"out", "2", "z1", "list"}, reply.MakeWrongTypeReply()},
+	})
+}
@@ -1,12 +1,15 @@
 package command
 
 import (
+	"math"
 	"simple-godis/database"
 	dbInterface "simple-godis/interface/database"
 	"simple-godis/interface/resp"
 	"simple-godis/lib/utils"
 	"simple-godis/resp/reply"
 	"strconv"
+	"strings"
+	"time"
 )
 
 /*
@@ -14,15 +17,17 @@ import (
 */
 
 func init() {
-	database.RegisterCommand("get", executeGet, -2)
-	database.RegisterCommand("set", executeSet, 3)
-	database.RegisterCommand("setnx", executeSetnx, 3)
-	database.RegisterCommand("getset", executeGetAndSet, 3)
-	database.RegisterCommand("strlen", executeStrLen, 2)
-	database.RegisterCommand("append", executeAppend, 3)
-	database.RegisterCommand("getDel", executeGetAndDel, 2)
-	database.RegisterCommand("incr", executeIncr, -2)
-	database.RegisterCommand("decr", executeDecr, -2)
+	database.RegisterCommand("get", executeGet, database.ReadFirstKey, -2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("set", executeSet, database.WriteFirstKey, -3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("setnx", executeSetnx, database.WriteFirstKey, 3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("getset", executeGetAndSet, database.WriteFirstKey, 3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("strlen", executeStrLen, database.ReadFirstKey, 2, database.FlagReadOnly).AttachKeys(1, 1, 1)
+	database.RegisterCommand("append", executeAppend, database.WriteFirstKey, 3, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("getDel", executeGetAndDel, database.WriteFirstKey, 2, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("incr", executeIncr, database.WriteFirstKey, -2, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("decr", executeDecr, database.WriteFirstKey, -2, database.FlagWrite).AttachKeys(1, 1, 1)
+	database.RegisterCommand("mget", executeMGet, database.ReadAllKeys, -2, database.FlagReadOnly).AttachKeys(1, -1, 1)
+	database.RegisterCommand("mset", executeMSet, prepareMSet, -3, database.FlagWrite).AttachKeys(1, -1, 2)
 }
 
 // executeGet 执行获取一个键对应的value
@@ -43,6 +48,11 @@ func executeGet(db *database.DB, args [][]byte) resp.Reply {
 	if iMap != nil {
 		return executeHGetAll(db, args)
 	}
+	// 判断要取的key是不是一个有序集合 如果是就改为查询有序集合内的所有元素和分数
+	sortedSet, _ := db.GetAsSortedSet(key)
+	if sortedSet != nil {
+		return elements2reply(sortedSet.Range(0, sortedSet.Len(), false), true)
+	}
 	entity, exists := db.GetEntity(key)
 	if !exists {
 		return reply.MakeNullBulkReply()
@@ -51,16 +61,145 @@ func executeGet(db *database.DB, args [][]byte) resp.Reply {
 	return reply.MakeBulkReply(respMes)
 }
 
-// executeGet 执行获取一个键对应的value
+const (
+	upsertPolicy = iota // 默认策略 无论key是否存在都写入
+	insertPolicy        // NX 仅当key不存在时写入
+	updatePolicy        // XX 仅当key存在时写入
+)
+
+const (
+	noTTL   = iota // 不设置过期时间 覆盖原有的过期时间
+	withTTL        // EX PX EXAT PXAT 设置过期时间
+	keepTTL        // KEEPTTL 保留原有的过期时间
+)
+
+// setOptions set指令解析后的可选参数
+type setOptions struct {
+	policy     int
+	ttlPolicy  int
+	expireTime time.Time
+	get        bool
+}
+
+// setExpireLimits 过期时间参数的上限 超过时换算成纳秒或者落盘使用的毫秒级时间戳会溢出
+var setExpireLimits = map[string]int64{
+	"EX":   math.MaxInt64 / int64(time.Second),
+	"PX":   math.MaxInt64 / int64(time.Millisecond),
+	"EXAT": math.MaxInt64 / 1000,
+	"PXAT": math.MaxInt64,
+}
+
+// parseSetOptions 解析set指令key value之后的可选参数
+// SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|KEEPTTL]
+func parseSetOptions(args [][]byte) (*setOptions, resp.Reply) {
+	options := &setOptions{
+		policy:    upsertPolicy,
+		ttlPolicy: noTTL,
+	}
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch arg {
+		case "NX":
+			if options.policy == updatePolicy {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			options.policy = insertPolicy
+		case "XX":
+			if options.policy == insertPolicy {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			options.policy = updatePolicy
+		case "GET":
+			options.get = true
+		case "KEEPTTL":
+			if options.ttlPolicy != noTTL {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			options.ttlPolicy = keepTTL
+		case "EX", "PX", "EXAT", "PXAT":
+			// 过期时间参数只能出现一次 并且后面必须跟一个数值
+			if options.ttlPolicy != noTTL || i+1 >= len(args) {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			i++
+			value, errReply := parseExpireArg(args[i], setExpireLimits[arg], "set")
+			if errReply != nil {
+				return nil, errReply
+			}
+			if value <= 0 {
+				return nil, reply.MakeErrReply("ERR invalid expire time in 'set' command")
+			}
+			switch arg {
+			case "EX":
+				options.expireTime = time.Now().Add(time.Duration(value) * time.Second)
+			case "PX":
+				options.expireTime = time.Now().Add(time.Duration(value) * time.Millisecond)
+			case "EXAT":
+				options.expireTime = time.Unix(value, 0)
+			case "PXAT":
+				options.expireTime = time.UnixMilli(value)
+			}
+			options.ttlPolicy = withTTL
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return options, nil
+}
+
+// executeSet 设置一个键对应的value 支持NX XX GET以及过期时间等可选参数
 func executeSet(db *database.DB, args [][]byte) resp.Reply {
 	key := string(args[0])
 	val := args[1]
+	options, errReply := parseSetOptions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	// GET参数需要返回旧值 旧值不是字符串时报错且不执行写入
+	var oldVal []byte
+	if options.get {
+		var errorReply reply.ErrorReply
+		oldVal, errorReply = db.GetAsString(key)
+		if errorReply != nil {
+			return errorReply
+		}
+	}
 	entity := &dbInterface.DataEntity{
 		Data: val,
 	}
-	db.PutEntity(key, entity)
-	db.AddAof(utils.ToCmdLine2("set", args...))
-	return reply.MakeOkReply()
+	var result int
+	switch options.policy {
+	case upsertPolicy:
+		db.PutEntity(key, entity)
+		result = 1
+	case insertPolicy:
+		result = db.PutEntityIfAbsent(key, entity)
+	case updatePolicy:
+		result = db.PutEntityIfExists(key, entity)
+	}
+	if result > 0 {
+		// 落盘时统一转换成不带条件的set指令 过期时间转换为绝对时间戳 保证重放的结果确定
+		switch options.ttlPolicy {
+		case withTTL:
+			db.Expire(key, options.expireTime)
+			db.AddAof(utils.ToCmdLine("set", key, string(val), "PXAT", strconv.FormatInt(options.expireTime.UnixMilli(), 10)))
+		case keepTTL:
+			db.AddAof(utils.ToCmdLine("set", key, string(val), "KEEPTTL"))
+		default:
+			db.Persist(key) // set会覆盖key原有的过期时间
+			db.AddAof(utils.ToCmdLine("set", key, string(val)))
+		}
+	}
+	if options.get {
+		if oldVal == nil {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply(oldVal)
+	}
+	if result > 0 {
+		return reply.MakeOkReply()
+	}
+	return reply.MakeNullBulkReply()
 }
 
 // executeSetnx 如果对应的key不存在，执行获取一个键对应的value
@@ -71,7 +210,10 @@ func executeSetnx(db *database.DB, args [][]byte) resp.Reply {
 		Data: val,
 	}
 	result := db.PutEntityIfAbsent(key, entity)
-	db.AddAof(utils.ToCmdLine2("setnx", args...))
+	// key已经存在时没有写入 不需要落盘
+	if result > 0 {
+		db.AddAof(utils.ToCmdLine2("setnx", args...))
+	}
 	return reply.MakeIntReply(int64(result))
 }
 
@@ -86,6 +228,7 @@ func executeGetAndSet(db *database.DB, args [][]byte) resp.Reply {
 	db.PutEntity(key, &dbInterface.DataEntity{
 		Data: val,
 	})
+	db.Persist(key)
 	db.AddAof(utils.ToCmdLine2("getset", args...))
 	return reply.MakeBulkReply(entity)
 }
@@ -207,3 +350,43 @@ func executeDecr(db *database.DB, args [][]byte) resp.Reply {
 		return reply.MakeIntReply(-1)
 	}
 }
+
+// executeMGet 返回所有key对应的value 不存在或者不是字符串的key返回nil
+func executeMGet(db *database.DB, args [][]byte) resp.Reply {
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		entity, exists := db.GetEntity(string(arg))
+		if !exists {
+			continue
+		}
+		if bytes, ok := entity.Data.([]byte); ok {
+			result[i] = bytes
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// prepareMSet mset key value [key value ...] 写入所有的key
+func prepareMSet(args [][]byte) ([]string, []string) {
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, string(args[i]))
+	}
+	return keys, nil
+}
+
+// executeMSet 同时设置多个key的值 清除它们原有的过期时间
+func executeMSet(db *database.DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	for i := 0; i < len(args); i += 2 {
+		key := string(args[i])
+		db.PutEntity(key, &dbInterface.DataEntity{
+			Data: args[i+1],
+		})
+		db.Persist(key)
+	}
+	db.AddAof(utils.ToCmdLine2("mset", args...))
+	return reply.MakeOkReply()
+}
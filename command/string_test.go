@@ -0,0 +1,74 @@
+package command
+
+import (
+	"simple-godis/database"
+	"simple-godis/resp/reply"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	db := database.MakeDB()
+	bulk := func(s string) *reply.BulkReply { return reply.MakeBulkReply([]byte(s)) }
+	runTestCases(t, db, []cmdTestCase{
+		{executeSet, []string{"k", "v1"}, reply.MakeOkReply()},
+		{executeSet, []string{"k", "v2", "NX"}, reply.MakeNullBulkReply()},
+		{executeGet, []string{"k"}, bulk("v1")},
+		{executeSet, []string{"k", "v2", "xx"}, reply.MakeOkReply()},
+		{executeSet, []string{"new", "v", "XX"}, reply.MakeNullBulkReply()},
+		{executeExists, []string{"new"}, reply.MakeIntReply(0)},
+		{executeSet, []string{"new", "v", "NX"}, reply.MakeOkReply()},
+		{executeSetnx, []string{"new", "other"}, reply.MakeIntReply(0)},
+		{executeSetnx, []string{"nx", "v"}, reply.MakeIntReply(1)},
+		// GET返回旧值 NX没有写入时也返回旧值
+		{executeSet, []string{"k", "v3", "GET"}, bulk("v2")},
+		{executeSet, []string{"k", "v4", "NX", "GET"}, bulk("v3")},
+		{executeGet, []string{"k"}, bulk("v3")},
+		{executeSet, []string{"missing", "v", "GET"}, reply.MakeNullBulkReply()},
+		{executeLPush, []string{"list", "a"}, reply.MakeIntReply(1)},
+		{executeSet, []string{"list", "v", "GET"}, reply.MakeWrongTypeReply()},
+		{executeType, []string{"list"}, reply.MakeStatusReply("list")},
+
+		// 过期时间
+		{executeSet, []string{"k", "v", "EX", "100"}, reply.MakeOkReply()},
+		{executeTTL, []string{"k"}, reply.MakeIntReply(100)},
+		{executeSet, []string{"k", "v", "KEEPTTL"}, reply.MakeOkReply()},
+		{executeTTL, []string{"k"}, reply.MakeIntReply(100)},
+		{executeSet, []string{"k", "v"}, reply.MakeOkReply()},
+		{executeTTL, []string{"k"}, reply.MakeIntReply(-1)},
+		{executeSet, []string{"k", "v", "PX", "100000"}, reply.MakeOkReply()},
+		{executeTTL, []string{"k"}, reply.MakeIntReply(100)},
+		{executeSet, []string{"k", "v", "PXAT", "1"}, reply.MakeOkReply()},
+		{executeTTL, []string{"k"}, reply.MakeIntReply(-2)},
+	})
+}
+
+func TestSetOptionErrors(t *testing.T) {
+	db := database.MakeDB()
+	syntaxErr := reply.MakeSyntaxErrReply()
+	invalidExpire := reply.MakeErrReply("ERR invalid expire time in 'set' command")
+	notInteger := reply.MakeErrReply("ERR value is not an integer or out of range")
+	runTestCases(t, db, []cmdTestCase{
+		{executeSet, []string{"k", "v"}, reply.MakeOkReply()},
+		// 互相冲突的参数
+		{executeSet, []string{"k", "x", "NX", "XX"}, syntaxErr},
+		{executeSet, []string{"k", "x", "XX", "NX"}, syntaxErr},
+		{executeSet, []string{"k", "x", "EX", "10", "PX", "10"}, syntaxErr},
+		{executeSet, []string{"k", "x", "EX", "10", "EX", "10"}, syntaxErr},
+		{executeSet, []string{"k", "x", "EX", "10", "KEEPTTL"}, syntaxErr},
+		{executeSet, []string{"k", "x", "KEEPTTL", "PXAT", "10"}, syntaxErr},
+		{executeSet, []string{"k", "x", "EX"}, syntaxErr},
+		{executeSet, []string{"k", "x", "FOO"}, syntaxErr},
+		// 非法的过期时间 包括换算后会溢出的值
+		{executeSet, []string{"k", "x", "EX", "abc"}, notInteger},
+		{executeSet, []string{"k", "x", "EX", "0"}, invalidExpire},
+		{executeSet, []string{"k", "x", "PX", "-1"}, invalidExpire},
+		{executeSet, []string{"k", "x", "EX", "9223372037"}, invalidExpire},
+		{executeSet, []string{"k", "x", "EX", "9223372036854775807"}, invalidExpire},
+		{executeSet, []string{"k", "x", "PX", "9223372036855"}, invalidExpire},
+		{executeSet, []string{"k", "x", "EXAT", "9223372036854776"}, invalidExpire},
+		// 参数有错误时不会写入
+		{executeGet, []string{"k"}, reply.MakeBulkReply([]byte("v"))},
+		{executeTTL, []string{"k"}, reply.MakeIntReply(-1)},
+		{executeSet, []string{"k", "x", "EX", "9223372036"}, reply.MakeOkReply()},
+	})
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"simple-godis/database"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/utils"
+	"simple-godis/resp/client"
+	"simple-godis/resp/reply"
+	"testing"
+)
+
+func makeInternalClient() *client.Client {
+	c := client.NewClient(nil)
+	c.SetInternal(true)
+	return c
+}
+
+// execExpect 执行指令并检查回复
+func execExpect(t *testing.T, db *database.StandaloneDatabase, c resp.Connection, want resp.Reply, args ...string) {
+	t.Helper()
+	got := db.Exec(c, utils.ToCmdLine(args...))
+	if string(got.ToBytes()) != string(want.ToBytes()) {
+		t.Errorf("%v: expected %q, got %q", args, want.ToBytes(), got.ToBytes())
+	}
+}
+
+func TestMultiExec(t *testing.T) {
+	db := database.MakeBasicStandaloneDatabase()
+	c := makeInternalClient()
+	queued := reply.MakeStatusReply("QUEUED")
+
+	execExpect(t, db, c, reply.MakeOkReply(), "multi")
+	execExpect(t, db, c, reply.MakeErrReply("ERR MULTI calls can not be nested"), "multi")
+	execExpect(t, db, c, queued, "set", "k", "1")
+	execExpect(t, db, c, queued, "incr", "k")
+	execExpect(t, db, c, queued, "get", "k")
+	execExpect(t, db, c, reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeOkReply(),
+		reply.MakeIntReply(2),
+		reply.MakeBulkReply([]byte("2")),
+	}), "exec")
+	execExpect(t, db, c, reply.MakeErrReply("ERR EXEC without MULTI"), "exec")
+
+	// 入队时出错的事务整个放弃
+	execExpect(t, db, c, reply.MakeOkReply(), "multi")
+	execExpect(t, db, c, queued, "set", "k", "3")
+	execExpect(t, db, c, reply.MakeArgNumErrReply("get"), "get")
+	execExpect(t, db, c, reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors."), "exec")
+	execExpect(t, db, c, reply.MakeBulkReply([]byte("2")), "get", "k")
+
+	// DISCARD之后队列中的指令不会执行
+	execExpect(t, db, c, reply.MakeOkReply(), "multi")
+	execExpect(t, db, c, queued, "set", "k", "4")
+	execExpect(t, db, c, reply.MakeOkReply(), "discard")
+	execExpect(t, db, c, reply.MakeErrReply("ERR DISCARD without MULTI"), "discard")
+	execExpect(t, db, c, reply.MakeBulkReply([]byte("2")), "get", "k")
+}
+
+func TestWatch(t *testing.T) {
+	db := database.MakeBasicStandaloneDatabase()
+	c1, c2 := makeInternalClient(), makeInternalClient()
+	queued := reply.MakeStatusReply("QUEUED")
+
+	// WATCH的key被其他客户端修改 EXEC放弃事务
+	execExpect(t, db, c1, reply.MakeOkReply(), "watch", "k")
+	execExpect(t, db, c2, reply.MakeOkReply(), "set", "k", "other")
+	execExpect(t, db, c1, reply.MakeOkReply(), "multi")
+	execExpect(t, db, c1, reply.MakeErrReply("ERR WATCH inside MULTI is not allowed"), "watch", "k")
+	execExpect(t, db, c1, queued, "set", "k", "mine")
+	execExpect(t, db, c1, reply.MakeNullBulkReply(), "exec")
+	execExpect(t, db, c1, reply.MakeBulkReply([]byte("other")), "get", "k")
+
+	// EXEC之后不再WATCH 没有被修改的key事务正常执行
+	execExpect(t, db, c1, reply.MakeOkReply(), "multi")
+	execExpect(t, db, c1, queued, "set", "k", "mine")
+	execExpect(t, db, c1, reply.MakeMultiRawReply([]resp.Reply{reply.MakeOkReply()}), "exec")
+
+	// UNWATCH之后的修改不影响事务
+	execExpect(t, db, c1, reply.MakeOkReply(), "watch", "k")
+	execExpect(t, db, c1, reply.MakeOkReply(), "unwatch")
+	execExpect(t, db, c2, reply.MakeOkReply(), "set", "k", "other")
+	execExpect(t, db, c1, reply.MakeOkReply(), "multi")
+	execExpect(t, db, c1, queued, "get", "k")
+	execExpect(t, db, c1, reply.MakeMultiRawReply([]resp.Reply{reply.MakeBulkReply([]byte("other"))}), "exec")
+}
@@ -16,28 +16,106 @@ type ServerProperties struct {
 	Port           int    `cfg:"port"`
 	AppendOnly     bool   `cfg:"appendOnly"`
 	AppendFilename string `cfg:"appendFilename"`
-	MaxClients     int    `cfg:"maxClients"`
-	RequirePass    string `cfg:"requirePass"`
+	AppendFsync    string `cfg:"appendfsync"` // aof文件的fsync策略 always everysec no
+	MaxClients     int    `cfg:"maxClients"`  // 最大连接数 超过时拒绝新的连接
+	Timeout        int    `cfg:"timeout"`     // 客户端空闲超过该秒数时关闭连接 0表示不关闭
+	RequirePass    string `cfg:"requirePass"` // 客户端需要使用AUTH认证的密码 为空时不需要认证
+	ACLFile        string `cfg:"aclfile"`     // 保存ACL用户的文件 启动时加载 ACL SAVE时写入
 	Databases      int    `cfg:"databases"`
 
-	Peers []string `cfg:"peers"`
-	Self  string   `cfg:"self"`
+	ProtoInlineMaxSize      int    `cfg:"proto-inline-max-size"`      // 一行内联指令的最大长度 支持kb mb gb单位 默认为64kb
+	ProtoMaxBulkLen         int    `cfg:"proto-max-bulk-len"`         // 指令中一个参数的最大长度 支持kb mb gb单位 默认为512mb
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit"` // 各类客户端的输出缓冲区限制 "class hard soft seconds [class hard soft seconds ...]"
+
+	DBFilename string `cfg:"dbfilename"` // 快照文件名
+	Save       string `cfg:"save"`       // 自动保存快照的规则 "seconds changes [seconds changes ...]"
+
+	AutoAofRewritePercentage int `cfg:"auto-aof-rewrite-percentage"` // aof文件相对上次重写后增长的百分比 0表示关闭自动重写
+	AutoAofRewriteMinSize    int `cfg:"auto-aof-rewrite-min-size"`   // 自动重写时aof文件的最小大小 支持kb mb gb单位
+
+	ReplicaOf       string `cfg:"replicaof"`         // 启动时复制的主节点 "host port"
+	ReplicaReadOnly bool   `cfg:"replica-read-only"` // 从节点是否只读
+	ReplBacklogSize int    `cfg:"repl-backlog-size"` // 复制积压缓冲区的大小 支持kb mb gb单位
+	MasterAuth      string `cfg:"masterauth"`        // 连接主节点时认证使用的密码
+
+	Peers              []string `cfg:"peers"`
+	Self               string   `cfg:"self"`
+	ClusterConfigFile  string   `cfg:"cluster-config-file"`  // 保存槽位表的集群配置文件 默认为nodes.conf
+	ClusterNodeTimeout int      `cfg:"cluster-node-timeout"` // 节点超过该毫秒数没有回复心跳时被认为疑似下线
+	ClusterPassword    string   `cfg:"cluster-password"`     // 集群节点之间认证使用的密码 集群模式下必须配置
 }
 
+// Version 服务端的版本 HELLO中返回
+const Version = "1.0.0"
+
 // Properties holds global config properties
 var Properties *ServerProperties
 
+// ClusterEnabled 配置了自己和其他节点的地址时以集群模式运行
+func (properties *ServerProperties) ClusterEnabled() bool {
+	return properties.Self != "" && len(properties.Peers) > 0
+}
+
 func init() {
 	// 默认配置
 	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
+		Bind:                     "127.0.0.1",
+		Port:                     6379,
+		AppendOnly:               false,
+		AppendFsync:              "everysec",
+		MaxClients:               defaultMaxClients,
+		AutoAofRewritePercentage: defaultAutoAofRewritePercentage,
+		AutoAofRewriteMinSize:    defaultAutoAofRewriteMinSize,
+		ReplicaReadOnly:          true,
+		ReplBacklogSize:          defaultReplBacklogSize,
+		ClusterNodeTimeout:       defaultClusterNodeTimeout,
+	}
+}
+
+const (
+	defaultMaxClients               = 10000
+	defaultAutoAofRewritePercentage = 100
+	defaultAutoAofRewriteMinSize    = 64 << 20
+	defaultReplBacklogSize          = 1 << 20
+	defaultClusterNodeTimeout       = 15000
+)
+
+// ParseSize 解析带有kb mb gb单位的大小 单位不区分大小写
+func ParseSize(value string) (int64, error) {
+	value = strings.ToLower(value)
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"gb", 1 << 30},
+		{"mb", 1 << 20},
+		{"kb", 1 << 10},
+		{"g", 1000 * 1000 * 1000},
+		{"m", 1000 * 1000},
+		{"k", 1000},
 	}
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			n, err := strconv.ParseInt(strings.TrimSuffix(value, unit.suffix), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * unit.scale, nil
+		}
+	}
+	return strconv.ParseInt(value, 10, 64)
 }
 
 func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+	config := &ServerProperties{
+		AppendFsync:              "everysec",
+		MaxClients:               defaultMaxClients,
+		AutoAofRewritePercentage: defaultAutoAofRewritePercentage,
+		AutoAofRewriteMinSize:    defaultAutoAofRewriteMinSize,
+		ReplicaReadOnly:          true,
+		ReplBacklogSize:          defaultReplBacklogSize,
+		ClusterNodeTimeout:       defaultClusterNodeTimeout,
+	}
 
 	// read config file
 	rawMap := make(map[string]string)
@@ -76,7 +154,7 @@ func parse(src io.Reader) *ServerProperties {
 			case reflect.String:
 				fieldVal.SetString(value)
 			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
+				intValue, err := ParseSize(value)
 				if err == nil {
 					fieldVal.SetInt(intValue)
 				}
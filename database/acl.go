@@ -0,0 +1,450 @@
+package database
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"simple-godis/config"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/wildcard"
+	"simple-godis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+访问控制列表
+每个用户有启用状态 密码 可以执行的指令和可以访问的key的模式
+指令规则按照设置的顺序生效 +cmd -cmd +@category -@category 后面的规则覆盖前面的规则
+指令的分类由指令的标志和aclExtraCategories决定
+内部连接不做检查 例如加载AOF 执行复制流 使用集群密码认证的节点 内部连接由显式的标记决定 与用户名无关
+以_开头的集群节点之间的内部指令只有内部连接可以执行 任何用户规则都不能开放
+*/
+
+// DefaultUser 新建立的连接使用的用户 requirePass为它的密码
+const DefaultUser = "default"
+
+// aclCategories 所有的指令分类
+var aclCategories = []string{"all", "read", "write", "admin", "dangerous", "connection", "transaction", "pubsub"}
+
+// aclExtraCategories 不能从指令的标志得到的分类 包括不在指令表中的指令
+var aclExtraCategories = map[string][]string{
+	"ping":         {"connection"},
+	"select":       {"connection"},
+	"exit":         {"connection"},
+	"asking":       {"connection"},
+	"command":      {"connection"},
+	"client":       {"admin", "connection", "dangerous"},
+	"commands":     {"connection"},
+	"keys":         {"dangerous"},
+	"info":         {"dangerous"},
+	"acl":          {"admin", "dangerous"},
+	"save":         {"admin", "dangerous"},
+	"bgsave":       {"admin", "dangerous"},
+	"lastsave":     {"admin", "dangerous"},
+	"bgrewriteaof": {"admin", "dangerous"},
+	"replicaof":    {"admin", "dangerous"},
+	"slaveof":      {"admin", "dangerous"},
+	"role":         {"admin", "dangerous"},
+	"psync":        {"admin", "dangerous"},
+	"replconf":     {"admin", "dangerous"},
+	"cluster":      {"admin", "dangerous"},
+	"multi":        {"transaction"},
+	"exec":         {"transaction"},
+	"discard":      {"transaction"},
+	"watch":        {"transaction"},
+	"unwatch":      {"transaction"},
+	"subscribe":    {"pubsub"},
+	"unsubscribe":  {"pubsub"},
+	"psubscribe":   {"pubsub"},
+	"punsubscribe": {"pubsub"},
+	"publish":      {"pubsub"},
+	"pubsub":       {"pubsub"},
+}
+
+// aclUser 一个用户的权限
+type aclUser struct {
+	name        string
+	enabled     bool
+	nopass      bool                // 任意密码都可以认证
+	passwords   []string            // 密码的sha256
+	cmdRules    []string            // 按顺序生效的指令规则
+	keyPatterns []string            // 可以访问的key的模式
+	patterns    []*wildcard.Pattern // 编译后的keyPatterns
+}
+
+// aclTable 所有的用户
+type aclTable struct {
+	mutex sync.RWMutex
+	users map[string]*aclUser
+}
+
+var acl = &aclTable{users: map[string]*aclUser{DefaultUser: makeDefaultUser()}}
+
+// makeDefaultUser 默认用户可以执行所有指令 访问所有key 配置了requirePass时需要使用该密码认证
+func makeDefaultUser() *aclUser {
+	user := &aclUser{name: DefaultUser}
+	rules := []string{"on", "~*", "+@all", "nopass"}
+	if config.Properties.RequirePass != "" {
+		rules[3] = ">" + config.Properties.RequirePass
+	}
+	for _, rule := range rules {
+		_ = user.applyRule(rule)
+	}
+	return user
+}
+
+// initACL 根据requirePass重置默认用户 配置了ACL文件并且文件存在时加载其中的用户
+func initACL() error {
+	acl.mutex.Lock()
+	acl.users = map[string]*aclUser{DefaultUser: makeDefaultUser()}
+	acl.mutex.Unlock()
+	if config.Properties.ACLFile == "" {
+		return nil
+	}
+	if _, err := os.Stat(config.Properties.ACLFile); os.IsNotExist(err) {
+		return nil
+	}
+	return loadACLFile(config.Properties.ACLFile)
+}
+
+// hashPassword 保存和比较的都是密码的sha256
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// isKnownCommand 判断指令是否存在
+func isKnownCommand(cmdName string) bool {
+	if _, ok := CommandTable[cmdName]; ok {
+		return true
+	}
+	_, ok := aclExtraCategories[cmdName]
+	return ok
+}
+
+// isKnownCategory 判断分类是否存在
+func isKnownCategory(category string) bool {
+	for _, c := range aclCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// inCategory 判断指令是否属于分类
+func inCategory(cmdName string, category string) bool {
+	if category == "all" {
+		return true
+	}
+	for _, c := range aclExtraCategories[cmdName] {
+		if c == category {
+			return true
+		}
+	}
+	cmd, ok := CommandTable[cmdName]
+	if !ok {
+		return false
+	}
+	switch category {
+	case "read":
+		return cmd.HasFlag(FlagReadOnly)
+	case "write":
+		return cmd.HasFlag(FlagWrite)
+	case "admin", "dangerous":
+		return cmd.HasFlag(FlagAdmin)
+	}
+	return false
+}
+
+// clone 复制一个用户 修改失败时不影响原来的用户
+func (user *aclUser) clone() *aclUser {
+	c := *user
+	c.passwords = append([]string(nil), user.passwords...)
+	c.cmdRules = append([]string(nil), user.cmdRules...)
+	c.keyPatterns = append([]string(nil), user.keyPatterns...)
+	c.patterns = append([]*wildcard.Pattern(nil), user.patterns...)
+	return &c
+}
+
+// applyRule 修改用户的一条规则
+func (user *aclUser) applyRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		user.enabled = true
+		return nil
+	case "off":
+		user.enabled = false
+		return nil
+	case "nopass":
+		user.nopass = true
+		user.passwords = nil
+		return nil
+	case "resetpass":
+		user.nopass = false
+		user.passwords = nil
+		return nil
+	case "allkeys":
+		rule = "~*"
+	case "resetkeys":
+		user.keyPatterns = nil
+		user.patterns = nil
+		return nil
+	case "allcommands":
+		rule = "+@all"
+	case "nocommands":
+		rule = "-@all"
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "nocommands", "off"} {
+			_ = user.applyRule(r)
+		}
+		return nil
+	}
+	if len(rule) < 2 {
+		return errors.New("Syntax error")
+	}
+	switch rule[0] {
+	case '>':
+		user.addPassword(hashPassword(rule[1:]))
+	case '<':
+		user.removePassword(hashPassword(rule[1:]))
+	case '#':
+		if _, err := hex.DecodeString(rule[1:]); err != nil || len(rule) != 1+sha256.Size*2 {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		user.addPassword(rule[1:])
+	case '!':
+		user.removePassword(rule[1:])
+	case '~':
+		user.keyPatterns = append(user.keyPatterns, rule[1:])
+		user.patterns = append(user.patterns, wildcard.CompilePattern(rule[1:]))
+	case '+', '-':
+		name := strings.ToLower(rule[1:])
+		if strings.HasPrefix(name, "@") {
+			if !isKnownCategory(name[1:]) {
+				return errors.New("Unknown command category")
+			}
+		} else if !isKnownCommand(name) {
+			return errors.New("Unknown command")
+		}
+		rule = rule[:1] + name
+		// 对所有指令生效的规则会覆盖之前所有的规则
+		if name == "@all" {
+			user.cmdRules = nil
+		}
+		user.cmdRules = append(user.cmdRules, rule)
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+// addPassword 添加一个密码的sha256
+func (user *aclUser) addPassword(hash string) {
+	user.nopass = false
+	for _, h := range user.passwords {
+		if h == hash {
+			return
+		}
+	}
+	user.passwords = append(user.passwords, hash)
+}
+
+// removePassword 删除一个密码的sha256
+func (user *aclUser) removePassword(hash string) {
+	for i, h := range user.passwords {
+		if h == hash {
+			user.passwords = append(user.passwords[:i], user.passwords[i+1:]...)
+			return
+		}
+	}
+}
+
+// checkPassword 判断用户能否使用password认证
+func (user *aclUser) checkPassword(password string) bool {
+	if !user.enabled {
+		return false
+	}
+	if user.nopass {
+		return true
+	}
+	hash := hashPassword(password)
+	for _, h := range user.passwords {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// canRun 判断用户能否执行指令 最后一条匹配的规则生效
+func (user *aclUser) canRun(cmdName string) bool {
+	allowed := false
+	for _, rule := range user.cmdRules {
+		name := rule[1:]
+		if strings.HasPrefix(name, "@") {
+			if inCategory(cmdName, name[1:]) {
+				allowed = rule[0] == '+'
+			}
+		} else if name == cmdName {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+// canAccess 判断用户能否访问key
+func (user *aclUser) canAccess(key string) bool {
+	for _, pattern := range user.patterns {
+		if pattern.IsMatch(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe 将用户的权限描述为规则 ACL LIST和ACL文件使用该格式
+func (user *aclUser) describe() string {
+	parts := []string{"user", user.name}
+	if user.enabled {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if user.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, hash := range user.passwords {
+		parts = append(parts, "#"+hash)
+	}
+	for _, pattern := range user.keyPatterns {
+		parts = append(parts, "~"+pattern)
+	}
+	parts = append(parts, user.describeCommands())
+	return strings.Join(parts, " ")
+}
+
+// describeCommands 用户的指令规则 没有规则时为-@all
+func (user *aclUser) describeCommands() string {
+	if len(user.cmdRules) == 0 {
+		return "-@all"
+	}
+	return strings.Join(user.cmdRules, " ")
+}
+
+// getUser 根据名称找到用户
+func (table *aclTable) getUser(name string) *aclUser {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	return table.users[name]
+}
+
+// sortedUsers 按照名称排序的所有用户
+func (table *aclTable) sortedUsers() []*aclUser {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	users := make([]*aclUser, 0, len(table.users))
+	for _, user := range table.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].name < users[j].name
+	})
+	return users
+}
+
+// Authenticate 判断能否使用用户名和密码认证
+func Authenticate(username string, password string) bool {
+	user := acl.getUser(username)
+	return user != nil && user.checkPassword(password)
+}
+
+// DefaultUserNoPass 默认用户不需要密码时 新建立的连接不需要认证
+func DefaultUserNoPass() bool {
+	user := acl.getUser(DefaultUser)
+	return user != nil && user.enabled && user.nopass
+}
+
+// CheckPermission 检查连接的用户能否执行指令以及访问指令中的key 没有权限时返回NOPERM错误
+func CheckPermission(conn resp.Connection, cmdLine CmdLine) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	keys, _ := GetCommandKeys(cmdLine)
+	return checkPermission(conn, cmdName, keys)
+}
+
+// checkPermission 检查连接的用户能否执行cmdName以及访问keys
+func checkPermission(conn resp.Connection, cmdName string, keys []string) resp.Reply {
+	if conn.IsInternal() {
+		return nil
+	}
+	username := conn.GetUser()
+	user := acl.getUser(username)
+	if user == nil || strings.HasPrefix(cmdName, "_") || !user.canRun(cmdName) {
+		return reply.MakeErrReply("NOPERM User " + username + " has no permissions to run the '" + cmdName + "' command")
+	}
+	for _, key := range keys {
+		if !user.canAccess(key) {
+			return reply.MakeErrReply("NOPERM No permissions to access a key")
+		}
+	}
+	return nil
+}
+
+// loadACLFile 加载ACL文件 每行为user name rule [rule ...] 有错误时不修改已有的用户
+func loadACLFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	users := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		position := filename + ":" + strconv.Itoa(lineNum) + ": "
+		if fields[0] != "user" || len(fields) < 2 {
+			return errors.New(position + "should start with user keyword followed by the username")
+		}
+		user := &aclUser{name: fields[1]}
+		for _, rule := range fields[2:] {
+			if err := user.applyRule(rule); err != nil {
+				return errors.New(position + err.Error() + ". '" + rule + "'")
+			}
+		}
+		users[user.name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	acl.mutex.Lock()
+	defer acl.mutex.Unlock()
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = acl.users[DefaultUser]
+	}
+	acl.users = users
+	return nil
+}
+
+// saveACLFile 将所有用户写入ACL文件 先写入临时文件再重命名
+func saveACLFile(filename string) error {
+	var buf strings.Builder
+	for _, user := range acl.sortedUsers() {
+		buf.WriteString(user.describe())
+		buf.WriteString("\n")
+	}
+	tmpFile := filename + ".tmp"
+	if err := os.WriteFile(tmpFile, []byte(buf.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, filename)
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"simple-godis/config"
+	"simple-godis/interface/resp"
+	"simple-godis/resp/reply"
+	"sort"
+	"strings"
+)
+
+// execACL ACL SETUSER|GETUSER|DELUSER|LIST|WHOAMI|CAT|SAVE|LOAD
+func execACL(conn resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("acl")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "setuser":
+		if len(args) < 3 {
+			return reply.MakeArgNumErrReply("acl|setuser")
+		}
+		return aclSetUser(string(args[2]), args[3:])
+	case "getuser":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("acl|getuser")
+		}
+		return aclGetUser(string(args[2]))
+	case "deluser":
+		if len(args) < 3 {
+			return reply.MakeArgNumErrReply("acl|deluser")
+		}
+		return aclDelUser(args[2:])
+	case "list":
+		users := acl.sortedUsers()
+		lines := make([][]byte, 0, len(users))
+		for _, user := range users {
+			lines = append(lines, []byte(user.describe()))
+		}
+		return reply.MakeMultiBulkReply(lines)
+	case "whoami":
+		username := conn.GetUser()
+		if username == "" {
+			username = DefaultUser
+		}
+		return reply.MakeBulkReply([]byte(username))
+	case "cat":
+		if len(args) > 3 {
+			return reply.MakeArgNumErrReply("acl|cat")
+		}
+		if len(args) == 2 {
+			categories := make([][]byte, 0, len(aclCategories))
+			for _, category := range aclCategories {
+				categories = append(categories, []byte(category))
+			}
+			return reply.MakeMultiBulkReply(categories)
+		}
+		return aclCat(strings.ToLower(string(args[2])))
+	case "save", "load":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("acl|" + subCmd)
+		}
+		if config.Properties.ACLFile == "" {
+			return reply.MakeErrReply("ERR This Redis instance is not configured to use an ACL file.")
+		}
+		var err error
+		if subCmd == "save" {
+			err = saveACLFile(config.Properties.ACLFile)
+		} else {
+			err = loadACLFile(config.Properties.ACLFile)
+		}
+		if err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try ACL HELP.")
+}
+
+// aclSetUser 创建或修改用户 任意一条规则有错误时不修改用户
+func aclSetUser(name string, rules [][]byte) resp.Reply {
+	acl.mutex.Lock()
+	defer acl.mutex.Unlock()
+	user, ok := acl.users[name]
+	if ok {
+		user = user.clone()
+	} else {
+		user = &aclUser{name: name}
+	}
+	for _, rule := range rules {
+		if err := user.applyRule(string(rule)); err != nil {
+			return reply.MakeErrReply("ERR Error in ACL SETUSER modifier '" + string(rule) + "': " + err.Error())
+		}
+	}
+	acl.users[name] = user
+	return reply.MakeOkReply()
+}
+
+// aclGetUser 返回用户的flags passwords commands keys 用户不存在时为nil
+func aclGetUser(name string) resp.Reply {
+	user := acl.getUser(name)
+	if user == nil {
+		return reply.MakeNullBulkReply()
+	}
+	var flags []resp.Reply
+	if user.enabled {
+		flags = append(flags, reply.MakeStatusReply("on"))
+	} else {
+		flags = append(flags, reply.MakeStatusReply("off"))
+	}
+	if user.nopass {
+		flags = append(flags, reply.MakeStatusReply("nopass"))
+	}
+	passwords := make([][]byte, 0, len(user.passwords))
+	for _, hash := range user.passwords {
+		passwords = append(passwords, []byte(hash))
+	}
+	keys := make([]string, 0, len(user.keyPatterns))
+	for _, pattern := range user.keyPatterns {
+		keys = append(keys, "~"+pattern)
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("flags")),
+		reply.MakeMultiRawReply(flags),
+		reply.MakeBulkReply([]byte("passwords")),
+		reply.MakeMultiBulkReply(passwords),
+		reply.MakeBulkReply([]byte("commands")),
+		reply.MakeBulkReply([]byte(user.describeCommands())),
+		reply.MakeBulkReply([]byte("keys")),
+		reply.MakeBulkReply([]byte(strings.Join(keys, " "))),
+	})
+}
+
+// aclDelUser 删除用户 返回删除的数量 默认用户不能删除
+func aclDelUser(names [][]byte) resp.Reply {
+	acl.mutex.Lock()
+	defer acl.mutex.Unlock()
+	for _, name := range names {
+		if string(name) == DefaultUser {
+			return reply.MakeErrReply("ERR The 'default' user cannot be removed")
+		}
+	}
+	var deleted int64
+	for _, name := range names {
+		if _, ok := acl.users[string(name)]; ok {
+			delete(acl.users, string(name))
+			deleted++
+		}
+	}
+	return reply.MakeIntReply(deleted)
+}
+
+// aclCat 返回分类中的所有指令
+func aclCat(category string) resp.Reply {
+	if !isKnownCategory(category) {
+		return reply.MakeErrReply("ERR Unknown category '" + category + "'")
+	}
+	var names []string
+	for name := range CommandTable {
+		if inCategory(name, category) {
+			names = append(names, name)
+		}
+	}
+	for name := range aclExtraCategories {
+		if _, ok := CommandTable[name]; !ok && inCategory(name, category) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	result := make([][]byte, 0, len(names))
+	for _, name := range names {
+		result = append(result, []byte(name))
+	}
+	return reply.MakeMultiBulkReply(result)
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"simple-godis/lib/utils"
+	"simple-godis/resp/client"
+	"simple-godis/resp/reply"
+	"testing"
+)
+
+// makeUserClient 使用指定用户的客户端连接
+func makeUserClient(user string) *client.Client {
+	c := client.NewClient(nil)
+	c.SetUser(user)
+	return c
+}
+
+// setTestUser 新建或修改用户 测试结束后恢复为只有默认用户
+func setTestUser(t *testing.T, name string, rules ...string) {
+	t.Helper()
+	if result := aclSetUser(name, utils.ToCmdLine(rules...)); reply.IsErrorReply(result) {
+		t.Fatalf("ACL SETUSER %s: %s", name, result.ToBytes())
+	}
+	t.Cleanup(func() {
+		_ = initACL()
+	})
+}
+
+func TestCheckPermissionInternal(t *testing.T) {
+	setTestUser(t, DefaultUser, "resetkeys", "~allowed:*", "-@all", "+subscribe")
+
+	// 用户名为空不代表是内部连接
+	for _, user := range []string{"", "missing", DefaultUser} {
+		c := makeUserClient(user)
+		if result := checkPermission(c, "set", []string{"allowed:1"}); !reply.IsErrorReply(result) {
+			t.Errorf("user %q: expected NOPERM for set", user)
+		}
+		if result := checkPermission(c, "_prepare", nil); !reply.IsErrorReply(result) {
+			t.Errorf("user %q: expected NOPERM for internal relay command", user)
+		}
+	}
+	if result := checkPermission(makeUserClient(DefaultUser), "subscribe", nil); result != nil {
+		t.Errorf("expected subscribe to be allowed, got %s", result.ToBytes())
+	}
+	setTestUser(t, "alice", "on", "nopass", "+@all", "~allowed:*")
+	if result := checkPermission(makeUserClient("alice"), "set", []string{"other"}); !reply.IsErrorReply(result) {
+		t.Error("expected NOPERM for key out of patterns")
+	}
+	if result := checkPermission(makeUserClient("alice"), "_prepare", nil); !reply.IsErrorReply(result) {
+		t.Error("expected NOPERM for internal relay command even with +@all")
+	}
+
+	// 只有显式标记的内部连接跳过检查 与用户无关
+	for _, user := range []string{"", DefaultUser} {
+		c := makeUserClient(user)
+		c.SetInternal(true)
+		for _, cmdName := range []string{"set", "_prepare", "flushall"} {
+			if result := checkPermission(c, cmdName, []string{"other"}); result != nil {
+				t.Errorf("internal user %q: expected %s to be allowed, got %s", user, cmdName, result.ToBytes())
+			}
+		}
+	}
+}
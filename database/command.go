@@ -10,18 +10,165 @@ import (
 
 var CommandTable = make(map[string]*command)
 
+// 指令的标志 可以组合使用
+const (
+	FlagReadOnly    = 1 << iota // 只读取数据
+	FlagWrite                   // 会修改数据
+	FlagAdmin                   // 管理指令
+	FlagNoCluster               // 集群模式下不可用
+	FlagMovableKeys             // key的位置不固定 需要通过prepare分析
+)
+
+// flagNames COMMAND INFO中标志的名称
+var flagNames = []struct {
+	flag int
+	name string
+}{
+	{FlagReadOnly, "readonly"},
+	{FlagWrite, "write"},
+	{FlagAdmin, "admin"},
+	{FlagNoCluster, "no-cluster"},
+	{FlagMovableKeys, "movablekeys"},
+}
+
 // command 一种类型的指令对应一个command
 type command struct {
+	name     string         // 指令名称 小写
 	executor ExecuteCommand // 具体对应的是哪个执行函数
+	prepare  PreFunc        // 分析指令会写入和读取哪些key
 	arity    int            // 参数数量
+	flags    int            // 指令的标志
+	firstKey int            // 第一个key的位置 0表示指令不涉及key
+	lastKey  int            // 最后一个key的位置 负数表示从末尾倒数 -1为最后一个参数
+	keyStep  int            // 相邻两个key之间的距离
 }
 
-// RegisterCommand input: name指令名称 executor具体的执行函数 arity参数个数
-// 新建一个command放到commandTable中
-func RegisterCommand(name string, executor ExecuteCommand, arity int) {
+// PreFunc 在执行指令之前分析出指令要写入的key和读取的key args不包含指令名称
+type PreFunc func(args [][]byte) (writeKeys []string, readKeys []string)
+
+// RegisterCommand input: name指令名称 executor具体的执行函数 prepare分析指令涉及的key arity参数个数 flags指令的标志
+// 新建一个command放到commandTable中 涉及key的指令需要再调用AttachKeys设置key的位置
+func RegisterCommand(name string, executor ExecuteCommand, prepare PreFunc, arity int, flags int) *command {
 	name = strings.ToLower(name)
-	CommandTable[name] = &command{
+	cmd := &command{
+		name:     name,
 		executor: executor,
+		prepare:  prepare,
 		arity:    arity,
+		flags:    flags,
+	}
+	CommandTable[name] = cmd
+	return cmd
+}
+
+// AttachKeys 设置key在指令中的位置 位置从指令名称之后的第一个参数开始计为1
+func (cmd *command) AttachKeys(firstKey int, lastKey int, step int) *command {
+	cmd.firstKey = firstKey
+	cmd.lastKey = lastKey
+	cmd.keyStep = step
+	return cmd
+}
+
+// Name 指令名称
+func (cmd *command) Name() string {
+	return cmd.name
+}
+
+// Arity 参数数量 负数表示至少需要的参数数量
+func (cmd *command) Arity() int {
+	return cmd.arity
+}
+
+// HasFlag 判断指令是否有flag标志
+func (cmd *command) HasFlag(flag int) bool {
+	return cmd.flags&flag != 0
+}
+
+// FlagNames 指令所有标志的名称
+func (cmd *command) FlagNames() []string {
+	var names []string
+	for _, f := range flagNames {
+		if cmd.HasFlag(f.flag) {
+			names = append(names, f.name)
+		}
 	}
+	return names
+}
+
+// KeySpec 返回第一个key的位置 最后一个key的位置和相邻key的距离
+func (cmd *command) KeySpec() (firstKey int, lastKey int, step int) {
+	return cmd.firstKey, cmd.lastKey, cmd.keyStep
+}
+
+// HasKeys 指令是否涉及key
+func (cmd *command) HasKeys() bool {
+	return cmd.firstKey > 0
+}
+
+// getKeys 根据key的位置从指令中取出所有的key 调用方需要先校验参数数量
+func (cmd *command) getKeys(cmdLine CmdLine) []string {
+	if !cmd.HasKeys() {
+		return nil
+	}
+	if cmd.HasFlag(FlagMovableKeys) {
+		writeKeys, readKeys := cmd.prepare(cmdLine[1:])
+		return append(writeKeys, readKeys...)
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last += len(cmdLine)
+	}
+	var keys []string
+	for i := cmd.firstKey; i <= last && i < len(cmdLine); i += cmd.keyStep {
+		keys = append(keys, string(cmdLine[i]))
+	}
+	return keys
+}
+
+// GetCommandKeys 返回指令涉及的所有key 指令不存在或者参数数量错误时ok为false
+func GetCommandKeys(cmdLine CmdLine) (keys []string, ok bool) {
+	cmd, ok := CommandTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		return nil, false
+	}
+	return cmd.getKeys(cmdLine), true
+}
+
+// IsWriteCommand 判断指令是否会修改数据
+func IsWriteCommand(cmdLine CmdLine) bool {
+	cmd, ok := CommandTable[strings.ToLower(string(cmdLine[0]))]
+	return ok && cmd.HasFlag(FlagWrite)
+}
+
+// WriteFirstKey 指令只写入第一个参数对应的key
+func WriteFirstKey(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, nil
+}
+
+// WriteAllKeys 指令写入所有参数对应的key
+func WriteAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return keys, nil
+}
+
+// ReadFirstKey 指令只读取第一个参数对应的key
+func ReadFirstKey(args [][]byte) ([]string, []string) {
+	return nil, []string{string(args[0])}
+}
+
+// ReadAllKeys 指令读取所有参数对应的key
+func ReadAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return nil, keys
+}
+
+// NoPrepare 指令不涉及任何key
+func NoPrepare(args [][]byte) ([]string, []string) {
+	return nil, nil
 }
@@ -5,17 +5,39 @@ import (
 	dbInterface "simple-godis/interface/database"
 	"simple-godis/interface/resp"
 	"simple-godis/lib/logger"
+	"simple-godis/lib/sync/lock"
 	"simple-godis/resp/reply"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type CmdLine = [][]byte
 
+const (
+	dataDictSize = 1 << 16 // 数据字典的分段数量
+	ttlDictSize  = 1 << 10 // 过期时间字典的分段数量
+	lockerSize   = 1024    // key锁的数量
+)
+
 // DB 一个子数据库 实现了smap.Map接口
 type DB struct {
-	index  int
-	Data   smap.Map
-	AddAof func(line CmdLine) // 分数据库落盘不需要知道落盘处理器的全部细节，只需要一个方法
+	index      int
+	Data       smap.Map
+	ttlMap     smap.Map               // 记录设置了过期时间的key key -> time.Time
+	watched    *watchedKeys           // 有客户端WATCH的key的版本号 用于WATCH
+	locker     *lock.Locks            // key级别的锁 保证读-改-写指令和多key指令的原子性
+	AddAof     func(lines ...CmdLine) // 分数据库落盘不需要知道落盘处理器的全部细节，只需要一个方法
+	stopExpire chan struct{}          // 通知定期删除协程退出
+	stats      *keyspaceStats         // 事务中复制出的DB共享同一份统计
+	readKeys   []string               // 只读指令还没有统计命中的key GetEntity第一次读取时统计
+	snapshots  *snapshotTracker       // 正在进行的快照 修改key之前先将旧值写入快照
+}
+
+// keyspaceStats 只读指令读取key的命中统计
+type keyspaceStats struct {
+	hits   int64 // 读取到的key的数量
+	misses int64 // 读取时不存在的key的数量
 }
 
 // ExecuteCommand 所有redis指令都要使用该函数执行
@@ -24,8 +46,14 @@ type ExecuteCommand func(db *DB, args [][]byte) resp.Reply
 // MakeDB 构建一个数据库
 func MakeDB() *DB {
 	db := &DB{
-		Data:   smap.MakeSyncMap(),
-		AddAof: func(line CmdLine) {},
+		Data:       smap.MakeConcurrentMap(dataDictSize),
+		ttlMap:     smap.MakeConcurrentMap(ttlDictSize),
+		watched:    makeWatchedKeys(),
+		locker:     lock.MakeLocks(lockerSize),
+		AddAof:     func(lines ...CmdLine) {},
+		stopExpire: make(chan struct{}),
+		stats:      &keyspaceStats{},
+		snapshots:  makeSnapshotTracker(),
 	}
 	return db
 }
@@ -34,7 +62,6 @@ func MakeDB() *DB {
 func (db *DB) Execute(conn resp.Connection, cmdLine CmdLine) resp.Reply {
 	// 统一将指令转为小写
 	cmdName := strings.ToLower(string(cmdLine[0]))
-	cmd, ok := CommandTable[cmdName]
 	if cmdName == "exit" {
 		err := conn.Close()
 		if err != nil {
@@ -43,14 +70,91 @@ func (db *DB) Execute(conn resp.Connection, cmdLine CmdLine) resp.Reply {
 		}
 		return reply.MakeOkReply()
 	}
+	cmd, ok := CommandTable[cmdName]
 	if !ok { // 不存在该指令集
 		return reply.MakeErrReply("ERR unknown command " + cmdName)
 	}
 	if !validateArity(cmd.arity, cmdLine) {
 		return reply.MakeArgNumErrReply(cmdName)
 	}
+	if errReply := checkPermission(conn, cmdName, cmd.getKeys(cmdLine)); errReply != nil {
+		return errReply
+	}
+	// 执行前按确定的顺序锁住指令涉及的所有key
+	writeKeys, readKeys := cmd.prepare(cmdLine[1:])
+	db.RWLocks(writeKeys, readKeys)
+	defer db.RWUnLocks(writeKeys, readKeys)
+	return db.execWithLock(cmd, cmdLine, writeKeys, readKeys)
+}
+
+// execNormalCommand 校验并执行一条普通指令 调用方需要已经持有指令涉及的key的锁
+func (db *DB) execNormalCommand(cmdLine CmdLine) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := CommandTable[cmdName]
+	if !ok { // 不存在该指令集
+		return reply.MakeErrReply("ERR unknown command " + cmdName)
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	writeKeys, readKeys := cmd.prepare(cmdLine[1:])
+	return db.execWithLock(cmd, cmdLine, writeKeys, readKeys)
+}
+
+// execWithLock 执行指令 指令修改了数据时增加写入的key的版本号
+// 指令执行时写入了aof说明确实修改了数据 没有生效或者出错的写指令不会让其他客户端的WATCH失效
+func (db *DB) execWithLock(cmd *command, cmdLine CmdLine, writeKeys []string, readKeys []string) resp.Reply {
 	executor := cmd.executor
-	return executor(db, cmdLine[1:]) // 将参数切出来
+	if cmd.HasFlag(FlagReadOnly) {
+		// readDB与db共享数据 只读指令自己读取key时统计命中 不需要再查找一次
+		readDB := *db
+		readDB.readKeys = append([]string(nil), readKeys...)
+		return executor(&readDB, cmdLine[1:])
+	}
+	if len(writeKeys) == 0 {
+		return executor(db, cmdLine[1:]) // 将参数切出来
+	}
+	db.snapshots.beforeWrite(db, writeKeys)
+	// execDB与db共享数据 只是在落盘时记录指令修改了数据
+	changed := false
+	execDB := *db
+	execDB.AddAof = func(lines ...CmdLine) {
+		changed = true
+		db.AddAof(lines...)
+	}
+	result := executor(&execDB, cmdLine[1:])
+	if changed {
+		db.AddVersion(writeKeys...)
+	}
+	return result
+}
+
+// countLookup 只读指令读取key时统计命中和未命中 同一个key被多次读取时只统计第一次
+func (db *DB) countLookup(key string, exists bool) {
+	for i, readKey := range db.readKeys {
+		if readKey != key {
+			continue
+		}
+		last := len(db.readKeys) - 1
+		db.readKeys[i] = db.readKeys[last]
+		db.readKeys = db.readKeys[:last]
+		if exists {
+			atomic.AddInt64(&db.stats.hits, 1)
+		} else {
+			atomic.AddInt64(&db.stats.misses, 1)
+		}
+		return
+	}
+}
+
+// RWLocks 给writeKeys加写锁 给readKeys加读锁
+func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWLocks(writeKeys, readKeys)
+}
+
+// RWUnLocks 释放RWLocks加的锁
+func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWUnLocks(writeKeys, readKeys)
 }
 
 // validateArity 验证参数的个数
@@ -67,44 +171,91 @@ func validateArity(arity int, commandArgs [][]byte) bool {
 func (db *DB) GetEntity(key string) (*dbInterface.DataEntity, bool) {
 	raw, ok := db.Data.Get(key) // Get返回的是空接口 需要转换为DataEntity
 	if !ok {
+		db.countLookup(key, false)
 		return nil, false
 	}
+	// 惰性删除 访问到已经过期的key时将其删除
+	if db.IsExpired(key) {
+		db.countLookup(key, false)
+		return nil, false
+	}
+	db.countLookup(key, true)
 	entity, _ := raw.(*dbInterface.DataEntity)
 	return entity, true
 }
 
 // PutEntity 从该索引的数据库中放入一个key对应的DataEntity
+// 先删除已经过期但还未被删除的key 避免新的值继承已经过去的过期时间
 func (db *DB) PutEntity(key string, entity *dbInterface.DataEntity) int {
+	db.IsExpired(key)
 	return db.Data.Put(key, entity)
 }
 
 // PutEntityIfExists 如果key在该索引对应的数据库中存在，
 // 从该索引的数据库中放入一个key对应的DataEntity
 func (db *DB) PutEntityIfExists(key string, entity *dbInterface.DataEntity) int {
+	db.IsExpired(key)
 	return db.Data.PutIfExists(key, entity)
 }
 
 // PutEntityIfAbsent 如果key在该索引对应的数据库中不存在，
 // 从该索引的数据库中放入一个key对应的DataEntity
 func (db *DB) PutEntityIfAbsent(key string, entity *dbInterface.DataEntity) int {
+	db.IsExpired(key) // 已经过期但还未被删除的key视为不存在
 	return db.Data.PutIfAbsent(key, entity)
 }
 
-// RemoveEntity 从该索引的数据库中删除一个key对应的DataEntity
+// RemoveEntity 从该索引的数据库中删除一个key对应的DataEntity 同时删除它的过期时间
 func (db *DB) RemoveEntity(key string) {
 	db.Data.Remove(key)
+	db.ttlMap.Remove(key)
 }
 
 // RemoveEntities 从该索引的数据库中删除一个或多个key对应的DataEntity
 func (db *DB) RemoveEntities(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
-		deleted += db.Data.Remove(key)
+		_, exists := db.GetEntity(key) // 已经过期的key不计入删除数量
+		if exists {
+			deleted++
+		}
+		db.RemoveEntity(key)
 	}
 	return deleted
 }
 
 // FlushKeys 从该索引的数据库中删除所有key
 func (db *DB) FlushKeys() {
+	db.snapshots.beforeFlush(db)
+	// 所有key都被修改了 WATCH了已有key的事务都需要放弃
+	db.watched.touchExisting(db)
 	db.Data.Clear()
+	db.ttlMap.Clear()
+}
+
+// ForEach 遍历该数据库中所有未过期的key expiration为nil表示该key没有设置过期时间
+func (db *DB) ForEach(consumer func(key string, entity *dbInterface.DataEntity, expiration *time.Time) bool) {
+	db.Data.ForEach(func(key string, raw interface{}) bool {
+		entity, _ := raw.(*dbInterface.DataEntity)
+		var expiration *time.Time
+		rawExpireTime, ok := db.ttlMap.Get(key)
+		if ok {
+			expireTime, _ := rawExpireTime.(time.Time)
+			if time.Now().After(expireTime) {
+				return true
+			}
+			expiration = &expireTime
+		}
+		return consumer(key, entity, expiration)
+	})
+}
+
+// AddVersion 将给定key的版本号加一 没有客户端WATCH的key不记录版本号
+func (db *DB) AddVersion(keys ...string) {
+	db.watched.touch(keys)
+}
+
+// GetVersion 获取key的版本号 没有客户端WATCH的key版本号为0
+func (db *DB) GetVersion(key string) uint32 {
+	return db.watched.version(key)
 }
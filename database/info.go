@@ -0,0 +1,211 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"simple-godis/config"
+	"simple-godis/interface/resp"
+	"simple-godis/pubsub"
+	"simple-godis/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+INFO指令 按照section输出服务器的状态 格式与redis相同 每个section以# Name开头 之后每行为name:value
+连接和指令数等由handler统计的内容通过SetInfoProvider提供
+*/
+
+// startTime 服务启动的时间
+var startTime = time.Now()
+
+// infoSection 一个section的名称和生成内容的方法
+type infoSection struct {
+	name     string
+	generate func(db *StandaloneDatabase, buf *bytes.Buffer)
+}
+
+// 默认输出的section 按顺序输出
+var infoSections = []*infoSection{
+	{name: "server", generate: serverInfo},
+	{name: "clients", generate: clientsInfo},
+	{name: "memory", generate: memoryInfo},
+	{name: "persistence", generate: persistenceInfo},
+	{name: "stats", generate: statsInfo},
+	{name: "replication", generate: replicationInfo},
+	{name: "cluster", generate: clusterInfo},
+	{name: "keyspace", generate: keyspaceInfo},
+}
+
+// execInfo INFO [section ...] 没有参数或者参数为all default everything时输出所有section
+func execInfo(db *StandaloneDatabase, args [][]byte) resp.Reply {
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		wanted[strings.ToLower(string(arg))] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+	var buf bytes.Buffer
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString(reply.CRLF)
+		}
+		buf.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + reply.CRLF)
+		section.generate(db, &buf)
+	}
+	return reply.MakeVerbatimReply("txt", buf.Bytes())
+}
+
+// writeInfoField 写入一行 name:value
+func writeInfoField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name + ":" + value + reply.CRLF)
+}
+
+// boolToInfo 将bool转换为INFO中使用的0和1
+func boolToInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// infoProviders section名称 -> 由管理连接的handler提供的内容
+var infoProviders = make(map[string]func(write func(name string, value string)))
+
+// SetInfoProvider 设置section中由handler提供的内容 连接由handler管理 数据库无法直接得到 需要在处理连接之前设置
+func SetInfoProvider(section string, provider func(write func(name string, value string))) {
+	infoProviders[section] = provider
+}
+
+// writeProvidedInfo 写入handler提供的内容
+func writeProvidedInfo(section string, buf *bytes.Buffer) {
+	provider := infoProviders[section]
+	if provider == nil {
+		return
+	}
+	provider(func(name string, value string) {
+		writeInfoField(buf, name, value)
+	})
+}
+
+// serverInfo 服务器相关的信息
+func serverInfo(db *StandaloneDatabase, buf *bytes.Buffer) {
+	mode := "standalone"
+	if config.Properties.ClusterEnabled() {
+		mode = "cluster"
+	}
+	uptime := int64(time.Since(startTime).Seconds())
+	writeInfoField(buf, "redis_version", config.Version)
+	writeInfoField(buf, "redis_mode", mode)
+	writeInfoField(buf, "os", runtime.GOOS)
+	writeInfoField(buf, "arch_bits", strconv.Itoa(strconv.IntSize))
+	writeInfoField(buf, "go_version", runtime.Version())
+	writeInfoField(buf, "process_id", strconv.Itoa(os.Getpid()))
+	writeInfoField(buf, "tcp_port", strconv.Itoa(config.Properties.Port))
+	writeInfoField(buf, "server_time_usec", strconv.FormatInt(time.Now().UnixNano()/int64(time.Microsecond), 10))
+	writeInfoField(buf, "uptime_in_seconds", strconv.FormatInt(uptime, 10))
+	writeInfoField(buf, "uptime_in_days", strconv.FormatInt(uptime/(24*3600), 10))
+}
+
+// clientsInfo 连接相关的信息
+func clientsInfo(db *StandaloneDatabase, buf *bytes.Buffer) {
+	writeProvidedInfo("clients", buf)
+}
+
+// memoryInfo 内存相关的信息 使用Go运行时的内存统计
+func memoryInfo(db *StandaloneDatabase, buf *bytes.Buffer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	writeInfoField(buf, "used_memory", strconv.FormatUint(stats.HeapAlloc, 10))
+	writeInfoField(buf, "used_memory_human", humanBytes(stats.HeapAlloc))
+	writeInfoField(buf, "used_memory_rss", strconv.FormatUint(stats.Sys, 10))
+	writeInfoField(buf, "used_memory_rss_human", humanBytes(stats.Sys))
+	writeInfoField(buf, "mem_allocator", "go")
+	writeInfoField(buf, "heap_inuse", strconv.FormatUint(stats.HeapInuse, 10))
+	writeInfoField(buf, "heap_idle", strconv.FormatUint(stats.HeapIdle, 10))
+	writeInfoField(buf, "heap_released", strconv.FormatUint(stats.HeapReleased, 10))
+	writeInfoField(buf, "heap_objects", strconv.FormatUint(stats.HeapObjects, 10))
+	writeInfoField(buf, "gc_count", strconv.FormatUint(uint64(stats.NumGC), 10))
+	writeInfoField(buf, "gc_pause_total_ns", strconv.FormatUint(stats.PauseTotalNs, 10))
+	writeInfoField(buf, "goroutines", strconv.Itoa(runtime.NumGoroutine()))
+}
+
+// humanBytes 将字节数转换为1.50M的形式
+func humanBytes(n uint64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatUint(n, 10) + units[0]
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + units[i]
+}
+
+// persistenceInfo 持久化相关的信息
+func persistenceInfo(db *StandaloneDatabase, buf *bytes.Buffer) {
+	db.saveMutex.Lock()
+	saving := db.saving
+	db.saveMutex.Unlock()
+	lastSaveStatus := "ok"
+	if !db.lastSaveOk.Get() {
+		lastSaveStatus = "err"
+	}
+	writeInfoField(buf, "loading", "0")
+	writeInfoField(buf, "rdb_changes_since_last_save", strconv.FormatInt(atomic.LoadInt64(&db.dirty), 10))
+	writeInfoField(buf, "rdb_bgsave_in_progress", boolToInfo(saving))
+	writeInfoField(buf, "rdb_last_save_time", strconv.FormatInt(atomic.LoadInt64(&db.lastSave), 10))
+	writeInfoField(buf, "rdb_last_bgsave_status", lastSaveStatus)
+	handler := db.aofHandler
+	writeInfoField(buf, "aof_enabled", boolToInfo(handler != nil))
+	if handler == nil {
+		return
+	}
+	writeInfoField(buf, "aof_rewrite_in_progress", boolToInfo(handler.IsRewriting()))
+	writeInfoField(buf, "aof_fsync", handler.FsyncPolicy())
+	writeInfoField(buf, "aof_last_fsync_time", strconv.FormatInt(handler.LastFsyncTime().Unix(), 10))
+	writeInfoField(buf, "aof_current_size", strconv.FormatInt(handler.AofSize(), 10))
+	writeInfoField(buf, "aof_base_size", strconv.FormatInt(handler.BaseSize(), 10))
+	writeInfoField(buf, "aof_buffer_length", strconv.Itoa(handler.PendingCommands()))
+	writeInfoField(buf, "aof_pending_fsync_bytes", strconv.FormatInt(handler.PendingBytes(), 10))
+}
+
+// statsInfo 统计信息 连接和指令数由handler提供
+func statsInfo(db *StandaloneDatabase, buf *bytes.Buffer) {
+	writeProvidedInfo("stats", buf)
+	var hits, misses int64
+	for _, database := range db.dbSet {
+		hits += atomic.LoadInt64(&database.stats.hits)
+		misses += atomic.LoadInt64(&database.stats.misses)
+	}
+	channels, patterns := pubsub.Counts(db.hub)
+	writeInfoField(buf, "keyspace_hits", strconv.FormatInt(hits, 10))
+	writeInfoField(buf, "keyspace_misses", strconv.FormatInt(misses, 10))
+	writeInfoField(buf, "pubsub_channels", strconv.Itoa(channels))
+	writeInfoField(buf, "pubsub_patterns", strconv.Itoa(patterns))
+}
+
+// clusterInfo 是否开启了集群模式
+func clusterInfo(db *StandaloneDatabase, buf *bytes.Buffer) {
+	writeInfoField(buf, "cluster_enabled", boolToInfo(config.Properties.ClusterEnabled()))
+}
+
+// keyspaceInfo 每个分数据库的key数量和设置了过期时间的key数量 没有key的数据库不输出 avg_ttl没有统计 固定为0
+func keyspaceInfo(db *StandaloneDatabase, buf *bytes.Buffer) {
+	for _, database := range db.dbSet {
+		keys := database.Data.Len()
+		if keys == 0 {
+			continue
+		}
+		writeInfoField(buf, "db"+strconv.Itoa(database.index),
+			"keys="+strconv.Itoa(keys)+",expires="+strconv.Itoa(database.ttlMap.Len())+",avg_ttl=0")
+	}
+}
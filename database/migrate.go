@@ -0,0 +1,87 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"simple-godis/aof"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/lib/utils"
+	"simple-godis/rdb"
+	"strconv"
+	"time"
+)
+
+/*
+集群迁移槽时搬运单个key 使用快照的格式序列化 一个payload中只包含一个key
+迁移过程中持有key的写锁 发送成功后才从本地删除 迁移期间对该key的写入不会丢失
+*/
+
+// DumpKey 将key序列化后交给send 发送成功后从本地删除 key不存在时返回false
+func (db *StandaloneDatabase) DumpKey(dbIndex int, key string, send func(payload []byte) error) (bool, error) {
+	if dbIndex < 0 || dbIndex >= len(db.dbSet) {
+		return false, errors.New("ERR DB index is out of range")
+	}
+	database := db.dbSet[dbIndex]
+	keys := []string{key}
+	database.RWLocks(keys, nil)
+	defer database.RWUnLocks(keys, nil)
+
+	entity, ok := database.GetEntity(key)
+	if !ok {
+		return false, nil
+	}
+	var expiration *time.Time
+	if expireTime, ok := database.GetExpiration(key); ok {
+		expiration = &expireTime
+	}
+	var buf bytes.Buffer
+	encoder, err := rdb.NewEncoder(&buf)
+	if err != nil {
+		return false, err
+	}
+	if err = encoder.WriteDBIndex(dbIndex); err != nil {
+		return false, err
+	}
+	if err = encoder.WriteEntity(key, entity, expiration); err != nil {
+		return false, err
+	}
+	if err = encoder.Close(); err != nil {
+		return false, err
+	}
+	if err = send(buf.Bytes()); err != nil {
+		return false, err
+	}
+	database.snapshots.beforeWrite(database, keys)
+	database.RemoveEntity(key)
+	database.AddVersion(key)
+	database.AddAof(utils.ToCmdLine("del", key))
+	return true, nil
+}
+
+// RestoreKeys 加载DumpKey序列化的数据 覆盖已有的key 已经过期的key不会被加载
+func (db *StandaloneDatabase) RestoreKeys(payload []byte) error {
+	now := time.Now()
+	return rdb.Decode(bytes.NewReader(payload), func(dbIndex int, key string, entity *dbInterface.DataEntity, expiration *time.Time) error {
+		if dbIndex < 0 || dbIndex >= len(db.dbSet) {
+			return errors.New("ERR DB index " + strconv.Itoa(dbIndex) + " is out of range")
+		}
+		if expiration != nil && now.After(*expiration) {
+			return nil
+		}
+		database := db.dbSet[dbIndex]
+		keys := []string{key}
+		database.RWLocks(keys, nil)
+		defer database.RWUnLocks(keys, nil)
+		database.snapshots.beforeWrite(database, keys)
+		database.PutEntity(key, entity)
+		database.Persist(key)
+		lines := []CmdLine{aof.EntityToCmd(key, entity)}
+		if expiration != nil {
+			database.Expire(key, *expiration)
+			lines = append(lines, MakeExpireCmd(key, *expiration))
+		}
+		database.AddVersion(key)
+		database.AddAof(append([]CmdLine{utils.ToCmdLine("del", key)}, lines...)...)
+		return nil
+	})
+}
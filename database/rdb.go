@@ -0,0 +1,209 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"simple-godis/config"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/lib/logger"
+	"simple-godis/rdb"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+二进制快照 SAVE BGSAVE LASTSAVE 以及按照save规则自动保存
+开始保存时短暂暂停所有指令 之后逐个key加读锁写入 写指令会先把还没有保存的旧值写入快照
+保存期间不会阻塞其他客户端 得到的是开始保存时刻的数据
+*/
+
+const defaultRdbFilename = "dump.rdb"
+
+// errSaveInProgress 已经有一个快照正在保存
+var errSaveInProgress = errors.New("ERR Background save already in progress")
+
+// saveRule 距离上次保存超过seconds秒并且至少有changes次修改时自动保存
+type saveRule struct {
+	seconds int64
+	changes int64
+}
+
+// parseSaveRules 解析save配置 格式为 "seconds changes [seconds changes ...]"
+func parseSaveRules(value string) []saveRule {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		logger.Warn("invalid save config: " + value)
+		return nil
+	}
+	rules := make([]saveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds <= 0 || changes <= 0 {
+			logger.Warn("invalid save config: " + value)
+			return nil
+		}
+		rules = append(rules, saveRule{seconds: seconds, changes: changes})
+	}
+	return rules
+}
+
+// rdbFilename 快照文件名
+func rdbFilename() string {
+	if config.Properties.DBFilename == "" {
+		return defaultRdbFilename
+	}
+	return config.Properties.DBFilename
+}
+
+// Save 将所有分数据库写入快照文件 先写入临时文件再原子地替换
+func (db *StandaloneDatabase) Save() error {
+	db.saveMutex.Lock()
+	if db.saving {
+		db.saveMutex.Unlock()
+		return errSaveInProgress
+	}
+	db.saving = true
+	db.saveMutex.Unlock()
+	return db.doSave()
+}
+
+// BackgroundSave 在后台保存快照
+func (db *StandaloneDatabase) BackgroundSave() error {
+	db.saveMutex.Lock()
+	if db.saving {
+		db.saveMutex.Unlock()
+		return errSaveInProgress
+	}
+	db.saving = true
+	db.saveMutex.Unlock()
+	go func() {
+		if err := db.doSave(); err != nil {
+			logger.Error("background save failed: ", err)
+		}
+	}()
+	return nil
+}
+
+// doSave 执行保存 调用前需要已经将saving置为true
+func (db *StandaloneDatabase) doSave() (err error) {
+	start := time.Now()
+	dirty := atomic.LoadInt64(&db.dirty)
+	defer func() {
+		db.saveMutex.Lock()
+		db.saving = false
+		db.saveMutex.Unlock()
+		if err == nil {
+			atomic.AddInt64(&db.dirty, -dirty)
+			atomic.StoreInt64(&db.lastSave, start.Unix())
+			logger.Info("DB saved on disk in " + time.Since(start).String())
+		}
+		db.lastSaveOk.Set(err == nil)
+	}()
+
+	filename := rdbFilename()
+	dir, name := filepath.Split(filename)
+	tmpFile, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+		}
+	}()
+	// 在pauseLock的写锁下开始快照 之后写入快照期间不再暂停其他指令
+	db.pauseLock.Lock()
+	s, err := db.beginSnapshot(tmpFile)
+	db.pauseLock.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = db.writeSnapshot(s); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	// rename是原子操作 保存过程中宕机不会损坏已有的快照文件
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+// loadRdb 启动时从快照文件中恢复数据 已经过期的key不会被加载 快照文件损坏时退出
+func (db *StandaloneDatabase) loadRdb() {
+	file, err := os.Open(rdbFilename())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error(err)
+		}
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	start := time.Now()
+	now := time.Now()
+	err = rdb.Decode(file, func(dbIndex int, key string, entity *dbInterface.DataEntity, expiration *time.Time) error {
+		if dbIndex < 0 || dbIndex >= len(db.dbSet) {
+			return errors.New("db index " + strconv.Itoa(dbIndex) + " is out of range")
+		}
+		if expiration != nil && now.After(*expiration) {
+			return nil
+		}
+		database := db.dbSet[dbIndex]
+		database.PutEntity(key, entity)
+		if expiration != nil {
+			database.Expire(key, *expiration)
+		}
+		return nil
+	})
+	if err != nil {
+		// 以空数据库启动会在下一次保存时覆盖损坏的快照文件 记录错误后退出 保留原来的文件
+		logger.Fatal("load rdb file " + rdbFilename() + " failed: " + err.Error())
+	}
+	atomic.StoreInt64(&db.lastSave, start.Unix())
+	logger.Info("DB loaded from disk in " + time.Since(start).String())
+}
+
+// startSaveCron 按照save规则定期检查是否需要自动保存
+func (db *StandaloneDatabase) startSaveCron() {
+	if len(db.saveRules) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if db.needSave() {
+					err := db.BackgroundSave()
+					if err != nil && err != errSaveInProgress {
+						logger.Error("auto save failed: ", err)
+					}
+				}
+			case <-db.stopSaveCron:
+				return
+			}
+		}
+	}()
+}
+
+// needSave 判断是否满足任意一条save规则
+func (db *StandaloneDatabase) needSave() bool {
+	dirty := atomic.LoadInt64(&db.dirty)
+	elapsed := time.Now().Unix() - atomic.LoadInt64(&db.lastSave)
+	for _, rule := range db.saveRules {
+		if dirty >= rule.changes && elapsed >= rule.seconds {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package database
+
+/*
+复制积压缓冲区 环形地保存最近写入复制流的字节
+从节点短暂断开后重连时 如果它的复制偏移量仍然在缓冲区内 可以只补发缺失的部分
+*/
+
+// replBacklog 环形缓冲区 begin和end是复制流中的偏移量 缓冲区中保存的是[begin, end)之间的字节
+type replBacklog struct {
+	buf   []byte
+	begin int64
+	end   int64
+}
+
+// makeReplBacklog replBacklog的构造方法 offset为复制流当前的偏移量
+func makeReplBacklog(size int, offset int64) *replBacklog {
+	return &replBacklog{
+		buf:   make([]byte, size),
+		begin: offset,
+		end:   offset,
+	}
+}
+
+// write 追加写入复制流中的字节 超出容量时覆盖最早的字节
+func (backlog *replBacklog) write(data []byte) {
+	size := int64(len(backlog.buf))
+	// 超出容量的部分一定会被覆盖 直接跳过
+	if int64(len(data)) > size {
+		skip := int64(len(data)) - size
+		backlog.end += skip
+		data = data[skip:]
+	}
+	for len(data) > 0 {
+		pos := backlog.end % size
+		n := copy(backlog.buf[pos:], data)
+		data = data[n:]
+		backlog.end += int64(n)
+	}
+	if backlog.end-backlog.begin > size {
+		backlog.begin = backlog.end - size
+	}
+}
+
+// readFrom 返回从offset开始到缓冲区末尾的字节 offset不在缓冲区范围内时返回false
+func (backlog *replBacklog) readFrom(offset int64) ([]byte, bool) {
+	if offset < backlog.begin || offset > backlog.end {
+		return nil, false
+	}
+	size := int64(len(backlog.buf))
+	result := make([]byte, backlog.end-offset)
+	pos := offset % size
+	n := copy(result, backlog.buf[pos:])
+	copy(result[n:], backlog.buf)
+	return result, true
+}
+
+// histLen 缓冲区中保存的字节数
+func (backlog *replBacklog) histLen() int64 {
+	return backlog.end - backlog.begin
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"bytes"
+	"simple-godis/config"
+	"strconv"
+	"testing"
+)
+
+// checkBacklog 与完整的复制流比较 缓冲区中只能读到最后size个字节 偏移量等于末尾时读到空数据
+func checkBacklog(t *testing.T, backlog *replBacklog, stream []byte, start int64) {
+	t.Helper()
+	size := int64(len(backlog.buf))
+	end := start + int64(len(stream))
+	begin := start
+	if end-begin > size {
+		begin = end - size
+	}
+	if backlog.begin != begin || backlog.end != end || backlog.histLen() != end-begin {
+		t.Fatalf("expected [%d, %d), got [%d, %d)", begin, end, backlog.begin, backlog.end)
+	}
+	for offset := begin - 2; offset <= end+2; offset++ {
+		data, ok := backlog.readFrom(offset)
+		if offset < begin || offset > end {
+			if ok {
+				t.Fatalf("offset %d out of [%d, %d] should not be readable", offset, begin, end)
+			}
+			continue
+		}
+		if !ok {
+			t.Fatalf("offset %d in [%d, %d] should be readable", offset, begin, end)
+		}
+		if want := stream[offset-start:]; !bytes.Equal(data, want) {
+			t.Fatalf("offset %d: expected %q, got %q", offset, want, data)
+		}
+	}
+}
+
+func TestReplBacklog(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  int64
+		writes []string
+	}{
+		{name: "empty", start: 0},
+		{name: "not full", start: 0, writes: []string{"abc", "de"}},
+		{name: "exactly full", start: 0, writes: []string{"abcd", "efgh"}},
+		{name: "wrap", start: 0, writes: []string{"abcdef", "ghij"}},
+		{name: "wrap many times", start: 0, writes: []string{"abc", "defgh", "ijklm", "n", "opqrstu", "vw"}},
+		{name: "write larger than size", start: 0, writes: []string{"ab", "cdefghijklmnop"}},
+		// 起始偏移量不是缓冲区大小的整数倍
+		{name: "unaligned start", start: 13, writes: []string{"abcde"}},
+		{name: "unaligned start wrap", start: 13, writes: []string{"abcde", "fghij", "k"}},
+		{name: "unaligned start larger than size", start: 13, writes: []string{"abcdefghijklmnopqrstu"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backlog := makeReplBacklog(8, tt.start)
+			var stream []byte
+			checkBacklog(t, backlog, stream, tt.start)
+			for _, data := range tt.writes {
+				backlog.write([]byte(data))
+				stream = append(stream, data...)
+				checkBacklog(t, backlog, stream, tt.start)
+			}
+		})
+	}
+}
+
+func TestCanPartialSync(t *testing.T) {
+	saved := config.Properties.ReplBacklogSize
+	defer func() { config.Properties.ReplBacklogSize = saved }()
+	config.Properties.ReplBacklogSize = 16
+
+	repl := makeReplication()
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+	if repl.canPartialSyncLocked(repl.replId, 0) {
+		t.Fatal("partial sync without backlog")
+	}
+	repl.offset = 100
+	repl.ensureBacklogLocked()
+	for i := 0; i < 3; i++ {
+		repl.appendLocked([]byte("0123456" + strconv.Itoa(i)))
+	}
+	// 复制流中共有24个字节 只有最后16个字节还在缓冲区中
+	tests := []struct {
+		replId string
+		offset int64
+		want   bool
+	}{
+		{replId: repl.replId, offset: 124, want: true},
+		{replId: repl.replId, offset: 110, want: true},
+		{replId: repl.replId, offset: 108, want: true},
+		// 已经被覆盖的偏移量需要全量同步
+		{replId: repl.replId, offset: 107, want: false},
+		{replId: repl.replId, offset: 100, want: false},
+		{replId: repl.replId, offset: 125, want: false},
+		{replId: "other", offset: 124, want: false},
+	}
+	for _, tt := range tests {
+		if got := repl.canPartialSyncLocked(tt.replId, tt.offset); got != tt.want {
+			t.Errorf("replId %s offset %d: expected %v, got %v", tt.replId, tt.offset, tt.want, got)
+		}
+	}
+
+	// 提升为主节点之后 旧的复制ID只在切换时的偏移量之前有效
+	repl.replId2 = repl.replId
+	repl.secondReplOffset = 116
+	repl.replId = makeReplId()
+	for _, tt := range []struct {
+		offset int64
+		want   bool
+	}{{offset: 110, want: true}, {offset: 116, want: true}, {offset: 117, want: false}, {offset: 107, want: false}} {
+		if got := repl.canPartialSyncLocked(repl.replId2, tt.offset); got != tt.want {
+			t.Errorf("replId2 offset %d: expected %v, got %v", tt.offset, tt.want, got)
+		}
+	}
+}
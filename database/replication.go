@@ -0,0 +1,506 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"simple-godis/config"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/logger"
+	"simple-godis/lib/utils"
+	"simple-godis/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+主从复制 主节点一侧
+复制流与DB.AddAof产生的指令流相同 写入复制积压缓冲区后由每个从节点各自的协程发送
+从节点首次同步时主节点短暂暂停指令 开始时间点快照并记录此时的复制偏移量 快照写入内存期间不阻塞其他指令 之后的复制流从该偏移量开始发送
+从节点断线重连时如果复制ID相同并且偏移量仍在积压缓冲区内 只补发缺失的部分
+*/
+
+const (
+	defaultReplBacklogSize = 1 << 20
+	replPingInterval       = 10 * time.Second // 主节点向从节点发送PING的周期
+)
+
+// slaveInfo 主节点记录的一个从节点
+type slaveInfo struct {
+	conn          resp.Connection
+	ip            string
+	listeningPort string
+	offset        int64     // 已经发送给从节点的偏移量
+	ackOffset     int64     // 从节点确认已经处理的偏移量
+	lastAck       time.Time // 上一次收到从节点确认的时间
+	closed        bool
+}
+
+// replication 复制状态 主节点和从节点共用复制ID 偏移量和积压缓冲区 从节点被提升为主节点后可以继续为其他从节点提供部分同步
+type replication struct {
+	mutex            sync.Mutex
+	cond             *sync.Cond // 复制流有新数据或者从节点断开时唤醒发送协程
+	replId           string     // 当前的复制ID
+	replId2          string     // 提升为主节点之前的复制ID
+	secondReplOffset int64      // replId2有效的最大偏移量
+	offset           int64      // 复制流当前的偏移量
+	backlog          *replBacklog
+	currentDB        int // 复制流中最后一次选择的分数据库 -1表示下一条指令之前需要先写入select
+	slaves           map[resp.Connection]*slaveInfo
+	listeningPorts   map[resp.Connection]string // 从节点在REPLCONF中告知的端口 完成PSYNC之前暂存
+	stopPing         chan struct{}
+
+	// 从节点相关 masterHost为空表示自己是主节点
+	masterHost   string
+	masterPort   int
+	masterConn   net.Conn  // 与主节点之间的连接
+	masterSynced bool      // 是否已经和主节点完成过同步
+	linkUp       bool      // 与主节点的连接是否正常
+	syncing      bool      // 是否正在接收主节点的快照
+	lastIO       time.Time // 最后一次收到主节点数据的时间
+	epoch        int64     // 每次修改主节点时加一 让旧的同步协程退出
+}
+
+// makeReplication replication的构造方法
+func makeReplication() *replication {
+	repl := &replication{
+		replId:           makeReplId(),
+		currentDB:        -1,
+		slaves:           make(map[resp.Connection]*slaveInfo),
+		listeningPorts:   make(map[resp.Connection]string),
+		stopPing:         make(chan struct{}),
+		secondReplOffset: -1,
+	}
+	repl.cond = sync.NewCond(&repl.mutex)
+	return repl
+}
+
+// makeReplId 生成40个字符的随机复制ID
+func makeReplId() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// backlogSize 复制积压缓冲区的大小
+func backlogSize() int {
+	if config.Properties.ReplBacklogSize <= 0 {
+		return defaultReplBacklogSize
+	}
+	return config.Properties.ReplBacklogSize
+}
+
+// isMaster 当前节点是否是主节点
+func (repl *replication) isMaster() bool {
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+	return repl.masterHost == ""
+}
+
+// feed 主节点将落盘的指令写入复制流 还没有从节点连接过时不记录
+func (repl *replication) feed(dbIndex int, lines []CmdLine) {
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+	if repl.backlog == nil || repl.masterHost != "" {
+		return
+	}
+	var buf bytes.Buffer
+	if dbIndex != repl.currentDB {
+		buf.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("select", strconv.Itoa(dbIndex))).ToBytes())
+		repl.currentDB = dbIndex
+	}
+	for _, line := range lines {
+		buf.Write(reply.MakeMultiBulkReply(line).ToBytes())
+	}
+	repl.appendLocked(buf.Bytes())
+}
+
+// appendLocked 将字节写入复制流 调用方需要持有锁
+func (repl *replication) appendLocked(data []byte) {
+	repl.backlog.write(data)
+	repl.offset += int64(len(data))
+	repl.cond.Broadcast()
+}
+
+// ensureBacklogLocked 第一个从节点连接时创建积压缓冲区
+func (repl *replication) ensureBacklogLocked() {
+	if repl.backlog == nil {
+		repl.backlog = makeReplBacklog(backlogSize(), repl.offset)
+	}
+}
+
+// startPing 定期向从节点发送PING 从节点据此判断与主节点的连接是否正常
+func (repl *replication) startPing() {
+	go func() {
+		ticker := time.NewTicker(replPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				repl.mutex.Lock()
+				if repl.backlog != nil && repl.masterHost == "" && len(repl.slaves) > 0 {
+					repl.appendLocked(reply.MakeMultiBulkReply(utils.ToCmdLine("ping")).ToBytes())
+				}
+				repl.mutex.Unlock()
+			case <-repl.stopPing:
+				return
+			}
+		}
+	}()
+}
+
+// canPartialSync 判断从节点能否从offset开始部分同步 调用方需要持有锁
+func (repl *replication) canPartialSyncLocked(replId string, offset int64) bool {
+	if repl.backlog == nil {
+		return false
+	}
+	if replId != repl.replId && (replId != repl.replId2 || offset > repl.secondReplOffset) {
+		return false
+	}
+	_, ok := repl.backlog.readFrom(offset)
+	return ok
+}
+
+// addSlaveLocked 记录一个从节点 offset为已经发送给它的偏移量 调用方需要持有锁
+func (repl *replication) addSlaveLocked(conn resp.Connection, offset int64) *slaveInfo {
+	slave := &slaveInfo{
+		conn:          conn,
+		listeningPort: repl.listeningPorts[conn],
+		offset:        offset,
+		ackOffset:     offset,
+		lastAck:       time.Now(),
+	}
+	if addr, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		if tcpAddr, ok := addr.RemoteAddr().(*net.TCPAddr); ok {
+			slave.ip = tcpAddr.IP.String()
+		}
+	}
+	delete(repl.listeningPorts, conn)
+	repl.slaves[conn] = slave
+	return slave
+}
+
+// serveSlave 不断将复制流中新的数据发送给从节点 从节点落后太多已经不在积压缓冲区内时断开连接
+func (repl *replication) serveSlave(slave *slaveInfo) {
+	// 快照发送完成后才使用replica分类的输出缓冲区限制 与redis一样快照本身不受限制
+	if replica, ok := slave.conn.(interface{ SetReplica() }); ok {
+		replica.SetReplica()
+	}
+	for {
+		repl.mutex.Lock()
+		for !slave.closed && slave.offset >= repl.offset {
+			repl.cond.Wait()
+		}
+		if slave.closed {
+			repl.mutex.Unlock()
+			return
+		}
+		data, ok := repl.backlog.readFrom(slave.offset)
+		if !ok {
+			repl.mutex.Unlock()
+			logger.Warn("replica " + slave.ip + ":" + slave.listeningPort + " is too far behind, disconnect")
+			_ = slave.conn.Close()
+			return
+		}
+		slave.offset += int64(len(data))
+		repl.mutex.Unlock()
+		if err := slave.conn.Write(data); err != nil {
+			_ = slave.conn.Close()
+			return
+		}
+	}
+}
+
+// removeSlave 连接关闭后移除从节点 唤醒它的发送协程退出
+func (repl *replication) removeSlave(conn resp.Connection) {
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+	delete(repl.listeningPorts, conn)
+	if slave, ok := repl.slaves[conn]; ok {
+		slave.closed = true
+		delete(repl.slaves, conn)
+		repl.cond.Broadcast()
+	}
+}
+
+// closeSlaves 断开所有从节点
+func (repl *replication) closeSlaves() {
+	repl.mutex.Lock()
+	slaves := make([]*slaveInfo, 0, len(repl.slaves))
+	for _, slave := range repl.slaves {
+		slave.closed = true
+		slaves = append(slaves, slave)
+	}
+	repl.slaves = make(map[resp.Connection]*slaveInfo)
+	repl.cond.Broadcast()
+	repl.mutex.Unlock()
+	for _, slave := range slaves {
+		_ = slave.conn.Close()
+	}
+}
+
+// execReplConf REPLCONF listening-port <port> | ACK <offset> | ...
+func (db *StandaloneDatabase) execReplConf(conn resp.Connection, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("replconf")
+	}
+	repl := db.repl
+	for i := 0; i < len(args); i += 2 {
+		option := strings.ToLower(string(args[i]))
+		value := string(args[i+1])
+		switch option {
+		case "listening-port":
+			repl.mutex.Lock()
+			repl.listeningPorts[conn] = value
+			repl.mutex.Unlock()
+		case "ack":
+			// 从节点的确认不需要回复
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return reply.MakeNoReply()
+			}
+			repl.mutex.Lock()
+			if slave, ok := repl.slaves[conn]; ok {
+				slave.ackOffset = offset
+				slave.lastAck = time.Now()
+			}
+			repl.mutex.Unlock()
+			return reply.MakeNoReply()
+		}
+	}
+	return reply.MakeOkReply()
+}
+
+// execPSync PSYNC <replid> <offset> 从节点请求同步 能部分同步时补发积压缓冲区中的数据 否则发送快照
+// 回复直接写入连接 之后该连接上只会收到复制流
+func (db *StandaloneDatabase) execPSync(conn resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("psync")
+	}
+	replId := string(args[0])
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	repl := db.repl
+	repl.mutex.Lock()
+	if repl.canPartialSyncLocked(replId, offset) {
+		data, _ := repl.backlog.readFrom(offset)
+		slave := repl.addSlaveLocked(conn, repl.offset)
+		header := "+CONTINUE " + repl.replId + reply.CRLF
+		repl.mutex.Unlock()
+		if err := conn.Write(append([]byte(header), data...)); err != nil {
+			repl.removeSlave(conn)
+			return reply.MakeNoReply()
+		}
+		logger.Info("partial resync with replica " + slave.ip + ":" + slave.listeningPort +
+			" from offset " + strconv.FormatInt(offset, 10))
+		go repl.serveSlave(slave)
+		return reply.MakeNoReply()
+	}
+	repl.mutex.Unlock()
+	return db.fullSync(conn)
+}
+
+// fullSync 生成快照并记录快照对应的复制偏移量 将快照发送给从节点后开始发送复制流
+func (db *StandaloneDatabase) fullSync(conn resp.Connection) resp.Reply {
+	repl := db.repl
+	snapshot, slave, replId, err := db.makeReplSnapshot(conn)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	var buf bytes.Buffer
+	buf.WriteString("+FULLRESYNC " + replId + " " + strconv.FormatInt(slave.offset, 10) + reply.CRLF)
+	buf.WriteString("$" + strconv.Itoa(len(snapshot)) + reply.CRLF)
+	buf.Write(snapshot)
+	if err := conn.Write(buf.Bytes()); err != nil {
+		repl.removeSlave(conn)
+		return reply.MakeNoReply()
+	}
+	logger.Info("full resync with replica " + slave.ip + ":" + slave.listeningPort +
+		" at offset " + strconv.FormatInt(slave.offset, 10))
+	go repl.serveSlave(slave)
+	return reply.MakeNoReply()
+}
+
+// makeReplSnapshot 暂停所有指令 只在此期间开始快照并在同一时刻记录从节点的偏移量
+// 之后逐个key写入快照 不再阻塞其他指令 快照是该偏移量时刻的数据 之后的写入从积压缓冲区中发送
+func (db *StandaloneDatabase) makeReplSnapshot(conn resp.Connection) ([]byte, *slaveInfo, string, error) {
+	var buf bytes.Buffer
+	repl := db.repl
+	db.pauseLock.Lock()
+	s, err := db.beginSnapshot(&buf)
+	if err != nil {
+		db.pauseLock.Unlock()
+		return nil, nil, "", err
+	}
+	repl.mutex.Lock()
+	repl.ensureBacklogLocked()
+	// 从节点加载快照后处于0号分数据库 复制流中的下一条指令之前需要先写入select
+	repl.currentDB = -1
+	slave := repl.addSlaveLocked(conn, repl.offset)
+	replId := repl.replId
+	repl.mutex.Unlock()
+	db.pauseLock.Unlock()
+
+	if err = db.writeSnapshot(s); err != nil {
+		repl.removeSlave(conn)
+		return nil, nil, "", err
+	}
+	return buf.Bytes(), slave, replId, nil
+}
+
+// close 关闭时停止与主节点的同步 断开所有从节点
+func (repl *replication) close() {
+	close(repl.stopPing)
+	repl.mutex.Lock()
+	repl.epoch++
+	if repl.masterConn != nil {
+		_ = repl.masterConn.Close()
+		repl.masterConn = nil
+	}
+	repl.mutex.Unlock()
+	repl.closeSlaves()
+}
+
+// parseReplicaOf 解析配置中的"host port"
+func parseReplicaOf(value string) (string, int, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return "", 0, errors.New("invalid replicaof config: " + value)
+	}
+	port, err := strconv.Atoi(fields[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, errors.New("invalid replicaof config: " + value)
+	}
+	return fields[0], port, nil
+}
+
+// 主从复制指令 作用于整个节点
+var replicationCommands = map[string]bool{
+	"replicaof": true,
+	"slaveof":   true,
+	"role":      true,
+	"psync":     true,
+	"replconf":  true,
+}
+
+// isReplicationCommand 判断是否是主从复制指令
+func isReplicationCommand(cmdName string) bool {
+	return replicationCommands[cmdName]
+}
+
+// execReplicationCommand 执行主从复制指令
+func execReplicationCommand(db *StandaloneDatabase, client resp.Connection, cmdName string, args CmdLine) resp.Reply {
+	switch cmdName {
+	case "replicaof", "slaveof":
+		return db.execReplicaOf(args[1:])
+	case "role":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return db.execRole()
+	case "psync":
+		return db.execPSync(client, args[1:])
+	case "replconf":
+		return db.execReplConf(client, args[1:])
+	}
+	return reply.MakeErrReply("ERR unknown command " + cmdName)
+}
+
+// execRole ROLE 主节点返回复制偏移量和所有从节点 从节点返回主节点的地址和连接状态
+func (db *StandaloneDatabase) execRole() resp.Reply {
+	repl := db.repl
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+	if repl.masterHost == "" {
+		slaves := make([]resp.Reply, 0, len(repl.slaves))
+		for _, slave := range repl.slaves {
+			slaves = append(slaves, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte(slave.ip)),
+				reply.MakeBulkReply([]byte(slave.listeningPort)),
+				reply.MakeBulkReply([]byte(strconv.FormatInt(slave.ackOffset, 10))),
+			}))
+		}
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("master")),
+			reply.MakeIntReply(repl.offset),
+			reply.MakeMultiRawReply(slaves),
+		})
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("slave")),
+		reply.MakeBulkReply([]byte(repl.masterHost)),
+		reply.MakeIntReply(int64(repl.masterPort)),
+		reply.MakeBulkReply([]byte(repl.linkState())),
+		reply.MakeIntReply(repl.offset),
+	})
+}
+
+// linkState 从节点与主节点之间的连接状态 调用方需要持有锁
+func (repl *replication) linkState() string {
+	switch {
+	case repl.syncing:
+		return "sync"
+	case repl.linkUp:
+		return "connected"
+	case repl.masterConn != nil:
+		return "handshake"
+	}
+	return "connect"
+}
+
+// replicationInfo INFO中主从复制相关的信息
+func replicationInfo(db *StandaloneDatabase, buf *bytes.Buffer) {
+	repl := db.repl
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+	if repl.masterHost == "" {
+		writeInfoField(buf, "role", "master")
+		writeInfoField(buf, "connected_slaves", strconv.Itoa(len(repl.slaves)))
+		i := 0
+		for _, slave := range repl.slaves {
+			lag := int64(time.Since(slave.lastAck).Seconds())
+			writeInfoField(buf, "slave"+strconv.Itoa(i), "ip="+slave.ip+",port="+slave.listeningPort+
+				",state=online,offset="+strconv.FormatInt(slave.ackOffset, 10)+",lag="+strconv.FormatInt(lag, 10))
+			i++
+		}
+	} else {
+		writeInfoField(buf, "role", "slave")
+		writeInfoField(buf, "master_host", repl.masterHost)
+		writeInfoField(buf, "master_port", strconv.Itoa(repl.masterPort))
+		linkStatus := "down"
+		lastIO := int64(-1)
+		if repl.linkUp {
+			linkStatus = "up"
+			lastIO = int64(time.Since(repl.lastIO).Seconds())
+		}
+		writeInfoField(buf, "master_link_status", linkStatus)
+		writeInfoField(buf, "master_last_io_seconds_ago", strconv.FormatInt(lastIO, 10))
+		writeInfoField(buf, "master_sync_in_progress", boolToInfo(repl.syncing))
+		writeInfoField(buf, "slave_repl_offset", strconv.FormatInt(repl.offset, 10))
+		writeInfoField(buf, "slave_read_only", boolToInfo(config.Properties.ReplicaReadOnly))
+		writeInfoField(buf, "connected_slaves", strconv.Itoa(len(repl.slaves)))
+	}
+	writeInfoField(buf, "master_replid", repl.replId)
+	replId2 := repl.replId2
+	if replId2 == "" {
+		replId2 = strings.Repeat("0", 40)
+	}
+	writeInfoField(buf, "master_replid2", replId2)
+	writeInfoField(buf, "master_repl_offset", strconv.FormatInt(repl.offset, 10))
+	writeInfoField(buf, "second_repl_offset", strconv.FormatInt(repl.secondReplOffset, 10))
+	writeInfoField(buf, "repl_backlog_active", boolToInfo(repl.backlog != nil))
+	writeInfoField(buf, "repl_backlog_size", strconv.Itoa(backlogSize()))
+	if repl.backlog != nil {
+		writeInfoField(buf, "repl_backlog_first_byte_offset", strconv.FormatInt(repl.backlog.begin, 10))
+		writeInfoField(buf, "repl_backlog_histlen", strconv.FormatInt(repl.backlog.histLen(), 10))
+	} else {
+		writeInfoField(buf, "repl_backlog_first_byte_offset", "0")
+		writeInfoField(buf, "repl_backlog_histlen", "0")
+	}
+}
@@ -0,0 +1,440 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"simple-godis/config"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/logger"
+	"simple-godis/lib/utils"
+	"simple-godis/rdb"
+	"simple-godis/resp/client"
+	"simple-godis/resp/parser"
+	"simple-godis/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+主从复制 从节点一侧
+REPLICAOF host port 之后由一个协程负责连接主节点 完成同步后不断执行主节点发来的复制流
+连接断开后每隔一段时间重连 重连时带上自己的复制ID和偏移量 主节点能补发缺失的部分时不需要重新发送快照
+*/
+
+const (
+	replRetryInterval = time.Second      // 与主节点断开后重连的间隔
+	replAckInterval   = time.Second      // 向主节点确认偏移量的周期
+	replTimeout       = 60 * time.Second // 超过该时间没有收到主节点的数据视为连接断开
+	replDialTimeout   = 5 * time.Second
+)
+
+var readOnlyErrReply = reply.MakeErrReply("READONLY You can't write against a read only replica.")
+
+// isReadOnlyReplica 当前节点是否是只读的从节点
+func (repl *replication) isReadOnlyReplica() bool {
+	return !repl.isMaster() && config.Properties.ReplicaReadOnly
+}
+
+// execReplicaOf REPLICAOF host port | REPLICAOF NO ONE
+func (db *StandaloneDatabase) execReplicaOf(args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("replicaof")
+	}
+	if strings.ToLower(string(args[0])) == "no" && strings.ToLower(string(args[1])) == "one" {
+		db.repl.promote()
+		return reply.MakeOkReply()
+	}
+	host := string(args[0])
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return reply.MakeErrReply("ERR Invalid master port")
+	}
+	if !db.replicaOf(host, port) {
+		return reply.MakeStatusReply("OK Already connected to specified master")
+	}
+	return reply.MakeOkReply()
+}
+
+// replicaOf 开始复制指定的主节点 已经在复制该主节点时返回false
+func (db *StandaloneDatabase) replicaOf(host string, port int) bool {
+	repl := db.repl
+	repl.mutex.Lock()
+	if repl.masterHost == host && repl.masterPort == port {
+		repl.mutex.Unlock()
+		return false
+	}
+	repl.masterHost = host
+	repl.masterPort = port
+	repl.linkUp = false
+	repl.epoch++
+	epoch := repl.epoch
+	if repl.masterConn != nil {
+		_ = repl.masterConn.Close()
+		repl.masterConn = nil
+	}
+	repl.mutex.Unlock()
+	// 更换主节点之后数据会和之前不同 断开下级的从节点 让它们重新同步
+	repl.closeSlaves()
+	logger.Info("start replicating " + host + ":" + strconv.Itoa(port))
+	go db.replicaLoop(epoch)
+	return true
+}
+
+// ReplicaOf 复制addr上的主节点 addr为空时提升为主节点 集群故障转移时切换主从关系使用
+func (db *StandaloneDatabase) ReplicaOf(addr string) error {
+	if addr == "" {
+		db.repl.promote()
+		return nil
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return errors.New("invalid master address " + addr)
+	}
+	db.replicaOf(host, port)
+	return nil
+}
+
+// ReplicationOffset 返回当前的复制偏移量 从节点为已经处理的复制流的偏移量
+func (db *StandaloneDatabase) ReplicationOffset() int64 {
+	db.repl.mutex.Lock()
+	defer db.repl.mutex.Unlock()
+	return db.repl.offset
+}
+
+// promote 从节点提升为主节点 保留原来的复制ID 之前的从节点可以继续部分同步
+func (repl *replication) promote() {
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+	if repl.masterHost == "" {
+		return
+	}
+	repl.masterHost = ""
+	repl.masterPort = 0
+	repl.linkUp = false
+	repl.syncing = false
+	repl.epoch++
+	if repl.masterConn != nil {
+		_ = repl.masterConn.Close()
+		repl.masterConn = nil
+	}
+	repl.replId2 = repl.replId
+	repl.secondReplOffset = repl.offset
+	repl.replId = makeReplId()
+	repl.currentDB = -1
+	logger.Info("promoted to master, new replication id " + repl.replId)
+}
+
+// isCurrentEpoch 判断同步协程是否仍然有效
+func (repl *replication) isCurrentEpoch(epoch int64) bool {
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+	return repl.epoch == epoch
+}
+
+// replicaLoop 不断与主节点同步 直到主节点被修改
+func (db *StandaloneDatabase) replicaLoop(epoch int64) {
+	repl := db.repl
+	for repl.isCurrentEpoch(epoch) {
+		err := db.syncWithMaster(epoch)
+		repl.mutex.Lock()
+		current := repl.epoch == epoch
+		if current {
+			repl.linkUp = false
+			repl.syncing = false
+			repl.masterConn = nil
+		}
+		repl.mutex.Unlock()
+		if !current {
+			return
+		}
+		if err != nil {
+			logger.Warn("replication with master failed: " + err.Error())
+		}
+		time.Sleep(replRetryInterval)
+	}
+}
+
+// deadlineReader 每次读取前设置超时时间
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	_ = r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	return r.conn.Read(p)
+}
+
+// syncWithMaster 连接主节点 完成同步后执行复制流 直到连接断开
+func (db *StandaloneDatabase) syncWithMaster(epoch int64) error {
+	repl := db.repl
+	repl.mutex.Lock()
+	addr := net.JoinHostPort(repl.masterHost, strconv.Itoa(repl.masterPort))
+	repl.mutex.Unlock()
+	conn, err := net.DialTimeout("tcp", addr, replDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	repl.mutex.Lock()
+	if repl.epoch != epoch {
+		repl.mutex.Unlock()
+		return nil
+	}
+	repl.masterConn = conn
+	replId, offset := repl.replId, repl.offset
+	if !repl.masterSynced {
+		replId, offset = "?", -1
+	}
+	repl.mutex.Unlock()
+
+	reader := bufio.NewReader(&deadlineReader{conn: conn, timeout: replTimeout})
+	if config.Properties.MasterAuth != "" {
+		if err = sendReplCommand(conn, reader, utils.ToCmdLine("auth", config.Properties.MasterAuth)); err != nil {
+			return err
+		}
+	}
+	port := strconv.Itoa(config.Properties.Port)
+	if err = sendReplCommand(conn, reader, utils.ToCmdLine("replconf", "listening-port", port)); err != nil {
+		return err
+	}
+	_, err = conn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("psync", replId, strconv.FormatInt(offset, 10))).ToBytes())
+	if err != nil {
+		return err
+	}
+	line, err := readReplLine(reader)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, "+"))
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return errors.New("bad FULLRESYNC reply: " + line)
+		}
+		if err = db.receiveSnapshot(reader, epoch, fields[1], masterOffset); err != nil {
+			return err
+		}
+	case len(fields) == 2 && fields[0] == "CONTINUE":
+		repl.continueFrom(fields[1])
+		logger.Info("partial resync with master accepted")
+	default:
+		return errors.New("unexpected PSYNC reply: " + line)
+	}
+
+	repl.mutex.Lock()
+	if repl.epoch != epoch {
+		repl.mutex.Unlock()
+		return nil
+	}
+	repl.linkUp = true
+	repl.lastIO = time.Now()
+	repl.mutex.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go repl.sendAck(conn, done)
+	// 主节点发来的复制流作为内部连接执行 不做权限检查
+	masterClient := client.NewClient(conn)
+	masterClient.SetInternal(true)
+	return db.applyReplStream(masterClient, reader, epoch)
+}
+
+// sendReplCommand 发送一条指令并读取一行回复 回复不是OK时返回error
+func sendReplCommand(conn net.Conn, reader *bufio.Reader, cmdLine CmdLine) error {
+	if _, err := conn.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+		return err
+	}
+	line, err := readReplLine(reader)
+	if err != nil {
+		return err
+	}
+	if strings.TrimPrefix(line, "+") != "OK" {
+		return errors.New(string(cmdLine[0]) + " failed: " + line)
+	}
+	return nil
+}
+
+// readReplLine 读取一行 去掉行尾的CRLF
+func readReplLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, reply.CRLF), nil
+}
+
+// receiveSnapshot 读取主节点发来的快照 暂停所有指令后清空数据并加载快照
+func (db *StandaloneDatabase) receiveSnapshot(reader *bufio.Reader, epoch int64, replId string, offset int64) error {
+	repl := db.repl
+	repl.mutex.Lock()
+	repl.syncing = true
+	repl.mutex.Unlock()
+
+	line, err := readReplLine(reader)
+	if err != nil {
+		return err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return errors.New("bad snapshot header: " + line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || size < 0 {
+		return errors.New("bad snapshot header: " + line)
+	}
+	snapshot := make([]byte, size)
+	if _, err = io.ReadFull(reader, snapshot); err != nil {
+		return err
+	}
+
+	db.pauseLock.Lock()
+	repl.mutex.Lock()
+	if repl.epoch != epoch {
+		repl.mutex.Unlock()
+		db.pauseLock.Unlock()
+		return nil
+	}
+	repl.mutex.Unlock()
+	for _, database := range db.dbSet {
+		database.FlushKeys()
+	}
+	now := time.Now()
+	err = rdb.Decode(bytes.NewReader(snapshot), func(dbIndex int, key string, entity *dbInterface.DataEntity, expiration *time.Time) error {
+		if dbIndex < 0 || dbIndex >= len(db.dbSet) {
+			return errors.New("db index " + strconv.Itoa(dbIndex) + " is out of range")
+		}
+		if expiration != nil && now.After(*expiration) {
+			return nil
+		}
+		database := db.dbSet[dbIndex]
+		database.PutEntity(key, entity)
+		if expiration != nil {
+			database.Expire(key, *expiration)
+		}
+		return nil
+	})
+	repl.mutex.Lock()
+	if err == nil {
+		repl.replId = replId
+		repl.replId2 = ""
+		repl.secondReplOffset = -1
+		repl.offset = offset
+		repl.backlog = makeReplBacklog(backlogSize(), offset)
+		repl.masterSynced = true
+	}
+	repl.syncing = false
+	repl.mutex.Unlock()
+	db.pauseLock.Unlock()
+	if err != nil {
+		return errors.New("load snapshot from master failed: " + err.Error())
+	}
+	logger.Info("full resync with master done, " + strconv.FormatInt(size, 10) + " bytes loaded")
+	// 快照中的数据没有经过AddAof 通过重写让aof文件与内存中的数据一致
+	if db.aofHandler != nil {
+		if err := db.aofHandler.BackgroundRewrite(); err != nil {
+			logger.Warn("rewrite aof after full resync failed: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// continueFrom 部分同步成功 主节点的复制ID变化时记录旧的复制ID
+func (repl *replication) continueFrom(replId string) {
+	repl.mutex.Lock()
+	defer repl.mutex.Unlock()
+	if replId != repl.replId {
+		repl.replId2 = repl.replId
+		repl.secondReplOffset = repl.offset
+		repl.replId = replId
+	}
+}
+
+// sendAck 定期告知主节点自己的复制偏移量
+func (repl *replication) sendAck(conn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(replAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			repl.mutex.Lock()
+			offset := repl.offset
+			repl.mutex.Unlock()
+			ack := utils.ToCmdLine("replconf", "ack", strconv.FormatInt(offset, 10))
+			if _, err := conn.Write(reply.MakeMultiBulkReply(ack).ToBytes()); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// execReplCommand 执行复制流中的一条指令 出错时只记录日志
+func (db *StandaloneDatabase) execReplCommand(masterClient *client.Client, cmdLine CmdLine) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(err)
+		}
+	}()
+	result := db.execCommand(masterClient, cmdLine)
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		logger.Warn("replicated command failed: " + errReply.Error())
+	}
+}
+
+// applyReplStream 执行主节点发来的复制流 每条指令执行后增加复制偏移量并写入自己的积压缓冲区
+func (db *StandaloneDatabase) applyReplStream(masterClient *client.Client, reader io.Reader, epoch int64) error {
+	repl := db.repl
+	ch := parser.ParseStream(reader)
+	defer func() {
+		// 连接关闭后解析协程还会发送一次错误 读完避免协程泄漏
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+	for payload := range ch {
+		if payload.Err != nil {
+			return payload.Err
+		}
+		cmd, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok {
+			return errors.New("unexpected data in replication stream")
+		}
+		raw := cmd.ToBytes()
+		// 与客户端执行的指令一样 清空分数据库时独占整个数据库
+		lockPause, unlockPause := db.pauseLock.RLock, db.pauseLock.RUnlock
+		if isFlushCommand(cmd.Msg) {
+			lockPause, unlockPause = db.pauseLock.Lock, db.pauseLock.Unlock
+		}
+		lockPause()
+		repl.mutex.Lock()
+		current := repl.epoch == epoch
+		repl.mutex.Unlock()
+		if !current {
+			unlockPause()
+			return nil
+		}
+		db.execReplCommand(masterClient, cmd.Msg)
+		repl.mutex.Lock()
+		repl.backlog.write(raw)
+		repl.offset += int64(len(raw))
+		repl.lastIO = time.Now()
+		repl.cond.Broadcast()
+		repl.mutex.Unlock()
+		unlockPause()
+	}
+	return errors.New("connection with master lost")
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"net"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/resp/client"
+	"testing"
+)
+
+func TestMakeReplSnapshot(t *testing.T) {
+	db := MakeBasicStandaloneDatabase()
+	db.dbSet[0].PutEntity("a", &dbInterface.DataEntity{Data: []byte("1")})
+	db.dbSet[2].PutEntity("b", &dbInterface.DataEntity{Data: []byte("2")})
+	db.repl.mutex.Lock()
+	db.repl.offset = 42
+	db.repl.mutex.Unlock()
+
+	conn, other := net.Pipe()
+	defer func() {
+		_ = conn.Close()
+		_ = other.Close()
+	}()
+	replica := client.NewClient(conn)
+	data, slave, replId, err := db.makeReplSnapshot(replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 从节点在开始快照的时刻登记 之后的写入从这个偏移量开始发送
+	if slave.offset != 42 || replId != db.repl.replId || db.repl.slaves[replica] != slave {
+		t.Fatalf("unexpected slave offset %d replId %s", slave.offset, replId)
+	}
+	if got := decodeSnapshot(t, data); len(got) != 2 || got[0] != "0/a=1" || got[1] != "2/b=2" {
+		t.Fatalf("unexpected snapshot %q", got)
+	}
+	// 写入快照期间和之后都不再持有pauseLock
+	db.pauseLock.Lock()
+	db.pauseLock.Unlock()
+	if len(db.snapshots.snapshots) != 0 {
+		t.Fatal("snapshot is still tracked after finished")
+	}
+}
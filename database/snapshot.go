@@ -0,0 +1,176 @@
+package database
+
+import (
+	"io"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/lib/logger"
+	"simple-godis/rdb"
+	"sync"
+	"time"
+)
+
+/*
+时间点快照 SAVE BGSAVE和主从全量同步使用
+开始时调用方持有pauseLock的写锁 此时没有正在执行的指令 之后不再暂停其他指令 逐个key加读锁写入快照
+写指令修改一个还没有写入快照的key之前 先将它当前的值写入快照 因此快照中每个key都是开始时刻的值
+开始之后新建的key同样标记为已经处理 遍历到时跳过 清空分数据库之前先写入其中剩余的所有key
+*/
+
+// snapshot 一次正在进行的快照
+type snapshot struct {
+	mutex   sync.Mutex
+	encoder *rdb.Encoder
+	start   time.Time             // 开始的时刻 此时已经过期的key不写入快照
+	dbIndex int                   // 编码器当前选择的分数据库 -1表示还没有选择
+	visited []map[string]struct{} // 每个分数据库中已经处理过的key
+	sealed  []bool                // 分数据库被清空之后其中的key都是开始之后新建的 不再写入
+	err     error                 // 写入出错之后不再写入 结束时返回
+}
+
+// snapshotTracker 记录正在进行的快照 所有分数据库共享
+type snapshotTracker struct {
+	mutex     sync.RWMutex
+	snapshots map[*snapshot]struct{}
+}
+
+func makeSnapshotTracker() *snapshotTracker {
+	return &snapshotTracker{
+		snapshots: make(map[*snapshot]struct{}),
+	}
+}
+
+func (tracker *snapshotTracker) add(s *snapshot) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.snapshots[s] = struct{}{}
+}
+
+// remove 等待正在写入旧值的指令结束 之后不会再有指令写入这个快照
+func (tracker *snapshotTracker) remove(s *snapshot) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	delete(tracker.snapshots, s)
+}
+
+// beforeWrite 写指令修改keys之前调用 调用方需要持有keys的写锁
+func (tracker *snapshotTracker) beforeWrite(db *DB, keys []string) {
+	tracker.mutex.RLock()
+	defer tracker.mutex.RUnlock()
+	for s := range tracker.snapshots {
+		s.capture(db, keys)
+	}
+}
+
+// beforeFlush 清空分数据库之前调用 调用方需要持有pauseLock的写锁
+func (tracker *snapshotTracker) beforeFlush(db *DB) {
+	tracker.mutex.RLock()
+	defer tracker.mutex.RUnlock()
+	for s := range tracker.snapshots {
+		s.captureAll(db)
+	}
+}
+
+// capture 将还没有处理过的key写入快照 调用方需要持有key的锁
+func (s *snapshot) capture(db *DB, keys []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, key := range keys {
+		s.captureLocked(db, key)
+	}
+}
+
+// captureAll 将分数据库中所有还没有处理过的key写入快照 之后不再写入这个分数据库
+func (s *snapshot) captureAll(db *DB) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, key := range db.Data.Keys() {
+		s.captureLocked(db, key)
+	}
+	s.sealed[db.index] = true
+	s.visited[db.index] = nil
+}
+
+func (s *snapshot) captureLocked(db *DB, key string) {
+	if s.sealed[db.index] {
+		return
+	}
+	if _, ok := s.visited[db.index][key]; ok {
+		return
+	}
+	s.visited[db.index][key] = struct{}{}
+	if s.err != nil {
+		return
+	}
+	raw, ok := db.Data.Get(key)
+	if !ok {
+		return
+	}
+	entity, _ := raw.(*dbInterface.DataEntity)
+	var expiration *time.Time
+	if expireTime, ok := db.GetExpiration(key); ok {
+		if s.start.After(expireTime) {
+			return
+		}
+		expiration = &expireTime
+	}
+	if s.dbIndex != db.index {
+		if s.err = s.encoder.WriteDBIndex(db.index); s.err != nil {
+			return
+		}
+		s.dbIndex = db.index
+	}
+	s.err = s.encoder.WriteEntity(key, entity, expiration)
+	if s.err == rdb.ErrUnsupportedType {
+		logger.Warn("skip key " + key + " with unsupported type when saving")
+		s.err = nil
+	}
+}
+
+// failed 写入是否已经出错
+func (s *snapshot) failed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err != nil
+}
+
+// beginSnapshot 开始一次快照 调用方需要持有pauseLock的写锁 保证开始时没有写到一半的指令
+func (db *StandaloneDatabase) beginSnapshot(w io.Writer) (*snapshot, error) {
+	encoder, err := rdb.NewEncoder(w)
+	if err != nil {
+		return nil, err
+	}
+	s := &snapshot{
+		encoder: encoder,
+		start:   time.Now(),
+		dbIndex: -1,
+		visited: make([]map[string]struct{}, len(db.dbSet)),
+		sealed:  make([]bool, len(db.dbSet)),
+	}
+	for i := range s.visited {
+		s.visited[i] = make(map[string]struct{})
+	}
+	db.snapshots.add(s)
+	return s, nil
+}
+
+// writeSnapshot 逐个key加读锁写入快照 已经被写指令提前写入的key会被跳过 结束后写入文件尾
+func (db *StandaloneDatabase) writeSnapshot(s *snapshot) error {
+	for _, database := range db.dbSet {
+		if s.failed() {
+			break
+		}
+		for _, key := range database.Data.Keys() {
+			database.locker.RLock(key)
+			s.capture(database, []string{key})
+			database.locker.RUnLock(key)
+			if s.failed() {
+				break
+			}
+		}
+	}
+	db.snapshots.remove(s)
+	if s.err != nil {
+		return s.err
+	}
+	return s.encoder.Close()
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"bytes"
+	"reflect"
+	List "simple-godis/datastructure/list"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/utils"
+	"simple-godis/rdb"
+	"simple-godis/resp/reply"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// decodeSnapshot 将快照中的键值对转换为 分数据库/key=值 的形式 列表的元素用逗号连接
+func decodeSnapshot(t *testing.T, data []byte) []string {
+	t.Helper()
+	var result []string
+	err := rdb.Decode(bytes.NewReader(data), func(dbIndex int, key string, entity *dbInterface.DataEntity, expiration *time.Time) error {
+		value := ""
+		switch val := entity.Data.(type) {
+		case []byte:
+			value = string(val)
+		case List.List:
+			val.ForEach(func(i int, v interface{}) bool {
+				if i > 0 {
+					value += ","
+				}
+				value += string(v.([]byte))
+				return true
+			})
+		}
+		if expiration != nil {
+			value += " ttl"
+		}
+		result = append(result, strconv.Itoa(dbIndex)+"/"+key+"="+value)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestSnapshotPointInTime(t *testing.T) {
+	db := MakeBasicStandaloneDatabase()
+	db0, db1 := db.dbSet[0], db.dbSet[1]
+	list := List.MakeQuickList()
+	list.Add([]byte("a"))
+	db0.PutEntity("list", &dbInterface.DataEntity{Data: list})
+	db0.PutEntity("str", &dbInterface.DataEntity{Data: []byte("old")})
+	db0.PutEntity("ttl", &dbInterface.DataEntity{Data: []byte("v")})
+	db0.Expire("ttl", time.Now().Add(time.Hour))
+	db0.PutEntity("deleted", &dbInterface.DataEntity{Data: []byte("v")})
+	db1.PutEntity("flushed", &dbInterface.DataEntity{Data: []byte("v")})
+
+	var buf bytes.Buffer
+	db.pauseLock.Lock()
+	s, err := db.beginSnapshot(&buf)
+	db.pauseLock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 开始之后的写入 包括原地修改列表 覆盖 删除 新建key和清空分数据库
+	rpush := &command{flags: FlagWrite, executor: func(db *DB, args [][]byte) resp.Reply {
+		entity, _ := db.GetEntity(string(args[0]))
+		entity.Data.(List.List).Add(args[1])
+		return reply.MakeOkReply()
+	}}
+	db0.execWithLock(rpush, utils.ToCmdLine("rpush", "list", "b"), []string{"list"}, nil)
+	put := func(database *DB, key string, value string) {
+		keys := []string{key}
+		database.RWLocks(keys, nil)
+		defer database.RWUnLocks(keys, nil)
+		database.snapshots.beforeWrite(database, keys)
+		database.PutEntity(key, &dbInterface.DataEntity{Data: []byte(value)})
+	}
+	put(db0, "str", "new")
+	put(db0, "created", "v")
+	db0.snapshots.beforeWrite(db0, []string{"deleted"})
+	db0.RemoveEntity("deleted")
+	db.pauseLock.Lock()
+	db1.FlushKeys()
+	db.pauseLock.Unlock()
+	put(db1, "afterFlush", "v")
+
+	if err := db.writeSnapshot(s); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"0/deleted=v", "0/list=a", "0/str=old", "0/ttl=v ttl", "1/flushed=v"}
+	if got := decodeSnapshot(t, buf.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	// 快照结束之后的写入不再写入快照
+	put(db0, "str", "later")
+	if len(db.snapshots.snapshots) != 0 {
+		t.Fatal("snapshot is still tracked after finished")
+	}
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	SortedSet "simple-godis/datastructure/sortedset"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/resp/reply"
+)
+
+// GetAsSortedSet 以key为键获取一个有序集合
+func (db *DB) GetAsSortedSet(key string) (*SortedSet.SortedSet, reply.ErrorReply) {
+	entity, existed := db.GetEntity(key)
+	if !existed {
+		return nil, nil
+	}
+	sortedSet, ok := entity.Data.(*SortedSet.SortedSet)
+	if !ok {
+		return nil, reply.MakeWrongTypeReply()
+	}
+	return sortedSet, nil
+}
+
+// GetOrInitSortedSet 尝试以key为键从数据库中获取一个有序集合，如果获取不到则创建一个新的并放入到数据库实体中
+func (db *DB) GetOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, init bool, errorReply reply.ErrorReply) {
+	sortedSet, errorReply = db.GetAsSortedSet(key)
+	if errorReply != nil {
+		return nil, false, errorReply
+	}
+	init = false
+	if sortedSet == nil {
+		sortedSet = SortedSet.MakeSortedSet()
+		db.PutEntity(key, &dbInterface.DataEntity{
+			Data: sortedSet,
+		})
+		init = true
+	}
+	return sortedSet, init, nil
+}
@@ -1,47 +1,115 @@
 package database
 
 import (
+	"errors"
 	"simple-godis/aof"
 	"simple-godis/config"
+	dbInterface "simple-godis/interface/database"
 	"simple-godis/interface/resp"
 	"simple-godis/lib/logger"
+	"simple-godis/lib/sync/atomic"
+	"simple-godis/pubsub"
 	"simple-godis/resp/reply"
 	"strconv"
 	"strings"
+	"sync"
+	syncAtomic "sync/atomic"
+	"time"
 )
 
 type StandaloneDatabase struct {
 	dbSet      []*DB
 	aofHandler *aof.AofHandler
+	hub        *pubsub.Hub // 发布订阅中心
+
+	// 主从复制相关
+	repl      *replication
+	pauseLock sync.RWMutex // 执行指令时加读锁 开始快照和清空数据库时加写锁暂停所有指令
+
+	// 快照相关
+	snapshots    *snapshotTracker // 正在进行的快照 所有分数据库共享
+	dirty        int64            // 上次保存快照之后的修改次数
+	lastSave     int64            // 上次成功保存快照的时间 unix秒
+	lastSaveOk   atomic.Boolean   // 上次保存快照是否成功
+	saving       bool             // 是否正在保存快照 由saveMutex保护
+	saveMutex    sync.Mutex
+	saveRules    []saveRule    // 自动保存的规则
+	stopSaveCron chan struct{} // 通知自动保存协程退出
+
+	// 集群跨节点指令的事务
+	txMutex      sync.Mutex
+	transactions map[string]*clusterTx
 }
 
 // MakeStandaloneDatabases 初始化数据库和分库以及处理指令文件记录的处理器
 func MakeStandaloneDatabases() *StandaloneDatabase {
-	databases := &StandaloneDatabase{}
-	if config.Properties.Databases == 0 {
-		config.Properties.Databases = 8
+	databases := MakeBasicStandaloneDatabase()
+	databases.saveRules = parseSaveRules(config.Properties.Save)
+	databases.stopSaveCron = make(chan struct{})
+	databases.lastSave = time.Now().Unix()
+	databases.lastSaveOk.Set(true)
+	if err := initACL(); err != nil {
+		panic(err)
 	}
-	databases.dbSet = make([]*DB, config.Properties.Databases)
-	for i := range databases.dbSet {
-		database := MakeDB()
-		database.index = i
-		databases.dbSet[i] = database
+	// 开启aof时以aof文件为准 否则从快照文件恢复数据
+	if !config.Properties.AppendOnly {
+		databases.loadRdb()
 	}
 	// 初始化AofHandler
 	if config.Properties.AppendOnly {
-		aofHandler, err := aof.NewAofHandler(databases)
+		aofHandler, err := aof.NewAofHandler(databases, func() dbInterface.DBEngine {
+			return MakeBasicStandaloneDatabase()
+		})
 		if err != nil {
 			panic(err)
 		}
 		databases.aofHandler = aofHandler
-		// 将落盘方法逐个添加到每个分数据库中
-		for _, db := range databases.dbSet {
-			finalDb := db
-			finalDb.AddAof = func(line CmdLine) {
-				databases.aofHandler.AddAof(finalDb.index, line)
+	}
+	// 将落盘方法逐个添加到每个分数据库中 落盘的指令数同时作为自动保存快照的修改次数 同时写入复制流
+	for _, db := range databases.dbSet {
+		finalDb := db
+		finalDb.AddAof = func(lines ...CmdLine) {
+			syncAtomic.AddInt64(&databases.dirty, int64(len(lines)))
+			if databases.aofHandler != nil {
+				databases.aofHandler.AddAof(finalDb.index, lines...)
 			}
+			databases.repl.feed(finalDb.index, lines)
 		}
 	}
+	// 加载完数据后再为每个分数据库启动定期删除
+	for _, db := range databases.dbSet {
+		db.startActiveExpire()
+	}
+	databases.startSaveCron()
+	databases.repl.startPing()
+	if config.Properties.ReplicaOf != "" {
+		host, port, err := parseReplicaOf(config.Properties.ReplicaOf)
+		if err != nil {
+			panic(err)
+		}
+		databases.replicaOf(host, port)
+	}
+	return databases
+}
+
+// MakeBasicStandaloneDatabase 只初始化数据库和分库 不落盘也不启动定期删除 用于AOF重写时的临时数据库
+func MakeBasicStandaloneDatabase() *StandaloneDatabase {
+	databases := &StandaloneDatabase{
+		hub:          pubsub.MakeHub(),
+		repl:         makeReplication(),
+		snapshots:    makeSnapshotTracker(),
+		transactions: make(map[string]*clusterTx),
+	}
+	if config.Properties.Databases == 0 {
+		config.Properties.Databases = 8
+	}
+	databases.dbSet = make([]*DB, config.Properties.Databases)
+	for i := range databases.dbSet {
+		database := MakeDB()
+		database.index = i
+		database.snapshots = databases.snapshots
+		databases.dbSet[i] = database
+	}
 	return databases
 }
 
@@ -51,24 +119,250 @@ func (db *StandaloneDatabase) Exec(client resp.Connection, args CmdLine) resp.Re
 			logger.Error(err)
 		}
 	}()
+	cmdName := strings.ToLower(string(args[0]))
+	// 指令表中的指令在DB.Execute中连同key一起检查权限
+	if _, ok := CommandTable[cmdName]; !ok {
+		if errReply := checkPermission(client, cmdName, nil); errReply != nil {
+			return errReply
+		}
+	}
+	// 订阅了频道或模式的客户端只能执行发布订阅相关的指令
+	if client.SubsCount() > 0 && !subscribedCommands[cmdName] {
+		return reply.MakeErrReply("ERR Can't execute '" + cmdName +
+			"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+	}
+	if isPubSubCommand(cmdName) {
+		if client.InMultiState() {
+			return reply.MakeErrReply("ERR " + strings.ToUpper(cmdName) + " inside MULTI is not allowed")
+		}
+		return execPubSubCommand(db.hub, client, cmdName, args)
+	}
+	if cmdName == "info" {
+		return execInfo(db, args[1:])
+	}
+	if cmdName == "acl" {
+		if client.InMultiState() {
+			return reply.MakeErrReply("ERR ACL inside MULTI is not allowed")
+		}
+		return execACL(client, args)
+	}
+	if isPersistCommand(cmdName) {
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		if client.InMultiState() {
+			return reply.MakeErrReply("ERR " + strings.ToUpper(cmdName) + " inside MULTI is not allowed")
+		}
+		return execPersistCommand(db, cmdName)
+	}
+	if isReplicationCommand(cmdName) {
+		if client.InMultiState() {
+			return reply.MakeErrReply("ERR " + strings.ToUpper(cmdName) + " inside MULTI is not allowed")
+		}
+		return execReplicationCommand(db, client, cmdName, args)
+	}
+	// 只读的从节点只接受主节点通过复制流发来的写指令
+	if db.repl.isReadOnlyReplica() && IsWriteCommand(args) {
+		if client.InMultiState() {
+			client.AddTxError(errors.New(readOnlyErrReply.Error()))
+		}
+		return readOnlyErrReply
+	}
+	if isFlushCommand(args) {
+		if client.InMultiState() {
+			return reply.MakeErrReply("ERR FLUSH inside MULTI is not allowed")
+		}
+		// 清空分数据库时不加key的锁 加pauseLock的写锁暂停其他所有指令
+		db.pauseLock.Lock()
+		defer db.pauseLock.Unlock()
+		return db.execCommand(client, args)
+	}
+	db.pauseLock.RLock()
+	defer db.pauseLock.RUnlock()
+	return db.execCommand(client, args)
+}
+
+// isFlushCommand 判断是否是清空分数据库的指令 执行时需要独占整个数据库
+func isFlushCommand(cmdLine CmdLine) bool {
+	return len(cmdLine) > 0 && strings.ToLower(string(cmdLine[0])) == "flush"
+}
+
+// execCommand 执行select 事务指令和普通指令 从节点执行复制流时也通过这里执行
+func (db *StandaloneDatabase) execCommand(client resp.Connection, args CmdLine) resp.Reply {
 	cmdName := strings.ToLower(string(args[0]))
 	if cmdName == "select" {
 		if len(args) != 2 {
 			return reply.MakeArgNumErrReply("select")
 		}
+		if client.InMultiState() {
+			return reply.MakeErrReply("ERR SELECT inside MULTI is not allowed")
+		}
 		return executeSelect(client, db, args[1:])
 	}
 	dbIndex := client.GetDBIndex()
 	database := db.dbSet[dbIndex]
+	if isTxCommand(cmdName) {
+		return execTxCommand(db, database, client, cmdName, args)
+	}
+	// 处于事务状态时指令进入队列 等待EXEC时执行
+	if client.InMultiState() && cmdName != "exit" {
+		return enqueueCmd(client, args)
+	}
 	return database.Execute(client, args)
 }
 
+// execTxCommand 执行事务控制指令
+func execTxCommand(db *StandaloneDatabase, database *DB, client resp.Connection, cmdName string, args CmdLine) resp.Reply {
+	switch cmdName {
+	case "multi":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return startMulti(client)
+	case "exec":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execMulti(db, database, client)
+	case "discard":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return discardMulti(db, client)
+	case "watch":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execWatch(database, client, args[1:])
+	case "unwatch":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execUnwatch(db, client)
+	}
+	return reply.MakeErrReply("ERR unknown command " + cmdName)
+}
+
+// 发布订阅指令 不属于任何一个分数据库
+var pubSubCommands = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"publish":      true,
+	"pubsub":       true,
+}
+
+// 订阅状态下允许执行的指令
+var subscribedCommands = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ping":         true,
+	"exit":         true,
+}
+
+// isPubSubCommand 判断是否是发布订阅指令
+func isPubSubCommand(cmdName string) bool {
+	return pubSubCommands[cmdName]
+}
+
+// execPubSubCommand 执行发布订阅指令
+func execPubSubCommand(hub *pubsub.Hub, client resp.Connection, cmdName string, args CmdLine) resp.Reply {
+	switch cmdName {
+	case "subscribe":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return pubsub.Subscribe(hub, client, args[1:])
+	case "unsubscribe":
+		return pubsub.UnSubscribe(hub, client, args[1:])
+	case "psubscribe":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return pubsub.PSubscribe(hub, client, args[1:])
+	case "punsubscribe":
+		return pubsub.PUnSubscribe(hub, client, args[1:])
+	case "publish":
+		return pubsub.Publish(hub, args[1:])
+	case "pubsub":
+		return pubsub.PubSub(hub, args[1:])
+	}
+	return reply.MakeErrReply("ERR unknown command " + cmdName)
+}
+
 func (db *StandaloneDatabase) Close() {
+	db.repl.close()
+	for _, database := range db.dbSet {
+		database.stopActiveExpire()
+	}
+	if db.aofHandler != nil {
+		db.aofHandler.Close()
+	}
+	if db.stopSaveCron != nil {
+		close(db.stopSaveCron)
+	}
+	// 配置了自动保存规则时 关闭前保存一次快照
+	if len(db.saveRules) > 0 {
+		if err := db.Save(); err != nil {
+			logger.Error("save before shutdown failed: ", err)
+		}
+	}
 	logger.Info("DB closed")
 }
 
+// AfterClientClose 客户端断开连接后取消它的所有订阅和WATCH 断开的是从节点时不再向它发送复制流
 func (db *StandaloneDatabase) AfterClientClose(conn resp.Connection) {
+	pubsub.UnsubscribeAll(db.hub, conn)
+	db.unwatchAll(conn)
+	db.repl.removeSlave(conn)
+}
 
+// ForEach 遍历一个分数据库中所有未过期的key
+func (db *StandaloneDatabase) ForEach(dbIndex int, consumer func(key string, entity *dbInterface.DataEntity, expiration *time.Time) bool) {
+	db.dbSet[dbIndex].ForEach(consumer)
+}
+
+// 持久化指令 作用于所有分数据库
+var persistCommands = map[string]bool{
+	"save":         true,
+	"bgsave":       true,
+	"lastsave":     true,
+	"bgrewriteaof": true,
+}
+
+// isPersistCommand 判断是否是持久化指令
+func isPersistCommand(cmdName string) bool {
+	return persistCommands[cmdName]
+}
+
+// execPersistCommand 执行持久化指令
+func execPersistCommand(db *StandaloneDatabase, cmdName string) resp.Reply {
+	switch cmdName {
+	case "save":
+		if err := db.Save(); err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeOkReply()
+	case "bgsave":
+		if err := db.BackgroundSave(); err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeStatusReply("Background saving started")
+	case "lastsave":
+		return reply.MakeIntReply(syncAtomic.LoadInt64(&db.lastSave))
+	case "bgrewriteaof":
+		if db.aofHandler == nil {
+			return reply.MakeErrReply("ERR append only file is disabled")
+		}
+		if err := db.aofHandler.BackgroundRewrite(); err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeStatusReply("Background append only file rewriting started")
+	}
+	return reply.MakeErrReply("ERR unknown command " + cmdName)
 }
 
 // executeSelect 执行选择数据库指令
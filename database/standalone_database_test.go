@@ -0,0 +1,36 @@
+package database
+
+import (
+	"simple-godis/lib/utils"
+	"testing"
+	"time"
+)
+
+func TestFlushExclusive(t *testing.T) {
+	db := MakeBasicStandaloneDatabase()
+	c := makeInternalClient()
+
+	// 其他指令执行期间持有pauseLock的读锁 FLUSH需要等待它们结束
+	db.pauseLock.RLock()
+	done := make(chan struct{})
+	go func() {
+		db.Exec(c, utils.ToCmdLine("flush"))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("FLUSH ran while another command was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	db.pauseLock.RUnlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("FLUSH did not run after other commands finished")
+	}
+
+	c.SetMultiState(true)
+	if result := db.Exec(c, utils.ToCmdLine("flush")); string(result.ToBytes()) != "-ERR FLUSH inside MULTI is not allowed\r\n" {
+		t.Fatalf("expected FLUSH to be rejected inside MULTI, got %q", result.ToBytes())
+	}
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"errors"
+	"simple-godis/aof"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+集群中跨节点的原子指令 每个节点上执行的部分作为一个事务 使用try-commit-cancel协议
+prepare 锁住指令涉及的key 记录写入的key当前的值作为回滚日志 之后一直持有锁
+与普通指令一样先加pauseLock的读锁再加key的锁 直到提交或回滚时释放
+commit 在持有锁的情况下执行指令 然后释放锁
+rollback 还没有提交时直接释放锁 已经提交时重新加锁用回滚日志恢复写入的key
+协调者宕机时 没有提交的事务在超时后自动回滚
+*/
+
+const clusterTxTimeout = 5 * time.Second
+
+const (
+	txPrepared = iota
+	txCommitted
+	txRolledBack
+)
+
+// clusterTx 跨节点指令在本节点上的事务
+type clusterTx struct {
+	mutex     sync.Mutex
+	db        *DB
+	cmdLine   CmdLine
+	writeKeys []string
+	readKeys  []string
+	undoLog   []CmdLine // 回滚时依次执行的指令
+	status    int
+}
+
+// PrepareTx 锁住cmdLine涉及的key并记录回滚日志 txId用于之后提交或回滚
+func (db *StandaloneDatabase) PrepareTx(txId string, dbIndex int, cmdLine CmdLine) error {
+	if dbIndex < 0 || dbIndex >= len(db.dbSet) {
+		return errors.New("ERR DB index is out of range")
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := CommandTable[cmdName]
+	if !ok {
+		return errors.New("ERR unknown command " + cmdName)
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return errors.New("ERR wrong number of arguments for '" + cmdName + "' command")
+	}
+	database := db.dbSet[dbIndex]
+	writeKeys, readKeys := cmd.prepare(cmdLine[1:])
+	tx := &clusterTx{
+		db:        database,
+		cmdLine:   cmdLine,
+		writeKeys: writeKeys,
+		readKeys:  readKeys,
+	}
+	db.pauseLock.RLock()
+	database.RWLocks(writeKeys, readKeys)
+	db.txMutex.Lock()
+	// 协调者可能在等待锁的期间已经放弃了这个事务
+	if _, exists := db.transactions[txId]; exists {
+		db.txMutex.Unlock()
+		database.RWUnLocks(writeKeys, readKeys)
+		db.pauseLock.RUnlock()
+		return errors.New("ERR transaction " + txId + " already exists")
+	}
+	tx.undoLog = makeUndoLog(database, writeKeys)
+	db.transactions[txId] = tx
+	db.txMutex.Unlock()
+
+	time.AfterFunc(clusterTxTimeout, func() {
+		tx.mutex.Lock()
+		if tx.status == txPrepared {
+			tx.unlock(db)
+			tx.status = txRolledBack
+		}
+		tx.mutex.Unlock()
+		db.txMutex.Lock()
+		delete(db.transactions, txId)
+		db.txMutex.Unlock()
+	})
+	return nil
+}
+
+// CommitTx 执行预备好的指令并释放锁
+func (db *StandaloneDatabase) CommitTx(txId string) (resp.Reply, error) {
+	tx := db.getTx(txId)
+	if tx == nil {
+		return nil, errors.New("ERR transaction " + txId + " not found")
+	}
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	if tx.status != txPrepared {
+		return nil, errors.New("ERR transaction " + txId + " is not prepared")
+	}
+	result := tx.db.execNormalCommand(tx.cmdLine)
+	tx.unlock(db)
+	tx.status = txCommitted
+	return result, nil
+}
+
+// RollbackTx 回滚事务 事务不存在时记录下来 之后迟到的prepare会失败
+func (db *StandaloneDatabase) RollbackTx(txId string) error {
+	db.txMutex.Lock()
+	tx, ok := db.transactions[txId]
+	if !ok {
+		db.transactions[txId] = &clusterTx{status: txRolledBack}
+		db.txMutex.Unlock()
+		time.AfterFunc(clusterTxTimeout, func() {
+			db.txMutex.Lock()
+			delete(db.transactions, txId)
+			db.txMutex.Unlock()
+		})
+		return nil
+	}
+	db.txMutex.Unlock()
+
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	switch tx.status {
+	case txPrepared:
+		tx.unlock(db)
+	case txCommitted:
+		db.pauseLock.RLock()
+		tx.db.RWLocks(tx.writeKeys, nil)
+		for _, cmdLine := range tx.undoLog {
+			tx.db.execNormalCommand(cmdLine)
+		}
+		tx.db.RWUnLocks(tx.writeKeys, nil)
+		db.pauseLock.RUnlock()
+	}
+	tx.status = txRolledBack
+	return nil
+}
+
+// unlock 释放prepare时加的锁
+func (tx *clusterTx) unlock(db *StandaloneDatabase) {
+	tx.db.RWUnLocks(tx.writeKeys, tx.readKeys)
+	db.pauseLock.RUnlock()
+}
+
+// getTx 根据txId找到事务
+func (db *StandaloneDatabase) getTx(txId string) *clusterTx {
+	db.txMutex.Lock()
+	defer db.txMutex.Unlock()
+	return db.transactions[txId]
+}
+
+// makeUndoLog 生成将keys恢复为当前值的指令 调用方需要持有keys的锁
+func makeUndoLog(db *DB, keys []string) []CmdLine {
+	var undoLog []CmdLine
+	for _, key := range keys {
+		undoLog = append(undoLog, utils.ToCmdLine("del", key))
+		entity, ok := db.GetEntity(key)
+		if !ok {
+			continue
+		}
+		// 空的列表 集合和哈希表生成的指令只有key 没有满足参数个数 删除后就是当前的状态
+		cmdLine := aof.EntityToCmd(key, entity)
+		if len(cmdLine) <= 2 {
+			continue
+		}
+		undoLog = append(undoLog, cmdLine)
+		if expireTime, ok := db.GetExpiration(key); ok {
+			undoLog = append(undoLog, MakeExpireCmd(key, expireTime))
+		}
+	}
+	return undoLog
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"reflect"
+	List "simple-godis/datastructure/list"
+	HashSet "simple-godis/datastructure/set"
+	"simple-godis/datastructure/smap"
+	SortedSet "simple-godis/datastructure/sortedset"
+	dbInterface "simple-godis/interface/database"
+	"simple-godis/lib/utils"
+	"testing"
+	"time"
+)
+
+func TestMakeUndoLog(t *testing.T) {
+	db := MakeDB()
+	list := List.MakeQuickList()
+	list.Add([]byte("a"))
+	db.PutEntity("list", &dbInterface.DataEntity{Data: list})
+	db.PutEntity("str", &dbInterface.DataEntity{Data: []byte{}})
+	expireTime := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	db.Expire("str", expireTime)
+	// 空的集合类型只需要删除 不能生成缺少参数的写入指令
+	db.PutEntity("emptyList", &dbInterface.DataEntity{Data: List.MakeQuickList()})
+	db.PutEntity("emptySet", &dbInterface.DataEntity{Data: HashSet.MakeSet()})
+	db.PutEntity("emptyHash", &dbInterface.DataEntity{Data: smap.MakeSimpleMap()})
+	db.PutEntity("emptyZSet", &dbInterface.DataEntity{Data: SortedSet.MakeSortedSet()})
+
+	got := makeUndoLog(db, []string{"list", "str", "missing", "emptyList", "emptySet", "emptyHash", "emptyZSet"})
+	want := []CmdLine{
+		utils.ToCmdLine("del", "list"),
+		utils.ToCmdLine("rpush", "list", "a"),
+		utils.ToCmdLine("del", "str"),
+		utils.ToCmdLine("set", "str", ""),
+		MakeExpireCmd("str", expireTime),
+		utils.ToCmdLine("del", "missing"),
+		utils.ToCmdLine("del", "emptyList"),
+		utils.ToCmdLine("del", "emptySet"),
+		utils.ToCmdLine("del", "emptyHash"),
+		utils.ToCmdLine("del", "emptyZSet"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,265 @@
+package database
+
+import (
+	"errors"
+	"simple-godis/interface/resp"
+	"simple-godis/lib/utils"
+	"simple-godis/resp/reply"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+事务 MULTI开启事务后指令进入队列 EXEC时原子地执行队列中的所有指令
+WATCH基于key的版本号实现乐观锁 EXEC时如果WATCH的key被修改过则放弃整个事务
+只为有客户端WATCH的key记录版本号 EXEC DISCARD UNWATCH和断开连接时取消WATCH 没有客户端WATCH时删除记录
+*/
+
+var queuedReply = reply.MakeStatusReply("QUEUED")
+
+// 事务控制指令 在事务中不进入队列
+var txCommands = map[string]bool{
+	"multi":   true,
+	"exec":    true,
+	"discard": true,
+	"watch":   true,
+	"unwatch": true,
+}
+
+// watchedKeys 被客户端WATCH的key 写入key时只需要读锁 WATCH和取消WATCH时加写锁
+type watchedKeys struct {
+	mutex sync.RWMutex
+	keys  map[string]*watchedKey
+}
+
+// watchedKey 一个被WATCH的key的版本号以及WATCH它的客户端
+type watchedKey struct {
+	version  uint32
+	watchers map[resp.Connection]struct{}
+}
+
+func makeWatchedKeys() *watchedKeys {
+	return &watchedKeys{
+		keys: make(map[string]*watchedKey),
+	}
+}
+
+// watch 记录conn WATCH了key 返回key当前的版本号
+func (watched *watchedKeys) watch(conn resp.Connection, key string) uint32 {
+	watched.mutex.Lock()
+	defer watched.mutex.Unlock()
+	w, ok := watched.keys[key]
+	if !ok {
+		w = &watchedKey{watchers: make(map[resp.Connection]struct{})}
+		watched.keys[key] = w
+	}
+	w.watchers[conn] = struct{}{}
+	return atomic.LoadUint32(&w.version)
+}
+
+// unwatch 取消conn对key的WATCH 没有客户端WATCH之后删除记录
+func (watched *watchedKeys) unwatch(conn resp.Connection, key string) {
+	watched.mutex.Lock()
+	defer watched.mutex.Unlock()
+	w, ok := watched.keys[key]
+	if !ok {
+		return
+	}
+	delete(w.watchers, conn)
+	if len(w.watchers) == 0 {
+		delete(watched.keys, key)
+	}
+}
+
+// touch 增加被WATCH的key的版本号
+func (watched *watchedKeys) touch(keys []string) {
+	watched.mutex.RLock()
+	defer watched.mutex.RUnlock()
+	if len(watched.keys) == 0 {
+		return
+	}
+	for _, key := range keys {
+		if w, ok := watched.keys[key]; ok {
+			atomic.AddUint32(&w.version, 1)
+		}
+	}
+}
+
+// touchExisting 清空分数据库之前增加所有被WATCH并且存在的key的版本号
+func (watched *watchedKeys) touchExisting(db *DB) {
+	watched.mutex.RLock()
+	defer watched.mutex.RUnlock()
+	for key, w := range watched.keys {
+		if _, ok := db.Data.Get(key); ok {
+			atomic.AddUint32(&w.version, 1)
+		}
+	}
+}
+
+// version 返回key的版本号 没有被WATCH的key返回0
+func (watched *watchedKeys) version(key string) uint32 {
+	watched.mutex.RLock()
+	defer watched.mutex.RUnlock()
+	if w, ok := watched.keys[key]; ok {
+		return atomic.LoadUint32(&w.version)
+	}
+	return 0
+}
+
+// isTxCommand 判断是否是事务控制指令
+func isTxCommand(cmdName string) bool {
+	return txCommands[cmdName]
+}
+
+// startMulti 开启事务
+func startMulti(conn resp.Connection) resp.Reply {
+	if conn.InMultiState() {
+		return reply.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	conn.SetMultiState(true)
+	return reply.MakeOkReply()
+}
+
+// discardMulti 放弃事务 清空队列中的指令并取消WATCH
+func discardMulti(db *StandaloneDatabase, conn resp.Connection) resp.Reply {
+	if !conn.InMultiState() {
+		return reply.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	db.unwatchAll(conn)
+	conn.SetMultiState(false)
+	return reply.MakeOkReply()
+}
+
+// enqueueCmd 校验指令后将指令加入事务队列 校验失败时记录错误 EXEC时会放弃整个事务
+func enqueueCmd(conn resp.Connection, cmdLine CmdLine) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := CommandTable[cmdName]
+	if !ok {
+		errReply := reply.MakeErrReply("ERR unknown command " + cmdName)
+		conn.AddTxError(errors.New(errReply.Error()))
+		return errReply
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		errReply := reply.MakeArgNumErrReply(cmdName)
+		conn.AddTxError(errors.New(errReply.Error()))
+		return errReply
+	}
+	if errReply := checkPermission(conn, cmdName, cmd.getKeys(cmdLine)); errReply != nil {
+		conn.AddTxError(errors.New(errReply.(reply.ErrorReply).Error()))
+		return errReply
+	}
+	conn.EnqueueCmd(cmdLine)
+	return queuedReply
+}
+
+// execWatch 记录key当前的版本号 WATCH key [key ...]
+func execWatch(db *DB, conn resp.Connection, args [][]byte) resp.Reply {
+	if conn.InMultiState() {
+		return reply.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	watching := conn.GetWatching()
+	for _, bkey := range args {
+		key := string(bkey)
+		// 先删除已经过期的key 之后再过期时版本号才会改变
+		db.expireIfNeeded(key)
+		watching[key] = db.watched.watch(conn, key)
+	}
+	return reply.MakeOkReply()
+}
+
+// execUnwatch 取消所有key的WATCH
+func execUnwatch(db *StandaloneDatabase, conn resp.Connection) resp.Reply {
+	db.unwatchAll(conn)
+	return reply.MakeOkReply()
+}
+
+// unwatchAll 取消客户端WATCH的所有key WATCH之后可能切换过分数据库 在所有分数据库中取消
+func (db *StandaloneDatabase) unwatchAll(conn resp.Connection) {
+	watching := conn.GetWatching()
+	if len(watching) == 0 {
+		return
+	}
+	for _, database := range db.dbSet {
+		for key := range watching {
+			database.watched.unwatch(conn, key)
+		}
+	}
+	for key := range watching {
+		delete(watching, key)
+	}
+}
+
+// execMulti 执行事务队列中的指令 执行结束后客户端退出事务状态并取消WATCH
+func execMulti(db *StandaloneDatabase, database *DB, conn resp.Connection) resp.Reply {
+	if !conn.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer func() {
+		db.unwatchAll(conn)
+		conn.SetMultiState(false)
+	}()
+	if len(conn.GetTxErrors()) > 0 {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	return database.ExecMulti(conn, conn.GetWatching(), conn.GetQueuedCmdLine())
+}
+
+// isWatchingChanged 判断WATCH的key在WATCH之后有没有被修改过或者过期 调用方需要已经持有key的锁
+func isWatchingChanged(db *DB, watching map[string]uint32) bool {
+	for key, version := range watching {
+		db.IsExpired(key)
+		if db.GetVersion(key) != version {
+			return true
+		}
+	}
+	return false
+}
+
+// getTxKeys 汇总事务中所有指令涉及的key WATCH的key作为读取的key
+func getTxKeys(watching map[string]uint32, cmdLines []CmdLine) (writeKeys []string, readKeys []string) {
+	for key := range watching {
+		readKeys = append(readKeys, key)
+	}
+	for _, cmdLine := range cmdLines {
+		cmd, ok := CommandTable[strings.ToLower(string(cmdLine[0]))]
+		if !ok {
+			continue
+		}
+		write, read := cmd.prepare(cmdLine[1:])
+		writeKeys = append(writeKeys, write...)
+		readKeys = append(readKeys, read...)
+	}
+	return writeKeys, readKeys
+}
+
+// ExecMulti 原子地执行事务中的指令 执行期间事务涉及的key都被锁住
+// 事务中产生的落盘指令被包装在MULTI...EXEC中一次性写入 宕机时可以在加载时发现不完整的事务
+func (db *DB) ExecMulti(conn resp.Connection, watching map[string]uint32, cmdLines []CmdLine) resp.Reply {
+	writeKeys, readKeys := getTxKeys(watching, cmdLines)
+	db.RWLocks(writeKeys, readKeys)
+	defer db.RWUnLocks(writeKeys, readKeys)
+
+	if isWatchingChanged(db, watching) {
+		return reply.MakeNullBulkReply()
+	}
+	// txDB与db共享数据 只是将落盘方法替换为收集事务中产生的落盘指令
+	aofLines := []CmdLine{utils.ToCmdLine("multi")}
+	txDB := *db
+	txDB.AddAof = func(lines ...CmdLine) {
+		aofLines = append(aofLines, lines...)
+	}
+	results := make([]resp.Reply, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		result := txDB.execNormalCommand(cmdLine)
+		if result == nil {
+			result = reply.MakeUnknownErrReply()
+		}
+		results = append(results, result)
+	}
+	if len(aofLines) > 1 {
+		aofLines = append(aofLines, utils.ToCmdLine("exec"))
+		db.AddAof(aofLines...)
+	}
+	return reply.MakeMultiRawReply(results)
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"simple-godis/lib/utils"
+	"simple-godis/resp/client"
+	"testing"
+)
+
+func makeInternalClient() *client.Client {
+	c := client.NewClient(nil)
+	c.SetInternal(true)
+	return c
+}
+
+// watchedCount 分数据库中记录了版本号的key的数量
+func watchedCount(db *DB) int {
+	db.watched.mutex.RLock()
+	defer db.watched.mutex.RUnlock()
+	return len(db.watched.keys)
+}
+
+func TestWatchedKeysReleased(t *testing.T) {
+	db := MakeBasicStandaloneDatabase()
+	db0, db1 := db.dbSet[0], db.dbSet[1]
+	c1, c2 := makeInternalClient(), makeInternalClient()
+
+	// 没有客户端WATCH的key不记录版本号
+	db0.AddVersion("a", "b")
+	if n := watchedCount(db0); n != 0 {
+		t.Fatalf("expected no versions for unwatched keys, got %d", n)
+	}
+
+	db.Exec(c1, utils.ToCmdLine("watch", "a", "b"))
+	db.Exec(c2, utils.ToCmdLine("watch", "a"))
+	if n := watchedCount(db0); n != 2 {
+		t.Fatalf("expected 2 watched keys, got %d", n)
+	}
+	db.Exec(c1, utils.ToCmdLine("unwatch"))
+	if n := watchedCount(db0); n != 1 {
+		t.Fatalf("expected a to be still watched by c2, got %d keys", n)
+	}
+	// 修改之后EXEC放弃事务 并且取消WATCH
+	db0.AddVersion("a")
+	db.Exec(c2, utils.ToCmdLine("multi"))
+	if result := db.Exec(c2, utils.ToCmdLine("exec")); string(result.ToBytes()) != "$-1\r\n" {
+		t.Fatalf("expected EXEC to abort, got %q", result.ToBytes())
+	}
+	if n := watchedCount(db0); n != 0 {
+		t.Fatalf("expected no watched keys after EXEC, got %d", n)
+	}
+
+	// DISCARD 断开连接以及WATCH之后切换分数据库的情况
+	db.Exec(c1, utils.ToCmdLine("watch", "a"))
+	db.Exec(c1, utils.ToCmdLine("multi"))
+	db.Exec(c1, utils.ToCmdLine("discard"))
+	db.Exec(c2, utils.ToCmdLine("watch", "a"))
+	db.Exec(c2, utils.ToCmdLine("select", "1"))
+	db.Exec(c2, utils.ToCmdLine("watch", "b"))
+	if watchedCount(db0) != 1 || watchedCount(db1) != 1 {
+		t.Fatalf("expected one watched key in each db, got %d and %d", watchedCount(db0), watchedCount(db1))
+	}
+	db.AfterClientClose(c2)
+	if watchedCount(db0) != 0 || watchedCount(db1) != 0 {
+		t.Fatalf("expected no watched keys after close, got %d and %d", watchedCount(db0), watchedCount(db1))
+	}
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"simple-godis/lib/utils"
+	"strconv"
+	"time"
+)
+
+/*
+key的过期时间 惰性删除+定期删除
+*/
+
+const (
+	activeExpireInterval   = 100 * time.Millisecond // 定期删除的周期
+	activeExpireSampleSize = 20                     // 每一轮随机抽样的key的数量
+)
+
+// Expire 设置key的过期时间点
+func (db *DB) Expire(key string, expireTime time.Time) {
+	db.ttlMap.Put(key, expireTime)
+}
+
+// Persist 移除key的过期时间
+func (db *DB) Persist(key string) int {
+	return db.ttlMap.Remove(key)
+}
+
+// GetExpiration 获取key的过期时间点 如果没有设置过期时间返回false
+func (db *DB) GetExpiration(key string) (time.Time, bool) {
+	raw, ok := db.ttlMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	expireTime, _ := raw.(time.Time)
+	return expireTime, true
+}
+
+// IsExpired 判断key是否已经过期 如果已经过期则将其从数据库中删除 并增加版本号让WATCH了该key的事务失效
+func (db *DB) IsExpired(key string) bool {
+	expireTime, ok := db.GetExpiration(key)
+	if !ok {
+		return false
+	}
+	expired := time.Now().After(expireTime)
+	if expired {
+		db.RemoveEntity(key)
+		db.AddVersion(key)
+	}
+	return expired
+}
+
+// MakeExpireCmd 生成以绝对时间戳表示过期时间的pexpireat指令 用于落盘
+// 使用绝对时间可以保证重放aof文件时不会让已经过期的key复活
+func MakeExpireCmd(key string, expireTime time.Time) CmdLine {
+	return utils.ToCmdLine("pexpireat", key, strconv.FormatInt(expireTime.UnixMilli(), 10))
+}
+
+// startActiveExpire 启动定期删除协程 周期性地随机抽样设置了过期时间的key并删除其中过期的key
+func (db *DB) startActiveExpire() {
+	go func() {
+		ticker := time.NewTicker(activeExpireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				db.activeExpireCycle()
+			case <-db.stopExpire:
+				return
+			}
+		}
+	}()
+}
+
+// stopActiveExpire 停止定期删除协程
+func (db *DB) stopActiveExpire() {
+	close(db.stopExpire)
+}
+
+// activeExpireCycle 一轮定期删除 如果抽样中过期key的比例超过1/4 说明过期的key较多 继续抽样
+func (db *DB) activeExpireCycle() {
+	for {
+		keys := db.ttlMap.RandomDistinctKeys(activeExpireSampleSize)
+		if len(keys) == 0 {
+			return
+		}
+		expiredCount := 0
+		for _, key := range keys {
+			if db.expireIfNeeded(key) {
+				expiredCount++
+			}
+		}
+		if expiredCount*4 <= len(keys) {
+			return
+		}
+	}
+}
+
+// expireIfNeeded 持有key的写锁删除过期的key 避免与正在执行的指令冲突
+func (db *DB) expireIfNeeded(key string) bool {
+	db.locker.Lock(key)
+	defer db.locker.UnLock(key)
+	return db.IsExpired(key)
+}
@@ -0,0 +1,221 @@
+package smap
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentMap 分段加锁的线程安全Map 实现Map接口
+// key经过哈希后落到某一个分段中 不同分段之间的读写互不影响
+type ConcurrentMap struct {
+	shards []*shard
+	count  int32
+}
+
+// shard ConcurrentMap的一个分段 由读写锁保护
+type shard struct {
+	m     map[string]interface{}
+	mutex sync.RWMutex
+}
+
+// computeCapacity 将分段数量向上取整为2的幂次 便于用位运算计算分段下标
+func computeCapacity(param int) (size int) {
+	if param <= 16 {
+		return 16
+	}
+	n := param - 1
+	n |= n >> 1
+	n |= n >> 2
+	n |= n >> 4
+	n |= n >> 8
+	n |= n >> 16
+	if n < 0 {
+		return 1 << 30
+	}
+	return n + 1
+}
+
+// MakeConcurrentMap ConcurrentMap的构造方法 shardCount为分段数量
+func MakeConcurrentMap(shardCount int) *ConcurrentMap {
+	shardCount = computeCapacity(shardCount)
+	shards := make([]*shard, shardCount)
+	for i := 0; i < shardCount; i++ {
+		shards[i] = &shard{
+			m: make(map[string]interface{}),
+		}
+	}
+	return &ConcurrentMap{
+		shards: shards,
+	}
+}
+
+const prime32 = uint32(16777619)
+
+// fnv32 计算key的FNV-1a哈希
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= prime32
+	}
+	return hash
+}
+
+// spread 计算key所在的分段下标
+func (m *ConcurrentMap) spread(hashCode uint32) uint32 {
+	tableSize := uint32(len(m.shards))
+	return (tableSize - 1) & hashCode
+}
+
+// getShard 获取key所在的分段
+func (m *ConcurrentMap) getShard(key string) *shard {
+	return m.shards[m.spread(fnv32(key))]
+}
+
+func (m *ConcurrentMap) Get(key string) (val interface{}, exists bool) {
+	s := m.getShard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	val, exists = s.m[key]
+	return val, exists
+}
+
+func (m *ConcurrentMap) Len() int {
+	return int(atomic.LoadInt32(&m.count))
+}
+
+func (m *ConcurrentMap) Put(key string, val interface{}) (result int) {
+	s := m.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 0
+	}
+	s.m[key] = val
+	atomic.AddInt32(&m.count, 1)
+	return 1
+}
+
+func (m *ConcurrentMap) PutIfAbsent(key string, val interface{}) (result int) {
+	s := m.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.m[key]; ok {
+		return 0
+	}
+	s.m[key] = val
+	atomic.AddInt32(&m.count, 1)
+	return 1
+}
+
+func (m *ConcurrentMap) PutIfExists(key string, val interface{}) (result int) {
+	s := m.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+func (m *ConcurrentMap) Remove(key string) (result int) {
+	s := m.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.m[key]; ok {
+		delete(s.m, key)
+		atomic.AddInt32(&m.count, -1)
+		return 1
+	}
+	return 0
+}
+
+// ForEach 遍历所有键值对 遍历某个分段时持有该分段的读锁 consumer中不能再写入该Map
+func (m *ConcurrentMap) ForEach(consumer Consumer) {
+	for _, s := range m.shards {
+		s.mutex.RLock()
+		goOn := func() bool {
+			defer s.mutex.RUnlock()
+			for key, value := range s.m {
+				if !consumer(key, value) {
+					return false
+				}
+			}
+			return true
+		}()
+		if !goOn {
+			break
+		}
+	}
+}
+
+func (m *ConcurrentMap) Keys() []string {
+	keys := make([]string, 0, m.Len())
+	m.ForEach(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// randomKey 从分段中随机取出一个key map的遍历顺序是随机的
+func (s *shard) randomKey() (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for key := range s.m {
+		return key, true
+	}
+	return "", false
+}
+
+// RandomKeys 随机返回limit个key 可能包含重复的key
+func (m *ConcurrentMap) RandomKeys(limit int) []string {
+	size := m.Len()
+	if size == 0 {
+		return nil
+	}
+	result := make([]string, 0, limit)
+	shardCount := len(m.shards)
+	for len(result) < limit {
+		s := m.shards[rand.Intn(shardCount)]
+		if key, ok := s.randomKey(); ok {
+			result = append(result, key)
+		} else if m.Len() == 0 {
+			break
+		}
+	}
+	return result
+}
+
+// RandomDistinctKeys 随机返回limit个不重复的key 数量不足时返回所有的key
+// 从随机的分段开始依次向后取 每个分段内部的遍历顺序也是随机的
+func (m *ConcurrentMap) RandomDistinctKeys(limit int) []string {
+	result := make([]string, 0, limit)
+	shardCount := len(m.shards)
+	start := rand.Intn(shardCount)
+	for i := 0; i < shardCount && len(result) < limit; i++ {
+		s := m.shards[(start+i)%shardCount]
+		s.mutex.RLock()
+		for key := range s.m {
+			if len(result) >= limit {
+				break
+			}
+			result = append(result, key)
+		}
+		s.mutex.RUnlock()
+	}
+	return result
+}
+
+// Clear 清空所有分段
+func (m *ConcurrentMap) Clear() {
+	for _, s := range m.shards {
+		s.mutex.Lock()
+		atomic.AddInt32(&m.count, -int32(len(s.m)))
+		s.m = make(map[string]interface{})
+		s.mutex.Unlock()
+	}
+}
@@ -99,7 +99,7 @@ func (s *SyncMap) RandomDistinctKeys(limit int) []string {
 		i++
 		return i < limit
 	})
-	return result
+	return result[:i]
 }
 
 func (s *SyncMap) Clear() {
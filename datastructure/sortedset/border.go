@@ -0,0 +1,152 @@
+package sortedset
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+/*
+范围查询的边界 包括按分数查询的边界和按字典序查询的边界
+*/
+
+const (
+	negativeInf int8 = -1
+	positiveInf int8 = 1
+)
+
+// Border 范围查询的边界
+// less 作为下界时 判断元素是否在下界之上
+// greater 作为上界时 判断元素是否在上界之下
+type Border interface {
+	less(element *Element) bool
+	greater(element *Element) bool
+	isIntersected(max Border) bool
+}
+
+// ScoreBorder 按分数查询的边界 例如 (1.5 表示不包含1.5 -inf +inf表示无穷
+type ScoreBorder struct {
+	Inf     int8
+	Value   float64
+	Exclude bool
+}
+
+func (border *ScoreBorder) less(element *Element) bool {
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < element.Score
+	}
+	return border.Value <= element.Score
+}
+
+func (border *ScoreBorder) greater(element *Element) bool {
+	if border.Inf == positiveInf {
+		return true
+	} else if border.Inf == negativeInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value > element.Score
+	}
+	return border.Value >= element.Score
+}
+
+// isIntersected 判断[min, max]是否是一个空区间
+func (border *ScoreBorder) isIntersected(max Border) bool {
+	maxBorder, ok := max.(*ScoreBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	return border.Value > maxBorder.Value ||
+		(border.Value == maxBorder.Value && (border.Exclude || maxBorder.Exclude))
+}
+
+// ParseScoreBorder 解析分数边界 支持 -inf +inf (1.5 1.5
+// 分数本身可以是±inf 所以-inf +inf作为普通的分数比较 +inf +inf可以查询到分数为inf的成员
+func ParseScoreBorder(s string) (*ScoreBorder, error) {
+	if len(s) > 0 && s[0] == '(' {
+		value, err := strconv.ParseFloat(s[1:], 64)
+		if err != nil || math.IsNaN(value) {
+			return nil, errors.New("ERR min or max is not a float")
+		}
+		return &ScoreBorder{Value: value, Exclude: true}, nil
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return nil, errors.New("ERR min or max is not a float")
+	}
+	return &ScoreBorder{Value: value}, nil
+}
+
+// LexBorder 按成员字典序查询的边界 例如 [a 表示包含a (a 表示不包含a - +表示无穷
+type LexBorder struct {
+	Inf     int8
+	Value   string
+	Exclude bool
+}
+
+func (border *LexBorder) less(element *Element) bool {
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < element.Member
+	}
+	return border.Value <= element.Member
+}
+
+func (border *LexBorder) greater(element *Element) bool {
+	if border.Inf == positiveInf {
+		return true
+	} else if border.Inf == negativeInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value > element.Member
+	}
+	return border.Value >= element.Member
+}
+
+func (border *LexBorder) isIntersected(max Border) bool {
+	maxBorder, ok := max.(*LexBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	return border.Value > maxBorder.Value ||
+		(border.Value == maxBorder.Value && (border.Exclude || maxBorder.Exclude))
+}
+
+// ParseLexBorder 解析字典序边界 必须以 [ 或 ( 开头 或者是 - +
+func ParseLexBorder(s string) (*LexBorder, error) {
+	if s == "+" {
+		return &LexBorder{Inf: positiveInf}, nil
+	}
+	if s == "-" {
+		return &LexBorder{Inf: negativeInf}, nil
+	}
+	if len(s) > 0 && s[0] == '(' {
+		return &LexBorder{Value: s[1:], Exclude: true}, nil
+	}
+	if len(s) > 0 && s[0] == '[' {
+		return &LexBorder{Value: s[1:]}, nil
+	}
+	return nil, errors.New("ERR min or max not valid string range item")
+}
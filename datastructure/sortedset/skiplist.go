@@ -0,0 +1,313 @@
+package sortedset
+
+import "math/rand"
+
+const maxLevel = 16
+
+// Element 有序集合中的元素 由成员和分数组成
+type Element struct {
+	Member string
+	Score  float64
+}
+
+// Level 跳表节点在某一层的指针 span记录了到下一个节点跨越了多少个节点 用于计算排名
+type Level struct {
+	forward *node
+	span    int64
+}
+
+// node 跳表节点
+type node struct {
+	Element
+	backward *node
+	level    []*Level // level[0]是最底层
+}
+
+// skiplist 跳表 元素按照(score, member)升序排列
+type skiplist struct {
+	header *node
+	tail   *node
+	length int64
+	level  int16
+}
+
+func makeNode(level int16, score float64, member string) *node {
+	n := &node{
+		Element: Element{
+			Score:  score,
+			Member: member,
+		},
+		level: make([]*Level, level),
+	}
+	for i := range n.level {
+		n.level[i] = new(Level)
+	}
+	return n
+}
+
+func makeSkiplist() *skiplist {
+	return &skiplist{
+		level:  1,
+		header: makeNode(maxLevel, 0, ""),
+	}
+}
+
+// randomLevel 随机生成新节点的层数 每升高一层的概率为1/4
+func randomLevel() int16 {
+	level := int16(1)
+	for float32(rand.Int31()&0xFFFF) < (0.25 * 0xFFFF) {
+		level++
+	}
+	if level < maxLevel {
+		return level
+	}
+	return maxLevel
+}
+
+// less 判断节点n是否排在(score, member)之前
+func (n *node) less(score float64, member string) bool {
+	return n.Score < score || (n.Score == score && n.Member < member)
+}
+
+// insert 插入一个新元素 调用方需要保证member不在跳表中
+func (skiplist *skiplist) insert(member string, score float64) *node {
+	update := make([]*node, maxLevel) // 每一层中新节点的前驱节点
+	rank := make([]int64, maxLevel)   // 每一层前驱节点的排名
+
+	// 从最高层开始向下查找插入位置
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		if i == skiplist.level-1 {
+			rank[i] = 0
+		} else {
+			rank[i] = rank[i+1]
+		}
+		for n.level[i].forward != nil && n.level[i].forward.less(score, member) {
+			rank[i] += n.level[i].span
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+
+	level := randomLevel()
+	// 新节点的层数超过了跳表的层数 初始化多出来的层
+	if level > skiplist.level {
+		for i := skiplist.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = skiplist.header
+			update[i].level[i].span = skiplist.length
+		}
+		skiplist.level = level
+	}
+
+	// 将新节点链接到每一层中
+	n = makeNode(level, score, member)
+	for i := int16(0); i < level; i++ {
+		n.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = n
+		n.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+	// 新节点没有到达的层 跨度加一
+	for i := level; i < skiplist.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] == skiplist.header {
+		n.backward = nil
+	} else {
+		n.backward = update[0]
+	}
+	if n.level[0].forward != nil {
+		n.level[0].forward.backward = n
+	} else {
+		skiplist.tail = n
+	}
+	skiplist.length++
+	return n
+}
+
+// removeNode 从跳表中删除节点n update是每一层中n的前驱节点
+func (skiplist *skiplist) removeNode(n *node, update []*node) {
+	for i := int16(0); i < skiplist.level; i++ {
+		if update[i].level[i].forward == n {
+			update[i].level[i].span += n.level[i].span - 1
+			update[i].level[i].forward = n.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if n.level[0].forward != nil {
+		n.level[0].forward.backward = n.backward
+	} else {
+		skiplist.tail = n.backward
+	}
+	for skiplist.level > 1 && skiplist.header.level[skiplist.level-1].forward == nil {
+		skiplist.level--
+	}
+	skiplist.length--
+}
+
+// remove 删除一个元素 如果元素存在返回true
+func (skiplist *skiplist) remove(member string, score float64) bool {
+	update := make([]*node, maxLevel)
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && n.level[i].forward.less(score, member) {
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+	n = n.level[0].forward
+	if n != nil && score == n.Score && n.Member == member {
+		skiplist.removeNode(n, update)
+		return true
+	}
+	return false
+}
+
+// getRank 返回元素的排名 排名从1开始 元素不存在时返回0
+func (skiplist *skiplist) getRank(member string, score float64) int64 {
+	var rank int64 = 0
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil &&
+			(n.level[i].forward.less(score, member) ||
+				(n.level[i].forward.Score == score && n.level[i].forward.Member == member)) {
+			rank += n.level[i].span
+			n = n.level[i].forward
+		}
+		// 分数也要相同 同一个成员在其他分数上的节点不是要查找的元素
+		if n != skiplist.header && n.Score == score && n.Member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// getByRank 根据排名获取节点 排名从1开始
+func (skiplist *skiplist) getByRank(rank int64) *node {
+	var traversed int64 = 0
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && (traversed+n.level[i].span) <= rank {
+			traversed += n.level[i].span
+			n = n.level[i].forward
+		}
+		if traversed == rank {
+			return n
+		}
+	}
+	return nil
+}
+
+// hasInRange 判断跳表中是否有元素在[min, max]范围内
+func (skiplist *skiplist) hasInRange(min Border, max Border) bool {
+	if min.isIntersected(max) {
+		return false
+	}
+	// 最大的元素比min小
+	n := skiplist.tail
+	if n == nil || !min.less(&n.Element) {
+		return false
+	}
+	// 最小的元素比max大
+	n = skiplist.header.level[0].forward
+	if n == nil || !max.greater(&n.Element) {
+		return false
+	}
+	return true
+}
+
+// getFirstInRange 返回范围内的第一个节点
+func (skiplist *skiplist) getFirstInRange(min Border, max Border) *node {
+	if !skiplist.hasInRange(min, max) {
+		return nil
+	}
+	n := skiplist.header
+	// 找到最后一个不满足min的节点
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && !min.less(&n.level[i].forward.Element) {
+			n = n.level[i].forward
+		}
+	}
+	n = n.level[0].forward
+	if !max.greater(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+// getLastInRange 返回范围内的最后一个节点
+func (skiplist *skiplist) getLastInRange(min Border, max Border) *node {
+	if !skiplist.hasInRange(min, max) {
+		return nil
+	}
+	n := skiplist.header
+	// 找到最后一个满足max的节点
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && max.greater(&n.level[i].forward.Element) {
+			n = n.level[i].forward
+		}
+	}
+	if !min.less(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+// removeRange 删除范围内的元素 limit<=0表示不限制删除的数量
+func (skiplist *skiplist) removeRange(min Border, max Border, limit int) (removed []*Element) {
+	update := make([]*node, maxLevel)
+	removed = make([]*Element, 0)
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && !min.less(&n.level[i].forward.Element) {
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+	n = n.level[0].forward
+	for n != nil {
+		if !max.greater(&n.Element) {
+			break
+		}
+		next := n.level[0].forward
+		removedElement := n.Element
+		removed = append(removed, &removedElement)
+		skiplist.removeNode(n, update)
+		if limit > 0 && len(removed) == limit {
+			break
+		}
+		n = next
+	}
+	return removed
+}
+
+// removeRangeByRank 删除排名在[start, stop)范围内的元素 排名从1开始
+func (skiplist *skiplist) removeRangeByRank(start int64, stop int64) (removed []*Element) {
+	var i int64 = 0
+	update := make([]*node, maxLevel)
+	removed = make([]*Element, 0)
+
+	n := skiplist.header
+	for level := skiplist.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && (i+n.level[level].span) < start {
+			i += n.level[level].span
+			n = n.level[level].forward
+		}
+		update[level] = n
+	}
+
+	i++
+	n = n.level[0].forward
+	for n != nil && i < stop {
+		next := n.level[0].forward
+		removedElement := n.Element
+		removed = append(removed, &removedElement)
+		skiplist.removeNode(n, update)
+		n = next
+		i++
+	}
+	return removed
+}
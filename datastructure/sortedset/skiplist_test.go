@@ -0,0 +1,176 @@
+package sortedset
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// sortElements 按(score, member)升序排列 与跳表的顺序相同
+func sortElements(elements []Element) {
+	sort.Slice(elements, func(i, j int) bool {
+		if elements[i].Score != elements[j].Score {
+			return elements[i].Score < elements[j].Score
+		}
+		return elements[i].Member < elements[j].Member
+	})
+}
+
+// checkSkiplist 检查跳表的顺序 每一层的跨度 反向指针以及排名查询与want一致
+func checkSkiplist(t *testing.T, sl *skiplist, want []Element) {
+	t.Helper()
+	if sl.length != int64(len(want)) {
+		t.Fatalf("expected length %d, got %d", len(want), sl.length)
+	}
+	ranks := make(map[*node]int64)
+	var prev *node
+	n := sl.header.level[0].forward
+	for i, element := range want {
+		if n == nil {
+			t.Fatalf("expected %v at rank %d, got end of list", element, i+1)
+		}
+		if n.Element != element {
+			t.Fatalf("expected %v at rank %d, got %v", element, i+1, n.Element)
+		}
+		if n.backward != prev {
+			t.Fatalf("wrong backward pointer at rank %d", i+1)
+		}
+		ranks[n] = int64(i + 1)
+		prev, n = n, n.level[0].forward
+	}
+	if n != nil {
+		t.Fatalf("unexpected element %v after rank %d", n.Element, len(want))
+	}
+	if sl.tail != prev {
+		t.Fatal("wrong tail")
+	}
+	// 每一层中节点的排名等于前面所有跨度之和
+	for level := int16(0); level < sl.level; level++ {
+		var rank int64
+		for n := sl.header; n.level[level].forward != nil; n = n.level[level].forward {
+			rank += n.level[level].span
+			if ranks[n.level[level].forward] != rank {
+				t.Fatalf("level %d: expected rank %d, got %d", level, ranks[n.level[level].forward], rank)
+			}
+		}
+	}
+	for i, element := range want {
+		if rank := sl.getRank(element.Member, element.Score); rank != int64(i+1) {
+			t.Fatalf("getRank(%v): expected %d, got %d", element, i+1, rank)
+		}
+		if n := sl.getByRank(int64(i + 1)); n == nil || n.Element != element {
+			t.Fatalf("getByRank(%d): expected %v", i+1, element)
+		}
+	}
+	if n := sl.getByRank(int64(len(want) + 1)); n != nil {
+		t.Fatalf("getByRank(%d): expected nil, got %v", len(want)+1, n.Element)
+	}
+}
+
+func TestSkiplistInsertRemove(t *testing.T) {
+	sl := makeSkiplist()
+	checkSkiplist(t, sl, nil)
+	// 分数相同时按成员排序
+	inputs := []Element{{"c", 1}, {"a", 1}, {"b", 1}, {"d", 0}, {"e", 2}, {"aa", 1}}
+	for _, element := range inputs {
+		sl.insert(element.Member, element.Score)
+	}
+	checkSkiplist(t, sl, []Element{{"d", 0}, {"a", 1}, {"aa", 1}, {"b", 1}, {"c", 1}, {"e", 2}})
+
+	// 成员相同但分数不同 不会删除
+	if sl.remove("a", 2) {
+		t.Fatal("removed element with wrong score")
+	}
+	if sl.remove("x", 1) {
+		t.Fatal("removed missing element")
+	}
+	if sl.getRank("x", 1) != 0 || sl.getRank("a", 2) != 0 {
+		t.Fatal("expected rank 0 for missing element")
+	}
+	if !sl.remove("aa", 1) || !sl.remove("d", 0) || !sl.remove("e", 2) {
+		t.Fatal("failed to remove element")
+	}
+	checkSkiplist(t, sl, []Element{{"a", 1}, {"b", 1}, {"c", 1}})
+	for _, member := range []string{"a", "b", "c"} {
+		sl.remove(member, 1)
+	}
+	checkSkiplist(t, sl, nil)
+	if sl.level != 1 {
+		t.Fatalf("expected level 1 after removing all elements, got %d", sl.level)
+	}
+}
+
+func TestSkiplistRandom(t *testing.T) {
+	rand.Seed(1)
+	sl := makeSkiplist()
+	var want []Element
+	members := make(map[string]float64)
+	for i := 0; i < 2000; i++ {
+		member := strconv.Itoa(rand.Intn(300))
+		score, exists := members[member]
+		if exists {
+			if !sl.remove(member, score) {
+				t.Fatalf("failed to remove %s", member)
+			}
+			delete(members, member)
+		} else {
+			// 分数只有几种取值 大部分元素的分数相同
+			score = float64(rand.Intn(5))
+			sl.insert(member, score)
+			members[member] = score
+		}
+		if i%100 == 0 {
+			want = want[:0]
+			for member, score := range members {
+				want = append(want, Element{Member: member, Score: score})
+			}
+			sortElements(want)
+			checkSkiplist(t, sl, want)
+		}
+	}
+}
+
+func TestSkiplistRemoveRangeByRank(t *testing.T) {
+	tests := []struct {
+		start   int64
+		stop    int64
+		removed []string
+	}{
+		{start: 1, stop: 1, removed: nil},
+		{start: 1, stop: 2, removed: []string{"m0"}},
+		{start: 3, stop: 6, removed: []string{"m2", "m3", "m4"}},
+		{start: 9, stop: 11, removed: []string{"m8", "m9"}},
+		{start: 1, stop: 11, removed: []string{"m0", "m1", "m2", "m3", "m4", "m5", "m6", "m7", "m8", "m9"}},
+		{start: 10, stop: 20, removed: []string{"m9"}},
+		{start: 11, stop: 20, removed: nil},
+	}
+	for _, tt := range tests {
+		sl := makeSkiplist()
+		var all []Element
+		for i := 0; i < 10; i++ {
+			// 每两个元素的分数相同
+			element := Element{Member: "m" + strconv.Itoa(i), Score: float64(i / 2)}
+			sl.insert(element.Member, element.Score)
+			all = append(all, element)
+		}
+		removed := sl.removeRangeByRank(tt.start, tt.stop)
+		if len(removed) != len(tt.removed) {
+			t.Fatalf("[%d, %d): expected %d removed, got %d", tt.start, tt.stop, len(tt.removed), len(removed))
+		}
+		rest := make([]Element, 0, len(all))
+		removedSet := make(map[string]bool)
+		for i, element := range removed {
+			if element.Member != tt.removed[i] {
+				t.Fatalf("[%d, %d): expected %s removed, got %s", tt.start, tt.stop, tt.removed[i], element.Member)
+			}
+			removedSet[element.Member] = true
+		}
+		for _, element := range all {
+			if !removedSet[element.Member] {
+				rest = append(rest, element)
+			}
+		}
+		checkSkiplist(t, sl, rest)
+	}
+}
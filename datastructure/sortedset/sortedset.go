@@ -0,0 +1,236 @@
+package sortedset
+
+import "strconv"
+
+// SortedSet 有序集合 使用哈希表记录成员到元素的映射 使用跳表维护元素的顺序
+type SortedSet struct {
+	dict     map[string]*Element
+	skiplist *skiplist
+}
+
+// Consumer SortedSet迭代器 返回false时终止遍历
+type Consumer func(element *Element) bool
+
+// MakeSortedSet SortedSet的构造方法
+func MakeSortedSet() *SortedSet {
+	return &SortedSet{
+		dict:     make(map[string]*Element),
+		skiplist: makeSkiplist(),
+	}
+}
+
+// Add 添加或更新一个成员的分数 如果是新成员返回true
+func (sortedSet *SortedSet) Add(member string, score float64) bool {
+	element, ok := sortedSet.dict[member]
+	sortedSet.dict[member] = &Element{
+		Member: member,
+		Score:  score,
+	}
+	if ok {
+		if score != element.Score {
+			sortedSet.skiplist.remove(member, element.Score)
+			sortedSet.skiplist.insert(member, score)
+		}
+		return false
+	}
+	sortedSet.skiplist.insert(member, score)
+	return true
+}
+
+// Len 返回有序集合中成员的数量
+func (sortedSet *SortedSet) Len() int64 {
+	return int64(len(sortedSet.dict))
+}
+
+// Get 获取一个成员对应的元素
+func (sortedSet *SortedSet) Get(member string) (element *Element, ok bool) {
+	element, ok = sortedSet.dict[member]
+	if !ok {
+		return nil, false
+	}
+	return element, true
+}
+
+// Remove 删除一个成员 如果成员存在返回true
+func (sortedSet *SortedSet) Remove(member string) bool {
+	element, ok := sortedSet.dict[member]
+	if ok {
+		sortedSet.skiplist.remove(member, element.Score)
+		delete(sortedSet.dict, member)
+		return true
+	}
+	return false
+}
+
+// GetRank 返回成员的排名 排名从0开始 desc为true时按分数从大到小排名 成员不存在时返回-1
+func (sortedSet *SortedSet) GetRank(member string, desc bool) (rank int64) {
+	element, ok := sortedSet.dict[member]
+	if !ok {
+		return -1
+	}
+	r := sortedSet.skiplist.getRank(member, element.Score)
+	if desc {
+		r = sortedSet.skiplist.length - r
+	} else {
+		r--
+	}
+	return r
+}
+
+// ForEach 遍历排名在[start, stop)范围内的元素 排名从0开始
+func (sortedSet *SortedSet) ForEach(start int64, stop int64, desc bool, consumer Consumer) {
+	size := sortedSet.Len()
+	if start < 0 || start >= size {
+		panic("illegal start " + strconv.FormatInt(start, 10))
+	}
+	if stop < start || stop > size {
+		panic("illegal end " + strconv.FormatInt(stop, 10))
+	}
+
+	// 找到起始节点
+	var n *node
+	if desc {
+		n = sortedSet.skiplist.tail
+		if start > 0 {
+			n = sortedSet.skiplist.getByRank(size - start)
+		}
+	} else {
+		n = sortedSet.skiplist.header.level[0].forward
+		if start > 0 {
+			n = sortedSet.skiplist.getByRank(start + 1)
+		}
+	}
+
+	sliceSize := int(stop - start)
+	for i := 0; i < sliceSize; i++ {
+		if !consumer(&n.Element) {
+			break
+		}
+		if desc {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+	}
+}
+
+// Range 返回排名在[start, stop)范围内的元素 排名从0开始
+func (sortedSet *SortedSet) Range(start int64, stop int64, desc bool) []*Element {
+	sliceSize := int(stop - start)
+	slice := make([]*Element, sliceSize)
+	if sliceSize == 0 {
+		return slice
+	}
+	i := 0
+	sortedSet.ForEach(start, stop, desc, func(element *Element) bool {
+		slice[i] = element
+		i++
+		return true
+	})
+	return slice
+}
+
+// RangeCount 返回在[min, max]范围内的元素个数
+func (sortedSet *SortedSet) RangeCount(min Border, max Border) int64 {
+	var i int64 = 0
+	sortedSet.ForEachByBorder(min, max, 0, -1, false, func(element *Element) bool {
+		i++
+		return true
+	})
+	return i
+}
+
+// ForEachByBorder 遍历[min, max]范围内的元素 跳过前offset个 最多遍历limit个 limit<0表示不限制
+func (sortedSet *SortedSet) ForEachByBorder(min Border, max Border, offset int64, limit int64, desc bool, consumer Consumer) {
+	var n *node
+	if desc {
+		n = sortedSet.skiplist.getLastInRange(min, max)
+	} else {
+		n = sortedSet.skiplist.getFirstInRange(min, max)
+	}
+
+	// 跳过offset个元素
+	for n != nil && offset > 0 {
+		if desc {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+		offset--
+	}
+
+	for i := int64(0); (i < limit || limit < 0) && n != nil; i++ {
+		// 跳过offset个元素之后可能已经超出范围
+		if !min.less(&n.Element) || !max.greater(&n.Element) {
+			break
+		}
+		if !consumer(&n.Element) {
+			break
+		}
+		if desc {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+	}
+}
+
+// RangeByBorder 返回[min, max]范围内的元素 跳过前offset个 最多返回limit个 limit<0表示不限制
+func (sortedSet *SortedSet) RangeByBorder(min Border, max Border, offset int64, limit int64, desc bool) []*Element {
+	if limit == 0 || offset < 0 {
+		return make([]*Element, 0)
+	}
+	slice := make([]*Element, 0)
+	sortedSet.ForEachByBorder(min, max, offset, limit, desc, func(element *Element) bool {
+		slice = append(slice, element)
+		return true
+	})
+	return slice
+}
+
+// RemoveRange 删除[min, max]范围内的元素 返回删除的数量
+func (sortedSet *SortedSet) RemoveRange(min Border, max Border) int64 {
+	removed := sortedSet.skiplist.removeRange(min, max, 0)
+	for _, element := range removed {
+		delete(sortedSet.dict, element.Member)
+	}
+	return int64(len(removed))
+}
+
+// RemoveByRank 删除排名在[start, stop)范围内的元素 排名从0开始 返回删除的数量
+func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
+	removed := sortedSet.skiplist.removeRangeByRank(start+1, stop+1)
+	for _, element := range removed {
+		delete(sortedSet.dict, element.Member)
+	}
+	return int64(len(removed))
+}
+
+// PopMin 弹出分数最小的count个元素
+func (sortedSet *SortedSet) PopMin(count int) []*Element {
+	first := sortedSet.skiplist.header.level[0].forward
+	if first == nil {
+		return nil
+	}
+	border := &ScoreBorder{
+		Value: first.Score,
+	}
+	removed := sortedSet.skiplist.removeRange(border, &ScoreBorder{Inf: positiveInf}, count)
+	for _, element := range removed {
+		delete(sortedSet.dict, element.Member)
+	}
+	return removed
+}
+
+// PopMax 弹出分数最大的count个元素
+func (sortedSet *SortedSet) PopMax(count int) []*Element {
+	size := sortedSet.Len()
+	if int64(count) > size {
+		count = int(size)
+	}
+	removed := sortedSet.Range(0, int64(count), true)
+	for _, element := range removed {
+		sortedSet.Remove(element.Member)
+	}
+	return removed
+}
@@ -0,0 +1,181 @@
+package sortedset
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// makeTestSortedSet 分数包括±inf 以及相同分数的成员
+func makeTestSortedSet() *SortedSet {
+	sortedSet := MakeSortedSet()
+	sortedSet.Add("a", 1)
+	sortedSet.Add("b", 2)
+	sortedSet.Add("c", 2)
+	sortedSet.Add("d", 3)
+	sortedSet.Add("ninf", math.Inf(-1))
+	sortedSet.Add("pinf", math.Inf(1))
+	return sortedSet
+}
+
+func members(elements []*Element) string {
+	result := make([]string, len(elements))
+	for i, element := range elements {
+		result[i] = element.Member
+	}
+	return strings.Join(result, " ")
+}
+
+func TestScoreBorder(t *testing.T) {
+	tests := []struct {
+		min  string
+		max  string
+		want string
+	}{
+		{min: "2", max: "2", want: "b c"},
+		{min: "(2", max: "3", want: "d"},
+		{min: "2", max: "(3", want: "b c"},
+		{min: "(1", max: "(3", want: "b c"},
+		{min: "(2", max: "(3", want: ""},
+		{min: "(2", max: "2", want: ""},
+		{min: "3", max: "1", want: ""},
+		{min: "1.5", max: "2.5", want: "b c"},
+		{min: "-inf", max: "+inf", want: "ninf a b c d pinf"},
+		{min: "-inf", max: "(1", want: "ninf"},
+		{min: "-inf", max: "-inf", want: "ninf"},
+		{min: "(-inf", max: "1", want: "a"},
+		{min: "(3", max: "+inf", want: "pinf"},
+		{min: "+inf", max: "+inf", want: "pinf"},
+		{min: "inf", max: "inf", want: "pinf"},
+		{min: "3", max: "(+inf", want: "d"},
+		{min: "+inf", max: "-inf", want: ""},
+	}
+	sortedSet := makeTestSortedSet()
+	for _, tt := range tests {
+		min, err := ParseScoreBorder(tt.min)
+		if err != nil {
+			t.Fatal(err)
+		}
+		max, err := ParseScoreBorder(tt.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := members(sortedSet.RangeByBorder(min, max, 0, -1, false)); got != tt.want {
+			t.Errorf("[%s, %s]: expected %q, got %q", tt.min, tt.max, tt.want, got)
+		}
+		if count := sortedSet.RangeCount(min, max); count != int64(len(strings.Fields(tt.want))) {
+			t.Errorf("[%s, %s]: expected count %d, got %d", tt.min, tt.max, len(strings.Fields(tt.want)), count)
+		}
+		// 倒序的结果是正序结果的反转
+		desc := strings.Fields(members(sortedSet.RangeByBorder(min, max, 0, -1, true)))
+		for i, j := 0, len(desc)-1; i < j; i, j = i+1, j-1 {
+			desc[i], desc[j] = desc[j], desc[i]
+		}
+		if got := strings.Join(desc, " "); got != tt.want {
+			t.Errorf("[%s, %s] desc: expected %q, got %q", tt.min, tt.max, tt.want, got)
+		}
+	}
+	for _, s := range []string{"", "(", "abc", "(abc", "nan", "(nan"} {
+		if _, err := ParseScoreBorder(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestLexBorder(t *testing.T) {
+	tests := []struct {
+		min  string
+		max  string
+		want string
+	}{
+		{min: "-", max: "+", want: "a aa b c"},
+		{min: "[a", max: "[b", want: "a aa b"},
+		{min: "(a", max: "[b", want: "aa b"},
+		{min: "[a", max: "(b", want: "a aa"},
+		{min: "(a", max: "(aa", want: ""},
+		{min: "[aa", max: "[aa", want: "aa"},
+		{min: "(aa", max: "[aa", want: ""},
+		{min: "[b", max: "[a", want: ""},
+		{min: "-", max: "(b", want: "a aa"},
+		{min: "[b", max: "+", want: "b c"},
+		{min: "[", max: "+", want: "a aa b c"},
+		{min: "+", max: "+", want: ""},
+		{min: "-", max: "-", want: ""},
+		{min: "+", max: "-", want: ""},
+	}
+	// 按字典序查询时所有成员的分数相同
+	sortedSet := MakeSortedSet()
+	for _, member := range []string{"c", "aa", "b", "a"} {
+		sortedSet.Add(member, 0)
+	}
+	for _, tt := range tests {
+		min, err := ParseLexBorder(tt.min)
+		if err != nil {
+			t.Fatal(err)
+		}
+		max, err := ParseLexBorder(tt.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := members(sortedSet.RangeByBorder(min, max, 0, -1, false)); got != tt.want {
+			t.Errorf("[%s, %s]: expected %q, got %q", tt.min, tt.max, tt.want, got)
+		}
+	}
+	for _, s := range []string{"", "a", "+a"} {
+		if _, err := ParseLexBorder(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestRangeByBorderLimit(t *testing.T) {
+	sortedSet := makeTestSortedSet()
+	min, _ := ParseScoreBorder("1")
+	max, _ := ParseScoreBorder("3")
+	tests := []struct {
+		offset int64
+		limit  int64
+		desc   bool
+		want   string
+	}{
+		{offset: 0, limit: 2, want: "a b"},
+		{offset: 1, limit: 2, want: "b c"},
+		{offset: 3, limit: -1, want: "d"},
+		{offset: 4, limit: -1, want: ""},
+		{offset: 0, limit: 0, want: ""},
+		{offset: -1, limit: 1, want: ""},
+		{offset: 1, limit: 2, desc: true, want: "c b"},
+		{offset: 3, limit: 5, desc: true, want: "a"},
+	}
+	for _, tt := range tests {
+		if got := members(sortedSet.RangeByBorder(min, max, tt.offset, tt.limit, tt.desc)); got != tt.want {
+			t.Errorf("offset %d limit %d desc %v: expected %q, got %q", tt.offset, tt.limit, tt.desc, tt.want, got)
+		}
+	}
+}
+
+func TestRemoveRange(t *testing.T) {
+	sortedSet := makeTestSortedSet()
+	min, _ := ParseScoreBorder("(1")
+	max, _ := ParseScoreBorder("+inf")
+	if removed := sortedSet.RemoveRange(min, max); removed != 4 {
+		t.Fatalf("expected 4 removed, got %d", removed)
+	}
+	if got := members(sortedSet.Range(0, sortedSet.Len(), false)); got != "ninf a" {
+		t.Fatalf("expected %q, got %q", "ninf a", got)
+	}
+	if _, ok := sortedSet.Get("b"); ok {
+		t.Fatal("removed member is still in dict")
+	}
+	// 按排名删除 排名从0开始 区间是[start, stop)
+	sortedSet = makeTestSortedSet()
+	if removed := sortedSet.RemoveByRank(1, 3); removed != 2 {
+		t.Fatalf("expected 2 removed, got %d", removed)
+	}
+	if got := members(sortedSet.Range(0, sortedSet.Len(), false)); got != "ninf c d pinf" {
+		t.Fatalf("expected %q, got %q", "ninf c d pinf", got)
+	}
+	if sortedSet.GetRank("c", false) != 1 || sortedSet.GetRank("c", true) != 2 || sortedSet.GetRank("a", false) != -1 {
+		t.Fatal("wrong rank after remove")
+	}
+}
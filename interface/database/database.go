@@ -1,6 +1,9 @@
 package database
 
-import "simple-godis/interface/resp"
+import (
+	"simple-godis/interface/resp"
+	"time"
+)
 
 type CmdLine = [][]byte
 
@@ -15,6 +18,30 @@ type Database interface {
 	AfterClientClose(conn resp.Connection)
 }
 
+// DBEngine 可以遍历全部数据的数据库 用于AOF重写等需要导出数据的场景
+// ForEach 遍历一个分数据库中所有未过期的key expiration为nil表示没有设置过期时间
+type DBEngine interface {
+	Database
+	ForEach(dbIndex int, consumer func(key string, entity *DataEntity, expiration *time.Time) bool)
+}
+
+// ClusterEngine 集群模式下本地数据库需要额外提供的能力 迁移槽时在节点之间搬运key
+// DumpKey 将key序列化后交给send 发送成功后从本地删除 key不存在时返回false
+// RestoreKeys 加载DumpKey序列化的数据 覆盖已有的key
+// ReplicaOf 复制addr上的主节点 addr为空时提升为主节点 故障转移时使用
+// ReplicationOffset 返回当前的复制偏移量 选举时复制进度越新的从节点越优先
+// PrepareTx CommitTx RollbackTx 跨节点原子指令在本节点上的预备 提交和回滚
+type ClusterEngine interface {
+	DBEngine
+	DumpKey(dbIndex int, key string, send func(payload []byte) error) (bool, error)
+	RestoreKeys(payload []byte) error
+	ReplicaOf(addr string) error
+	ReplicationOffset() int64
+	PrepareTx(txId string, dbIndex int, cmdLine CmdLine) error
+	CommitTx(txId string) (resp.Reply, error)
+	RollbackTx(txId string) error
+}
+
 // DataEntity 抽象了Redis中所有的数据结构
 type DataEntity struct {
 	Data interface{}
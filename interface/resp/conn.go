@@ -3,7 +3,41 @@ package resp
 // Connection 接口代表了客户端的一个连接
 type Connection interface {
 	Write([]byte) error
+	// Push 异步发送数据 不等待写入连接
+	Push([]byte) error
 	GetDBIndex() int
 	SelectDB(int)
 	Close() error
+
+	// 事务相关
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	ClearQueuedCmds()
+	GetWatching() map[string]uint32
+	AddTxError(err error)
+	GetTxErrors() []error
+
+	// 发布订阅相关
+	Subscribe(channel string)
+	UnSubscribe(channel string)
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	SubsCount() int
+	GetChannels() []string
+	GetPatterns() []string
+
+	// 集群相关
+	IsPeer() bool
+	IsAsking() bool
+	SetAsking(asking bool)
+
+	// 认证相关
+	GetUser() string
+	// IsInternal 是否是通过集群密码认证的节点或者复制链路等内部连接 内部连接不做权限检查
+	IsInternal() bool
+
+	// Protocol 回复使用的协议 发布订阅的消息按照协议编码
+	Protocol() int
 }
@@ -5,3 +5,9 @@ type Reply interface {
 	ToBytes() []byte
 	ToClient() []byte
 }
+
+// Resp3Reply 在RESP3协议中有单独编码的回复 其他回复在RESP3中与ToBytes相同
+type Resp3Reply interface {
+	Reply
+	ToResp3() []byte
+}
@@ -0,0 +1,61 @@
+package hashslot
+
+/*
+集群的哈希槽 与Redis Cluster相同 key通过CRC16映射到16384个槽中的一个
+key中包含{hashtag}时只对hashtag计算哈希 相关的key可以通过相同的hashtag落在同一个槽中
+*/
+
+// SlotCount 集群中槽的总数
+const SlotCount = 16384
+
+// crc16Table CRC16-CCITT(XMODEM)的查找表 多项式0x1021
+var crc16Table = makeCRC16Table()
+
+func makeCRC16Table() [256]uint16 {
+	var table [256]uint16
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// CRC16 计算CRC16-CCITT(XMODEM)校验和
+func CRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// HashTag 返回key中第一对{}之间的内容 没有hashtag或者{}之间为空时返回key本身
+func HashTag(key string) string {
+	for i := 0; i < len(key); i++ {
+		if key[i] != '{' {
+			continue
+		}
+		for j := i + 1; j < len(key); j++ {
+			if key[j] == '}' {
+				if j == i+1 {
+					return key
+				}
+				return key[i+1 : j]
+			}
+		}
+		return key
+	}
+	return key
+}
+
+// KeySlot 计算key所在的槽
+func KeySlot(key string) int {
+	return int(CRC16([]byte(HashTag(key))) % SlotCount)
+}
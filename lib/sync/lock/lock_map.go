@@ -0,0 +1,123 @@
+package lock
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+key级别的锁 key经过哈希后对应锁表中的一把读写锁 多个key可能共用同一把锁
+一次锁定多个key时按照锁的下标顺序加锁 避免不同指令之间相互等待造成死锁
+*/
+
+const prime32 = uint32(16777619)
+
+// Locks 锁表
+type Locks struct {
+	table []*sync.RWMutex
+}
+
+// MakeLocks Locks的构造方法 tableSize为锁的数量
+func MakeLocks(tableSize int) *Locks {
+	table := make([]*sync.RWMutex, tableSize)
+	for i := 0; i < tableSize; i++ {
+		table[i] = &sync.RWMutex{}
+	}
+	return &Locks{
+		table: table,
+	}
+}
+
+// fnv32 计算key的FNV-1a哈希
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= prime32
+	}
+	return hash
+}
+
+// spread 计算key对应的锁的下标
+func (locks *Locks) spread(hashCode uint32) uint32 {
+	tableSize := uint32(len(locks.table))
+	return hashCode % tableSize
+}
+
+// Lock 给一个key加写锁
+func (locks *Locks) Lock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].Lock()
+}
+
+// UnLock 释放一个key的写锁
+func (locks *Locks) UnLock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].Unlock()
+}
+
+// RLock 给一个key加读锁
+func (locks *Locks) RLock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].RLock()
+}
+
+// RUnLock 释放一个key的读锁
+func (locks *Locks) RUnLock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].RUnlock()
+}
+
+// toLockIndices 计算一组key对应的锁的下标 去重后按从小到大排序
+func (locks *Locks) toLockIndices(keys ...[]string) []uint32 {
+	indexSet := make(map[uint32]struct{})
+	for _, group := range keys {
+		for _, key := range group {
+			indexSet[locks.spread(fnv32(key))] = struct{}{}
+		}
+	}
+	indices := make([]uint32, 0, len(indexSet))
+	for index := range indexSet {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return indices[i] < indices[j]
+	})
+	return indices
+}
+
+// toWriteIndexSet 计算需要加写锁的下标集合
+func (locks *Locks) toWriteIndexSet(writeKeys []string) map[uint32]struct{} {
+	writeIndexSet := make(map[uint32]struct{}, len(writeKeys))
+	for _, key := range writeKeys {
+		writeIndexSet[locks.spread(fnv32(key))] = struct{}{}
+	}
+	return writeIndexSet
+}
+
+// RWLocks 给writeKeys加写锁 给readKeys加读锁 一把锁同时被读写时加写锁
+func (locks *Locks) RWLocks(writeKeys []string, readKeys []string) {
+	indices := locks.toLockIndices(writeKeys, readKeys)
+	writeIndexSet := locks.toWriteIndexSet(writeKeys)
+	for _, index := range indices {
+		if _, ok := writeIndexSet[index]; ok {
+			locks.table[index].Lock()
+		} else {
+			locks.table[index].RLock()
+		}
+	}
+}
+
+// RWUnLocks 释放RWLocks加的锁 按照加锁的相反顺序释放
+func (locks *Locks) RWUnLocks(writeKeys []string, readKeys []string) {
+	indices := locks.toLockIndices(writeKeys, readKeys)
+	writeIndexSet := locks.toWriteIndexSet(writeKeys)
+	for i := len(indices) - 1; i >= 0; i-- {
+		index := indices[i]
+		if _, ok := writeIndexSet[index]; ok {
+			locks.table[index].Unlock()
+		} else {
+			locks.table[index].RUnlock()
+		}
+	}
+}
@@ -14,8 +14,10 @@ const ConfigFile string = "redis.conf"
 
 // 没有配置文件时的默认配置
 var defaultProperties = &config.ServerProperties{
-	Bind: "0.0.0.0",
-	Port: 6378,
+	Bind:            "0.0.0.0",
+	Port:            6378,
+	ReplicaReadOnly: true,
+	MaxClients:      10000,
 }
 
 // 判断文件是否存在
@@ -0,0 +1,159 @@
+package pubsub
+
+import (
+	"simple-godis/interface/resp"
+	"simple-godis/lib/wildcard"
+	"sync"
+)
+
+/*
+发布订阅中心 记录每个频道和每个模式的订阅者
+*/
+
+// patternSubscribers 一个模式的订阅者
+type patternSubscribers struct {
+	pattern     *wildcard.Pattern
+	subscribers map[resp.Connection]struct{}
+}
+
+// Hub 发布订阅中心 由单机数据库持有
+type Hub struct {
+	subs     map[string]map[resp.Connection]struct{} // 频道 -> 订阅者
+	patterns map[string]*patternSubscribers          // 模式 -> 订阅者
+	mutex    sync.RWMutex
+}
+
+// MakeHub Hub的构造方法
+func MakeHub() *Hub {
+	return &Hub{
+		subs:     make(map[string]map[resp.Connection]struct{}),
+		patterns: make(map[string]*patternSubscribers),
+	}
+}
+
+// subscribe 将客户端加入频道的订阅者 客户端已经订阅过时返回false
+func (hub *Hub) subscribe(channel string, conn resp.Connection) bool {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	subscribers, ok := hub.subs[channel]
+	if !ok {
+		subscribers = make(map[resp.Connection]struct{})
+		hub.subs[channel] = subscribers
+	}
+	if _, ok := subscribers[conn]; ok {
+		return false
+	}
+	subscribers[conn] = struct{}{}
+	return true
+}
+
+// unsubscribe 将客户端从频道的订阅者中移除 频道没有订阅者时删除频道
+func (hub *Hub) unsubscribe(channel string, conn resp.Connection) bool {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	subscribers, ok := hub.subs[channel]
+	if !ok {
+		return false
+	}
+	if _, ok := subscribers[conn]; !ok {
+		return false
+	}
+	delete(subscribers, conn)
+	if len(subscribers) == 0 {
+		delete(hub.subs, channel)
+	}
+	return true
+}
+
+// psubscribe 将客户端加入模式的订阅者 客户端已经订阅过时返回false
+func (hub *Hub) psubscribe(pattern string, conn resp.Connection) bool {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	subscribers, ok := hub.patterns[pattern]
+	if !ok {
+		subscribers = &patternSubscribers{
+			pattern:     wildcard.CompilePattern(pattern),
+			subscribers: make(map[resp.Connection]struct{}),
+		}
+		hub.patterns[pattern] = subscribers
+	}
+	if _, ok := subscribers.subscribers[conn]; ok {
+		return false
+	}
+	subscribers.subscribers[conn] = struct{}{}
+	return true
+}
+
+// punsubscribe 将客户端从模式的订阅者中移除 模式没有订阅者时删除模式
+func (hub *Hub) punsubscribe(pattern string, conn resp.Connection) bool {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	subscribers, ok := hub.patterns[pattern]
+	if !ok {
+		return false
+	}
+	if _, ok := subscribers.subscribers[conn]; !ok {
+		return false
+	}
+	delete(subscribers.subscribers, conn)
+	if len(subscribers.subscribers) == 0 {
+		delete(hub.patterns, pattern)
+	}
+	return true
+}
+
+// publish 向频道的订阅者和匹配频道的模式的订阅者推送消息 返回收到消息的订阅者数量
+func (hub *Hub) publish(channel string, message []byte) int {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	received := 0
+	if subscribers, ok := hub.subs[channel]; ok {
+		payload := makeMessage(channel, message)
+		for conn := range subscribers {
+			push(conn, payload)
+			received++
+		}
+	}
+	for pattern, subscribers := range hub.patterns {
+		if !subscribers.pattern.IsMatch(channel) {
+			continue
+		}
+		payload := makePMessage(pattern, channel, message)
+		for conn := range subscribers.subscribers {
+			push(conn, payload)
+			received++
+		}
+	}
+	return received
+}
+
+// channels 返回有订阅者的频道 pattern为nil时返回所有频道
+func (hub *Hub) channels(pattern *wildcard.Pattern) []string {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	result := make([]string, 0, len(hub.subs))
+	for channel := range hub.subs {
+		if pattern == nil || pattern.IsMatch(channel) {
+			result = append(result, channel)
+		}
+	}
+	return result
+}
+
+// numSub 返回频道的订阅者数量 不包括模式订阅者
+func (hub *Hub) numSub(channel string) int {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return len(hub.subs[channel])
+}
+
+// numPat 返回所有模式的订阅数量
+func (hub *Hub) numPat() int {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	count := 0
+	for _, subscribers := range hub.patterns {
+		count += len(subscribers.subscribers)
+	}
+	return count
+}
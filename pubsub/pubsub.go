@@ -0,0 +1,198 @@
+package pubsub
+
+import (
+	"simple-godis/interface/resp"
+	"simple-godis/lib/wildcard"
+	"simple-godis/resp/reply"
+	"strings"
+)
+
+/*
+发布订阅指令 订阅确认和消息都通过resp.Connection.Write直接推送给客户端 按照客户端的协议编码 RESP3中使用push类型
+*/
+
+const (
+	subscribeMsg    = "subscribe"
+	unsubscribeMsg  = "unsubscribe"
+	psubscribeMsg   = "psubscribe"
+	punsubscribeMsg = "punsubscribe"
+	messageMsg      = "message"
+	pmessageMsg     = "pmessage"
+)
+
+// push 按照客户端的协议推送消息 订阅者读取太慢时不阻塞发布者
+func push(conn resp.Connection, message resp.Reply) {
+	_ = conn.Push(reply.Encode(message, conn.Protocol()))
+}
+
+// makeAck 订阅和取消订阅的确认消息 [类型, 频道或模式, 客户端当前的订阅数量]
+func makeAck(kind string, name []byte, count int) resp.Reply {
+	var nameReply resp.Reply = reply.MakeBulkReply(name)
+	if name == nil {
+		nameReply = reply.MakeNullBulkReply()
+	}
+	return reply.MakePushReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(kind)),
+		nameReply,
+		reply.MakeIntReply(int64(count)),
+	})
+}
+
+// makeMessage 推送给频道订阅者的消息 [message, 频道, 消息内容]
+func makeMessage(channel string, message []byte) resp.Reply {
+	return reply.MakePushReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(messageMsg)),
+		reply.MakeBulkReply([]byte(channel)),
+		reply.MakeBulkReply(message),
+	})
+}
+
+// makePMessage 推送给模式订阅者的消息 [pmessage, 模式, 频道, 消息内容]
+func makePMessage(pattern string, channel string, message []byte) resp.Reply {
+	return reply.MakePushReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(pmessageMsg)),
+		reply.MakeBulkReply([]byte(pattern)),
+		reply.MakeBulkReply([]byte(channel)),
+		reply.MakeBulkReply(message),
+	})
+}
+
+// Subscribe 订阅一个或多个频道 SUBSCRIBE channel [channel ...]
+func Subscribe(hub *Hub, conn resp.Connection, args [][]byte) resp.Reply {
+	for _, arg := range args {
+		channel := string(arg)
+		if hub.subscribe(channel, conn) {
+			conn.Subscribe(channel)
+		}
+		push(conn, makeAck(subscribeMsg, arg, conn.SubsCount()))
+	}
+	return reply.MakeNoReply()
+}
+
+// UnSubscribe 取消订阅频道 没有参数时取消订阅所有频道 UNSUBSCRIBE [channel ...]
+func UnSubscribe(hub *Hub, conn resp.Connection, args [][]byte) resp.Reply {
+	channels := toStrings(args)
+	if len(channels) == 0 {
+		channels = conn.GetChannels()
+	}
+	if len(channels) == 0 {
+		push(conn, makeAck(unsubscribeMsg, nil, conn.SubsCount()))
+		return reply.MakeNoReply()
+	}
+	for _, channel := range channels {
+		if hub.unsubscribe(channel, conn) {
+			conn.UnSubscribe(channel)
+		}
+		push(conn, makeAck(unsubscribeMsg, []byte(channel), conn.SubsCount()))
+	}
+	return reply.MakeNoReply()
+}
+
+// PSubscribe 订阅一个或多个模式 PSUBSCRIBE pattern [pattern ...]
+func PSubscribe(hub *Hub, conn resp.Connection, args [][]byte) resp.Reply {
+	for _, arg := range args {
+		pattern := string(arg)
+		if hub.psubscribe(pattern, conn) {
+			conn.PSubscribe(pattern)
+		}
+		push(conn, makeAck(psubscribeMsg, arg, conn.SubsCount()))
+	}
+	return reply.MakeNoReply()
+}
+
+// PUnSubscribe 取消订阅模式 没有参数时取消订阅所有模式 PUNSUBSCRIBE [pattern ...]
+func PUnSubscribe(hub *Hub, conn resp.Connection, args [][]byte) resp.Reply {
+	patterns := toStrings(args)
+	if len(patterns) == 0 {
+		patterns = conn.GetPatterns()
+	}
+	if len(patterns) == 0 {
+		push(conn, makeAck(punsubscribeMsg, nil, conn.SubsCount()))
+		return reply.MakeNoReply()
+	}
+	for _, pattern := range patterns {
+		if hub.punsubscribe(pattern, conn) {
+			conn.PUnSubscribe(pattern)
+		}
+		push(conn, makeAck(punsubscribeMsg, []byte(pattern), conn.SubsCount()))
+	}
+	return reply.MakeNoReply()
+}
+
+// UnsubscribeAll 客户端断开连接时取消它的所有订阅
+func UnsubscribeAll(hub *Hub, conn resp.Connection) {
+	for _, channel := range conn.GetChannels() {
+		hub.unsubscribe(channel, conn)
+		conn.UnSubscribe(channel)
+	}
+	for _, pattern := range conn.GetPatterns() {
+		hub.punsubscribe(pattern, conn)
+		conn.PUnSubscribe(pattern)
+	}
+}
+
+// Publish 向频道发布消息 返回收到消息的订阅者数量 PUBLISH channel message
+func Publish(hub *Hub, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	received := hub.publish(string(args[0]), args[1])
+	return reply.MakeIntReply(int64(received))
+}
+
+// PubSub 查看发布订阅的状态 PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func PubSub(hub *Hub, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("pubsub")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "channels":
+		if len(args) > 2 {
+			return reply.MakeArgNumErrReply("pubsub|channels")
+		}
+		var pattern *wildcard.Pattern
+		if len(args) == 2 {
+			pattern = wildcard.CompilePattern(string(args[1]))
+		}
+		channels := hub.channels(pattern)
+		if len(channels) == 0 {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		result := make([][]byte, len(channels))
+		for i, channel := range channels {
+			result[i] = []byte(channel)
+		}
+		return reply.MakeMultiBulkReply(result)
+	case "numsub":
+		result := make([]resp.Reply, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			result = append(result,
+				reply.MakeBulkReply(arg),
+				reply.MakeIntReply(int64(hub.numSub(string(arg)))))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "numpat":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("pubsub|numpat")
+		}
+		return reply.MakeIntReply(int64(hub.numPat()))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try PUBSUB CHANNELS, NUMSUB, NUMPAT")
+}
+
+// Counts 有订阅者的频道数和模式数
+func Counts(hub *Hub) (channels int, patterns int) {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return len(hub.subs), len(hub.patterns)
+}
+
+// toStrings 将参数转换为字符串
+func toStrings(args [][]byte) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = string(arg)
+	}
+	return result
+}
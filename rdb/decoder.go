@@ -0,0 +1,212 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+	"math"
+	List "simple-godis/datastructure/list"
+	HashSet "simple-godis/datastructure/set"
+	"simple-godis/datastructure/smap"
+	SortedSet "simple-godis/datastructure/sortedset"
+	dbInterface "simple-godis/interface/database"
+	"strconv"
+	"time"
+)
+
+// ErrChecksum 快照文件不完整或者已经损坏
+var ErrChecksum = errors.New("rdb checksum mismatch, file is truncated or corrupted")
+
+// errFormat 快照文件格式错误
+func errFormat(msg string) error {
+	return errors.New("bad rdb format: " + msg)
+}
+
+// Consumer 读取快照时每读到一个键值对调用一次 expiration为nil表示没有设置过期时间
+type Consumer func(dbIndex int, key string, entity *dbInterface.DataEntity, expiration *time.Time) error
+
+// Decode 读取整个快照文件 先校验CRC64再逐个解析键值对 校验失败时不会调用consumer
+func Decode(r io.Reader, consumer Consumer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) < len(magic)+2+1+8 {
+		return ErrChecksum
+	}
+	body, trailer := data[:len(data)-8], data[len(data)-8:]
+	if crc64.Checksum(body, crcTable) != binary.BigEndian.Uint64(trailer) {
+		return ErrChecksum
+	}
+	if string(body[:len(magic)]) != magic {
+		return errFormat("bad magic")
+	}
+	fileVersion := binary.BigEndian.Uint16(body[len(magic):])
+	if fileVersion > version {
+		return errFormat("unsupported version " + strconv.Itoa(int(fileVersion)))
+	}
+	decoder := &decoder{
+		reader: bytes.NewReader(body[len(magic)+2:]),
+	}
+	return decoder.decode(consumer)
+}
+
+// decoder 解析校验过的快照内容
+type decoder struct {
+	reader *bytes.Reader
+}
+
+func (decoder *decoder) decode(consumer Consumer) error {
+	dbIndex := 0
+	var expiration *time.Time
+	for {
+		op, err := decoder.reader.ReadByte()
+		if err != nil {
+			return errFormat("unexpected end of file")
+		}
+		switch op {
+		case opEOF:
+			return nil
+		case opSelectDB:
+			index, err := decoder.readLength()
+			if err != nil {
+				return err
+			}
+			dbIndex = int(index)
+			continue
+		case opExpireMs:
+			ms, err := decoder.readUint64()
+			if err != nil {
+				return err
+			}
+			expireTime := time.UnixMilli(int64(ms))
+			expiration = &expireTime
+			continue
+		}
+		key, err := decoder.readString()
+		if err != nil {
+			return err
+		}
+		entity, err := decoder.readValue(op)
+		if err != nil {
+			return err
+		}
+		if err := consumer(dbIndex, string(key), entity, expiration); err != nil {
+			return err
+		}
+		expiration = nil
+	}
+}
+
+// readValue 按照类型解析value
+func (decoder *decoder) readValue(valueType byte) (*dbInterface.DataEntity, error) {
+	switch valueType {
+	case typeString:
+		val, err := decoder.readString()
+		if err != nil {
+			return nil, err
+		}
+		return &dbInterface.DataEntity{Data: val}, nil
+	case typeList:
+		size, err := decoder.readLength()
+		if err != nil {
+			return nil, err
+		}
+		list := List.MakeQuickList()
+		for i := uint64(0); i < size; i++ {
+			val, err := decoder.readString()
+			if err != nil {
+				return nil, err
+			}
+			list.Add(val)
+		}
+		return &dbInterface.DataEntity{Data: list}, nil
+	case typeSet:
+		size, err := decoder.readLength()
+		if err != nil {
+			return nil, err
+		}
+		set := HashSet.MakeSet()
+		for i := uint64(0); i < size; i++ {
+			member, err := decoder.readString()
+			if err != nil {
+				return nil, err
+			}
+			set.Add(string(member))
+		}
+		return &dbInterface.DataEntity{Data: set}, nil
+	case typeHash:
+		size, err := decoder.readLength()
+		if err != nil {
+			return nil, err
+		}
+		hash := smap.MakeSimpleMap()
+		for i := uint64(0); i < size; i++ {
+			field, err := decoder.readString()
+			if err != nil {
+				return nil, err
+			}
+			val, err := decoder.readString()
+			if err != nil {
+				return nil, err
+			}
+			hash.Put(string(field), val)
+		}
+		return &dbInterface.DataEntity{Data: hash}, nil
+	case typeSortedSet:
+		size, err := decoder.readLength()
+		if err != nil {
+			return nil, err
+		}
+		sortedSet := SortedSet.MakeSortedSet()
+		for i := uint64(0); i < size; i++ {
+			member, err := decoder.readString()
+			if err != nil {
+				return nil, err
+			}
+			bits, err := decoder.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			sortedSet.Add(string(member), math.Float64frombits(bits))
+		}
+		return &dbInterface.DataEntity{Data: sortedSet}, nil
+	}
+	return nil, errFormat("unknown value type " + strconv.Itoa(int(valueType)))
+}
+
+// readLength 读取uvarint编码的长度
+func (decoder *decoder) readLength() (uint64, error) {
+	length, err := binary.ReadUvarint(decoder.reader)
+	if err != nil {
+		return 0, errFormat("bad length")
+	}
+	return length, nil
+}
+
+// readUint64 读取8字节的整数
+func (decoder *decoder) readUint64() (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(decoder.reader, buf[:]); err != nil {
+		return 0, errFormat("unexpected end of file")
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// readString 读取长度和内容
+func (decoder *decoder) readString() ([]byte, error) {
+	length, err := decoder.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(decoder.reader.Len()) {
+		return nil, errFormat("string length out of range")
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(decoder.reader, buf); err != nil {
+		return nil, errFormat("unexpected end of file")
+	}
+	return buf, nil
+}
@@ -0,0 +1,229 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+	List "simple-godis/datastructure/list"
+	HashSet "simple-godis/datastructure/set"
+	"simple-godis/datastructure/smap"
+	SortedSet "simple-godis/datastructure/sortedset"
+	dbInterface "simple-godis/interface/database"
+	"time"
+)
+
+/*
+二进制快照文件格式
+文件头: magic(8字节 "GODISRDB") + 版本号(2字节)
+分数据库: opSelectDB + 分数据库下标(uvarint)
+键值对: [opExpireMs + 过期时间(8字节 unix毫秒)] + 类型(1字节) + key + value
+文件尾: opEOF + CRC64校验和(8字节 覆盖opEOF之前的所有内容)
+字符串以 长度(uvarint) + 内容 的形式编码
+*/
+
+const (
+	magic   = "GODISRDB"
+	version = uint16(1)
+)
+
+// 操作码
+const (
+	opSelectDB = byte(0xFE)
+	opExpireMs = byte(0xFC)
+	opEOF      = byte(0xFF)
+)
+
+// 值的类型
+const (
+	typeString    = byte(0)
+	typeList      = byte(1)
+	typeSet       = byte(2)
+	typeHash      = byte(3)
+	typeSortedSet = byte(4)
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// ErrUnsupportedType 数据实体的类型不能写入快照
+var ErrUnsupportedType = errors.New("unsupported data type")
+
+// Encoder 将数据写入快照文件
+type Encoder struct {
+	output io.Writer
+	writer *bufio.Writer
+	crc    hash.Hash64
+	buf    [binary.MaxVarintLen64]byte
+}
+
+// NewEncoder Encoder的构造方法 写入文件头
+func NewEncoder(w io.Writer) (*Encoder, error) {
+	crc := crc64.New(crcTable)
+	encoder := &Encoder{
+		output: w,
+		writer: bufio.NewWriter(io.MultiWriter(w, crc)),
+		crc:    crc,
+	}
+	if _, err := encoder.writer.WriteString(magic); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(encoder.buf[:2], version)
+	if _, err := encoder.writer.Write(encoder.buf[:2]); err != nil {
+		return nil, err
+	}
+	return encoder, nil
+}
+
+// WriteDBIndex 开始写入一个分数据库
+func (encoder *Encoder) WriteDBIndex(dbIndex int) error {
+	if err := encoder.writer.WriteByte(opSelectDB); err != nil {
+		return err
+	}
+	return encoder.writeLength(uint64(dbIndex))
+}
+
+// WriteEntity 写入一个键值对 expiration为nil表示没有设置过期时间
+func (encoder *Encoder) WriteEntity(key string, entity *dbInterface.DataEntity, expiration *time.Time) error {
+	if expiration != nil {
+		if err := encoder.writer.WriteByte(opExpireMs); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(encoder.buf[:8], uint64(expiration.UnixMilli()))
+		if _, err := encoder.writer.Write(encoder.buf[:8]); err != nil {
+			return err
+		}
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		return encoder.writeValue(typeString, key, func() error {
+			return encoder.writeString(val)
+		})
+	case List.List:
+		return encoder.writeValue(typeList, key, func() error {
+			return encoder.writeList(val)
+		})
+	case *HashSet.Set:
+		return encoder.writeValue(typeSet, key, func() error {
+			return encoder.writeSet(val)
+		})
+	case smap.Map:
+		return encoder.writeValue(typeHash, key, func() error {
+			return encoder.writeHash(val)
+		})
+	case *SortedSet.SortedSet:
+		return encoder.writeValue(typeSortedSet, key, func() error {
+			return encoder.writeSortedSet(val)
+		})
+	}
+	return ErrUnsupportedType
+}
+
+// Close 写入文件尾和校验和
+func (encoder *Encoder) Close() error {
+	if err := encoder.writer.WriteByte(opEOF); err != nil {
+		return err
+	}
+	if err := encoder.writer.Flush(); err != nil {
+		return err
+	}
+	// 校验和本身不计入校验范围 直接写入底层的文件
+	binary.BigEndian.PutUint64(encoder.buf[:8], encoder.crc.Sum64())
+	_, err := encoder.output.Write(encoder.buf[:8])
+	return err
+}
+
+// writeValue 写入类型和key 再写入value
+func (encoder *Encoder) writeValue(valueType byte, key string, writeValue func() error) error {
+	if err := encoder.writer.WriteByte(valueType); err != nil {
+		return err
+	}
+	if err := encoder.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return writeValue()
+}
+
+// writeLength 以uvarint编码写入长度
+func (encoder *Encoder) writeLength(length uint64) error {
+	n := binary.PutUvarint(encoder.buf[:], length)
+	_, err := encoder.writer.Write(encoder.buf[:n])
+	return err
+}
+
+// writeString 写入长度和内容
+func (encoder *Encoder) writeString(bytes []byte) error {
+	if err := encoder.writeLength(uint64(len(bytes))); err != nil {
+		return err
+	}
+	_, err := encoder.writer.Write(bytes)
+	return err
+}
+
+// writeList 元素数量 + 每个元素
+func (encoder *Encoder) writeList(list List.List) error {
+	if err := encoder.writeLength(uint64(list.Len())); err != nil {
+		return err
+	}
+	var err error
+	list.ForEach(func(i int, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		err = encoder.writeString(bytes)
+		return err == nil
+	})
+	return err
+}
+
+// writeSet 成员数量 + 每个成员
+func (encoder *Encoder) writeSet(set *HashSet.Set) error {
+	members := set.Members()
+	if err := encoder.writeLength(uint64(len(members))); err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err := encoder.writeString([]byte(member)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHash 字段数量 + 每个字段和值
+func (encoder *Encoder) writeHash(hash smap.Map) error {
+	if err := encoder.writeLength(uint64(hash.Len())); err != nil {
+		return err
+	}
+	var err error
+	hash.ForEach(func(field string, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		if err = encoder.writeString([]byte(field)); err != nil {
+			return false
+		}
+		err = encoder.writeString(bytes)
+		return err == nil
+	})
+	return err
+}
+
+// writeSortedSet 成员数量 + 每个成员和分数 分数以float64的二进制形式写入
+func (encoder *Encoder) writeSortedSet(sortedSet *SortedSet.SortedSet) error {
+	size := sortedSet.Len()
+	if err := encoder.writeLength(uint64(size)); err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+	var err error
+	sortedSet.ForEach(0, size, false, func(element *SortedSet.Element) bool {
+		if err = encoder.writeString([]byte(element.Member)); err != nil {
+			return false
+		}
+		binary.BigEndian.PutUint64(encoder.buf[:8], math.Float64bits(element.Score))
+		_, err = encoder.writer.Write(encoder.buf[:8])
+		return err == nil
+	})
+	return err
+}
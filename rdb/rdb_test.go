@@ -0,0 +1,221 @@
+package rdb
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	List "simple-godis/datastructure/list"
+	HashSet "simple-godis/datastructure/set"
+	"simple-godis/datastructure/smap"
+	SortedSet "simple-godis/datastructure/sortedset"
+	dbInterface "simple-godis/interface/database"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testEntry 写入快照的一个键值对
+type testEntry struct {
+	dbIndex    int
+	key        string
+	entity     *dbInterface.DataEntity
+	expiration *time.Time
+}
+
+func makeTestEntries() []testEntry {
+	list := List.MakeQuickList()
+	for i := 0; i < 100; i++ {
+		list.Add([]byte("item" + strconv.Itoa(i)))
+	}
+	set := HashSet.MakeSet("a", "b", "")
+	hash := smap.MakeSimpleMap()
+	hash.Put("field", []byte("value"))
+	hash.Put("", []byte{})
+	hash.Put("bin", []byte{0, 0xFF, '\r', '\n'})
+	sortedSet := SortedSet.MakeSortedSet()
+	sortedSet.Add("a", 1.5)
+	sortedSet.Add("b", -2)
+	sortedSet.Add("ninf", math.Inf(-1))
+	sortedSet.Add("pinf", math.Inf(1))
+	expiration := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	expired := time.UnixMilli(1)
+
+	var entries []testEntry
+	values := map[string]interface{}{
+		"string": []byte("hello\x00world"),
+		"empty":  []byte{},
+		"list":   list,
+		"set":    set,
+		"hash":   hash,
+		"zset":   sortedSet,
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// 每种类型都写入不带过期时间 带过期时间和已经过期的键值对 分布在两个分数据库中
+	for _, dbIndex := range []int{0, 15} {
+		for _, key := range keys {
+			entity := &dbInterface.DataEntity{Data: values[key]}
+			entries = append(entries,
+				testEntry{dbIndex: dbIndex, key: key, entity: entity},
+				testEntry{dbIndex: dbIndex, key: key + ":ttl", entity: entity, expiration: &expiration},
+				testEntry{dbIndex: dbIndex, key: key + ":expired", entity: entity, expiration: &expired},
+			)
+		}
+	}
+	return entries
+}
+
+func encodeEntries(t *testing.T, entries []testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbIndex := -1
+	for _, entry := range entries {
+		if entry.dbIndex != dbIndex {
+			dbIndex = entry.dbIndex
+			if err := encoder.WriteDBIndex(dbIndex); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := encoder.WriteEntity(entry.key, entry.entity, entry.expiration); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// dumpEntity 将数据实体转换为可以比较的形式 集合和哈希表的成员排序后比较
+func dumpEntity(t *testing.T, entity *dbInterface.DataEntity) []string {
+	t.Helper()
+	var result []string
+	switch val := entity.Data.(type) {
+	case []byte:
+		result = append(result, "string", string(val))
+	case List.List:
+		result = append(result, "list")
+		val.ForEach(func(i int, v interface{}) bool {
+			result = append(result, string(v.([]byte)))
+			return true
+		})
+	case *HashSet.Set:
+		members := val.Members()
+		sort.Strings(members)
+		result = append(append(result, "set"), members...)
+	case smap.Map:
+		var fields []string
+		val.ForEach(func(field string, v interface{}) bool {
+			fields = append(fields, field+"="+string(v.([]byte)))
+			return true
+		})
+		sort.Strings(fields)
+		result = append(append(result, "hash"), fields...)
+	case *SortedSet.SortedSet:
+		result = append(result, "zset")
+		if val.Len() > 0 {
+			val.ForEach(0, val.Len(), false, func(element *SortedSet.Element) bool {
+				result = append(result, element.Member, strconv.FormatUint(math.Float64bits(element.Score), 16))
+				return true
+			})
+		}
+	default:
+		t.Fatalf("unexpected type %T", entity.Data)
+	}
+	return result
+}
+
+func TestRoundTrip(t *testing.T) {
+	entries := makeTestEntries()
+	data := encodeEntries(t, entries)
+	i := 0
+	err := Decode(bytes.NewReader(data), func(dbIndex int, key string, entity *dbInterface.DataEntity, expiration *time.Time) error {
+		if i >= len(entries) {
+			t.Fatalf("unexpected key %s", key)
+		}
+		want := entries[i]
+		i++
+		if dbIndex != want.dbIndex || key != want.key {
+			t.Fatalf("expected %d/%s, got %d/%s", want.dbIndex, want.key, dbIndex, key)
+		}
+		if (expiration == nil) != (want.expiration == nil) {
+			t.Fatalf("%s: expected expiration %v, got %v", key, want.expiration, expiration)
+		}
+		if expiration != nil && !expiration.Equal(*want.expiration) {
+			t.Fatalf("%s: expected expiration %v, got %v", key, want.expiration, expiration)
+		}
+		if got, wantDump := dumpEntity(t, entity), dumpEntity(t, want.entity); !reflect.DeepEqual(got, wantDump) {
+			t.Fatalf("%s: expected %q, got %q", key, wantDump, got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != len(entries) {
+		t.Fatalf("expected %d entries, got %d", len(entries), i)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	data := encodeEntries(t, nil)
+	err := Decode(bytes.NewReader(data), func(int, string, *dbInterface.DataEntity, *time.Time) error {
+		t.Fatal("unexpected entry")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnsupportedType(t *testing.T) {
+	encoder, err := NewEncoder(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.WriteEntity("key", &dbInterface.DataEntity{Data: 1}, nil); err != ErrUnsupportedType {
+		t.Fatalf("expected ErrUnsupportedType, got %v", err)
+	}
+}
+
+// decodeCorrupted 损坏的文件必须被拒绝 且不能读出任何键值对
+func decodeCorrupted(t *testing.T, name string, data []byte) {
+	t.Helper()
+	err := Decode(bytes.NewReader(data), func(int, string, *dbInterface.DataEntity, *time.Time) error {
+		t.Fatalf("%s: consumer called for corrupted file", name)
+		return nil
+	})
+	if err != ErrChecksum {
+		t.Fatalf("%s: expected ErrChecksum, got %v", name, err)
+	}
+}
+
+func TestFlippedByte(t *testing.T) {
+	data := encodeEntries(t, makeTestEntries())
+	corrupted := make([]byte, len(data))
+	// 包括文件头 内容和校验和本身在内的每一个字节
+	for i := range data {
+		for _, mask := range []byte{0x01, 0xFF} {
+			copy(corrupted, data)
+			corrupted[i] ^= mask
+			decodeCorrupted(t, "flip byte "+strconv.Itoa(i), corrupted)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data := encodeEntries(t, makeTestEntries())
+	for n := 0; n < len(data); n++ {
+		decodeCorrupted(t, "truncate to "+strconv.Itoa(n), data[:n])
+	}
+	// 末尾多出的数据也会导致校验失败
+	decodeCorrupted(t, "trailing garbage", append(append([]byte{}, data...), 0))
+}
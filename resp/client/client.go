@@ -2,23 +2,85 @@ package client
 
 import (
 	"net"
+	"simple-godis/lib/sync/atomic"
 	"simple-godis/lib/sync/wait"
+	"strconv"
+	"strings"
 	"sync"
+	syncAtomic "sync/atomic"
 	"time"
 )
 
+// nextClientID 最后分配的客户端id
+var nextClientID uint64
+
 // Client 抽象了客户端的连接
 type Client struct {
 	conn       net.Conn
-	waiting    wait.Wait
-	mutex      sync.Mutex
+	waiting    wait.Wait // 正在写入连接 关闭连接前等待写入完成
 	selectedDB int
+
+	// 输出缓冲区相关
+	mutex          sync.Mutex     // 保护输出缓冲区
+	outBuf         []byte         // 还没有写入连接的数据
+	flushing       bool           // 是否有协程正在写入连接
+	outputClosed   bool           // 超过输出缓冲区限制后关闭了连接
+	softLimitSince time.Time      // 开始超过软限制的时间
+	replica        atomic.Boolean // 是否是从节点的连接
+
+	// 事务相关
+	multiState bool              // 是否处于MULTI之后 EXEC之前
+	queue      [][][]byte        // 事务中排队等待执行的指令
+	watching   map[string]uint32 // WATCH的key以及WATCH时key的版本号
+	txErrors   []error           // 指令入队时出现的错误 有错误时EXEC会放弃整个事务
+
+	// 发布订阅相关
+	subs  map[string]struct{} // 订阅的频道
+	psubs map[string]struct{} // 订阅的模式
+
+	// 集群相关
+	peer          bool // 是否是集群中其他节点建立的连接 需要通过集群密码认证
+	peerRequested bool // 是否发送了握手指令 认证之前仍然是普通客户端
+	asking        bool // 是否收到了ASKING 只对下一条指令有效
+
+	authenticated bool           // 是否已经通过AUTH认证
+	internal      atomic.Boolean // 通过集群密码认证的节点或者复制链路 不做权限检查 其他连接也会读取
+	protocol      int32          // HELLO协商的协议 0表示没有协商 发布消息的协程也会读取
+
+	lastInteraction int64          // 最后一次收到指令的时间 unix纳秒 用于关闭空闲的连接
+	timeoutExempt   atomic.Boolean // 不会因为空闲被关闭
+
+	// CLIENT指令相关 其他连接执行CLIENT LIST KILL时会读取
+	id              uint64         // 按照建立连接的顺序分配的唯一id
+	createdAt       time.Time      // 建立连接的时间
+	pendingOutput   int64          // 缓冲区中和正在写入连接的字节数
+	closeAfterReply atomic.Boolean // 回复之后关闭连接
+	infoMutex       sync.Mutex     // 保护下面的字段
+	user            string         // 连接使用的ACL用户
+	name            string         // CLIENT SETNAME设置的名称
+	lastCmd         string         // 最后一次执行的指令
+	queryBuf        int            // 正在执行的指令的大小
+	state           clientState
+}
+
+// clientState 指令执行完成后的连接状态 由连接自己的协程记录 其他连接读取
+type clientState struct {
+	db    int
+	subs  int
+	psubs int
+	multi int // 事务中排队的指令数 不在事务中时为-1
+	peer  bool
 }
 
 // NewClient 指定conn新建一个客户端的连接
 func NewClient(conn net.Conn) *Client {
+	now := time.Now()
 	return &Client{
-		conn: conn,
+		conn:            conn,
+		lastInteraction: now.UnixNano(),
+		id:              syncAtomic.AddUint64(&nextClientID, 1),
+		createdAt:       now,
+		state:           clientState{multi: -1},
 	}
 }
 
@@ -35,22 +97,6 @@ func (session *Client) Close() error {
 	return nil
 }
 
-// Write 给客户端发送数据
-func (session *Client) Write(bytes []byte) error {
-	if len(bytes) == 0 {
-		return nil
-	}
-	// 同一时刻只能有一个协程向客户端写
-	session.mutex.Lock()
-	session.waiting.Add(1)
-	defer func() {
-		session.waiting.Done()
-		session.mutex.Unlock()
-	}()
-	_, err := session.conn.Write(bytes)
-	return err
-}
-
 // GetDBIndex 返回客户端指定的数据库
 func (session *Client) GetDBIndex() int {
 	return session.selectedDB
@@ -60,3 +106,295 @@ func (session *Client) GetDBIndex() int {
 func (session *Client) SelectDB(dbIndex int) {
 	session.selectedDB = dbIndex
 }
+
+// InMultiState 客户端是否处于事务状态
+func (session *Client) InMultiState() bool {
+	return session.multiState
+}
+
+// SetMultiState 开启或结束事务状态 结束事务时清空排队的指令和WATCH的key
+func (session *Client) SetMultiState(state bool) {
+	if !state {
+		session.watching = nil
+		session.queue = nil
+		session.txErrors = nil
+	}
+	session.multiState = state
+}
+
+// GetQueuedCmdLine 返回事务中排队的指令
+func (session *Client) GetQueuedCmdLine() [][][]byte {
+	return session.queue
+}
+
+// EnqueueCmd 将指令加入事务队列
+func (session *Client) EnqueueCmd(cmdLine [][]byte) {
+	session.queue = append(session.queue, cmdLine)
+}
+
+// ClearQueuedCmds 清空事务队列
+func (session *Client) ClearQueuedCmds() {
+	session.queue = nil
+}
+
+// GetWatching 返回WATCH的key以及WATCH时的版本号
+func (session *Client) GetWatching() map[string]uint32 {
+	if session.watching == nil {
+		session.watching = make(map[string]uint32)
+	}
+	return session.watching
+}
+
+// AddTxError 记录指令入队时出现的错误
+func (session *Client) AddTxError(err error) {
+	session.txErrors = append(session.txErrors, err)
+}
+
+// GetTxErrors 返回指令入队时出现的错误
+func (session *Client) GetTxErrors() []error {
+	return session.txErrors
+}
+
+// Subscribe 记录客户端订阅的频道
+func (session *Client) Subscribe(channel string) {
+	if session.subs == nil {
+		session.subs = make(map[string]struct{})
+	}
+	session.subs[channel] = struct{}{}
+}
+
+// UnSubscribe 移除客户端订阅的频道
+func (session *Client) UnSubscribe(channel string) {
+	delete(session.subs, channel)
+}
+
+// PSubscribe 记录客户端订阅的模式
+func (session *Client) PSubscribe(pattern string) {
+	if session.psubs == nil {
+		session.psubs = make(map[string]struct{})
+	}
+	session.psubs[pattern] = struct{}{}
+}
+
+// PUnSubscribe 移除客户端订阅的模式
+func (session *Client) PUnSubscribe(pattern string) {
+	delete(session.psubs, pattern)
+}
+
+// SubsCount 返回客户端订阅的频道和模式的总数
+func (session *Client) SubsCount() int {
+	return len(session.subs) + len(session.psubs)
+}
+
+// GetChannels 返回客户端订阅的所有频道
+func (session *Client) GetChannels() []string {
+	channels := make([]string, 0, len(session.subs))
+	for channel := range session.subs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// GetPatterns 返回客户端订阅的所有模式
+func (session *Client) GetPatterns() []string {
+	patterns := make([]string, 0, len(session.psubs))
+	for pattern := range session.psubs {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// IsPeer 是否是集群中其他节点建立的连接 只有通过集群密码认证后才是
+func (session *Client) IsPeer() bool {
+	return session.peer
+}
+
+// SetPeer 标记为集群中其他节点建立的连接 需要先验证集群密码
+func (session *Client) SetPeer(peer bool) {
+	session.peer = peer
+}
+
+// IsPeerRequested 是否发送了集群节点的握手指令
+func (session *Client) IsPeerRequested() bool {
+	return session.peerRequested
+}
+
+// RequestPeer 收到握手指令 之后使用集群密码认证的连接成为集群节点
+func (session *Client) RequestPeer() {
+	session.peerRequested = true
+}
+
+// IsAsking 是否收到了ASKING
+func (session *Client) IsAsking() bool {
+	return session.asking
+}
+
+// SetAsking 设置或清除ASKING标记
+func (session *Client) SetAsking(asking bool) {
+	session.asking = asking
+}
+
+// IsAuthenticated 是否已经通过认证
+func (session *Client) IsAuthenticated() bool {
+	return session.authenticated
+}
+
+// SetAuthenticated 设置认证状态
+func (session *Client) SetAuthenticated(authenticated bool) {
+	session.authenticated = authenticated
+}
+
+// GetUser 返回连接使用的ACL用户
+func (session *Client) GetUser() string {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	return session.user
+}
+
+// IsInternal 是否是通过集群密码认证的节点或者复制链路等内部连接 其他连接也可以调用
+func (session *Client) IsInternal() bool {
+	return session.internal.Get()
+}
+
+// SetInternal 标记为内部连接 只能在验证了集群密码或者建立复制链路之后调用
+func (session *Client) SetInternal(internal bool) {
+	session.internal.Set(internal)
+}
+
+// SetUser 设置连接使用的ACL用户
+func (session *Client) SetUser(user string) {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	session.user = user
+}
+
+// Protocol 返回回复使用的协议 没有使用HELLO协商时使用文本协议
+func (session *Client) Protocol() int {
+	return int(syncAtomic.LoadInt32(&session.protocol))
+}
+
+// SetProtocol 设置HELLO协商的协议
+func (session *Client) SetProtocol(protocol int) {
+	syncAtomic.StoreInt32(&session.protocol, int32(protocol))
+}
+
+// UpdateInteraction 记录收到指令的时间
+func (session *Client) UpdateInteraction() {
+	syncAtomic.StoreInt64(&session.lastInteraction, time.Now().UnixNano())
+}
+
+// IdleTime 距离最后一次收到指令的时间
+func (session *Client) IdleTime() time.Duration {
+	return time.Duration(time.Now().UnixNano() - syncAtomic.LoadInt64(&session.lastInteraction))
+}
+
+// IsTimeoutExempt 是否不会因为空闲被关闭
+func (session *Client) IsTimeoutExempt() bool {
+	return session.timeoutExempt.Get()
+}
+
+// SetTimeoutExempt 设置是否不会因为空闲被关闭
+func (session *Client) SetTimeoutExempt(exempt bool) {
+	session.timeoutExempt.Set(exempt)
+}
+
+// ID 返回客户端的唯一id
+func (session *Client) ID() uint64 {
+	return session.id
+}
+
+// GetName 返回CLIENT SETNAME设置的名称
+func (session *Client) GetName() string {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	return session.name
+}
+
+// SetName 设置客户端的名称 为空时清除名称
+func (session *Client) SetName(name string) {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	session.name = name
+}
+
+// BeginCommand 记录正在执行的指令以及指令的大小
+func (session *Client) BeginCommand(cmdName string, size int) {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	session.lastCmd = cmdName
+	session.queryBuf = size
+}
+
+// EndCommand 指令执行完成后记录连接的状态 其他连接不能直接读取这些状态
+func (session *Client) EndCommand() {
+	state := clientState{
+		db:    session.selectedDB,
+		subs:  len(session.subs),
+		psubs: len(session.psubs),
+		multi: -1,
+		peer:  session.peer,
+	}
+	if session.multiState {
+		state.multi = len(session.queue)
+	}
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	session.queryBuf = 0
+	session.state = state
+}
+
+// HasSubscriptions 最后一条指令执行完成后是否订阅了频道或模式 其他连接可以调用
+func (session *Client) HasSubscriptions() bool {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	return session.state.subs+session.state.psubs > 0
+}
+
+// IsCloseAfterReply 是否需要在回复之后关闭连接
+func (session *Client) IsCloseAfterReply() bool {
+	return session.closeAfterReply.Get()
+}
+
+// SetCloseAfterReply 标记在回复之后关闭连接
+func (session *Client) SetCloseAfterReply() {
+	session.closeAfterReply.Set(true)
+}
+
+// Describe 返回CLIENT LIST和CLIENT INFO中一个客户端的信息
+func (session *Client) Describe() string {
+	session.infoMutex.Lock()
+	defer session.infoMutex.Unlock()
+	state := session.state
+	// C集群节点 P订阅中 x事务中 N没有标志
+	flags := ""
+	if state.peer {
+		flags += "C"
+	}
+	if state.subs+state.psubs > 0 {
+		flags += "P"
+	}
+	if state.multi >= 0 {
+		flags += "x"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	fields := []string{
+		"id=" + strconv.FormatUint(session.id, 10),
+		"addr=" + session.conn.RemoteAddr().String(),
+		"laddr=" + session.conn.LocalAddr().String(),
+		"name=" + session.name,
+		"age=" + strconv.FormatInt(int64(time.Since(session.createdAt)/time.Second), 10),
+		"idle=" + strconv.FormatInt(int64(session.IdleTime()/time.Second), 10),
+		"flags=" + flags,
+		"db=" + strconv.Itoa(state.db),
+		"sub=" + strconv.Itoa(state.subs),
+		"psub=" + strconv.Itoa(state.psubs),
+		"multi=" + strconv.Itoa(state.multi),
+		"qbuf=" + strconv.Itoa(session.queryBuf),
+		"omem=" + strconv.FormatInt(syncAtomic.LoadInt64(&session.pendingOutput), 10),
+		"cmd=" + session.lastCmd,
+		"user=" + session.user,
+	}
+	return strings.Join(fields, " ")
+}
@@ -1,13 +1,17 @@
 package client
 
 import (
+	"errors"
+	"io"
 	"net"
 	"runtime/debug"
+	"simple-godis/config"
 	"simple-godis/interface/resp"
 	"simple-godis/lib/logger"
+	"simple-godis/lib/sync/atomic"
 	"simple-godis/lib/sync/wait"
-	"simple-godis/resp/parser"
 	"simple-godis/resp/reply"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -15,10 +19,12 @@ import (
 // ClusterClient is a pipeline mode redis client
 type ClusterClient struct {
 	conn        net.Conn
+	reader      *ReplyReader  // 读取对方的回复 对方支持时使用RESP3
 	pendingReqs chan *request // wait to send
 	waitingReqs chan *request // waiting response
 	ticker      *time.Ticker
 	addr        string
+	broken      atomic.Boolean // 请求超时或者重连失败后标记为不可用 连接池不再复用该连接
 
 	working *sync.WaitGroup // its counter presents unfinished requests(pending and waiting)
 }
@@ -38,15 +44,82 @@ const (
 	maxWait  = 3 * time.Second
 )
 
+// PeerHandshake 集群节点之间建立连接后发送的第一条指令 对方收到后使用resp协议回复 该指令没有回复
+const PeerHandshake = "_peer"
+
+// dialPeer 连接集群中的其他节点并发送握手指令 使用集群密码AUTH认证 之后使用HELLO切换到RESP3
+func dialPeer(addr string) (net.Conn, *ReplyReader, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = conn.Write(reply.MakeMultiBulkReply([][]byte{[]byte(PeerHandshake)}).ToBytes()); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	reader := MakeReplyReader(conn)
+	if err = authPeer(conn, reader, PeerPassword()); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	if err = helloPeer(conn, reader); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, reader, nil
+}
+
+// PeerPassword 集群节点之间认证使用的密码 只使用专门的cluster-password 不会使用客户端的requirePass
+func PeerPassword() string {
+	return config.Properties.ClusterPassword
+}
+
+// syncRequest 发送指令并同步等待回复 只在建立连接时使用
+func syncRequest(conn net.Conn, reader *ReplyReader, cmdLine [][]byte) (resp.Reply, error) {
+	if _, err := conn.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+		return nil, err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(maxWait))
+	defer func() {
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+	return reader.ReadReply()
+}
+
+// authPeer 使用AUTH认证 握手之后对方使用resp协议回复
+func authPeer(conn net.Conn, reader *ReplyReader, password string) error {
+	result, err := syncRequest(conn, reader, [][]byte{[]byte("AUTH"), []byte(password)})
+	if err != nil {
+		return err
+	}
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return errors.New("authentication with " + conn.RemoteAddr().String() + " failed: " + errReply.Error())
+	}
+	return nil
+}
+
+// helloPeer 使用HELLO 3切换到RESP3 对方不支持HELLO时继续使用RESP2
+func helloPeer(conn net.Conn, reader *ReplyReader) error {
+	result, err := syncRequest(conn, reader, [][]byte{[]byte("HELLO"), []byte(strconv.Itoa(reply.Protocol3))})
+	if err != nil {
+		return err
+	}
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		logger.Warn("peer " + conn.RemoteAddr().String() + " does not support RESP3: " + errReply.Error())
+	}
+	return nil
+}
+
 // MakeClusterClient creates a new client
 func MakeClusterClient(addr string) (*ClusterClient, error) {
-	conn, err := net.Dial("tcp", addr)
+	conn, reader, err := dialPeer(addr)
 	if err != nil {
 		return nil, err
 	}
 	return &ClusterClient{
 		addr:        addr,
 		conn:        conn,
+		reader:      reader,
 		pendingReqs: make(chan *request, chanSize),
 		waitingReqs: make(chan *request, chanSize),
 		working:     &sync.WaitGroup{},
@@ -58,7 +131,7 @@ func (client *ClusterClient) Start() {
 	client.ticker = time.NewTicker(10 * time.Second)
 	go client.handleWrite()
 	go func() {
-		err := client.handleRead()
+		err := client.handleRead(client.reader)
 		if err != nil {
 			logger.Error(err)
 		}
@@ -91,14 +164,15 @@ func (client *ClusterClient) handleConnectionError(err error) error {
 			return err
 		}
 	}
-	conn, err1 := net.Dial("tcp", client.addr)
+	conn, reader, err1 := dialPeer(client.addr)
 	if err1 != nil {
 		logger.Error(err1)
 		return err1
 	}
 	client.conn = conn
+	client.reader = reader
 	go func() {
-		_ = client.handleRead()
+		_ = client.handleRead(reader)
 	}()
 	return nil
 }
@@ -128,14 +202,21 @@ func (client *ClusterClient) Send(args [][]byte) resp.Reply {
 	client.pendingReqs <- request
 	timeout := request.waiting.WaitWithTimeout(maxWait)
 	if timeout {
+		client.broken.Set(true)
 		return reply.MakeErrReply("server time out")
 	}
 	if request.err != nil {
+		client.broken.Set(true)
 		return reply.MakeErrReply("request failed")
 	}
 	return request.reply
 }
 
+// IsBroken 连接是否已经不可用
+func (client *ClusterClient) IsBroken() bool {
+	return client.broken.Get()
+}
+
 func (client *ClusterClient) doHeartbeat() {
 	request := &request{
 		args:      [][]byte{[]byte("PING")},
@@ -189,14 +270,19 @@ func (client *ClusterClient) finishRequest(reply resp.Reply) {
 	}
 }
 
-func (client *ClusterClient) handleRead() error {
-	ch := parser.ParseStream(client.conn)
-	for payload := range ch {
-		if payload.Err != nil {
-			client.finishRequest(reply.MakeErrReply(payload.Err.Error()))
-			continue
+// handleRead 依次读取回复 读取出错时结束等待中的请求
+func (client *ClusterClient) handleRead(reader *ReplyReader) error {
+	for {
+		result, err := reader.ReadReply()
+		if err != nil {
+			client.finishRequest(reply.MakeErrReply(err.Error()))
+			if err == io.EOF || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			// 出现协议错误后无法确定下一个回复的开始位置 连接不再使用
+			client.broken.Set(true)
+			return err
 		}
-		client.finishRequest(payload.Data)
+		client.finishRequest(result)
 	}
-	return nil
 }
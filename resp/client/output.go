@@ -0,0 +1,252 @@
+package client
+
+import (
+	"errors"
+	"simple-godis/config"
+	"simple-godis/lib/logger"
+	"strconv"
+	"strings"
+	"sync"
+	syncAtomic "sync/atomic"
+	"time"
+)
+
+/*
+输出缓冲区
+回复先写入缓冲区 由Flush或者缓冲区超过replyChunkBytes时写入连接 流水线中的多个回复合并为一次写入
+同一时刻只有一个协程写入连接 其他协程只把数据追加到缓冲区 由正在写入的协程一并发送
+客户端读取太慢时缓冲区不断增长 超过硬限制或者超过软限制一段时间后关闭连接
+*/
+
+// replyChunkBytes 缓冲区超过该大小时立即写入连接
+const replyChunkBytes = 16 * 1024
+
+// 客户端的分类 不同的分类使用不同的输出缓冲区限制
+const (
+	classNormal  = "normal"
+	classPubSub  = "pubsub"
+	classReplica = "replica"
+)
+
+// defaultOutputBufferLimit 与redis的默认值相同
+const defaultOutputBufferLimit = "normal 0 0 0 pubsub 32mb 8mb 60 replica 256mb 64mb 60"
+
+var errOutputLimitReached = errors.New("output buffer limit reached")
+
+// outputBufferLimit 一类客户端的输出缓冲区限制 为0时不限制
+type outputBufferLimit struct {
+	hard        int64         // 超过时立即关闭连接
+	soft        int64         // 持续超过softSeconds后关闭连接
+	softSeconds time.Duration // 允许超过软限制的时间
+}
+
+var (
+	outputLimits     map[string]*outputBufferLimit
+	outputLimitsOnce sync.Once
+	limitDisconnects int64 // 因为超过输出缓冲区限制被关闭的连接数
+)
+
+// parseOutputBufferLimits 解析client-output-buffer-limit 每个分类依次为名称 硬限制 软限制 秒数
+func parseOutputBufferLimits(value string, limits map[string]*outputBufferLimit) error {
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class == "slave" {
+			class = classReplica
+		}
+		if class != classNormal && class != classPubSub && class != classReplica {
+			return errors.New("invalid client class '" + fields[i] + "'")
+		}
+		hard, err := config.ParseSize(fields[i+1])
+		if err != nil || hard < 0 {
+			return errors.New("invalid hard limit '" + fields[i+1] + "'")
+		}
+		soft, err := config.ParseSize(fields[i+2])
+		if err != nil || soft < 0 {
+			return errors.New("invalid soft limit '" + fields[i+2] + "'")
+		}
+		seconds, err := strconv.Atoi(fields[i+3])
+		if err != nil || seconds < 0 {
+			return errors.New("invalid soft seconds '" + fields[i+3] + "'")
+		}
+		limits[class] = &outputBufferLimit{
+			hard:        hard,
+			soft:        soft,
+			softSeconds: time.Duration(seconds) * time.Second,
+		}
+	}
+	return nil
+}
+
+// getOutputLimit 返回一类客户端的限制 第一次使用时解析配置 配置中没有的分类使用默认值
+func getOutputLimit(class string) *outputBufferLimit {
+	outputLimitsOnce.Do(func() {
+		outputLimits = make(map[string]*outputBufferLimit)
+		_ = parseOutputBufferLimits(defaultOutputBufferLimit, outputLimits)
+		if config.Properties.ClientOutputBufferLimit == "" {
+			return
+		}
+		if err := parseOutputBufferLimits(config.Properties.ClientOutputBufferLimit, outputLimits); err != nil {
+			logger.Warn("invalid client-output-buffer-limit: " + err.Error())
+		}
+	})
+	return outputLimits[class]
+}
+
+// OutputLimitDisconnects 因为超过输出缓冲区限制被关闭的连接数
+func OutputLimitDisconnects() int64 {
+	return syncAtomic.LoadInt64(&limitDisconnects)
+}
+
+// limitClass 客户端的分类
+func (session *Client) limitClass() string {
+	if session.replica.Get() {
+		return classReplica
+	}
+	if session.HasSubscriptions() {
+		return classPubSub
+	}
+	return classNormal
+}
+
+// overLimitLocked 检查等待发送的数据是否超过限制 内部连接不限制 需要持有mutex
+func (session *Client) overLimitLocked(pending int64) bool {
+	if session.IsInternal() {
+		return false
+	}
+	limit := getOutputLimit(session.limitClass())
+	if limit.hard > 0 && pending >= limit.hard {
+		return true
+	}
+	if limit.soft == 0 || pending < limit.soft {
+		session.softLimitSince = time.Time{}
+		return false
+	}
+	if session.softLimitSince.IsZero() {
+		session.softLimitSince = time.Now()
+		return false
+	}
+	return time.Since(session.softLimitSince) > limit.softSeconds
+}
+
+// appendLocked 将数据追加到缓冲区 超过限制时关闭连接 需要持有mutex
+func (session *Client) appendLocked(bytes []byte) error {
+	if session.outputClosed {
+		return errOutputLimitReached
+	}
+	pending := syncAtomic.AddInt64(&session.pendingOutput, int64(len(bytes)))
+	session.outBuf = append(session.outBuf, bytes...)
+	if !session.overLimitLocked(pending) {
+		return nil
+	}
+	// 丢弃还没有发送的数据 关闭连接后正在写入的协程和读取指令的协程都会退出
+	session.outputClosed = true
+	syncAtomic.AddInt64(&session.pendingOutput, -int64(len(session.outBuf)))
+	session.outBuf = nil
+	syncAtomic.AddInt64(&limitDisconnects, 1)
+	logger.Warn("client " + session.RemoteAddr().String() + " scheduled to be closed for overcoming of output buffer limits")
+	_ = session.conn.Close()
+	return errOutputLimitReached
+}
+
+// flushLocked 将缓冲区写入连接 其他协程正在写入时直接返回 由该协程一并发送 需要持有mutex 返回时释放
+func (session *Client) flushLocked() error {
+	if session.flushing || len(session.outBuf) == 0 {
+		session.mutex.Unlock()
+		return nil
+	}
+	session.flushing = true
+	session.waiting.Add(1)
+	return session.drainLocked()
+}
+
+// drainLocked 不断写入直到缓冲区为空 调用前需要设置flushing并持有mutex 返回时释放
+func (session *Client) drainLocked() error {
+	defer session.waiting.Done()
+	for len(session.outBuf) > 0 {
+		data := session.outBuf
+		session.outBuf = nil
+		session.mutex.Unlock()
+		_, err := session.conn.Write(data)
+		syncAtomic.AddInt64(&session.pendingOutput, -int64(len(data)))
+		session.mutex.Lock()
+		if err != nil {
+			syncAtomic.AddInt64(&session.pendingOutput, -int64(len(session.outBuf)))
+			session.outBuf = nil
+			session.flushing = false
+			session.mutex.Unlock()
+			return err
+		}
+	}
+	session.flushing = false
+	session.mutex.Unlock()
+	return nil
+}
+
+// Write 给客户端发送数据 立即写入连接
+func (session *Client) Write(bytes []byte) error {
+	if len(bytes) == 0 {
+		return nil
+	}
+	session.mutex.Lock()
+	if err := session.appendLocked(bytes); err != nil {
+		session.mutex.Unlock()
+		return err
+	}
+	return session.flushLocked()
+}
+
+// Buffer 将回复写入缓冲区 缓冲区超过replyChunkBytes时写入连接 否则等待Flush
+func (session *Client) Buffer(bytes []byte) error {
+	if len(bytes) == 0 {
+		return nil
+	}
+	session.mutex.Lock()
+	if err := session.appendLocked(bytes); err != nil {
+		session.mutex.Unlock()
+		return err
+	}
+	if len(session.outBuf) < replyChunkBytes {
+		session.mutex.Unlock()
+		return nil
+	}
+	return session.flushLocked()
+}
+
+// Flush 将缓冲区中的回复写入连接
+func (session *Client) Flush() error {
+	session.mutex.Lock()
+	return session.flushLocked()
+}
+
+// Push 将数据追加到缓冲区 由后台协程写入连接 其他客户端推送消息时不会被读取太慢的客户端阻塞
+func (session *Client) Push(bytes []byte) error {
+	if len(bytes) == 0 {
+		return nil
+	}
+	session.mutex.Lock()
+	if err := session.appendLocked(bytes); err != nil {
+		session.mutex.Unlock()
+		return err
+	}
+	if session.flushing {
+		session.mutex.Unlock()
+		return nil
+	}
+	session.flushing = true
+	session.waiting.Add(1)
+	session.mutex.Unlock()
+	go func() {
+		session.mutex.Lock()
+		_ = session.drainLocked()
+	}()
+	return nil
+}
+
+// SetReplica 标记为从节点的连接 使用replica分类的输出缓冲区限制
+func (session *Client) SetReplica() {
+	session.replica.Set(true)
+}
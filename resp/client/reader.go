@@ -0,0 +1,217 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"simple-godis/interface/resp"
+	"simple-godis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+/*
+集群节点之间读取回复
+按照RESP2和RESP3协议逐个读取完整的回复 聚合类型递归读取其中的元素
+只包含字符串和空值的数组读取为MultiBulkReply 与parser的结果保持一致
+*/
+
+// ReplyReader 从连接中读取回复
+type ReplyReader struct {
+	reader *bufio.Reader
+}
+
+// MakeReplyReader 新建一个ReplyReader
+func MakeReplyReader(reader io.Reader) *ReplyReader {
+	return &ReplyReader{
+		reader: bufio.NewReader(reader),
+	}
+}
+
+// protocolError 回复不符合协议
+func protocolError(line string) error {
+	return errors.New("Protocol error: " + strconv.Quote(line))
+}
+
+// readLine 读取一行 不包含\r\n
+func (r *ReplyReader) readLine() (string, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", protocolError(line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readBlob 读取长度为n的内容和之后的\r\n
+func (r *ReplyReader) readBlob(n int64) ([]byte, error) {
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.reader, buf); err != nil {
+		return nil, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, protocolError(string(buf))
+	}
+	return buf[:n], nil
+}
+
+// readReplies 连续读取n个回复
+func (r *ReplyReader) readReplies(n int) ([]resp.Reply, error) {
+	replies := make([]resp.Reply, n)
+	for i := range replies {
+		element, err := r.ReadReply()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = element
+	}
+	return replies, nil
+}
+
+// readPairs 读取n个键值对
+func (r *ReplyReader) readPairs(n int) (*reply.MapReply, error) {
+	pairs, err := r.readReplies(n * 2)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]resp.Reply, n)
+	values := make([]resp.Reply, n)
+	for i := 0; i < n; i++ {
+		keys[i], values[i] = pairs[2*i], pairs[2*i+1]
+	}
+	return reply.MakeMapReply(keys, values), nil
+}
+
+// ReadReply 读取一个完整的回复 返回的错误是io错误或协议错误 之后不能再继续读取
+func (r *ReplyReader) ReadReply() (resp.Reply, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	body := line[1:]
+	switch line[0] {
+	case '+':
+		return reply.MakeStatusReply(body), nil
+	case '-':
+		return reply.MakeErrReply(body), nil
+	case ':':
+		code, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, protocolError(line)
+		}
+		return reply.MakeIntReply(code), nil
+	case '_':
+		return reply.MakeNullBulkReply(), nil
+	case '#':
+		if body != "t" && body != "f" {
+			return nil, protocolError(line)
+		}
+		return reply.MakeBooleanReply(body == "t"), nil
+	case ',':
+		return parseDouble(line)
+	case '(':
+		value, ok := new(big.Int).SetString(body, 10)
+		if !ok {
+			return nil, protocolError(line)
+		}
+		return reply.MakeBigNumberReply(value), nil
+	}
+	n, err := strconv.ParseInt(body, 10, 64)
+	if err != nil || n < -1 {
+		return nil, protocolError(line)
+	}
+	switch line[0] {
+	case '$', '!', '=':
+		if n == -1 {
+			return reply.MakeNullBulkReply(), nil
+		}
+		blob, err := r.readBlob(n)
+		if err != nil {
+			return nil, err
+		}
+		if line[0] == '!' {
+			return reply.MakeErrReply(string(blob)), nil
+		}
+		if line[0] == '=' {
+			if len(blob) < 4 || blob[3] != ':' {
+				return nil, protocolError(line)
+			}
+			return reply.MakeVerbatimReply(string(blob[:3]), blob[4:]), nil
+		}
+		return reply.MakeBulkReply(blob), nil
+	case '*':
+		if n == -1 {
+			return reply.MakeNullReply(), nil
+		}
+		if n == 0 {
+			return reply.MakeEmptyMultiBulkReply(), nil
+		}
+		replies, err := r.readReplies(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return toMultiBulk(replies), nil
+	case '~', '>':
+		replies, err := r.readReplies(int(n))
+		if err != nil {
+			return nil, err
+		}
+		if line[0] == '~' {
+			return reply.MakeSetReply(replies), nil
+		}
+		return reply.MakePushReply(replies), nil
+	case '%':
+		return r.readPairs(int(n))
+	case '|':
+		attributes, err := r.readPairs(int(n))
+		if err != nil {
+			return nil, err
+		}
+		result, err := r.ReadReply()
+		if err != nil {
+			return nil, err
+		}
+		return reply.MakeAttributeReply(attributes, result), nil
+	}
+	return nil, protocolError(line)
+}
+
+// parseDouble 解析RESP3中的浮点数 ,1.5 ,inf ,-inf ,nan
+func parseDouble(line string) (resp.Reply, error) {
+	switch strings.ToLower(line[1:]) {
+	case "inf":
+		return reply.MakeDoubleReply(math.Inf(1)), nil
+	case "-inf":
+		return reply.MakeDoubleReply(math.Inf(-1)), nil
+	case "nan":
+		return reply.MakeDoubleReply(math.NaN()), nil
+	}
+	value, err := strconv.ParseFloat(line[1:], 64)
+	if err != nil {
+		return nil, protocolError(line)
+	}
+	return reply.MakeDoubleReply(value), nil
+}
+
+// toMultiBulk 只包含字符串和空值的数组转换为MultiBulkReply 否则转换为MultiRawReply
+func toMultiBulk(replies []resp.Reply) resp.Reply {
+	msg := make([][]byte, len(replies))
+	for i, element := range replies {
+		switch element := element.(type) {
+		case *reply.BulkReply:
+			msg[i] = element.Msg
+			if msg[i] == nil {
+				msg[i] = []byte{}
+			}
+		case *reply.NullBulkReply:
+			msg[i] = nil
+		default:
+			return reply.MakeMultiRawReply(replies)
+		}
+	}
+	return reply.MakeMultiBulkReply(msg)
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"simple-godis/database"
+	"simple-godis/interface/resp"
+	"simple-godis/resp/client"
+	"simple-godis/resp/reply"
+)
+
+/*
+认证
+默认用户需要密码时 客户端需要先使用AUTH认证 除了AUTH HELLO QUIT之外的指令都回复NOAUTH
+AUTH password使用默认用户认证 AUTH username password使用ACL中的用户认证
+集群中的其他节点建立连接时先发送握手指令 再使用cluster-password认证 认证后作为内部连接不做权限检查
+只有集群模式下接受握手指令 集群密码必须单独配置 客户端的密码不能用于成为集群节点
+*/
+
+// noAuthCommands 认证之前可以执行的指令
+var noAuthCommands = map[string]bool{
+	"auth":  true,
+	"hello": true,
+	"quit":  true,
+}
+
+var (
+	noAuthErrReply    = reply.MakeErrReply("NOAUTH Authentication required.")
+	wrongPassErrReply = reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+)
+
+// isAuthenticated 客户端是否可以执行指令 默认用户不需要密码时不需要认证
+func isAuthenticated(c *client.Client) bool {
+	return c.IsAuthenticated() || database.DefaultUserNoPass()
+}
+
+// execAuth AUTH [username] password
+func execAuth(c *client.Client, args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeArgNumErrReply("auth")
+	}
+	username, password := database.DefaultUser, string(args[len(args)-1])
+	if len(args) == 2 {
+		username = string(args[0])
+	}
+	// 发送了握手指令的连接使用集群密码认证后成为集群节点
+	if c.IsPeerRequested() && len(args) == 1 && client.PeerPassword() != "" &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(client.PeerPassword())) == 1 {
+		authenticatePeer(c)
+		return reply.MakeOkReply()
+	}
+	if len(args) == 1 && database.DefaultUserNoPass() {
+		return reply.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. " +
+			"Are you sure your configuration is correct?")
+	}
+	if !database.Authenticate(username, password) {
+		return wrongPassErrReply
+	}
+	c.SetUser(username)
+	c.SetAuthenticated(true)
+	return reply.MakeOkReply()
+}
+
+// authenticatePeer 标记为集群节点 作为内部连接不做权限检查
+func authenticatePeer(c *client.Client) {
+	c.SetPeer(true)
+	c.SetInternal(true)
+	c.SetUser("")
+	c.SetAuthenticated(true)
+}
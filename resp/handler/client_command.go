@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"simple-godis/interface/resp"
+	"simple-godis/resp/client"
+	"simple-godis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+CLIENT指令
+连接由handler管理 CLIENT指令在handler中执行 不经过数据库
+LIST INFO列出连接的信息 KILL关闭其他的连接 PAUSE UNPAUSE暂停和恢复客户端的指令
+*/
+
+// execClient CLIENT LIST|INFO|ID|SETNAME|GETNAME|KILL|PAUSE|UNPAUSE
+func (handler *RespHandler) execClient(c *client.Client, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("client")
+	}
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR CLIENT inside MULTI is not allowed")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "list":
+		return handler.clientList(args[2:])
+	case "info":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|info")
+		}
+		return reply.MakeBulkReply([]byte(c.Describe() + "\n"))
+	case "id":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|id")
+		}
+		return reply.MakeIntReply(int64(c.ID()))
+	case "setname":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("client|setname")
+		}
+		name := string(args[2])
+		if !isValidClientName(name) {
+			return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.SetName(name)
+		return reply.MakeOkReply()
+	case "getname":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|getname")
+		}
+		name := c.GetName()
+		if name == "" {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply([]byte(name))
+	case "kill":
+		if len(args) < 3 {
+			return reply.MakeArgNumErrReply("client|kill")
+		}
+		return handler.clientKill(c, args[2:])
+	case "pause":
+		if len(args) != 3 && len(args) != 4 {
+			return reply.MakeArgNumErrReply("client|pause")
+		}
+		timeout, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil || timeout < 0 {
+			return reply.MakeErrReply("ERR timeout is not an integer or out of range")
+		}
+		all := true
+		if len(args) == 4 {
+			switch strings.ToLower(string(args[3])) {
+			case "all":
+			case "write":
+				all = false
+			default:
+				return reply.MakeSyntaxErrReply()
+			}
+		}
+		handler.pauseClients(time.Duration(timeout)*time.Millisecond, all)
+		return reply.MakeOkReply()
+	case "unpause":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|unpause")
+		}
+		handler.unpauseClients()
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLIENT HELP.")
+}
+
+// isValidClientName 名称中不能有空格 换行等字符
+func isValidClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedClients 按照id的顺序返回所有的连接
+func (handler *RespHandler) sortedClients() []*client.Client {
+	var clients []*client.Client
+	handler.activeConn.Range(func(key interface{}, value interface{}) bool {
+		clients = append(clients, key.(*client.Client))
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID() < clients[j].ID()
+	})
+	return clients
+}
+
+// clientList CLIENT LIST [TYPE normal|pubsub] [ID id [id ...]]
+func (handler *RespHandler) clientList(args [][]byte) resp.Reply {
+	var clientType string
+	var ids map[uint64]bool
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "type":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			i++
+			clientType = strings.ToLower(string(args[i]))
+			if clientType != "normal" && clientType != "pubsub" {
+				return reply.MakeErrReply("ERR Unknown client type '" + string(args[i]) + "'")
+			}
+		case "id":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			ids = make(map[uint64]bool)
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseUint(string(args[i]), 10, 64)
+				if err != nil || id == 0 {
+					return reply.MakeErrReply("ERR Invalid client ID")
+				}
+				ids[id] = true
+			}
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	var buf strings.Builder
+	for _, session := range handler.sortedClients() {
+		if ids != nil && !ids[session.ID()] {
+			continue
+		}
+		if clientType != "" && (clientType == "pubsub") != session.HasSubscriptions() {
+			continue
+		}
+		buf.WriteString(session.Describe())
+		buf.WriteString("\n")
+	}
+	return reply.MakeBulkReply([]byte(buf.String()))
+}
+
+// clientKill CLIENT KILL addr 或者 CLIENT KILL [ID id] [ADDR addr] [USER username] [SKIPME yes|no]
+// 只有addr一个参数时回复OK 否则回复关闭的连接数
+func (handler *RespHandler) clientKill(c *client.Client, args [][]byte) resp.Reply {
+	var id uint64
+	var addr, user string
+	hasUser, skipMe := false, true
+	if len(args) == 1 {
+		addr = string(args[0])
+	} else {
+		if len(args)%2 != 0 {
+			return reply.MakeSyntaxErrReply()
+		}
+		for i := 0; i < len(args); i += 2 {
+			value := string(args[i+1])
+			switch strings.ToLower(string(args[i])) {
+			case "id":
+				parsed, err := strconv.ParseUint(value, 10, 64)
+				if err != nil || parsed == 0 {
+					return reply.MakeErrReply("ERR client-id should be greater than 0")
+				}
+				id = parsed
+			case "addr":
+				addr = value
+			case "user":
+				user, hasUser = value, true
+			case "skipme":
+				switch strings.ToLower(value) {
+				case "yes":
+					skipMe = true
+				case "no":
+					skipMe = false
+				default:
+					return reply.MakeSyntaxErrReply()
+				}
+			default:
+				return reply.MakeSyntaxErrReply()
+			}
+		}
+	}
+	var killed int64
+	for _, session := range handler.sortedClients() {
+		if (id != 0 && session.ID() != id) ||
+			(addr != "" && session.RemoteAddr().String() != addr) ||
+			(hasUser && session.GetUser() != user) {
+			continue
+		}
+		if session == c {
+			// 只有addr参数时不跳过自己
+			if skipMe && len(args) > 1 {
+				continue
+			}
+			c.SetCloseAfterReply()
+		} else {
+			go func(session *client.Client) {
+				_ = session.Close()
+			}(session)
+		}
+		killed++
+	}
+	if len(args) == 1 {
+		if killed == 0 {
+			return reply.MakeErrReply("ERR No such client")
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeIntReply(killed)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"net"
+	"simple-godis/config"
+	"simple-godis/lib/logger"
+	"simple-godis/resp/client"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/*
+连接管理
+连接数达到maxClients时拒绝新的连接
+配置了timeout时定期检查activeConn中的连接 关闭空闲超过timeout秒的客户端 认证后的集群节点和订阅中的客户端除外
+*/
+
+var maxClientsErrBytes = []byte("-ERR max number of clients reached\r\n")
+
+// clientsCronInterval 检查空闲连接的间隔
+const clientsCronInterval = time.Second
+
+// acceptClient 连接数没有超过maxClients时接受连接 否则回复错误后关闭连接
+func (handler *RespHandler) acceptClient(conn net.Conn) bool {
+	count := atomic.AddInt64(&handler.connCount, 1)
+	maxClients := config.Properties.MaxClients
+	if maxClients <= 0 || count <= int64(maxClients) {
+		atomic.AddInt64(&handler.totalConns, 1)
+		return true
+	}
+	atomic.AddInt64(&handler.connCount, -1)
+	atomic.AddInt64(&handler.rejectedConns, 1)
+	_, _ = conn.Write(maxClientsErrBytes)
+	_ = conn.Close()
+	logger.Warn("max number of clients reached, connection from " + conn.RemoteAddr().String() + " rejected")
+	return false
+}
+
+// startClientsCron 定期关闭空闲的连接
+func (handler *RespHandler) startClientsCron() {
+	go func() {
+		ticker := time.NewTicker(clientsCronInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				handler.closeIdleClients()
+			case <-handler.stopCron:
+				return
+			}
+		}
+	}()
+}
+
+// closeIdleClients 关闭空闲超过timeout的连接 连接关闭后由Handle完成清理
+func (handler *RespHandler) closeIdleClients() {
+	timeout := time.Duration(config.Properties.Timeout) * time.Second
+	if timeout <= 0 {
+		return
+	}
+	handler.activeConn.Range(func(key interface{}, value interface{}) bool {
+		session := key.(*client.Client)
+		if session.IsTimeoutExempt() || session.IdleTime() <= timeout {
+			return true
+		}
+		atomic.AddInt64(&handler.timedOutConns, 1)
+		logger.Info("closing idle client " + session.RemoteAddr().String())
+		go func() {
+			_ = session.Close()
+		}()
+		return true
+	})
+}
+
+// clientsInfo INFO clients的内容
+func (handler *RespHandler) clientsInfo(write func(name string, value string)) {
+	write("connected_clients", strconv.FormatInt(atomic.LoadInt64(&handler.connCount), 10))
+	write("maxclients", strconv.Itoa(config.Properties.MaxClients))
+	write("rejected_connections", strconv.FormatInt(atomic.LoadInt64(&handler.rejectedConns), 10))
+	write("timeout", strconv.Itoa(config.Properties.Timeout))
+	write("timedout_connections", strconv.FormatInt(atomic.LoadInt64(&handler.timedOutConns), 10))
+}
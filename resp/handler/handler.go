@@ -2,12 +2,12 @@ package handler
 
 import (
 	"context"
-	"io"
 	"net"
 	"simple-godis/clus"
 	"simple-godis/config"
 	"simple-godis/database"
 	dbInterface "simple-godis/interface/database"
+	"simple-godis/interface/resp"
 	"simple-godis/lib/logger"
 	"simple-godis/lib/sync/atomic"
 	"simple-godis/resp/client"
@@ -15,6 +15,7 @@ import (
 	"simple-godis/resp/reply"
 	"strings"
 	"sync"
+	syncAtomic "sync/atomic"
 )
 
 // RespHandler TCP层处理resp协议
@@ -22,94 +23,170 @@ type RespHandler struct {
 	activeConn sync.Map
 	db         dbInterface.Database
 	closing    atomic.Boolean
+	closeOnce  sync.Once
+
+	connCount     int64         // 当前的连接数
+	totalConns    int64         // 接受过的连接总数
+	rejectedConns int64         // 因为超过maxClients被拒绝的连接数
+	timedOutConns int64         // 因为空闲超时被关闭的连接数
+	totalCommands int64         // 执行过的指令总数
+	ops           opsSampler    // 统计每秒执行的指令数
+	stopCron      chan struct{} // 关闭时停止定时任务
+	pause         pauseState    // CLIENT PAUSE暂停客户端的指令
 }
 
 func MakeRespHandler() *RespHandler {
 	var db dbInterface.Database
 	// 如果是集群模式 则建立集群数据库
-	if config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
+	if config.Properties.ClusterEnabled() {
 		db = clus.MakeClusterDatabase()
 	} else { // 否则建立单机数据库
 		db = database.MakeStandaloneDatabases()
 	}
-	return &RespHandler{
-		db: db,
+	handler := &RespHandler{
+		db:       db,
+		stopCron: make(chan struct{}),
 	}
+	handler.registerInfoProviders()
+	handler.startClientsCron()
+	handler.startStatsCron()
+	return handler
 }
 
 // Handle 实现handler.Handle方法 处理客户端的连接
 func (handler *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 	if handler.closing.Get() {
 		_ = conn.Close()
+		return
+	}
+	if !handler.acceptClient(conn) {
+		return
 	}
 	newClient := client.NewClient(conn)             // 使用conn新建一个客户端连接
+	newClient.SetUser(database.DefaultUser)         // 认证之前使用默认用户
 	handler.activeConn.Store(newClient, struct{}{}) // 将连接存储
-	ch := parser.ParseStream(conn)                  // 解析器不断监听管道的数据并将处理后的数据传递到channel中
-	for payload := range ch {                       // ch在被关闭之前会一直循环输出
-		if payload.Err != nil { // 如果监听的指令存在错误
-			if payload.Err == io.EOF || payload.Err == io.ErrUnexpectedEOF ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") {
-				handler.closeClient(newClient) // 客户端关闭连接
-				return
-			} else { // 协议解析错误
-				errReply := reply.MakeErrReply(payload.Err.Error())
-				err := newClient.Write(errReply.ToBytes()) // 将错误写回客户端
-				if err != nil {                            // 回复用户出错时出错
-					handler.closeClient(newClient)
-					return
-				}
-				continue
-			}
-		} else { // 指令不存在错误
-			if payload.Data == nil { // 用户发送的指令为空
-				logger.Info("empty command received")
-				continue
-			}
-			// 2.指令不为空 尝试将数据转换成二维字节数组
-			command, ok := payload.Data.(*reply.MultiBulkReply)
-			if !ok {
-				continue
-			}
-			// 3.转换成功，db执行指令
-			execResult := handler.db.Exec(newClient, command.Msg)
-			if execResult != nil {
-				err := newClient.Write(execResult.ToClient())
-				if err != nil { // 回复用户出错时出错
-					handler.closeClient(newClient)
-					return
-				}
-			} else {
-				err := newClient.Write(reply.MakeUnknownErrReply().ToBytes())
-				if err != nil { // 回复用户出错时出错
-					handler.closeClient(newClient)
-					return
-				}
+	reader := parser.MakeReader(conn)               // 在当前协程中同步读取客户端发来的指令
+	defer reader.Release()
+	for {
+		cmdLine, err := reader.ReadCommand()
+		if err != nil {
+			if parser.IsProtocolError(err) { // 协议错误之后的数据无法解析 回复错误后关闭连接
+				_ = newClient.Write(reply.MakeErrReply(err.Error()).ToBytes())
 			}
+			handler.closeClient(newClient)
+			return
+		}
+		if handler.closing.Get() { // 开始关闭之后不再执行新的指令
+			handler.closeClient(newClient)
+			return
+		}
+		if !handler.handleCommand(newClient, cmdLine) {
+			return
+		}
+		// 缓冲区中已经有下一条指令时先不发送 流水线中的回复合并后一起写入连接
+		if reader.Buffered() > 0 {
+			continue
+		}
+		if err := newClient.Flush(); err != nil {
+			handler.closeClient(newClient)
+			return
 		}
 	}
 }
 
+// handleCommand 执行一条指令并将回复写入缓冲区 连接被关闭时返回false
+func (handler *RespHandler) handleCommand(newClient *client.Client, cmdLine [][]byte) bool {
+	// 集群中的其他节点建立连接后先发送握手指令 之后回复使用resp协议 便于对方解析
+	// 握手指令不能证明身份 使用集群密码认证之后才作为集群节点 单机模式下作为普通指令处理
+	if len(cmdLine) == 1 && config.Properties.ClusterEnabled() &&
+		strings.ToLower(string(cmdLine[0])) == client.PeerHandshake {
+		newClient.RequestPeer()
+		newClient.SetProtocol(reply.Protocol2)
+		return true
+	}
+	newClient.UpdateInteraction()
+	syncAtomic.AddInt64(&handler.totalCommands, 1)
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	newClient.BeginCommand(cmdName, commandSize(cmdLine))
+	// 认证之后由db执行指令
+	var execResult resp.Reply
+	switch {
+	case cmdName == "auth":
+		execResult = execAuth(newClient, cmdLine[1:])
+	case cmdName == "hello":
+		execResult = handler.execHello(newClient, cmdLine)
+	case cmdName == "quit":
+		_ = newClient.Write(reply.Encode(reply.MakeOkReply(), newClient.Protocol()))
+		handler.closeClient(newClient)
+		return false
+	case !isAuthenticated(newClient) && !noAuthCommands[cmdName]:
+		execResult = noAuthErrReply
+	case cmdName == "client":
+		// 连接由handler管理 CLIENT指令不经过数据库执行
+		execResult = database.CheckPermission(newClient, cmdLine)
+		if execResult == nil {
+			execResult = handler.execClient(newClient, cmdLine)
+		}
+	default:
+		handler.waitPaused(newClient, cmdName, cmdLine)
+		execResult = handler.db.Exec(newClient, cmdLine)
+	}
+	newClient.EndCommand()
+	if execResult == nil {
+		execResult = reply.MakeUnknownErrReply()
+	}
+	// 认证后的集群节点和订阅中的客户端不会因为空闲被关闭
+	newClient.SetTimeoutExempt(newClient.IsInternal() || newClient.SubsCount() > 0)
+	if err := newClient.Buffer(reply.Encode(execResult, newClient.Protocol())); err != nil { // 回复用户出错时出错
+		handler.closeClient(newClient)
+		return false
+	}
+	if newClient.IsCloseAfterReply() { // CLIENT KILL关闭了自己的连接
+		_ = newClient.Flush()
+		handler.closeClient(newClient)
+		return false
+	}
+	return true
+}
+
+// commandSize 指令所有参数的字节数
+func commandSize(cmdLine [][]byte) int {
+	size := 0
+	for _, arg := range cmdLine {
+		size += len(arg)
+	}
+	return size
+}
+
 // closeClient 关闭指定的一个客户端的连接
 func (handler *RespHandler) closeClient(client *client.Client) {
+	// 连接池移除连接 同一个连接只处理一次
+	if _, ok := handler.activeConn.LoadAndDelete(client); !ok {
+		return
+	}
+	syncAtomic.AddInt64(&handler.connCount, -1)
 	logger.Info("Connection closed: " + client.RemoteAddr().String())
 	_ = client.Close()
 	handler.db.AfterClientClose(client) // 关闭后的处理
-	handler.activeConn.Delete(client)   // 连接池移除连接
 }
 
 // Close 实现handler.Close方法
 func (handler *RespHandler) Close() error {
-	logger.Info("Handler shutting down...")
-	handler.closing.Set(true)
+	// 收到关闭信号和服务退出时都会调用Close 只关闭一次 后调用的一方等待关闭完成
+	handler.closeOnce.Do(func() {
+		logger.Info("Handler shutting down...")
+		handler.closing.Set(true)
+		close(handler.stopCron)
 
-	handler.activeConn.Range(
-		func(key interface{}, value interface{}) bool {
-			session := key.(*client.Client)
-			_ = session.Close()
-			return true
-		},
-	)
-	// 关闭数据库连接
-	handler.db.Close()
+		handler.activeConn.Range(
+			func(key interface{}, value interface{}) bool {
+				session := key.(*client.Client)
+				_ = session.Close()
+				return true
+			},
+		)
+		// 关闭数据库连接
+		handler.db.Close()
+	})
 	return nil
 }
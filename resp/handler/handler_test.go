@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"simple-godis/config"
+	"simple-godis/database"
+	"simple-godis/lib/utils"
+	"simple-godis/resp/client"
+	"simple-godis/resp/reply"
+	"strings"
+	"testing"
+
+	_ "simple-godis/command"
+)
+
+// testConn 通过内存中的管道连接到handler的客户端
+// 普通连接使用文本协议 握手之后使用RESP2 只比较回复的第一行
+type testConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// makeTestHandler 使用单机数据库的handler 默认用户需要密码 并且只能执行GET
+func makeTestHandler(t *testing.T, clusterEnabled bool) *RespHandler {
+	t.Helper()
+	saved := *config.Properties
+	t.Cleanup(func() { *config.Properties = saved })
+	config.Properties.RequirePass = "clientpw"
+	config.Properties.ClusterPassword = "clusterpw"
+	config.Properties.Self, config.Properties.Peers = "", nil
+	if clusterEnabled {
+		// 只需要handler认为处于集群模式 数据库仍然使用单机数据库
+		config.Properties.Self = "127.0.0.1:6399"
+		config.Properties.Peers = []string{"127.0.0.1:6400"}
+	}
+	handler := &RespHandler{
+		db:       database.MakeStandaloneDatabases(),
+		stopCron: make(chan struct{}),
+	}
+	t.Cleanup(func() { _ = handler.Close() })
+	admin := client.NewClient(nil)
+	admin.SetInternal(true)
+	setUser := utils.ToCmdLine("acl", "setuser", database.DefaultUser, "resetkeys", "~allowed:*", "-@all", "+get")
+	if result := handler.db.Exec(admin, setUser); reply.IsErrorReply(result) {
+		t.Fatalf("ACL SETUSER: %s", result.ToBytes())
+	}
+	return handler
+}
+
+func dialTestHandler(t *testing.T, handler *RespHandler) *testConn {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	go handler.Handle(context.Background(), serverConn)
+	t.Cleanup(func() { _ = clientConn.Close() })
+	return &testConn{conn: clientConn, reader: bufio.NewReader(clientConn)}
+}
+
+// send 发送指令 不等待回复 用于没有回复的握手指令
+func (c *testConn) send(t *testing.T, args ...string) {
+	t.Helper()
+	if _, err := c.conn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectPrefix 回复的第一行去掉类型前缀之后以prefix开头 用于只关心错误类型的情况
+func (c *testConn) expectPrefix(t *testing.T, prefix string, args ...string) {
+	t.Helper()
+	c.send(t, args...)
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(strings.TrimLeft(line, "+-"), prefix) {
+		t.Fatalf("%v: expected %q, got %q", args, prefix, line)
+	}
+}
+
+func TestPeerRequiresClusterPassword(t *testing.T) {
+	handler := makeTestHandler(t, true)
+
+	// 客户端的密码不能用于成为集群节点 仍然受到ACL的限制
+	c := dialTestHandler(t, handler)
+	c.send(t, client.PeerHandshake)
+	c.expectPrefix(t, "OK", "auth", "clientpw")
+	c.expectPrefix(t, "NOPERM", "set", "allowed:1", "v")
+	c.expectPrefix(t, "NOPERM", "get", "other")
+	c.expectPrefix(t, "NOPERM", "_prepare", "tx", "0", "set", "allowed:1", "v")
+
+	// 密码错误时不会成为集群节点 也不会通过认证
+	c = dialTestHandler(t, handler)
+	c.send(t, client.PeerHandshake)
+	c.expectPrefix(t, "WRONGPASS", "auth", "wrong")
+	c.expectPrefix(t, "NOAUTH", "get", "allowed:1")
+
+	// 没有发送握手指令时集群密码不能用于认证
+	c = dialTestHandler(t, handler)
+	c.expectPrefix(t, "WRONGPASS", "auth", "clusterpw")
+
+	// 使用集群密码认证之后作为内部连接 不做权限检查
+	c = dialTestHandler(t, handler)
+	c.send(t, client.PeerHandshake)
+	c.expectPrefix(t, "OK", "auth", "clusterpw")
+	c.expectPrefix(t, "OK", "set", "other", "v")
+	c.expectPrefix(t, "$1", "get", "other")
+}
+
+func TestPeerHandshakeStandalone(t *testing.T) {
+	handler := makeTestHandler(t, false)
+
+	// 单机模式下握手指令作为普通指令处理 不能使用集群密码认证
+	c := dialTestHandler(t, handler)
+	c.expectPrefix(t, "NOAUTH", client.PeerHandshake)
+	c.expectPrefix(t, "WRONGPASS", "auth", "clusterpw")
+	c.expectPrefix(t, "OK", "auth", "clientpw")
+	c.expectPrefix(t, "NOPERM", client.PeerHandshake)
+	c.expectPrefix(t, "NOPERM", "set", "allowed:1", "v")
+}
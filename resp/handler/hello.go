@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"simple-godis/config"
+	"simple-godis/interface/resp"
+	"simple-godis/resp/client"
+	"simple-godis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+/*
+HELLO [protover [AUTH username password] [SETNAME clientname]]
+协商连接使用的协议 2为RESP2 3为RESP3 可以同时认证和设置名称 回复服务端和连接的信息
+没有使用HELLO的客户端使用文本协议
+*/
+
+var helloNoAuthErrReply = reply.MakeErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+	"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and " +
+	"select the RESP protocol version at the same time")
+
+// execHello 参数有错误或者认证失败时不修改连接的协议
+func (handler *RespHandler) execHello(c *client.Client, args [][]byte) resp.Reply {
+	protocol := c.Protocol()
+	if len(args) >= 2 {
+		version, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return reply.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if version != reply.Protocol2 && version != reply.Protocol3 {
+			return reply.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		protocol = version
+	}
+	var authArgs [][]byte
+	var name []byte
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToLower(string(args[i])); {
+		case option == "auth" && i+2 < len(args):
+			authArgs = args[i+1 : i+3]
+			i += 2
+		case option == "setname" && i+1 < len(args):
+			name = args[i+1]
+			if !isValidClientName(string(name)) {
+				return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			i++
+		default:
+			return reply.MakeErrReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+		}
+	}
+	if authArgs != nil {
+		if result := execAuth(c, authArgs); reply.IsErrorReply(result) {
+			return result
+		}
+	}
+	if !isAuthenticated(c) {
+		return helloNoAuthErrReply
+	}
+	if name != nil {
+		c.SetName(string(name))
+	}
+	c.SetProtocol(protocol)
+	mode := "standalone"
+	if config.Properties.ClusterEnabled() {
+		mode = "cluster"
+	}
+	// 没有使用HELLO协商时proto为2
+	proto := protocol
+	if proto == reply.ProtocolText {
+		proto = reply.Protocol2
+	}
+	return reply.MakeMapReply(
+		reply.BulkReplies([][]byte{
+			[]byte("server"), []byte("version"), []byte("proto"), []byte("id"), []byte("mode"), []byte("modules"),
+		}),
+		[]resp.Reply{
+			reply.MakeBulkReply([]byte("redis")),
+			reply.MakeBulkReply([]byte(config.Version)),
+			reply.MakeIntReply(int64(proto)),
+			reply.MakeIntReply(int64(c.ID())),
+			reply.MakeBulkReply([]byte(mode)),
+			reply.MakeEmptyMultiBulkReply(),
+		},
+	)
+}
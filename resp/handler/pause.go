@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"simple-godis/database"
+	"simple-godis/resp/client"
+	"sync"
+	"time"
+)
+
+/*
+CLIENT PAUSE
+暂停期间客户端的指令等待暂停结束后再执行 WRITE模式只暂停写指令 ALL模式暂停所有指令
+认证后的集群节点等内部连接以及复制相关的指令不会暂停 CLIENT指令也不会暂停 以便执行UNPAUSE
+*/
+
+// pauseState 客户端指令的暂停状态
+type pauseState struct {
+	mutex      sync.Mutex
+	resume     chan struct{} // 暂停结束时关闭
+	pauseUntil time.Time     // 暂停的截止时间
+	all        bool          // 是否暂停所有指令
+}
+
+// 暂停期间仍然可以执行的指令
+var unpausedCommands = map[string]bool{
+	"client":   true,
+	"replconf": true,
+	"psync":    true,
+	"sync":     true,
+}
+
+// pauseClients 暂停客户端的指令 新的暂停覆盖之前的暂停
+func (handler *RespHandler) pauseClients(timeout time.Duration, all bool) {
+	state := &handler.pause
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.resume == nil {
+		state.resume = make(chan struct{})
+	}
+	state.pauseUntil = time.Now().Add(timeout)
+	state.all = all
+}
+
+// unpauseClients 结束暂停 等待中的指令继续执行
+func (handler *RespHandler) unpauseClients() {
+	state := &handler.pause
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.resume != nil {
+		close(state.resume)
+		state.resume = nil
+	}
+	state.pauseUntil = time.Time{}
+}
+
+// waitPaused 暂停期间等待暂停结束 事务中写指令在EXEC时执行 WRITE模式下EXEC也需要等待
+func (handler *RespHandler) waitPaused(c *client.Client, cmdName string, cmdLine [][]byte) {
+	if c.IsInternal() || unpausedCommands[cmdName] {
+		return
+	}
+	isWrite := database.IsWriteCommand(cmdLine) || cmdName == "exec"
+	// 等待期间可能再次暂停 需要重新检查
+	for {
+		state := &handler.pause
+		state.mutex.Lock()
+		resume, remaining, all := state.resume, time.Until(state.pauseUntil), state.all
+		state.mutex.Unlock()
+		if resume == nil || remaining <= 0 || (!all && !isWrite) {
+			return
+		}
+		// 等待之前先发送已经执行完的指令的回复
+		_ = c.Flush()
+		timer := time.NewTimer(remaining)
+		select {
+		case <-resume:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
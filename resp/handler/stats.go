@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"simple-godis/database"
+	"simple-godis/resp/client"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+INFO中由handler统计的内容
+每100毫秒采样一次执行过的指令总数 与redis一样使用最近16次采样的平均值作为每秒执行的指令数
+*/
+
+const (
+	statsSampleInterval = 100 * time.Millisecond
+	statsSampleCount    = 16
+)
+
+// opsSampler 计算每秒执行的指令数
+type opsSampler struct {
+	mutex     sync.Mutex
+	lastTime  time.Time
+	lastCount int64
+	samples   [statsSampleCount]int64 // 每次采样时的每秒指令数
+	index     int
+}
+
+// sample 记录一次采样 count为执行过的指令总数
+func (sampler *opsSampler) sample(count int64, now time.Time) {
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+	if elapsed := now.Sub(sampler.lastTime); !sampler.lastTime.IsZero() && elapsed > 0 {
+		sampler.samples[sampler.index] = (count - sampler.lastCount) * int64(time.Second) / int64(elapsed)
+		sampler.index = (sampler.index + 1) % statsSampleCount
+	}
+	sampler.lastTime = now
+	sampler.lastCount = count
+}
+
+// perSecond 最近采样的平均值
+func (sampler *opsSampler) perSecond() int64 {
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+	var sum int64
+	for _, value := range sampler.samples {
+		sum += value
+	}
+	return sum / statsSampleCount
+}
+
+// startStatsCron 定期采样执行过的指令数
+func (handler *RespHandler) startStatsCron() {
+	go func() {
+		ticker := time.NewTicker(statsSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				handler.ops.sample(atomic.LoadInt64(&handler.totalCommands), now)
+			case <-handler.stopCron:
+				return
+			}
+		}
+	}()
+}
+
+// statsInfo INFO stats中由handler统计的内容
+func (handler *RespHandler) statsInfo(write func(name string, value string)) {
+	write("total_connections_received", strconv.FormatInt(atomic.LoadInt64(&handler.totalConns), 10))
+	write("total_commands_processed", strconv.FormatInt(atomic.LoadInt64(&handler.totalCommands), 10))
+	write("instantaneous_ops_per_sec", strconv.FormatInt(handler.ops.perSecond(), 10))
+	write("client_output_buffer_limit_disconnections", strconv.FormatInt(client.OutputLimitDisconnects(), 10))
+}
+
+// registerInfoProviders 将连接和指令的统计提供给INFO指令
+func (handler *RespHandler) registerInfoProviders() {
+	database.SetInfoProvider("clients", handler.clientsInfo)
+	database.SetInfoProvider("stats", handler.statsInfo)
+}
@@ -0,0 +1,145 @@
+package parser
+
+import (
+	"bufio"
+	"simple-godis/config"
+)
+
+/*
+内联指令 不以*开头的一行文本 例如使用telnet nc发送的 SET key "hello world"
+参数之间使用空白分隔 与redis-cli一样支持双引号和单引号
+双引号中支持\n \r \t \b \a \\ \" \xHH转义 单引号中只支持\'转义
+*/
+
+// defaultInlineMaxSize 没有配置proto-inline-max-size时一行内联指令的最大长度
+const defaultInlineMaxSize = 64 * 1024
+
+var (
+	errInlineTooBig     = &ProtocolError{Msg: "too big inline request"}
+	errUnbalancedQuotes = &ProtocolError{Msg: "unbalanced quotes in request"}
+)
+
+// inlineMaxSize 一行的最大长度
+func inlineMaxSize() int {
+	if config.Properties.ProtoInlineMaxSize > 0 {
+		return config.Properties.ProtoInlineMaxSize
+	}
+	return defaultInlineMaxSize
+}
+
+// readLineWithLimit 读取一行 超过limit时不再继续读取 避免客户端发送没有换行的数据占用内存
+func readLineWithLimit(bufReader *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := bufReader.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return nil, errInlineTooBig
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// isSpace 参数之间的分隔符
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// hexDigit 十六进制字符的值 不是十六进制字符时返回-1
+func hexDigit(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
+
+// splitInlineArgs 将一行内联指令拆分为参数 引号不匹配或者右引号之后没有空白时返回错误
+func splitInlineArgs(line []byte) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg []byte
+		inDoubleQuotes, inSingleQuotes := false, false
+		for done := false; !done; {
+			if inDoubleQuotes {
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' &&
+					hexDigit(line[i+2]) >= 0 && hexDigit(line[i+3]) >= 0 {
+					arg = append(arg, byte(hexDigit(line[i+2])*16+hexDigit(line[i+3])))
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				} else if line[i] == '"' {
+					// 右引号之后必须是空白或者行尾
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			} else if inSingleQuotes {
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					arg = append(arg, '\'')
+					i++
+				} else if line[i] == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			} else {
+				if i == len(line) || isSpace(line[i]) {
+					done = true
+				} else if line[i] == '"' {
+					inDoubleQuotes = true
+				} else if line[i] == '\'' {
+					inSingleQuotes = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		if arg == nil {
+			arg = []byte{}
+		}
+		args = append(args, arg)
+	}
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"io"
+	"simple-godis/config"
+	"strings"
+	"testing"
+)
+
+func TestSplitInlineArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  error
+	}{
+		{line: "", want: nil},
+		{line: " \t\r\n", want: nil},
+		{line: "PING", want: []string{"PING"}},
+		{line: "SET key value\r\n", want: []string{"SET", "key", "value"}},
+		{line: "  SET\t key   value  \n", want: []string{"SET", "key", "value"}},
+		{line: `SET key "hello world"`, want: []string{"SET", "key", "hello world"}},
+		{line: `SET "" ''`, want: []string{"SET", "", ""}},
+		{line: `"a" "b"`, want: []string{"a", "b"}},
+		{line: `SET k "a\nb\r\tc\bd\ae"`, want: []string{"SET", "k", "a\nb\r\tc\bd\ae"}},
+		{line: `SET k "\"quoted\" \\"`, want: []string{"SET", "k", `"quoted" \`}},
+		{line: `SET k "\x41\x4a\x00\xff"`, want: []string{"SET", "k", "AJ\x00\xff"}},
+		{line: `SET k "\x4"`, want: []string{"SET", "k", "x4"}},
+		{line: `SET k "\xZZ"`, want: []string{"SET", "k", "xZZ"}},
+		{line: `SET k "\q"`, want: []string{"SET", "k", "q"}},
+		{line: `SET k 'hello world'`, want: []string{"SET", "k", "hello world"}},
+		{line: `SET k 'it\'s'`, want: []string{"SET", "k", "it's"}},
+		{line: `SET k 'a\nb\x41"'`, want: []string{"SET", "k", `a\nb\x41"`}},
+		{line: `SET k "it's"`, want: []string{"SET", "k", "it's"}},
+		{line: `SET k ab"c"`, want: []string{"SET", "k", "abc"}},
+		{line: `SET k "abc`, err: errUnbalancedQuotes},
+		{line: `SET k 'abc`, err: errUnbalancedQuotes},
+		{line: `SET k "abc"def`, err: errUnbalancedQuotes},
+		{line: `SET k 'abc'def`, err: errUnbalancedQuotes},
+		{line: `SET k "abc\"`, err: errUnbalancedQuotes},
+	}
+	for _, tt := range tests {
+		args, err := splitInlineArgs([]byte(tt.line))
+		if err != tt.err {
+			t.Errorf("%q: expected error %v, got %v", tt.line, tt.err, err)
+			continue
+		}
+		if len(args) != len(tt.want) {
+			t.Errorf("%q: expected %q, got %q", tt.line, tt.want, args)
+			continue
+		}
+		for i, arg := range args {
+			if string(arg) != tt.want[i] {
+				t.Errorf("%q: expected %q, got %q", tt.line, tt.want, args)
+				break
+			}
+		}
+	}
+}
+
+func TestInlineMaxSize(t *testing.T) {
+	saved := config.Properties.ProtoInlineMaxSize
+	defer func() { config.Properties.ProtoInlineMaxSize = saved }()
+
+	tests := []struct {
+		maxSize int
+		line    string
+		err     error
+	}{
+		// 长度包括行尾的\r\n
+		{maxSize: 16, line: "SET k " + strings.Repeat("v", 8) + "\r\n", err: io.EOF},
+		{maxSize: 16, line: "SET k " + strings.Repeat("v", 9) + "\r\n", err: errInlineTooBig},
+		{maxSize: 16, line: "SET k " + strings.Repeat("v", 64), err: errInlineTooBig},
+		// 超过bufio缓冲区但没有超过限制的行可以正常读取
+		{maxSize: 0, line: "SET k " + strings.Repeat("v", readerBufferSize*2) + "\r\n", err: io.EOF},
+		{maxSize: 0, line: "SET k " + strings.Repeat("v", defaultInlineMaxSize) + "\r\n", err: errInlineTooBig},
+	}
+	for i, tt := range tests {
+		config.Properties.ProtoInlineMaxSize = tt.maxSize
+		for _, split := range splitReaders {
+			_, err := readAll(split.make([]byte(tt.line)))
+			if err != tt.err {
+				t.Errorf("case %d %s: expected %v, got %v", i, split.name, tt.err, err)
+			}
+		}
+	}
+}
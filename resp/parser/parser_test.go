@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"simple-godis/resp/reply"
+	"strconv"
+	"testing"
+)
+
+// makeCommands 生成n条SET指令 值的长度为valueSize
+func makeCommands(n int, valueSize int) []byte {
+	var buf bytes.Buffer
+	value := bytes.Repeat([]byte("v"), valueSize)
+	for i := 0; i < n; i++ {
+		cmdLine := [][]byte{[]byte("SET"), []byte("key:" + strconv.Itoa(i)), value}
+		buf.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+	}
+	return buf.Bytes()
+}
+
+func benchmarkParseStream(b *testing.B, data []byte, n int) {
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		for payload := range ParseStream(bytes.NewReader(data)) {
+			if payload.Err != nil {
+				if payload.Err != io.EOF {
+					b.Fatal(payload.Err)
+				}
+				break
+			}
+			count++
+		}
+		if count != n {
+			b.Fatalf("expected %d commands, got %d", n, count)
+		}
+	}
+}
+
+func benchmarkReader(b *testing.B, data []byte, n int) {
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader := MakeReader(bytes.NewReader(data))
+		count := 0
+		for {
+			_, err := reader.ReadCommand()
+			if err != nil {
+				if err != io.EOF {
+					b.Fatal(err)
+				}
+				break
+			}
+			count++
+		}
+		reader.Release()
+		if count != n {
+			b.Fatalf("expected %d commands, got %d", n, count)
+		}
+	}
+}
+
+func BenchmarkParseStreamSmall(b *testing.B) {
+	benchmarkParseStream(b, makeCommands(1000, 16), 1000)
+}
+
+func BenchmarkReaderSmall(b *testing.B) {
+	benchmarkReader(b, makeCommands(1000, 16), 1000)
+}
+
+func BenchmarkParseStreamLarge(b *testing.B) {
+	benchmarkParseStream(b, makeCommands(100, 64*1024), 100)
+}
+
+func BenchmarkReaderLarge(b *testing.B) {
+	benchmarkReader(b, makeCommands(100, 64*1024), 100)
+}
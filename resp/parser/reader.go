@@ -0,0 +1,222 @@
+package parser
+
+import (
+	"bufio"
+	"io"
+	"simple-godis/config"
+	"sync"
+)
+
+/*
+Reader 同步读取客户端发来的指令 每个连接在自己的协程中调用ReadCommand 不需要额外的解析协程和channel
+头部行直接在bufio的缓冲区中解析 不会分配内存 每条指令只为参数列表和每个参数的内容分配内存
+返回的参数不会被复用 可以直接保存到数据库中
+*/
+
+const (
+	// readerBufferSize bufio的缓冲区大小 *和$开头的头部行必须能放入缓冲区
+	readerBufferSize = 16 * 1024
+	// maxMultiBulkLen 一条指令最多的参数个数 与redis相同
+	maxMultiBulkLen = 1024 * 1024
+	// defaultMaxBulkLen 没有配置proto-max-bulk-len时一个参数的最大长度
+	defaultMaxBulkLen = 512 * 1024 * 1024
+	// bulkPreallocSize 参数超过该长度时随着读取逐步扩容 避免只声明长度不发送数据的客户端占用内存
+	bulkPreallocSize = 64 * 1024
+	// argsPreallocSize 预先分配的参数列表的最大长度
+	argsPreallocSize = 1024
+)
+
+// ProtocolError 客户端发送的数据不符合协议 之后的数据无法继续解析 回复错误后需要关闭连接
+type ProtocolError struct {
+	Msg string
+}
+
+func (err *ProtocolError) Error() string {
+	return "Protocol error: " + err.Msg
+}
+
+// IsProtocolError 判断是否是协议错误 其他错误是读取连接时的io错误
+func IsProtocolError(err error) bool {
+	_, ok := err.(*ProtocolError)
+	return ok
+}
+
+// bufReaderPool 复用连接的bufio缓冲区
+var bufReaderPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReaderSize(nil, readerBufferSize)
+	},
+}
+
+// maxBulkLen 一个参数的最大长度
+func maxBulkLen() int64 {
+	if config.Properties.ProtoMaxBulkLen > 0 {
+		return int64(config.Properties.ProtoMaxBulkLen)
+	}
+	return defaultMaxBulkLen
+}
+
+// Reader 从io流中读取指令
+type Reader struct {
+	reader *bufio.Reader
+}
+
+// MakeReader 新建一个Reader 不再使用时调用Release归还缓冲区
+func MakeReader(reader io.Reader) *Reader {
+	bufReader := bufReaderPool.Get().(*bufio.Reader)
+	bufReader.Reset(reader)
+	return &Reader{
+		reader: bufReader,
+	}
+}
+
+// Release 归还缓冲区 之后不能再读取
+func (r *Reader) Release() {
+	if r.reader == nil {
+		return
+	}
+	r.reader.Reset(nil)
+	bufReaderPool.Put(r.reader)
+	r.reader = nil
+}
+
+// Buffered 缓冲区中还没有解析的字节数 不为0时说明客户端使用了流水线
+func (r *Reader) Buffered() int {
+	return r.reader.Buffered()
+}
+
+// ReadCommand 读取一条完整的指令 跳过空行和空数组
+// 返回的错误是io错误或者ProtocolError 出错后不能再继续读取
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	for {
+		prefix, err := r.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		var args [][]byte
+		if prefix[0] == '*' {
+			args, err = r.readMultiBulk()
+		} else {
+			args, err = r.readInline()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+// readInline 读取一行内联指令
+func (r *Reader) readInline() ([][]byte, error) {
+	line, err := readLineWithLimit(r.reader, inlineMaxSize())
+	if err != nil {
+		return nil, err
+	}
+	return splitInlineArgs(line)
+}
+
+// readHeader 读取*或$开头的头部行并解析其中的长度 返回的切片指向bufio的缓冲区
+func (r *Reader) readHeader(tooBig string) (byte, int64, bool, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return 0, 0, false, &ProtocolError{Msg: tooBig}
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return line[0], 0, false, nil
+	}
+	n, ok := parseLength(line[1 : len(line)-2])
+	return line[0], n, ok, nil
+}
+
+// readMultiBulk 读取*<count>\r\n之后的count个参数 每个参数的格式为$<len>\r\n<data>\r\n
+func (r *Reader) readMultiBulk() ([][]byte, error) {
+	_, count, ok, err := r.readHeader("too big mbulk count string")
+	if err != nil {
+		return nil, err
+	}
+	if !ok || count > maxMultiBulkLen {
+		return nil, &ProtocolError{Msg: "invalid multibulk length"}
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+	capacity := count
+	if capacity > argsPreallocSize {
+		capacity = argsPreallocSize
+	}
+	args := make([][]byte, 0, capacity)
+	limit := maxBulkLen()
+	for i := int64(0); i < count; i++ {
+		prefix, n, ok, err := r.readHeader("too big bulk count string")
+		if err != nil {
+			return nil, err
+		}
+		if prefix != '$' {
+			return nil, &ProtocolError{Msg: "expected '$', got '" + string(prefix) + "'"}
+		}
+		if !ok || n < 0 || n > limit {
+			return nil, &ProtocolError{Msg: "invalid bulk length"}
+		}
+		arg, err := r.readBulk(int(n))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk 读取长度为n的参数和之后的\r\n
+func (r *Reader) readBulk(n int) ([]byte, error) {
+	capacity := n
+	if capacity > bulkPreallocSize {
+		capacity = bulkPreallocSize
+	}
+	arg := make([]byte, 0, capacity)
+	for len(arg) < n {
+		if len(arg) == cap(arg) {
+			grown := 2 * cap(arg)
+			if grown > n {
+				grown = n
+			}
+			arg = append(make([]byte, 0, grown), arg...)
+		}
+		read, err := io.ReadFull(r.reader, arg[len(arg):cap(arg)])
+		arg = arg[:len(arg)+read]
+		if err != nil {
+			return nil, err
+		}
+	}
+	crlf, err := r.reader.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if crlf[0] != '\r' || crlf[1] != '\n' {
+		return nil, &ProtocolError{Msg: "bulk length does not match"}
+	}
+	_, _ = r.reader.Discard(2)
+	return arg, nil
+}
+
+// parseLength 解析头部行中的十进制长度 不分配内存 只有-1一种负数
+func parseLength(digits []byte) (int64, bool) {
+	if len(digits) == 2 && digits[0] == '-' && digits[1] == '1' {
+		return -1, true
+	}
+	if len(digits) == 0 || len(digits) > 18 {
+		return 0, false
+	}
+	var n int64
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	return n, true
+}
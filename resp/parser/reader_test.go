@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"simple-godis/config"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// chunkReader 每次最多返回size个字节 模拟一条指令分多次到达
+type chunkReader struct {
+	data []byte
+	size int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.size
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// splitReaders 同一份数据的不同到达方式 结果必须相同
+var splitReaders = []struct {
+	name string
+	make func(data []byte) io.Reader
+}{
+	{"whole", func(data []byte) io.Reader { return bytes.NewReader(data) }},
+	{"one byte", func(data []byte) io.Reader { return iotest.OneByteReader(bytes.NewReader(data)) }},
+	{"half", func(data []byte) io.Reader { return iotest.HalfReader(bytes.NewReader(data)) }},
+	{"chunk 3", func(data []byte) io.Reader { return &chunkReader{data: data, size: 3} }},
+}
+
+// readAll 读取所有指令直到出错
+func readAll(reader io.Reader) ([][][]byte, error) {
+	r := MakeReader(reader)
+	defer r.Release()
+	var cmds [][][]byte
+	for {
+		args, err := r.ReadCommand()
+		if err != nil {
+			return cmds, err
+		}
+		cmds = append(cmds, args)
+	}
+}
+
+func multiBulk(args ...string) string {
+	var buf strings.Builder
+	buf.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	return buf.String()
+}
+
+func TestReadCommand(t *testing.T) {
+	large := strings.Repeat("x", bulkPreallocSize*2+7)
+	tests := []struct {
+		name  string
+		input string
+		want  [][]string
+		err   string // 为空时读完所有指令后返回io.EOF 否则是ProtocolError的内容
+	}{
+		{name: "multibulk", input: multiBulk("SET", "key", "value"), want: [][]string{{"SET", "key", "value"}}},
+		{name: "pipeline", input: multiBulk("GET", "a") + multiBulk("GET", "b"), want: [][]string{{"GET", "a"}, {"GET", "b"}}},
+		{name: "empty bulk", input: multiBulk("SET", "key", ""), want: [][]string{{"SET", "key", ""}}},
+		{name: "binary bulk", input: multiBulk("SET", "key", "a\r\nb\x00"), want: [][]string{{"SET", "key", "a\r\nb\x00"}}},
+		{name: "large bulk", input: multiBulk("SET", "key", large), want: [][]string{{"SET", "key", large}}},
+		{name: "skip empty multibulk", input: "*0\r\n*-1\r\n" + multiBulk("PING"), want: [][]string{{"PING"}}},
+		{name: "inline", input: "SET key value\r\n", want: [][]string{{"SET", "key", "value"}}},
+		{name: "inline without cr", input: "PING\n", want: [][]string{{"PING"}}},
+		{name: "skip empty line", input: "\r\n  \r\n" + multiBulk("PING"), want: [][]string{{"PING"}}},
+		{name: "inline and multibulk", input: "GET a\r\n" + multiBulk("GET", "b"), want: [][]string{{"GET", "a"}, {"GET", "b"}}},
+		{name: "invalid multibulk length", input: "*abc\r\n", err: "invalid multibulk length"},
+		{name: "negative multibulk length", input: "*-2\r\n", err: "invalid multibulk length"},
+		{name: "oversized multibulk length", input: "*" + strconv.Itoa(maxMultiBulkLen+1) + "\r\n", err: "invalid multibulk length"},
+		{name: "multibulk without crlf", input: "*1\n$4\r\nPING\r\n", err: "invalid multibulk length"},
+		{name: "too big mbulk count", input: "*" + strings.Repeat("1", readerBufferSize), err: "too big mbulk count string"},
+		{name: "expected $", input: "*1\r\n:1\r\n", err: "expected '$', got ':'"},
+		{name: "null bulk", input: "*1\r\n$-1\r\n", err: "invalid bulk length"},
+		{name: "negative bulk length", input: "*1\r\n$-5\r\n", err: "invalid bulk length"},
+		{name: "invalid bulk length", input: "*1\r\n$1a\r\n", err: "invalid bulk length"},
+		{name: "too big bulk count", input: "*1\r\n$" + strings.Repeat("1", readerBufferSize), err: "too big bulk count string"},
+		{name: "missing crlf after bulk", input: "*1\r\n$4\r\nPINGXX", err: "bulk length does not match"},
+		{name: "bulk longer than length", input: "*2\r\n$3\r\nGETX\r\n$1\r\na\r\n", err: "bulk length does not match"},
+		{name: "commands before error", input: multiBulk("PING") + "*1\r\n$2\r\nab\n\n", want: [][]string{{"PING"}}, err: "bulk length does not match"},
+		{name: "unbalanced quotes", input: "SET \"key value\r\n", err: "unbalanced quotes in request"},
+	}
+	for _, tt := range tests {
+		for _, split := range splitReaders {
+			t.Run(tt.name+"/"+split.name, func(t *testing.T) {
+				cmds, err := readAll(split.make([]byte(tt.input)))
+				assertCommands(t, cmds, tt.want)
+				if tt.err == "" {
+					if err != io.EOF {
+						t.Fatalf("expected io.EOF, got %v", err)
+					}
+					return
+				}
+				protocolErr, ok := err.(*ProtocolError)
+				if !ok {
+					t.Fatalf("expected protocol error %q, got %v", tt.err, err)
+				}
+				if protocolErr.Msg != tt.err {
+					t.Fatalf("expected protocol error %q, got %q", tt.err, protocolErr.Msg)
+				}
+			})
+		}
+	}
+}
+
+func assertCommands(t *testing.T, cmds [][][]byte, want [][]string) {
+	t.Helper()
+	if len(cmds) != len(want) {
+		t.Fatalf("expected %d commands, got %d", len(want), len(cmds))
+	}
+	for i, args := range cmds {
+		if len(args) != len(want[i]) {
+			t.Fatalf("command %d: expected %d args, got %d", i, len(want[i]), len(args))
+		}
+		for j, arg := range args {
+			if string(arg) != want[i][j] {
+				t.Fatalf("command %d arg %d: expected %.32q, got %.32q", i, j, want[i][j], arg)
+			}
+		}
+	}
+}
+
+// TestReadCommandPartial 连接在指令中间断开时返回io错误而不是协议错误
+func TestReadCommandPartial(t *testing.T) {
+	full := multiBulk("SET", "key", "value")
+	for i := 1; i < len(full); i++ {
+		for _, split := range splitReaders {
+			_, err := readAll(split.make([]byte(full[:i])))
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				t.Fatalf("%s: truncated at %d: expected EOF, got %v", split.name, i, err)
+			}
+		}
+	}
+}
+
+func TestReadCommandMaxBulkLen(t *testing.T) {
+	saved := config.Properties.ProtoMaxBulkLen
+	defer func() { config.Properties.ProtoMaxBulkLen = saved }()
+	config.Properties.ProtoMaxBulkLen = 8
+
+	cmds, err := readAll(strings.NewReader(multiBulk("SET", "key", "12345678")))
+	assertCommands(t, cmds, [][]string{{"SET", "key", "12345678"}})
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	// 超过限制时只根据头部就拒绝 不会读取或分配参数的内容
+	_, err = readAll(strings.NewReader("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$9\r\n"))
+	if protocolErr, ok := err.(*ProtocolError); !ok || protocolErr.Msg != "invalid bulk length" {
+		t.Fatalf("expected invalid bulk length, got %v", err)
+	}
+	config.Properties.ProtoMaxBulkLen = 0
+	_, err = readAll(strings.NewReader("*1\r\n$" + strconv.Itoa(defaultMaxBulkLen+1) + "\r\n"))
+	if protocolErr, ok := err.(*ProtocolError); !ok || protocolErr.Msg != "invalid bulk length" {
+		t.Fatalf("expected invalid bulk length, got %v", err)
+	}
+}
+
+// TestReadCommandArgsNotReused 之后的读取不会覆盖之前返回的参数 归还的缓冲区不会残留上一个连接的数据
+func TestReadCommandArgsNotReused(t *testing.T) {
+	first := multiBulk("SET", "key1", strings.Repeat("a", 100))
+	second := multiBulk("SET", "key2", strings.Repeat("b", readerBufferSize*2))
+	third := "SET key3 c\r\n"
+	r := MakeReader(strings.NewReader(first + second + third))
+	var cmds [][][]byte
+	for i := 0; i < 3; i++ {
+		args, err := r.ReadCommand()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, args)
+	}
+	assertCommands(t, cmds, [][]string{
+		{"SET", "key1", strings.Repeat("a", 100)},
+		{"SET", "key2", strings.Repeat("b", readerBufferSize*2)},
+		{"SET", "key3", "c"},
+	})
+
+	// 缓冲区中还有未读取的指令时归还 下一个Reader只读取自己的数据
+	r = MakeReader(strings.NewReader(multiBulk("GET", "a") + multiBulk("GET", "b")))
+	if _, err := r.ReadCommand(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Buffered() == 0 {
+		t.Fatal("expected pipelined command in buffer")
+	}
+	r.Release()
+	cmds, err := readAll(strings.NewReader(multiBulk("GET", "c")))
+	assertCommands(t, cmds, [][]string{{"GET", "c"}})
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
@@ -3,11 +3,17 @@ package reply
 /*
 记录一些固定的回复格式或内容
 */
-var pongBytes = []byte("PONG\r\n") // pong的字节数组
-var okBytes = []byte("OK\r\n")
-var nullBulkBytes = []byte("nil\r\n")     // nil 空字符串回复
-var emptyMultiBulkBytes = []byte("0\r\n") // 空数组回复
-var noBytes = []byte("")                  // 空回复
+var pongBytes = []byte("+PONG\r\n") // pong的字节数组
+var okBytes = []byte("+OK\r\n")
+var nullBulkBytes = []byte("$-1\r\n")      // nil 空字符串回复
+var emptyMultiBulkBytes = []byte("*0\r\n") // 空数组回复
+var noBytes = []byte("")                   // 空回复
+
+// 回复给客户端的文本
+var pongClientBytes = []byte("PONG\r\n")
+var okClientBytes = []byte("OK\r\n")
+var nullBulkClientBytes = []byte("nil\r\n")
+var emptyMultiBulkClientBytes = []byte("0\r\n")
 
 /*
 本地持有一些固定回复 节约内存
@@ -33,7 +39,7 @@ func (reply *PongReply) ToBytes() []byte {
 }
 
 func (reply *PongReply) ToClient() []byte {
-	return pongBytes
+	return pongClientBytes
 }
 
 // OkReply 回复客户端OK
@@ -51,7 +57,7 @@ func (reply *OkReply) ToBytes() []byte {
 }
 
 func (reply *OkReply) ToClient() []byte {
-	return okBytes
+	return okClientBytes
 }
 
 // NullBulkReply 空回复
@@ -69,7 +75,7 @@ func (reply *NullBulkReply) ToBytes() []byte {
 }
 
 func (reply *NullBulkReply) ToClient() []byte {
-	return nullBulkBytes
+	return nullBulkClientBytes
 }
 
 // EmptyMultiBulkReply 空数组回复
@@ -87,7 +93,7 @@ func (reply *EmptyMultiBulkReply) ToBytes() []byte {
 }
 
 func (reply *EmptyMultiBulkReply) ToClient() []byte {
-	return emptyMultiBulkBytes
+	return emptyMultiBulkClientBytes
 }
 
 // NoReply 空回复
@@ -103,3 +109,7 @@ func MakeNoReply() *NoReply {
 func (reply *NoReply) ToBytes() []byte {
 	return noBytes
 }
+
+func (reply *NoReply) ToClient() []byte {
+	return noBytes
+}
@@ -11,7 +11,8 @@ import (
 */
 var (
 	nullBulkReplyBytes = []byte("nil")
-	CRLF               = "\r\n" // CRLF resp协议的换行符
+	nullBulkRespBytes  = []byte("$-1\r\n") // resp协议中的空字符串
+	CRLF               = "\r\n"            // CRLF resp协议的换行符
 )
 
 // ErrorReply Redis通信异常回复接口
@@ -37,7 +38,7 @@ func MakeBulkReply(msg []byte) *BulkReply {
 func (reply *BulkReply) ToBytes() []byte {
 	// 判断传递的信息长度是否为空
 	if len(reply.Msg) == 0 {
-		return nullBulkReplyBytes
+		return nullBulkRespBytes
 	}
 	return []byte("$" + strconv.Itoa(len(reply.Msg)) + CRLF + string(reply.Msg) + CRLF)
 }
@@ -63,7 +64,7 @@ func (reply *MultiBulkReply) ToBytes() []byte {
 	// 遍历每一个string
 	for _, lineMsg := range reply.Msg {
 		if lineMsg == nil {
-			buf.WriteString(string(nullBulkReplyBytes) + CRLF)
+			buf.Write(nullBulkRespBytes)
 		} else {
 			buf.WriteString("$" + strconv.Itoa(len(lineMsg)) + CRLF + string(lineMsg) + CRLF)
 		}
@@ -0,0 +1,388 @@
+package reply
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"simple-godis/interface/resp"
+	"strconv"
+)
+
+/*
+RESP3协议的回复
+客户端使用HELLO 3切换到RESP3之后使用ToResp3编码 map set double等有类型的回复
+ToBytes是这些回复在RESP2中的编码 例如map转换为数组 double转换为字符串 boolean转换为整数
+*/
+
+// 客户端使用的协议
+const (
+	ProtocolText = 0 // 默认的文本协议 使用ToClient编码
+	Protocol2    = 2 // RESP2 使用ToBytes编码
+	Protocol3    = 3 // RESP3 使用ToResp3编码
+)
+
+var (
+	resp3NullBytes       = []byte("_\r\n")
+	nullMultiBulkBytes   = []byte("*-1\r\n")
+	resp3TrueBytes       = []byte("#t\r\n")
+	resp3FalseBytes      = []byte("#f\r\n")
+	resp2TrueBytes       = []byte(":1\r\n")
+	resp2FalseBytes      = []byte(":0\r\n")
+	trueClientBytes      = []byte("1\r\n")
+	falseClientBytes     = []byte("0\r\n")
+	theNullReply         = new(NullReply)
+	theTrueBooleanReply  = &BooleanReply{Value: true}
+	theFalseBooleanReply = &BooleanReply{Value: false}
+)
+
+// ToResp3 将回复转换为RESP3协议的字节 没有单独的RESP3编码时与ToBytes相同
+func ToResp3(r resp.Reply) []byte {
+	if r3, ok := r.(resp.Resp3Reply); ok {
+		return r3.ToResp3()
+	}
+	return r.ToBytes()
+}
+
+// Encode 按照客户端使用的协议将回复转换为字节
+func Encode(r resp.Reply, protocol int) []byte {
+	switch protocol {
+	case Protocol3:
+		return ToResp3(r)
+	case Protocol2:
+		return r.ToBytes()
+	}
+	return r.ToClient()
+}
+
+// BulkReplies 将多个字符串转换为BulkReply 用于构造map set等回复
+func BulkReplies(msg [][]byte) []resp.Reply {
+	replies := make([]resp.Reply, len(msg))
+	for i, m := range msg {
+		if m == nil {
+			replies[i] = MakeNullBulkReply()
+		} else {
+			replies[i] = MakeBulkReply(m)
+		}
+	}
+	return replies
+}
+
+// writeAggregate 写入聚合类型的头部和所有元素 resp3为true时元素使用RESP3编码
+func writeAggregate(buf *bytes.Buffer, prefix byte, count int, replies []resp.Reply, resp3 bool) {
+	buf.WriteByte(prefix)
+	buf.WriteString(strconv.Itoa(count) + CRLF)
+	for _, r := range replies {
+		if resp3 {
+			buf.Write(ToResp3(r))
+		} else {
+			buf.Write(r.ToBytes())
+		}
+	}
+}
+
+// writeClientLines 文本协议中依次写入所有元素 与MultiBulkReply的文本格式相同
+func writeClientLines(replies []resp.Reply) []byte {
+	if len(replies) == 0 {
+		return emptyMultiBulkClientBytes
+	}
+	var buf bytes.Buffer
+	for _, r := range replies {
+		buf.Write(r.ToClient())
+	}
+	return buf.Bytes()
+}
+
+// ToResp3 NullBulkReply在RESP3中使用null
+func (reply *NullBulkReply) ToResp3() []byte {
+	return resp3NullBytes
+}
+
+// ToResp3 MultiBulkReply中的空值在RESP3中使用null
+func (reply *MultiBulkReply) ToResp3() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(reply.Msg)) + CRLF)
+	for _, lineMsg := range reply.Msg {
+		if lineMsg == nil {
+			buf.Write(resp3NullBytes)
+		} else {
+			buf.WriteString("$" + strconv.Itoa(len(lineMsg)) + CRLF + string(lineMsg) + CRLF)
+		}
+	}
+	return buf.Bytes()
+}
+
+// ToResp3 MultiRawReply的元素使用RESP3编码
+func (r *MultiRawReply) ToResp3() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(r.Replies), r.Replies, true)
+	return buf.Bytes()
+}
+
+// MapReply 键值对 RESP2中转换为键值交替的数组
+type MapReply struct {
+	Keys   []resp.Reply
+	Values []resp.Reply
+}
+
+// MakeMapReply MapReply的构造方法 keys和values的长度需要相同
+func MakeMapReply(keys []resp.Reply, values []resp.Reply) *MapReply {
+	return &MapReply{
+		Keys:   keys,
+		Values: values,
+	}
+}
+
+// pairs 键值交替排列
+func (reply *MapReply) pairs() []resp.Reply {
+	pairs := make([]resp.Reply, 0, len(reply.Keys)*2)
+	for i := range reply.Keys {
+		pairs = append(pairs, reply.Keys[i], reply.Values[i])
+	}
+	return pairs
+}
+
+func (reply *MapReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(reply.Keys)*2, reply.pairs(), false)
+	return buf.Bytes()
+}
+
+func (reply *MapReply) ToClient() []byte {
+	return writeClientLines(reply.pairs())
+}
+
+func (reply *MapReply) ToResp3() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '%', len(reply.Keys), reply.pairs(), true)
+	return buf.Bytes()
+}
+
+// SetReply 无序且不重复的元素 RESP2中转换为数组
+type SetReply struct {
+	Replies []resp.Reply
+}
+
+// MakeSetReply SetReply的构造方法
+func MakeSetReply(replies []resp.Reply) *SetReply {
+	return &SetReply{
+		Replies: replies,
+	}
+}
+
+func (reply *SetReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(reply.Replies), reply.Replies, false)
+	return buf.Bytes()
+}
+
+func (reply *SetReply) ToClient() []byte {
+	return writeClientLines(reply.Replies)
+}
+
+func (reply *SetReply) ToResp3() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '~', len(reply.Replies), reply.Replies, true)
+	return buf.Bytes()
+}
+
+// PushReply 服务端主动推送的消息 例如发布订阅的消息 RESP2中转换为数组
+type PushReply struct {
+	Replies []resp.Reply
+}
+
+// MakePushReply PushReply的构造方法
+func MakePushReply(replies []resp.Reply) *PushReply {
+	return &PushReply{
+		Replies: replies,
+	}
+}
+
+func (reply *PushReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(reply.Replies), reply.Replies, false)
+	return buf.Bytes()
+}
+
+// ToClient 与MultiRawReply相同 文本协议中推送的消息也使用resp数组
+func (reply *PushReply) ToClient() []byte {
+	return reply.ToBytes()
+}
+
+func (reply *PushReply) ToResp3() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '>', len(reply.Replies), reply.Replies, true)
+	return buf.Bytes()
+}
+
+// AttributeReply 附带在回复之前的辅助信息 RESP2中只发送回复本身
+type AttributeReply struct {
+	Attributes *MapReply
+	Reply      resp.Reply
+}
+
+// MakeAttributeReply AttributeReply的构造方法
+func MakeAttributeReply(attributes *MapReply, reply resp.Reply) *AttributeReply {
+	return &AttributeReply{
+		Attributes: attributes,
+		Reply:      reply,
+	}
+}
+
+func (reply *AttributeReply) ToBytes() []byte {
+	return reply.Reply.ToBytes()
+}
+
+func (reply *AttributeReply) ToClient() []byte {
+	return reply.Reply.ToClient()
+}
+
+func (reply *AttributeReply) ToResp3() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '|', len(reply.Attributes.Keys), reply.Attributes.pairs(), true)
+	buf.Write(ToResp3(reply.Reply))
+	return buf.Bytes()
+}
+
+// DoubleReply 浮点数 RESP2中转换为字符串
+type DoubleReply struct {
+	Value float64
+}
+
+// MakeDoubleReply DoubleReply的构造方法
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{
+		Value: value,
+	}
+}
+
+// FormatDouble 将浮点数转换为字符串 无穷大与redis保持一致输出为inf和-inf
+func FormatDouble(value float64) string {
+	if math.IsInf(value, 1) {
+		return "inf"
+	} else if math.IsInf(value, -1) {
+		return "-inf"
+	} else if math.IsNaN(value) {
+		return "nan"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (reply *DoubleReply) ToBytes() []byte {
+	str := FormatDouble(reply.Value)
+	return []byte("$" + strconv.Itoa(len(str)) + CRLF + str + CRLF)
+}
+
+func (reply *DoubleReply) ToClient() []byte {
+	return []byte(FormatDouble(reply.Value) + CRLF)
+}
+
+func (reply *DoubleReply) ToResp3() []byte {
+	return []byte("," + FormatDouble(reply.Value) + CRLF)
+}
+
+// BooleanReply 布尔值 RESP2中转换为整数1和0
+type BooleanReply struct {
+	Value bool
+}
+
+// MakeBooleanReply BooleanReply的构造方法
+func MakeBooleanReply(value bool) *BooleanReply {
+	if value {
+		return theTrueBooleanReply
+	}
+	return theFalseBooleanReply
+}
+
+func (reply *BooleanReply) ToBytes() []byte {
+	if reply.Value {
+		return resp2TrueBytes
+	}
+	return resp2FalseBytes
+}
+
+func (reply *BooleanReply) ToClient() []byte {
+	if reply.Value {
+		return trueClientBytes
+	}
+	return falseClientBytes
+}
+
+func (reply *BooleanReply) ToResp3() []byte {
+	if reply.Value {
+		return resp3TrueBytes
+	}
+	return resp3FalseBytes
+}
+
+// NullReply RESP3中的空值 RESP2中转换为空数组*-1 空字符串使用NullBulkReply
+type NullReply struct {
+}
+
+// MakeNullReply NullReply的构造方法
+func MakeNullReply() *NullReply {
+	return theNullReply
+}
+
+func (reply *NullReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+func (reply *NullReply) ToClient() []byte {
+	return nullBulkClientBytes
+}
+
+func (reply *NullReply) ToResp3() []byte {
+	return resp3NullBytes
+}
+
+// BigNumberReply 超出int64范围的整数 RESP2中转换为字符串
+type BigNumberReply struct {
+	Value *big.Int
+}
+
+// MakeBigNumberReply BigNumberReply的构造方法
+func MakeBigNumberReply(value *big.Int) *BigNumberReply {
+	return &BigNumberReply{
+		Value: value,
+	}
+}
+
+func (reply *BigNumberReply) ToBytes() []byte {
+	str := reply.Value.String()
+	return []byte("$" + strconv.Itoa(len(str)) + CRLF + str + CRLF)
+}
+
+func (reply *BigNumberReply) ToClient() []byte {
+	return []byte(reply.Value.String() + CRLF)
+}
+
+func (reply *BigNumberReply) ToResp3() []byte {
+	return []byte("(" + reply.Value.String() + CRLF)
+}
+
+// VerbatimReply 带有格式的文本 格式为三个字符 例如txt mkd RESP2中转换为字符串
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+// MakeVerbatimReply VerbatimReply的构造方法
+func MakeVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{
+		Format: format,
+		Text:   text,
+	}
+}
+
+func (reply *VerbatimReply) ToBytes() []byte {
+	return MakeBulkReply(reply.Text).ToBytes()
+}
+
+func (reply *VerbatimReply) ToClient() []byte {
+	return MakeBulkReply(reply.Text).ToClient()
+}
+
+func (reply *VerbatimReply) ToResp3() []byte {
+	return []byte("=" + strconv.Itoa(len(reply.Format)+1+len(reply.Text)) + CRLF +
+		reply.Format + ":" + string(reply.Text) + CRLF)
+}
@@ -23,7 +23,7 @@ type Config struct {
 
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	closeChan := make(chan struct{})
-	signalChan := make(chan os.Signal) // 负载是系统的信号
+	signalChan := make(chan os.Signal, 1) // 负载是系统的信号
 	// 当系统调用发出如下信号时传递给signalChan
 	signal.Notify(signalChan, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 